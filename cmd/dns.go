@@ -0,0 +1,98 @@
+/*
+Copyright © 2022 Netmaker Team <info@netmaker.io>
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gravitl/netclient/dns"
+	"github.com/gravitl/netclient/functions"
+	"github.com/spf13/cobra"
+)
+
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "manage the dns resolver of netclient",
+}
+
+var dnsCacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the response cache of the dns resolver",
+}
+
+var dnsCacheFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Args:  cobra.NoArgs,
+	Short: "drop the cached responses of forwarded dns queries",
+	Long: `drops the responses the running daemon cached for queries forwarded to the
+configured nameservers, so the next lookups are resolved upstream.
+
+For example:
+netclient dns cache flush`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := functions.FlushDNSCache(); err != nil {
+			fmt.Println("\nFailed to flush dns cache:", err)
+		}
+	},
+}
+
+var dnsForwardCmd = &cobra.Command{
+	Use:       "forward [sequential|stagger|race]",
+	Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{dns.DNS_FORWARD_SEQUENTIAL, dns.DNS_FORWARD_STAGGER, dns.DNS_FORWARD_RACE},
+	Short:     "display or set how dns queries are forwarded to the nameservers",
+	Long: `displays or sets how the dns resolver forwards the queries of the current server
+to its best matching nameservers:
+
+sequential   one nameserver at a time, the next one after a failure (default)
+stagger      the next nameserver also after a short delay without a response
+race         all nameservers at once, the first answer wins
+
+The strategy set for the server takes precedence over dns_forward_strategy in the
+host config.
+
+For example:
+netclient dns forward          // display the strategy in use
+netclient dns forward race     // race the nameservers of the current server`,
+	Run: func(cmd *cobra.Command, args []string) {
+		strategy := ""
+		if len(args) > 0 {
+			strategy = args[0]
+		}
+		if err := functions.DNSForwardStrategy(strategy); err != nil {
+			fmt.Println("\nFailed to manage dns forward strategy:", err)
+		}
+	},
+}
+
+var dnsStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Args:  cobra.NoArgs,
+	Short: "display the queries answered by the dns resolver",
+	Long: `displays the queries the running daemon answered since it started, the most
+queried names, the NXDOMAIN rate and the error rates of the upstream nameservers.
+
+For example:
+netclient dns stats            // display the stats in a table
+netclient dns stats --top 25   // display the 25 most queried names
+netclient dns stats -j         // display the stats in JSON format`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		top, _ := cmd.Flags().GetInt("top")
+		if err := functions.DNSStats(jsonOutput, top); err != nil {
+			fmt.Println("\nFailed to get dns stats:", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dnsCmd)
+	dnsCmd.AddCommand(dnsCacheCmd)
+	dnsCacheCmd.AddCommand(dnsCacheFlushCmd)
+
+	dnsCmd.AddCommand(dnsForwardCmd)
+	dnsCmd.AddCommand(dnsStatsCmd)
+	dnsStatsCmd.Flags().BoolP("json", "j", false, "display the stats in JSON format")
+	dnsStatsCmd.Flags().Int("top", 10, "number of most queried names to display")
+}
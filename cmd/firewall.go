@@ -0,0 +1,100 @@
+/*
+Copyright © 2022 Netmaker Team <info@netmaker.io>
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gravitl/netclient/functions"
+	"github.com/spf13/cobra"
+)
+
+var firewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "inspect the firewall rules managed by netclient",
+}
+
+var firewallPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Args:  cobra.NoArgs,
+	Short: "display the rule changes a firewall update would make",
+	Long: `display the rules a firewall update would add and delete, without applying them.
+The update is read from a file holding the json payload sent by the server and is
+planned against the rules installed by the running daemon.
+
+For example:
+netclient firewall plan -f update.json                    // plan for the firewall in use
+netclient firewall plan -f update.json --backend iptables // render iptables commands
+netclient firewall plan -f update.json -j                 // display the plan in JSON format`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		backend, _ := cmd.Flags().GetString("backend")
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := functions.PlanFirewall(file, backend, jsonOutput); err != nil {
+			fmt.Println("\nFailed to plan firewall update:", err)
+		}
+	},
+}
+
+var firewallCheckCmd = &cobra.Command{
+	Use:   "check",
+	Args:  cobra.NoArgs,
+	Short: "check whether the installed acl rules allow a flow",
+	Long: `evaluates a new flow against the acl rules installed by the running daemon and the
+default targets of the acl chains, and displays the rule deciding the verdict.
+Flows to an address outside the host's networks are checked against the egress rules.
+
+For example:
+netclient firewall check --src 100.64.0.2 --dst 100.64.0.1 --proto tcp --port 22
+netclient firewall check --src 100.64.0.2 --dst 10.10.0.5 --proto tcp --port 5432 -j`,
+	Run: func(cmd *cobra.Command, args []string) {
+		src, _ := cmd.Flags().GetString("src")
+		dst, _ := cmd.Flags().GetString("dst")
+		proto, _ := cmd.Flags().GetString("proto")
+		port, _ := cmd.Flags().GetInt("port")
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := functions.CheckFirewall(src, dst, proto, port, jsonOutput); err != nil {
+			fmt.Println("\nFailed to check flow:", err)
+		}
+	},
+}
+
+var firewallStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Args:  cobra.NoArgs,
+	Short: "display the hits of the acl rules",
+	Long: `displays the packets and bytes matched by the firewall rules installed by the running daemon,
+summed per acl policy, egress gateway and ingress node.
+
+For example:
+netclient firewall stats    // display the hits in a table
+netclient firewall stats -j // display the hits in JSON format`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := functions.FirewallStats(jsonOutput); err != nil {
+			fmt.Println("\nFailed to get firewall stats:", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(firewallCmd)
+	firewallCmd.AddCommand(firewallPlanCmd)
+	firewallPlanCmd.Flags().StringP("file", "f", "", "file with the firewall update payload")
+	firewallPlanCmd.Flags().String("backend", "", "firewall to render the plan for (nftables|iptables), defaults to the one in use")
+	firewallPlanCmd.Flags().BoolP("json", "j", false, "display the plan in JSON format")
+	firewallPlanCmd.MarkFlagRequired("file")
+
+	firewallCmd.AddCommand(firewallCheckCmd)
+	firewallCheckCmd.Flags().String("src", "", "source ip of the flow")
+	firewallCheckCmd.Flags().String("dst", "", "destination ip of the flow")
+	firewallCheckCmd.Flags().String("proto", "tcp", "protocol of the flow (tcp|udp|icmp)")
+	firewallCheckCmd.Flags().Int("port", 0, "destination port of the flow")
+	firewallCheckCmd.Flags().BoolP("json", "j", false, "display the result in JSON format")
+	firewallCheckCmd.MarkFlagRequired("src")
+	firewallCheckCmd.MarkFlagRequired("dst")
+
+	firewallCmd.AddCommand(firewallStatsCmd)
+	firewallStatsCmd.Flags().BoolP("json", "j", false, "display the hits in JSON format")
+}
@@ -93,11 +93,95 @@ type Config struct {
 	//for Internet gateway
 	OriginalDefaultGatewayIp net.IP `json:"original_default_gateway_ip_old" yaml:"original_default_gateway_ip_old"`
 	CurrGwNmIP               net.IP `json:"curr_gw_nm_ip" yaml:"curr_gw_nm_ip"`
-	//for manage DNS
+	//for manage DNS, the manager type is detected on start, resolved-dbus configures systemd-resolved over d-bus instead of resolvectl
 	DNSManagerType string   `json:"dns_manager_type" yaml:"dns_manager_type"`
 	NameServers    []string `json:"name_servers" yaml:"name_servers"`
 	DNSSearch      string   `json:"dns_search" yaml:"dns_search"`
 	DNSOptions     string   `json:"dns_options" yaml:"dns_options"`
+	//for local prometheus exporter, disabled when empty
+	MetricsExporterAddr string `json:"metrics_exporter_addr" yaml:"metrics_exporter_addr"`
+	//for rate and connection limits of acl policies, keyed by acl id
+	AclLimits map[string]AclLimit `json:"acl_limits" yaml:"acl_limits"`
+	//for turning off the periodic comparison of the live firewall with the installed rules
+	DisableFirewallReconcile bool `json:"disable_firewall_reconcile" yaml:"disable_firewall_reconcile"`
+	//for logging the packets dropped by the acl chains to a local file, optionally streamed with the flow logs
+	DropLog       bool `json:"drop_log" yaml:"drop_log"`
+	DropLogExport bool `json:"drop_log_export" yaml:"drop_log_export"`
+	//for forwarding queries to the best matching nameservers, sequential (default), stagger or race. A server's own strategy takes precedence
+	DNSForwardStrategy string `json:"dns_forward_strategy" yaml:"dns_forward_strategy"`
+	//for the size of the dns response cache, 0 uses the default size and a negative size disables caching
+	DNSCacheSize int `json:"dns_cache_size" yaml:"dns_cache_size"`
+	//for querying tls:// and https:// nameservers in cleartext over udp when the encrypted exchange fails
+	DNSUDPFallback bool `json:"dns_udp_fallback" yaml:"dns_udp_fallback"`
+	//for records served by the local dns resolver besides the addresses of the network hosts
+	DNSRecords []DNSRecord `json:"dns_records" yaml:"dns_records"`
+	//for logging the queries answered by the local dns resolver to a local file
+	DNSQueryLog bool `json:"dns_query_log" yaml:"dns_query_log"`
+	//for blocking the names listed in blocklist files, in hosts format or with a domain per line
+	DNSBlocklists []string `json:"dns_blocklists" yaml:"dns_blocklists"`
+	//for the answer to a blocked query, nxdomain (default) or null for the 0.0.0.0 and :: addresses
+	DNSBlockResponse string `json:"dns_block_response" yaml:"dns_block_response"`
+	//for the names allowed or denied per network, keyed by network
+	DNSPolicies map[string]DNSPolicy `json:"dns_policies" yaml:"dns_policies"`
+	//for validating the dnssec signatures of forwarded responses, bogus responses are answered with SERVFAIL
+	DNSSECValidation bool `json:"dnssec_validation" yaml:"dnssec_validation"`
+	//for the trust anchors of the validation as DS or DNSKEY records, the root zone keys when empty
+	DNSSECTrustAnchors []string `json:"dnssec_trust_anchors" yaml:"dnssec_trust_anchors"`
+	//for the match domains of unsigned internal zones whose responses are not validated
+	DNSSECInsecureDomains []string `json:"dnssec_insecure_domains" yaml:"dnssec_insecure_domains"`
+	//for exporting the flows to an ipfix (default) or netflow9 collector at host:port, disabled when empty.
+	//The addresses after nat are exported as the post nat elements. The peer identities, and the gateway forwarding
+	//with its egress range, are exported as enterprise specific ipfix elements of the enterprise number when set
+	FlowExportCollector  string `json:"flow_export_collector" yaml:"flow_export_collector"`
+	FlowExportProtocol   string `json:"flow_export_protocol" yaml:"flow_export_protocol"`
+	FlowExportEnterprise uint32 `json:"flow_export_enterprise" yaml:"flow_export_enterprise"`
+	//for spooling the flows to disk while the server is unreachable, replayed in order once it is back.
+	//The spool is capped to the size in MB and, when set, to the age in hours, disabled when the size is 0
+	FlowSpoolSize   int `json:"flow_spool_size" yaml:"flow_spool_size"`
+	FlowSpoolMaxAge int `json:"flow_spool_max_age" yaml:"flow_spool_max_age"`
+	//for the seconds between the interim updates of the counters of active flows, 0 uses the default
+	//interval and a negative interval disables the updates
+	FlowInterimInterval int `json:"flow_interim_interval" yaml:"flow_interim_interval"`
+	//for the flows tracked, rules are matched in order before the default exclusions of dns, icmp and metrics flows
+	FlowFilters []FlowFilterRule `json:"flow_filters" yaml:"flow_filters"`
+}
+
+// FlowFilterRule - includes or excludes the flows matching all the set fields of the rule. Protocols
+// are names (tcp, udp, icmp, icmpv6) or numbers, ports are single ports or ranges such as 8000-8100
+// and match either end of the flow as do the cidrs and participant types (node, user, extclient,
+// egress_route, external). SampleRate keeps a fraction of the flows an include rule matches, all when 0
+type FlowFilterRule struct {
+	Action           string   `json:"action" yaml:"action"`
+	Protocols        []string `json:"protocols" yaml:"protocols"`
+	Ports            []string `json:"ports" yaml:"ports"`
+	CIDRs            []string `json:"cidrs" yaml:"cidrs"`
+	ParticipantTypes []string `json:"participant_types" yaml:"participant_types"`
+	Networks         []string `json:"networks" yaml:"networks"`
+	Direction        string   `json:"direction" yaml:"direction"`
+	SampleRate       float64  `json:"sample_rate" yaml:"sample_rate"`
+}
+
+// DNSPolicy - names the local dns resolver allows or denies to the hosts of a network. A name
+// matches its subdomains too, allowed names are not blocked by the deny rules or the blocklists
+type DNSPolicy struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+// DNSRecord - a record of the local dns resolver, e.g. a CNAME, SRV, TXT or PTR record.
+// Value is the record data in zone file format, such as "10 5 5432 db.netmaker." for SRV
+type DNSRecord struct {
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// AclLimit - limits enforced per source address on the traffic an acl policy accepts, including
+// its established connections, zero disables a limit. Traffic over a limit is dropped
+type AclLimit struct {
+	PacketsPerSec uint32 `json:"packets_per_sec" yaml:"packets_per_sec"`
+	BytesPerSec   uint32 `json:"bytes_per_sec" yaml:"bytes_per_sec"`
+	MaxConns      uint32 `json:"max_conns" yaml:"max_conns"`
 }
 
 func init() {
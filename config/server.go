@@ -34,6 +34,9 @@ type Server struct {
 	AccessKey      string              `json:"accesskey" yaml:"accesskey"`
 	NameServers    []string            `json:"name_servers"`
 	DnsNameservers []models.Nameserver `json:"dns_nameservers"`
+	// DNSForwardStrategy - how queries are forwarded to the best matching nameservers: sequential,
+	// stagger or race. The strategy of the host config applies when empty
+	DNSForwardStrategy string `json:"dns_forward_strategy,omitempty"`
 }
 
 // OldNetmakerServerConfig - pre v0.18.0 server configuration
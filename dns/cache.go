@@ -0,0 +1,210 @@
+package dns
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/miekg/dns"
+)
+
+const (
+	// cacheDefaultSize - number of responses kept when the size is not configured
+	cacheDefaultSize = 4096
+	// cacheMaxTTL - upper bound on how long a response is served from the cache
+	cacheMaxTTL = time.Hour
+	// cacheNegativeTTL - how long a negative response without a SOA record is cached
+	cacheNegativeTTL = time.Minute
+	// cachePrefetchHits - hits after which an entry is refreshed before it expires
+	cachePrefetchHits = 3
+	// cachePrefetchRatio - fraction of the ttl left when a hot entry is refreshed
+	cachePrefetchRatio = 10
+)
+
+// dnsCache - responses of the forwarded queries
+var dnsCache = newResponseCache()
+
+// responseCache - LRU cache of forwarded responses, keyed by question and the match domain of the
+// nameservers that answered it. Entries expire with the lowest ttl of their records
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	key         string
+	msg         *dns.Msg
+	stored      time.Time
+	ttl         time.Duration
+	hits        int
+	prefetching bool
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey - key of a question forwarded to the nameservers of matchDomain
+func cacheKey(q dns.Question, matchDomain string) string {
+	return fmt.Sprintf("%s|%s|%d|%d", matchDomain, strings.ToLower(dns.Fqdn(q.Name)), q.Qtype, q.Qclass)
+}
+
+// cacheSize - configured number of cached responses, 0 when caching is disabled
+func cacheSize() int {
+	size := config.Netclient().DNSCacheSize
+	if size < 0 {
+		return 0
+	}
+	if size == 0 {
+		return cacheDefaultSize
+	}
+	return size
+}
+
+// responseCache.get - returns a copy of the cached response with the ttls of its records lowered by
+// the time spent in the cache. prefetch is set once for a hot entry close to expiring
+func (c *responseCache) get(key string, now time.Time) (msg *dns.Msg, prefetch bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	age := now.Sub(entry.stored)
+	if age >= entry.ttl {
+		c.remove(elem)
+		return nil, false, false
+	}
+	c.lru.MoveToFront(elem)
+	entry.hits++
+	remaining := entry.ttl - age
+	if entry.hits >= cachePrefetchHits && !entry.prefetching && remaining*cachePrefetchRatio <= entry.ttl {
+		entry.prefetching = true
+		prefetch = true
+	}
+	msg = entry.msg.Copy()
+	ttl := uint32(remaining / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				rr.Header().Ttl = min(rr.Header().Ttl, ttl)
+			}
+		}
+	}
+	return msg, prefetch, true
+}
+
+// responseCache.set - caches the response for its ttl, evicting the least recently used entries
+// beyond the configured size. Responses without a cacheable ttl are ignored
+func (c *responseCache) set(key string, msg *dns.Msg, now time.Time) {
+	size := cacheSize()
+	ttl := responseTTL(msg)
+	if size == 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.msg = msg.Copy()
+		entry.stored = now
+		entry.ttl = ttl
+		entry.prefetching = false
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, msg: msg.Copy(), stored: now, ttl: ttl})
+	for c.lru.Len() > size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *responseCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// responseCache.flush - drops all entries and returns how many were cached
+func (c *responseCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	return n
+}
+
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// responseTTL - how long a response may be cached: the lowest ttl of its records for an answer, the
+// SOA minimum for a negative response, and 0 for failures and truncated responses
+func responseTTL(msg *dns.Msg) time.Duration {
+	if msg == nil || msg.Truncated {
+		return 0
+	}
+	var ttl time.Duration
+	switch {
+	case msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0:
+		ttl = cacheMaxTTL
+		for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+			for _, rr := range section {
+				if rr.Header().Rrtype != dns.TypeOPT {
+					ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+				}
+			}
+		}
+	case msg.Rcode == dns.RcodeSuccess || msg.Rcode == dns.RcodeNameError:
+		ttl = cacheNegativeTTL
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl = time.Duration(min(soa.Hdr.Ttl, soa.Minttl)) * time.Second
+				break
+			}
+		}
+	}
+	return min(ttl, cacheMaxTTL)
+}
+
+// resolveCached - answers the query from the cache, resolving and caching it on a miss. Returns the
+// nameserver of a resolved response, and hit for an answer from the cache.
+// Hot entries close to expiring are refreshed in the background
+func resolveCached(r *dns.Msg, matchDomain string, resolve func(*dns.Msg) (*dns.Msg, string)) (resp *dns.Msg, upstream string, hit bool) {
+	key := cacheKey(r.Question[0], matchDomain)
+	if resp, prefetch, ok := dnsCache.get(key, time.Now()); ok {
+		if prefetch {
+			go func(q *dns.Msg) {
+				if resp, _ := resolve(q); resp != nil {
+					dnsCache.set(key, resp, time.Now())
+				}
+			}(r.Copy())
+		}
+		return resp, "", true
+	}
+	resp, upstream = resolve(r)
+	if resp != nil {
+		dnsCache.set(key, resp, time.Now())
+	}
+	return resp, upstream, false
+}
+
+// ResponseCacheLen - number of cached responses of forwarded queries
+func ResponseCacheLen() int {
+	return dnsCache.len()
+}
+
+// FlushResponseCache - drops the cached responses of forwarded queries, returns how many were cached
+func FlushResponseCache() int {
+	return dnsCache.flush()
+}
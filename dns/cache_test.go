@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func answer(name string, ttl uint32) *dns.Msg {
+	m := &dns.Msg{}
+	m.SetQuestion(name, dns.TypeA)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP("10.0.0.1"),
+	}}
+	return m
+}
+
+func TestResponseTTL(t *testing.T) {
+	assert.Equal(t, 30*time.Second, responseTTL(answer("a.example.", 30)))
+	assert.Equal(t, cacheMaxTTL, responseTTL(answer("a.example.", 86400)))
+
+	nx := &dns.Msg{}
+	nx.Rcode = dns.RcodeNameError
+	assert.Equal(t, cacheNegativeTTL, responseTTL(nx))
+	nx.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Rrtype: dns.TypeSOA, Ttl: 300}, Minttl: 20}}
+	assert.Equal(t, 20*time.Second, responseTTL(nx))
+
+	servfail := &dns.Msg{}
+	servfail.Rcode = dns.RcodeServerFailure
+	assert.Zero(t, responseTTL(servfail))
+
+	truncated := answer("a.example.", 30)
+	truncated.Truncated = true
+	assert.Zero(t, responseTTL(truncated))
+}
+
+func TestResponseCache(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	cfg := host
+	cfg.DNSCacheSize = 2
+	config.UpdateNetclient(cfg)
+
+	c := newResponseCache()
+	now := time.Now()
+	q := dns.Question{Name: "A.Example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	assert.Equal(t, cacheKey(q, ".example."), cacheKey(dns.Question{Name: "a.example", Qtype: dns.TypeA, Qclass: dns.ClassINET}, ".example."))
+	assert.NotEqual(t, cacheKey(q, ".example."), cacheKey(q, "."))
+
+	c.set("a", answer("a.example.", 100), now)
+	msg, prefetch, ok := c.get("a", now.Add(40*time.Second))
+	require.True(t, ok)
+	assert.False(t, prefetch)
+	assert.Equal(t, uint32(60), msg.Answer[0].Header().Ttl, "ttl lowered by the time spent in the cache")
+
+	// hot entry close to expiring is prefetched once
+	c.get("a", now.Add(50*time.Second))
+	_, prefetch, _ = c.get("a", now.Add(95*time.Second))
+	assert.True(t, prefetch)
+	_, prefetch, _ = c.get("a", now.Add(96*time.Second))
+	assert.False(t, prefetch)
+
+	_, _, ok = c.get("a", now.Add(100*time.Second))
+	assert.False(t, ok, "expired")
+	assert.Zero(t, c.len())
+
+	// least recently used entry is evicted
+	c.set("a", answer("a.example.", 100), now)
+	c.set("b", answer("b.example.", 100), now)
+	c.get("a", now)
+	c.set("c", answer("c.example.", 100), now)
+	_, _, ok = c.get("b", now)
+	assert.False(t, ok)
+	_, _, ok = c.get("a", now)
+	assert.True(t, ok)
+
+	assert.Equal(t, 2, c.flush())
+	assert.Zero(t, c.len())
+
+	cfg.DNSCacheSize = -1
+	config.UpdateNetclient(cfg)
+	c.set("a", answer("a.example.", 100), now)
+	assert.Zero(t, c.len(), "caching disabled")
+}
@@ -12,11 +12,12 @@ import (
 )
 
 const (
-	DNS_MANAGER_STUB             = "stub"        // '/run/systemd/resolve/stub-resolv.conf'
-	DNS_MANAGER_UPLINK           = "uplink"      // '/run/systemd/resolve/resolv.conf'
-	DNS_MANAGER_RESOLVECONF      = "resolveconf" // 'generated by resolvconf(8)'
-	DNS_MANAGER_FILE             = "file"        // other than above
-	DNS_MANAGER_WINDOWS_REGISTRY = "registry"    // for Windows machines
+	DNS_MANAGER_STUB             = "stub"          // '/run/systemd/resolve/stub-resolv.conf'
+	DNS_MANAGER_UPLINK           = "uplink"        // '/run/systemd/resolve/resolv.conf'
+	DNS_MANAGER_RESOLVECONF      = "resolveconf"   // 'generated by resolvconf(8)'
+	DNS_MANAGER_FILE             = "file"          // other than above
+	DNS_MANAGER_WINDOWS_REGISTRY = "registry"      // for Windows machines
+	DNS_MANAGER_RESOLVED_DBUS    = "resolved-dbus" // systemd-resolved configured over d-bus, set by config
 )
 
 var (
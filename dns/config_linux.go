@@ -39,6 +39,10 @@ func isUplinkSupported() bool {
 	return config.Netclient().DNSManagerType == DNS_MANAGER_UPLINK
 }
 
+func isResolvedDBusSupported() bool {
+	return config.Netclient().DNSManagerType == DNS_MANAGER_RESOLVED_DBUS
+}
+
 func isResolveconfSupported() bool {
 	return config.Netclient().DNSManagerType == DNS_MANAGER_RESOLVECONF
 }
@@ -51,7 +55,12 @@ func isResolveconfSupported() bool {
 func FlushLocalDnsCache() (err error) {
 	dnsConfigMutex.Lock()
 	defer dnsConfigMutex.Unlock()
-	if isStubSupported() || isUplinkSupported() {
+	if isResolvedDBusSupported() {
+		err = flushResolvedCaches()
+		if err != nil {
+			slog.Warn("Flush local DNS domain caches failed", "error", err.Error())
+		}
+	} else if isStubSupported() || isUplinkSupported() {
 		_, err = ncutils.RunCmd("resolvectl flush-caches", false)
 		if err != nil {
 			slog.Warn("Flush local DNS domain caches failed", "error", err.Error())
@@ -64,7 +73,9 @@ func FlushLocalDnsCache() (err error) {
 func SetupDNSConfig() (err error) {
 	dnsConfigMutex.Lock()
 	defer dnsConfigMutex.Unlock()
-	if isStubSupported() {
+	if isResolvedDBusSupported() {
+		err = setupResolvedDBus()
+	} else if isStubSupported() {
 		err = setupResolvectl()
 	} else if isUplinkSupported() {
 		err = setupResolveUplink()
@@ -84,7 +95,9 @@ func SetupDNSConfig() (err error) {
 func RestoreDNSConfig() (err error) {
 	dnsConfigMutex.Lock()
 	defer dnsConfigMutex.Unlock()
-	if isStubSupported() {
+	if isResolvedDBusSupported() {
+		err = restoreResolvedDBus()
+	} else if isStubSupported() {
 
 	} else if isUplinkSupported() {
 		err = restoreResolveUplink()
@@ -163,30 +176,14 @@ func setupResolvectl() (err error) {
 		slog.Warn("add DNS IP for netmaker failed", "error", err.Error())
 	}
 
+	linkDomains, matchAll := resolvedLinkDomains()
 	domains := ""
-	defaultDomain := config.GetServer(config.CurrServer).DefaultDomain
-	if defaultDomain != "" {
-		domains = defaultDomain
-	}
-
-	var matchAll bool
-
-	server := config.GetServer(config.CurrServer)
-	if server != nil {
-		for _, ns := range server.DnsNameservers {
-			if ns.IsFallback {
-				continue
-			}
-			if ns.MatchDomain != "." {
-				if ns.IsSearchDomain {
-					domains = domains + " " + ns.MatchDomain
-				} else {
-					// ~domain is treated as a routing only domain.
-					domains = domains + " ~" + ns.MatchDomain
-				}
-			} else {
-				matchAll = true
-			}
+	for _, d := range linkDomains {
+		if d.RoutingOnly {
+			// ~domain is treated as a routing only domain.
+			domains = domains + " ~" + d.Domain
+		} else {
+			domains = domains + " " + d.Domain
 		}
 	}
 
@@ -581,6 +578,13 @@ func restoreResolveconf() error {
 func InitDNSConfig() {
 	dnsConfigMutex.Lock()
 	defer dnsConfigMutex.Unlock()
+	// the d-bus backend is only chosen by config, when systemd-resolved manages the host dns
+	useDBus := config.Netclient().DNSManagerType == DNS_MANAGER_RESOLVED_DBUS
+	defer func() {
+		if useDBus && config.Netclient().DNSManagerType == DNS_MANAGER_STUB {
+			config.Netclient().DNSManagerType = DNS_MANAGER_RESOLVED_DBUS
+		}
+	}()
 	f, err := os.Open(resolvconfFilePath)
 	if err != nil {
 		slog.Error("error opending file", "error", resolvconfFilePath, err.Error())
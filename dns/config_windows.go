@@ -70,6 +70,10 @@ func SetupDNSConfig() error {
 			}
 		}
 	}
+	// route reverse lookups of network addresses to the local records
+	for _, zone := range networkReverseZones() {
+		matchDomainsMap[zone] = true
+	}
 
 	return configure(dnsIp, matchDomainsMap, searchDomainsMap, matchAllDomains)
 }
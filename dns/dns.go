@@ -8,6 +8,7 @@ import (
 
 	"github.com/gravitl/netmaker/models"
 	"github.com/miekg/dns"
+	"golang.org/x/exp/slog"
 )
 
 var dnsSyncMutex = sync.Mutex{} // used to mutex functions of the DNS
@@ -29,7 +30,7 @@ func newDNSRecord(name string, t uint16, dest string) dnsRecord {
 }
 
 func buildDNSEntryKey(name string, t uint16) string {
-	return fmt.Sprintf("%s.%d", name, t)
+	return fmt.Sprintf("%s.%d", recordName(name), t)
 }
 
 // Sync up the DNS entries with NM server
@@ -63,22 +64,45 @@ func SyncDNS(network string, dnsEntries []models.DNSEntry) error {
 	GetDNSResolverInstance().DnsEntriesCacheMap[network] = dnsEntryMap
 
 	//Refresh dns store
-	GetDNSResolverInstance().DnsEntriesCacheStore = make(map[string]dns.RR)
-	for _, v := range GetDNSResolverInstance().DnsEntriesCacheMap {
+	refreshDNSStore()
+
+	//drop forwarded responses the new records may shadow
+	dnsCache.flush()
+
+	//Flush local dns cache if any
+	FlushLocalDnsCache()
+
+	return nil
+}
+
+// ReloadLocalRecords - rebuilds the dns store with the configured local records
+func ReloadLocalRecords() {
+	dnsSyncMutex.Lock()
+	defer dnsSyncMutex.Unlock()
+	refreshDNSStore()
+}
+
+// refreshDNSStore - rebuilds the dns store from the records of all networks, the reverse records
+// of their addresses and the configured local records
+func refreshDNSStore() {
+	resolver := GetDNSResolverInstance()
+	dnsMapMutex.Lock()
+	resolver.DnsEntriesCacheStore = make(map[string][]dns.RR)
+	dnsMapMutex.Unlock()
+	for _, v := range resolver.DnsEntriesCacheMap {
 		for _, d := range v {
 			if d.Type == dns.TypeA {
-				GetDNSResolverInstance().RegisterA(d)
-				continue
+				resolver.RegisterA(d)
 			}
 			if d.Type == dns.TypeAAAA {
-				GetDNSResolverInstance().RegisterAAAA(d)
-				continue
+				resolver.RegisterAAAA(d)
+			}
+			if err := resolver.RegisterPTR(d); err != nil {
+				slog.Debug("failed to register PTR record", "name", d.Name, "error", err)
 			}
 		}
 	}
-
-	//Flush local dns cache if any
-	FlushLocalDnsCache()
-
-	return nil
+	for _, rr := range localRecords() {
+		resolver.RegisterRecord(rr)
+	}
 }
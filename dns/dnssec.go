@@ -0,0 +1,680 @@
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/logger"
+	"github.com/gravitl/netmaker/models"
+	"github.com/miekg/dns"
+)
+
+// rootTrustAnchors - DS records of the key signing keys of the root zone
+var rootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+const (
+	// dnssecMaxKeyTTL - upper bound on how long validated zone keys are cached
+	dnssecMaxKeyTTL = time.Hour
+	// dnssecInsecureTTL - how long a zone proven to be unsigned is cached
+	dnssecInsecureTTL = time.Minute * 5
+)
+
+// ErrDNSSECBogus - the response failed the dnssec validation
+var ErrDNSSECBogus = errors.New("dnssec validation failed")
+
+// zoneKeys - validated keys of a zone, no keys when the zone is proven to be unsigned
+type zoneKeys struct {
+	keys    []*dns.DNSKEY
+	secure  bool
+	expires time.Time
+}
+
+// keyCache - validated keys of the zones, keyed by zone
+type keyCache struct {
+	mu    sync.Mutex
+	zones map[string]zoneKeys
+}
+
+var dnssecKeyCache = &keyCache{zones: make(map[string]zoneKeys)}
+
+func (c *keyCache) get(zone string, now time.Time) (zoneKeys, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := c.zones[zone]
+	if ok && now.After(keys.expires) {
+		delete(c.zones, zone)
+		return zoneKeys{}, false
+	}
+	return keys, ok
+}
+
+func (c *keyCache) set(zone string, keys zoneKeys) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.zones[zone] = keys
+}
+
+// validatesDNSSEC - reports if the forwarded response of a query is validated, queries with the
+// CD bit and names under the opted out match domains are not validated
+func validatesDNSSEC(r *dns.Msg) bool {
+	if !config.Netclient().DNSSECValidation || r.CheckingDisabled {
+		return false
+	}
+	for _, domain := range config.Netclient().DNSSECInsecureDomains {
+		if dns.IsSubDomain(dns.Fqdn(strings.TrimPrefix(domain, ".")), r.Question[0].Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// forwardValidated - forwards the query with the DO bit set and validates the response. Secure
+// responses have the AD bit set, bogus responses are replaced with SERVFAIL
+func forwardValidated(r *dns.Msg, nameservers []models.Nameserver, strategy string) (*dns.Msg, string) {
+	q := r.Copy()
+	setDO(q)
+	resp, ns := forwardDNSQuery(q, nameservers, strategy)
+	if resp == nil || (resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError) {
+		return resp, ns
+	}
+	v := &dnssecValidator{
+		anchors: trustAnchors(),
+		now:     time.Now(),
+		resolve: func(name string, qtype uint16) (*dns.Msg, error) {
+			m := new(dns.Msg)
+			m.SetQuestion(name, qtype)
+			setDO(m)
+			resp, _ := forwardDNSQuery(m, nameservers, strategy)
+			if resp == nil {
+				return nil, fmt.Errorf("no response to %s %s", name, dns.TypeToString[qtype])
+			}
+			return resp, nil
+		},
+	}
+	secure, err := v.validate(resp)
+	if err != nil {
+		logger.Log(1, fmt.Sprintf("dnssec validation of %s from %s failed: %v", r.Question[0].Name, ns, err))
+		failure := new(dns.Msg)
+		failure.SetRcode(r, dns.RcodeServerFailure)
+		return failure, ns
+	}
+	resp.AuthenticatedData = secure
+	return resp, ns
+}
+
+// setValidatedReply - sets the AD bit of the reply to a validated response for clients asking for
+// it, and drops the dnssec records for clients that did not set the DO bit
+func setValidatedReply(r, reply, resp *dns.Msg) {
+	do := r.IsEdns0() != nil && r.IsEdns0().Do()
+	reply.AuthenticatedData = resp.AuthenticatedData && (do || r.AuthenticatedData)
+	if do {
+		return
+	}
+	qtype := r.Question[0].Qtype
+	strip := func(rrs []dns.RR) []dns.RR {
+		kept := rrs[:0]
+		for _, rr := range rrs {
+			switch rr.Header().Rrtype {
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+				if rr.Header().Rrtype != qtype {
+					continue
+				}
+			}
+			kept = append(kept, rr)
+		}
+		return kept
+	}
+	reply.Answer = strip(reply.Answer)
+	reply.Ns = strip(reply.Ns)
+}
+
+// setDO - sets the DO bit of the query, adding an OPT record when missing
+func setDO(m *dns.Msg) {
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetDo()
+		return
+	}
+	m.SetEdns0(dns.DefaultMsgSize, true)
+}
+
+// trustAnchors - configured trust anchors as DS records keyed by zone, the root zone keys by default
+func trustAnchors() map[string][]*dns.DS {
+	records := config.Netclient().DNSSECTrustAnchors
+	if len(records) == 0 {
+		records = rootTrustAnchors
+	}
+	anchors := make(map[string][]*dns.DS)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil || rr == nil {
+			logger.Log(0, fmt.Sprintf("skipping invalid dnssec trust anchor %q: %v", record, err))
+			continue
+		}
+		var ds *dns.DS
+		switch anchor := rr.(type) {
+		case *dns.DS:
+			ds = anchor
+		case *dns.DNSKEY:
+			ds = anchor.ToDS(dns.SHA256)
+		}
+		if ds == nil {
+			logger.Log(0, fmt.Sprintf("skipping dnssec trust anchor %q, not a DS or DNSKEY record", record))
+			continue
+		}
+		zone := strings.ToLower(ds.Hdr.Name)
+		anchors[zone] = append(anchors[zone], ds)
+	}
+	return anchors
+}
+
+// dnssecValidator - validates responses with the chain of trust from the trust anchors, looking up
+// the keys of the zones with resolve
+type dnssecValidator struct {
+	anchors map[string][]*dns.DS
+	now     time.Time
+	resolve func(name string, qtype uint16) (*dns.Msg, error)
+}
+
+// dnssecValidator.validate - reports if a response is secure, or an error when it is bogus. Unsigned
+// responses are insecure when their zone is proven to be unsigned
+func (v *dnssecValidator) validate(resp *dns.Msg) (bool, error) {
+	if len(resp.Answer) > 0 {
+		secure := true
+		for _, set := range rrsets(resp.Answer) {
+			setSecure, err := v.verifySet(set, resp.Answer)
+			if err != nil {
+				return false, err
+			}
+			secure = secure && setSecure
+		}
+		return secure, nil
+	}
+	// a negative response is secure when its signed NSEC or NSEC3 records prove the denial
+	denials := []dns.RR{}
+	for _, set := range rrsets(resp.Ns) {
+		setSecure, err := v.verifySet(set, resp.Ns)
+		if err != nil {
+			return false, err
+		}
+		if !setSecure {
+			return false, nil
+		}
+		if t := set[0].Header().Rrtype; t == dns.TypeNSEC || t == dns.TypeNSEC3 {
+			denials = append(denials, set...)
+		}
+	}
+	if len(denials) == 0 {
+		insecure, err := v.insecure(resp.Question[0].Name)
+		if err != nil {
+			return false, err
+		}
+		if !insecure {
+			return false, fmt.Errorf("%w: %s has no signed denial of existence", ErrDNSSECBogus, resp.Question[0].Name)
+		}
+		return false, nil
+	}
+	secure, proven := provesDenial(resp.Question[0], resp.Rcode == dns.RcodeNameError, denials)
+	if !proven {
+		return false, fmt.Errorf("%w: denial of %s %s is not proven", ErrDNSSECBogus, resp.Question[0].Name, dns.TypeToString[resp.Question[0].Qtype])
+	}
+	return secure, nil
+}
+
+// dnssecValidator.verifySet - verifies an rrset with the RRSIGs of the section, reports false when it
+// is not signed and its zone is proven to be unsigned
+func (v *dnssecValidator) verifySet(set []dns.RR, section []dns.RR) (bool, error) {
+	owner := set[0].Header().Name
+	sigs := rrsigs(set, section)
+	if len(sigs) == 0 {
+		insecure, err := v.insecure(owner)
+		if err != nil {
+			return false, err
+		}
+		if !insecure {
+			return false, fmt.Errorf("%w: %s %s is not signed", ErrDNSSECBogus, owner, dns.TypeToString[set[0].Header().Rrtype])
+		}
+		return false, nil
+	}
+	var lastErr error
+	for _, sig := range sigs {
+		if !dns.IsSubDomain(sig.SignerName, owner) {
+			lastErr = fmt.Errorf("%w: %s signed by %s outside of its zone", ErrDNSSECBogus, owner, sig.SignerName)
+			continue
+		}
+		keys, err := v.zoneKeys(sig.SignerName)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !keys.secure {
+			return false, nil
+		}
+		if err := v.verify(sig, keys.keys, set); err != nil {
+			lastErr = err
+			continue
+		}
+		return true, nil
+	}
+	return false, lastErr
+}
+
+// dnssecValidator.verify - verifies the signature of an rrset with the matching key
+func (v *dnssecValidator) verify(sig *dns.RRSIG, keys []*dns.DNSKEY, set []dns.RR) error {
+	if !sig.ValidityPeriod(v.now) {
+		return fmt.Errorf("%w: signature of %s %s has expired", ErrDNSSECBogus, sig.Hdr.Name, dns.TypeToString[sig.TypeCovered])
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, set); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no key of %s verifies the signature of %s %s", ErrDNSSECBogus, sig.SignerName, sig.Hdr.Name, dns.TypeToString[sig.TypeCovered])
+}
+
+// dnssecValidator.zoneKeys - returns the keys of a zone validated with the DS records of its parent
+// or the trust anchors
+func (v *dnssecValidator) zoneKeys(zone string) (zoneKeys, error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+	if keys, ok := dnssecKeyCache.get(zone, v.now); ok {
+		return keys, nil
+	}
+	ds, secure, err := v.zoneDS(zone)
+	if err != nil {
+		return zoneKeys{}, err
+	}
+	if !secure {
+		keys := zoneKeys{expires: v.now.Add(dnssecInsecureTTL)}
+		dnssecKeyCache.set(zone, keys)
+		return keys, nil
+	}
+	resp, err := v.resolve(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return zoneKeys{}, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+	}
+	keys := zoneKeys{secure: true, expires: v.now.Add(dnssecMaxKeyTTL)}
+	set := []dns.RR{}
+	for _, rr := range resp.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok && strings.EqualFold(key.Hdr.Name, zone) {
+			keys.keys = append(keys.keys, key)
+			set = append(set, key)
+			keys.expires = minExpiry(keys.expires, v.now, key.Hdr.Ttl)
+		}
+	}
+	// the key set is signed by one of the keys the DS records of the zone vouch for
+	trusted := []*dns.DNSKEY{}
+	for _, key := range keys.keys {
+		for _, d := range ds {
+			if key.KeyTag() == d.KeyTag && key.Algorithm == d.Algorithm && key.Flags&dns.ZONE != 0 {
+				if digest := key.ToDS(d.DigestType); digest != nil && strings.EqualFold(digest.Digest, d.Digest) {
+					trusted = append(trusted, key)
+				}
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		return zoneKeys{}, fmt.Errorf("%w: no DNSKEY of %s matches its DS records", ErrDNSSECBogus, zone)
+	}
+	var lastErr error
+	for _, sig := range rrsigs(set, resp.Answer) {
+		if lastErr = v.verify(sig, trusted, set); lastErr == nil {
+			dnssecKeyCache.set(zone, keys)
+			return keys, nil
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%w: DNSKEY of %s is not signed", ErrDNSSECBogus, zone)
+	}
+	return zoneKeys{}, lastErr
+}
+
+// dnssecValidator.zoneDS - returns the validated DS records of a zone, or false when the zone is
+// proven to be unsigned
+func (v *dnssecValidator) zoneDS(zone string) ([]*dns.DS, bool, error) {
+	if anchors, ok := v.anchors[zone]; ok {
+		return anchors, true, nil
+	}
+	if zone == "." {
+		// no trust anchor above the zone
+		return nil, false, nil
+	}
+	resp, err := v.resolve(zone, dns.TypeDS)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+	}
+	ds := []*dns.DS{}
+	set := []dns.RR{}
+	for _, rr := range resp.Answer {
+		if d, ok := rr.(*dns.DS); ok && strings.EqualFold(d.Hdr.Name, zone) {
+			ds = append(ds, d)
+			set = append(set, d)
+		}
+	}
+	if len(ds) > 0 {
+		secure, err := v.verifyParentSet(zone, set, resp.Answer)
+		return ds, secure, err
+	}
+	// without DS records the parent has to prove the delegation is unsigned
+	for _, set := range rrsets(resp.Ns) {
+		secure, err := v.verifyParentSet(zone, set, resp.Ns)
+		if err != nil || !secure {
+			return nil, false, err
+		}
+	}
+	if provesInsecureDelegation(zone, resp.Ns) {
+		return nil, false, nil
+	}
+	return nil, false, fmt.Errorf("%w: missing DS records of %s are not proven", ErrDNSSECBogus, zone)
+}
+
+// dnssecValidator.verifyParentSet - verifies the DS records of a zone or their denial, which are
+// signed by a parent of the zone. Keeping to the parents makes the walk up the chain of trust finite
+func (v *dnssecValidator) verifyParentSet(zone string, set []dns.RR, section []dns.RR) (bool, error) {
+	sigs := rrsigs(set, section)
+	if len(sigs) == 0 {
+		insecure, err := v.insecure(parentName(zone))
+		if err != nil {
+			return false, err
+		}
+		if !insecure {
+			return false, fmt.Errorf("%w: %s %s is not signed", ErrDNSSECBogus, set[0].Header().Name, dns.TypeToString[set[0].Header().Rrtype])
+		}
+		return false, nil
+	}
+	for _, sig := range sigs {
+		if strings.EqualFold(sig.SignerName, zone) || !dns.IsSubDomain(sig.SignerName, zone) {
+			return false, fmt.Errorf("%w: %s %s of %s signed by %s", ErrDNSSECBogus, set[0].Header().Name, dns.TypeToString[set[0].Header().Rrtype], zone, sig.SignerName)
+		}
+	}
+	return v.verifySet(set, section)
+}
+
+// parentName - name without its first label
+func parentName(name string) string {
+	i, end := dns.NextLabel(name, 0)
+	if end || i >= len(name) {
+		return "."
+	}
+	return name[i:]
+}
+
+// dnssecValidator.insecure - reports if name is in a zone proven to be unsigned
+func (v *dnssecValidator) insecure(name string) (bool, error) {
+	resp, err := v.resolve(name, dns.TypeSOA)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+	}
+	zone := ""
+	for _, rr := range append(append([]dns.RR{}, resp.Answer...), resp.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+			zone = soa.Hdr.Name
+			break
+		}
+	}
+	if zone == "" {
+		return false, fmt.Errorf("%w: zone of %s not found", ErrDNSSECBogus, name)
+	}
+	keys, err := v.zoneKeys(zone)
+	if err != nil {
+		return false, err
+	}
+	return !keys.secure, nil
+}
+
+// provesInsecureDelegation - reports if the NSEC or NSEC3 records prove zone is delegated without
+// DS records, or covered by an opt-out NSEC3 span
+func provesInsecureDelegation(zone string, ns []dns.RR) bool {
+	for _, rr := range ns {
+		switch denial := rr.(type) {
+		case *dns.NSEC:
+			if strings.EqualFold(denial.Hdr.Name, zone) {
+				return hasType(denial.TypeBitMap, dns.TypeNS) && !hasType(denial.TypeBitMap, dns.TypeDS) &&
+					!hasType(denial.TypeBitMap, dns.TypeSOA)
+			}
+		case *dns.NSEC3:
+			if denial.Match(zone) {
+				return hasType(denial.TypeBitMap, dns.TypeNS) && !hasType(denial.TypeBitMap, dns.TypeDS) &&
+					!hasType(denial.TypeBitMap, dns.TypeSOA)
+			}
+			if denial.Cover(zone) && denial.Flags&1 == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provesDenial - reports if the NSEC or NSEC3 records prove the name or the type of the question
+// does not exist (RFC 4035 section 5.4, RFC 5155 section 8). A proof relying on an opt-out NSEC3
+// span is not secure, as unsigned delegations in the span are not listed
+func provesDenial(q dns.Question, nxdomain bool, denials []dns.RR) (secure bool, proven bool) {
+	nsecs := []*dns.NSEC{}
+	nsec3s := []*dns.NSEC3{}
+	for _, rr := range denials {
+		switch denial := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, denial)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, denial)
+		}
+	}
+	if len(nsecs) > 0 {
+		proven = nsecProvesDenial(q, nxdomain, nsecs)
+		return proven, proven
+	}
+	return nsec3ProvesDenial(q, nxdomain, nsec3s)
+}
+
+// nsecProvesDenial - an NXDOMAIN needs NSEC records covering the name and the wildcard of its
+// closest encloser, a NODATA one matching the name or, for a wildcard, covering the name and
+// matching the wildcard, without the type in its bitmap
+func nsecProvesDenial(q dns.Question, nxdomain bool, nsecs []*dns.NSEC) bool {
+	if !nxdomain {
+		for _, nsec := range nsecs {
+			if strings.EqualFold(nsec.Hdr.Name, q.Name) {
+				return !hasType(nsec.TypeBitMap, q.Qtype) && !hasType(nsec.TypeBitMap, dns.TypeCNAME)
+			}
+		}
+	}
+	var cover *dns.NSEC
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, q.Name) {
+			cover = nsec
+			break
+		}
+	}
+	if cover == nil {
+		return false
+	}
+	// an empty non-terminal has no records of its own but names below it
+	if !nxdomain && dns.IsSubDomain(q.Name, cover.NextDomain) {
+		return true
+	}
+	wildcard := "*." + nsecClosestEncloser(q.Name, cover)
+	if wildcard == "*.." {
+		wildcard = "*."
+	}
+	for _, nsec := range nsecs {
+		if nxdomain && nsecCovers(nsec, wildcard) {
+			return true
+		}
+		if !nxdomain && strings.EqualFold(nsec.Hdr.Name, wildcard) {
+			return !hasType(nsec.TypeBitMap, q.Qtype) && !hasType(nsec.TypeBitMap, dns.TypeCNAME)
+		}
+	}
+	return false
+}
+
+// nsecCovers - reports if name falls between the owner and the next name of the NSEC in the
+// canonical order. An NSEC at a delegation or a DNAME does not prove anything about the names below it
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if !strings.EqualFold(owner, name) && dns.IsSubDomain(owner, name) &&
+		(hasType(nsec.TypeBitMap, dns.TypeDNAME) ||
+			(hasType(nsec.TypeBitMap, dns.TypeNS) && !hasType(nsec.TypeBitMap, dns.TypeSOA))) {
+		return false
+	}
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	// the last NSEC of the zone points back to the apex
+	return dns.IsSubDomain(next, name) && (canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0)
+}
+
+// nsecClosestEncloser - the longest ancestor of name that exists according to the NSEC covering it
+func nsecClosestEncloser(name string, cover *dns.NSEC) string {
+	common := dns.CompareDomainName(name, cover.Hdr.Name)
+	if n := dns.CompareDomainName(name, cover.NextDomain); n > common {
+		common = n
+	}
+	labels := dns.SplitDomainName(name)
+	return dns.Fqdn(strings.Join(labels[len(labels)-common:], "."))
+}
+
+// nsec3ProvesDenial - an NXDOMAIN needs the closest encloser proof and an NSEC3 covering its
+// wildcard, a NODATA an NSEC3 matching the name or the wildcard without the type in its bitmap.
+// A missing DS is proven by an opt-out span covering the delegation
+func nsec3ProvesDenial(q dns.Question, nxdomain bool, nsec3s []*dns.NSEC3) (secure bool, proven bool) {
+	if !nxdomain {
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(q.Name) {
+				proven = !hasType(nsec3.TypeBitMap, q.Qtype) && !hasType(nsec3.TypeBitMap, dns.TypeCNAME)
+				return proven, proven
+			}
+		}
+	}
+	encloser, optOut, ok := nsec3ClosestEncloser(q.Name, nsec3s)
+	if !ok {
+		return false, false
+	}
+	wildcard := "*." + encloser
+	if encloser == "." {
+		wildcard = "*."
+	}
+	for _, nsec3 := range nsec3s {
+		if nxdomain && nsec3.Cover(wildcard) {
+			return !optOut, true
+		}
+		if !nxdomain && nsec3.Match(wildcard) {
+			proven = !hasType(nsec3.TypeBitMap, q.Qtype) && !hasType(nsec3.TypeBitMap, dns.TypeCNAME)
+			return proven && !optOut, proven
+		}
+	}
+	if !nxdomain && q.Qtype == dns.TypeDS && optOut {
+		return false, true
+	}
+	return false, false
+}
+
+// nsec3ClosestEncloser - finds the closest encloser of name, the longest ancestor matching an NSEC3
+// with the next closer name covered by one, and reports if the covering NSEC3 has the opt-out flag
+func nsec3ClosestEncloser(name string, nsec3s []*dns.NSEC3) (encloser string, optOut bool, ok bool) {
+	labels := dns.SplitDomainName(name)
+	for i := 1; i <= len(labels); i++ {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		nextCloser := dns.Fqdn(strings.Join(labels[i-1:], "."))
+		matched := false
+		for _, nsec3 := range nsec3s {
+			if nsec3.Match(candidate) {
+				// a delegation or a DNAME is not an encloser of the names below it
+				matched = hasType(nsec3.TypeBitMap, dns.TypeSOA) || (!hasType(nsec3.TypeBitMap, dns.TypeNS) &&
+					!hasType(nsec3.TypeBitMap, dns.TypeDNAME))
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		for _, nsec3 := range nsec3s {
+			if nsec3.Cover(nextCloser) {
+				return candidate, nsec3.Flags&1 == 1, true
+			}
+		}
+		return "", false, false
+	}
+	return "", false, false
+}
+
+// canonicalCompare - compares two names in the canonical order of RFC 4034 section 6.1, label by
+// label from the root with the labels compared as lower case octets
+func canonicalCompare(a, b string) int {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := bytes.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// canonicalLabels - the labels of a name in wire format, with the escapes of the presentation format resolved
+func canonicalLabels(name string) [][]byte {
+	buf := make([]byte, 256)
+	end, err := dns.PackDomainName(dns.CanonicalName(name), buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	labels := [][]byte{}
+	for off := 0; off < end && buf[off] != 0; off += int(buf[off]) + 1 {
+		labels = append(labels, buf[off+1:off+1+int(buf[off])])
+	}
+	return labels
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// rrsets - groups the records of a section by owner and type, leaving out the signatures
+func rrsets(section []dns.RR) [][]dns.RR {
+	sets := [][]dns.RR{}
+	index := make(map[string]int)
+	for _, rr := range section {
+		t := rr.Header().Rrtype
+		if t == dns.TypeRRSIG || t == dns.TypeOPT {
+			continue
+		}
+		key := fmt.Sprintf("%s|%d", strings.ToLower(rr.Header().Name), t)
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+	return sets
+}
+
+// rrsigs - signatures of the section covering an rrset
+func rrsigs(set []dns.RR, section []dns.RR) []*dns.RRSIG {
+	sigs := []*dns.RRSIG{}
+	for _, rr := range section {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == set[0].Header().Rrtype &&
+			strings.EqualFold(sig.Hdr.Name, set[0].Header().Name) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+func minExpiry(expires, now time.Time, ttl uint32) time.Time {
+	if e := now.Add(time.Duration(ttl) * time.Second); e.Before(expires) {
+		return e
+	}
+	return expires
+}
@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"crypto"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signedZone struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, zone string) signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	require.NoError(t, err)
+	return signedZone{key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign - returns the rrset followed by its signature
+func (z signedZone) sign(t *testing.T, expiration time.Time, set ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: set[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		Algorithm:  z.key.Algorithm,
+		SignerName: z.key.Hdr.Name,
+		KeyTag:     z.key.KeyTag(),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	require.NoError(t, sig.Sign(z.priv, set))
+	return append(append([]dns.RR{}, set...), sig)
+}
+
+func rr(t *testing.T, s string) dns.RR {
+	r, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return r
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	keys := dnssecKeyCache
+	t.Cleanup(func() { dnssecKeyCache = keys })
+	dnssecKeyCache = &keyCache{zones: make(map[string]zoneKeys)}
+
+	valid := time.Now().Add(time.Hour)
+	root := newSignedZone(t, ".")
+	example := newSignedZone(t, "example.")
+	responses := map[string]*dns.Msg{}
+	respond := func(name string, qtype uint16, answer, ns []dns.RR) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		m.Answer, m.Ns = answer, ns
+		responses[fmt.Sprintf("%s|%d", name, qtype)] = m
+	}
+	exampleSOA := rr(t, "example. 3600 IN SOA ns.example. admin.example. 1 3600 600 86400 300")
+	respond(".", dns.TypeDNSKEY, root.sign(t, valid, root.key), nil)
+	respond("example.", dns.TypeDS, root.sign(t, valid, example.key.ToDS(dns.SHA256)), nil)
+	respond("example.", dns.TypeDNSKEY, example.sign(t, valid, example.key), nil)
+	respond("www.example.", dns.TypeSOA, nil, example.sign(t, valid, exampleSOA))
+	respond("insecure.example.", dns.TypeDS, nil, append(
+		example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "insecure.example. 300 IN NSEC z.example. NS RRSIG NSEC"))...))
+	respond("host.insecure.example.", dns.TypeSOA, nil, []dns.RR{
+		rr(t, "insecure.example. 3600 IN SOA ns.insecure.example. admin.insecure.example. 1 3600 600 86400 300")})
+
+	v := &dnssecValidator{
+		anchors: map[string][]*dns.DS{".": {root.key.ToDS(dns.SHA256)}},
+		now:     time.Now(),
+		resolve: func(name string, qtype uint16) (*dns.Msg, error) {
+			if m, ok := responses[fmt.Sprintf("%s|%d", name, qtype)]; ok {
+				return m, nil
+			}
+			return nil, fmt.Errorf("no response to %s %s", name, dns.TypeToString[qtype])
+		},
+	}
+	answer := func(name string, rrs ...dns.RR) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		m.Answer = rrs
+		return m
+	}
+
+	www := rr(t, "www.example. 300 IN A 192.0.2.1")
+	secure, err := v.validate(answer("www.example.", example.sign(t, valid, www)...))
+	require.NoError(t, err)
+	assert.True(t, secure)
+
+	tampered := example.sign(t, valid, www)
+	tampered[0] = rr(t, "www.example. 300 IN A 192.0.2.66")
+	_, err = v.validate(answer("www.example.", tampered...))
+	assert.ErrorIs(t, err, ErrDNSSECBogus)
+
+	_, err = v.validate(answer("www.example.", example.sign(t, time.Now().Add(-time.Minute), www)...))
+	assert.ErrorIs(t, err, ErrDNSSECBogus, "expired signature")
+
+	_, err = v.validate(answer("www.example.", www))
+	assert.ErrorIs(t, err, ErrDNSSECBogus, "unsigned answer in a signed zone")
+
+	secure, err = v.validate(answer("host.insecure.example.", rr(t, "host.insecure.example. 300 IN A 192.0.2.2")))
+	require.NoError(t, err)
+	assert.False(t, secure, "unsigned answer under a proven insecure delegation")
+
+	nxdomain := answer("missing.example.")
+	nxdomain.Rcode = dns.RcodeNameError
+	nxdomain.Ns = append(example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "example. 300 IN NSEC www.example. SOA NS RRSIG NSEC DNSKEY"))...)
+	secure, err = v.validate(nxdomain)
+	require.NoError(t, err)
+	assert.True(t, secure)
+
+	// a validly signed NSEC that does not cover the name proves nothing
+	nxdomain.Ns = append(example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "example. 300 IN NSEC abc.example. SOA NS RRSIG NSEC DNSKEY"))...)
+	_, err = v.validate(nxdomain)
+	assert.ErrorIs(t, err, ErrDNSSECBogus, "NSEC not covering the name")
+
+	nxdomain.Ns = append(example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "m.example. 300 IN NSEC n.example. A RRSIG NSEC"))...)
+	_, err = v.validate(nxdomain)
+	assert.ErrorIs(t, err, ErrDNSSECBogus, "wildcard not denied")
+
+	nodata := answer("www.example.")
+	nodata.Ns = append(example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "www.example. 300 IN NSEC z.example. A RRSIG NSEC"))...)
+	_, err = v.validate(nodata)
+	assert.ErrorIs(t, err, ErrDNSSECBogus, "NSEC listing the denied type")
+
+	nodata.Ns = append(example.sign(t, valid, exampleSOA),
+		example.sign(t, valid, rr(t, "www.example. 300 IN NSEC z.example. AAAA RRSIG NSEC"))...)
+	secure, err = v.validate(nodata)
+	require.NoError(t, err)
+	assert.True(t, secure)
+}
+
+// nsec3Chain - NSEC3 records chaining the hashes of the names of a zone, each with its types
+func nsec3Chain(t *testing.T, zone string, optOut bool, names map[string]string) []dns.RR {
+	hashes := []string{}
+	types := map[string]string{}
+	for name, bitmap := range names {
+		hash := dns.HashName(name, dns.SHA1, 0, "")
+		hashes = append(hashes, hash)
+		types[hash] = bitmap
+	}
+	sort.Strings(hashes)
+	flags := 0
+	if optOut {
+		flags = 1
+	}
+	chain := []dns.RR{}
+	for i, hash := range hashes {
+		next := hashes[(i+1)%len(hashes)]
+		chain = append(chain, rr(t, fmt.Sprintf("%s.%s 300 IN NSEC3 1 %d 0 - %s %s", strings.ToLower(hash), zone, flags, next, types[hash])))
+	}
+	return chain
+}
+
+func TestProvesDenial(t *testing.T) {
+	chain := nsec3Chain(t, "example.", false, map[string]string{
+		"example.":     "SOA NS DNSKEY RRSIG",
+		"www.example.": "A RRSIG",
+	})
+	tests := []struct {
+		name     string
+		q        dns.Question
+		nxdomain bool
+		denials  []dns.RR
+		secure   bool
+		proven   bool
+	}{
+		{"nsec3 nxdomain", dns.Question{Name: "missing.example.", Qtype: dns.TypeA}, true, chain, true, true},
+		{"nsec3 nxdomain of an existing name", dns.Question{Name: "www.example.", Qtype: dns.TypeA}, true, chain, false, false},
+		{"nsec3 nodata", dns.Question{Name: "www.example.", Qtype: dns.TypeAAAA}, false, chain, true, true},
+		{"nsec3 nodata of a listed type", dns.Question{Name: "www.example.", Qtype: dns.TypeA}, false, chain, false, false},
+		{"nsec3 nodata without a match", dns.Question{Name: "missing.example.", Qtype: dns.TypeA}, false, chain, false, false},
+		{"nsec3 opt-out nxdomain", dns.Question{Name: "missing.example.", Qtype: dns.TypeA}, true,
+			nsec3Chain(t, "example.", true, map[string]string{"example.": "SOA NS DNSKEY RRSIG"}), false, true},
+		{"nsec outside of the zone", dns.Question{Name: "missing.other.", Qtype: dns.TypeA}, true,
+			[]dns.RR{rr(t, "www.example. 300 IN NSEC example. A RRSIG NSEC")}, false, false},
+		{"nsec below a delegation", dns.Question{Name: "a.sub.example.", Qtype: dns.TypeA}, true,
+			[]dns.RR{rr(t, "sub.example. 300 IN NSEC www.example. NS RRSIG NSEC"),
+				rr(t, "example. 300 IN NSEC sub.example. SOA NS RRSIG NSEC DNSKEY")}, false, false},
+		{"nsec empty non-terminal", dns.Question{Name: "b.example.", Qtype: dns.TypeA}, false,
+			[]dns.RR{rr(t, "a.example. 300 IN NSEC a.b.example. A RRSIG NSEC")}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secure, proven := provesDenial(tt.q, tt.nxdomain, tt.denials)
+			assert.Equal(t, tt.proven, proven)
+			assert.Equal(t, tt.secure, secure)
+		})
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// the order of the example in RFC 4034 section 6.1
+	names := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.",
+		"z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example."}
+	for i := 1; i < len(names); i++ {
+		assert.Negative(t, canonicalCompare(names[i-1], names[i]), "%s before %s", names[i-1], names[i])
+	}
+}
+
+func TestSetValidatedReply(t *testing.T) {
+	r := query("www.example.", dns.TypeA)
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	resp.AuthenticatedData = true
+	resp.Answer = []dns.RR{
+		rr(t, "www.example. 300 IN A 192.0.2.1"),
+		rr(t, "www.example. 300 IN RRSIG A 13 2 300 20300101000000 20200101000000 1234 example. c2ln"),
+	}
+
+	reply := new(dns.Msg)
+	reply.SetReply(r)
+	setForwardedReply(reply, resp)
+	setValidatedReply(r, reply, resp)
+	assert.False(t, reply.AuthenticatedData)
+	assert.Len(t, reply.Answer, 1, "signatures dropped without the DO bit")
+	assert.Len(t, resp.Answer, 2, "response left untouched")
+
+	setDO(r)
+	reply = new(dns.Msg)
+	reply.SetReply(r)
+	setForwardedReply(reply, resp)
+	setValidatedReply(r, reply, resp)
+	assert.True(t, reply.AuthenticatedData)
+	assert.Len(t, reply.Answer, 2)
+}
+
+func TestTrustAnchors(t *testing.T) {
+	anchors := trustAnchors()
+	require.Len(t, anchors["."], 2, "root zone keys by default")
+	assert.Equal(t, uint16(20326), anchors["."][0].KeyTag)
+}
@@ -0,0 +1,254 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/logger"
+	"github.com/gravitl/netmaker/models"
+	"github.com/miekg/dns"
+)
+
+// strategies of forwarding a query to the best matching nameservers, set per server
+const (
+	DNS_FORWARD_SEQUENTIAL = "sequential" // one nameserver at a time, the next after a failure
+	DNS_FORWARD_STAGGER    = "stagger"    // the next nameserver also after a short delay without response
+	DNS_FORWARD_RACE       = "race"       // all nameservers at once
+)
+
+const (
+	// forwardStaggerDelay - delay before the next nameserver is queried by the stagger strategy
+	forwardStaggerDelay = time.Millisecond * 150
+	// upstreamDemoteFailures - consecutive failures after which a nameserver is demoted
+	upstreamDemoteFailures = 3
+	// upstreamDemoteTime - how long a nameserver is first demoted, doubled on every further failure
+	upstreamDemoteTime = time.Second * 30
+	// upstreamMaxDemoteTime - upper bound on the demotion of a nameserver
+	upstreamMaxDemoteTime = time.Minute * 5
+)
+
+// exchangeUpstream - sends a query to a nameserver
+var exchangeUpstream = exchangeDNSQueryWithPool
+
+// upstreamStats - health of a nameserver over time
+type upstreamStats struct {
+	// srtt - smoothed round trip time of the responses
+	srtt         time.Duration
+	failures     int
+	demotedUntil time.Time
+	queries      uint64
+	errors       uint64
+}
+
+// upstreamHealth - health of the nameservers queries were forwarded to, keyed by nameserver
+type upstreamHealth struct {
+	mu    sync.Mutex
+	stats map[string]*upstreamStats
+}
+
+var dnsUpstreamHealth = &upstreamHealth{stats: make(map[string]*upstreamStats)}
+
+func (h *upstreamHealth) get(ns string) *upstreamStats {
+	s, ok := h.stats[ns]
+	if !ok {
+		s = &upstreamStats{}
+		h.stats[ns] = s
+	}
+	return s
+}
+
+// upstreamHealth.success - records a response of the nameserver and its round trip time
+func (h *upstreamHealth) success(ns string, rtt time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(ns)
+	s.queries++
+	if s.srtt == 0 {
+		s.srtt = rtt
+	} else {
+		s.srtt = (7*s.srtt + rtt) / 8
+	}
+	s.failures = 0
+	s.demotedUntil = time.Time{}
+}
+
+// upstreamHealth.failure - records a failed query, demoting the nameserver after consecutive failures
+func (h *upstreamHealth) failure(ns string, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(ns)
+	s.queries++
+	s.errors++
+	s.failures++
+	if s.failures < upstreamDemoteFailures {
+		return
+	}
+	demote := upstreamDemoteTime << min(s.failures-upstreamDemoteFailures, 4)
+	s.demotedUntil = now.Add(min(demote, upstreamMaxDemoteTime))
+}
+
+// upstreamHealth.order - sorts the nameservers by health, demoted nameservers last and the others
+// by their round trip time. Nameservers without responses yet come first so they get measured
+func (h *upstreamHealth) order(nameservers []string, now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ordered := append([]string{}, nameservers...)
+	demoted := func(ns string) bool {
+		s, ok := h.stats[ns]
+		return ok && now.Before(s.demotedUntil)
+	}
+	srtt := func(ns string) time.Duration {
+		if s, ok := h.stats[ns]; ok {
+			return s.srtt
+		}
+		return 0
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if demoted(ordered[i]) != demoted(ordered[j]) {
+			return !demoted(ordered[i])
+		}
+		return srtt(ordered[i]) < srtt(ordered[j])
+	})
+	return ordered
+}
+
+// ValidForwardStrategy - reports if the strategy is one the resolver knows
+func ValidForwardStrategy(strategy string) bool {
+	switch strategy {
+	case DNS_FORWARD_SEQUENTIAL, DNS_FORWARD_STAGGER, DNS_FORWARD_RACE:
+		return true
+	}
+	return false
+}
+
+// ForwardStrategy - returns the strategy forwarding the queries of the server, the one set for the
+// server, else the one of the host config, else sequential
+func ForwardStrategy(server *config.Server) string {
+	if server != nil && ValidForwardStrategy(server.DNSForwardStrategy) {
+		return server.DNSForwardStrategy
+	}
+	if strategy := config.Netclient().DNSForwardStrategy; ValidForwardStrategy(strategy) {
+		return strategy
+	}
+	return DNS_FORWARD_SEQUENTIAL
+}
+
+// forwardStagger - delay between the queries to the nameservers of a strategy,
+// negative when the next nameserver is only queried after a failure
+func forwardStagger(strategy string) time.Duration {
+	switch strategy {
+	case DNS_FORWARD_RACE:
+		return 0
+	case DNS_FORWARD_STAGGER:
+		return forwardStaggerDelay
+	default:
+		return -1
+	}
+}
+
+// forwardDNSQuery - resolves the query with the best matching nameservers, trying the fallback
+// nameservers after the others. Returns the first answer, or the last negative response when no
+// nameserver had an answer, along with the nameserver it came from
+func forwardDNSQuery(r *dns.Msg, nameservers []models.Nameserver, strategy string) (*dns.Msg, string) {
+	var negative *upstreamResult
+	for _, fallback := range []bool{false, true} {
+		ips := []string{}
+		for _, nameserver := range nameservers {
+			if nameserver.IsFallback != fallback {
+				continue
+			}
+			for _, ip := range nameserver.IPs {
+				if !slices.Contains(ips, ip) {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		if len(ips) == 0 {
+			continue
+		}
+		if fallback {
+			logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with configured nameservers, falling back to fallback nameservers", r.Question[0].Name))
+		}
+		answer, tierNegative := exchangeNameservers(r, dnsUpstreamHealth.order(ips, time.Now()), forwardStagger(strategy))
+		if answer != nil {
+			return answer.resp, answer.ns
+		}
+		if tierNegative != nil {
+			negative = tierNegative
+		}
+	}
+	if negative == nil {
+		return nil, ""
+	}
+	return negative.resp, negative.ns
+}
+
+type upstreamResult struct {
+	ns   string
+	resp *dns.Msg
+	err  error
+	rtt  time.Duration
+}
+
+// exchangeNameservers - queries the nameservers in order, starting the next one when a query fails
+// or after the stagger delay, and returns the first answer. A negative stagger waits for each
+// query to complete. negative is the last NXDOMAIN or empty response when no nameserver answered
+func exchangeNameservers(r *dns.Msg, nameservers []string, stagger time.Duration) (answer, negative *upstreamResult) {
+	results := make(chan upstreamResult, len(nameservers))
+	exchange := exchangeUpstream
+	next, pending := 0, 0
+	launch := func() {
+		ns := nameservers[next]
+		next++
+		pending++
+		logger.Log(4, fmt.Sprintf("forwarding dns query %s to nameserver %s", r.Question[0].Name, ns))
+		go func(q *dns.Msg) {
+			start := time.Now()
+			resp, err := exchange(q, ns)
+			results <- upstreamResult{ns: ns, resp: resp, err: err, rtt: time.Since(start)}
+		}(r.Copy())
+	}
+	launch()
+	for pending > 0 {
+		var timer *time.Timer
+		var staggerC <-chan time.Time
+		if next < len(nameservers) && stagger >= 0 {
+			timer = time.NewTimer(stagger)
+			staggerC = timer.C
+		}
+		select {
+		case res := <-results:
+			pending--
+			switch {
+			case res.err != nil || res.resp == nil:
+				logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: %v", r.Question[0].Name, res.ns, res.err))
+				dnsUpstreamHealth.failure(res.ns, time.Now())
+			case res.resp.Rcode != dns.RcodeSuccess && res.resp.Rcode != dns.RcodeNameError:
+				logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: rcode %d", r.Question[0].Name, res.ns, res.resp.Rcode))
+				dnsUpstreamHealth.failure(res.ns, time.Now())
+			case res.resp.Rcode == dns.RcodeNameError || len(res.resp.Answer) == 0:
+				logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: rcode %d, no answer", r.Question[0].Name, res.ns, res.resp.Rcode))
+				dnsUpstreamHealth.success(res.ns, res.rtt)
+				negative = &res
+			default:
+				logger.Log(4, fmt.Sprintf("resolved dns query %s with nameserver %s: %v", r.Question[0].Name, res.ns, res.resp.Answer))
+				dnsUpstreamHealth.success(res.ns, res.rtt)
+				return &res, nil
+			}
+			// hand over to the next nameserver right away
+			if next < len(nameservers) {
+				launch()
+			}
+		case <-staggerC:
+			launch()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return nil, negative
+}
@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubUpstreams - replaces the exchange with nameservers answering after a delay, or failing
+// after the delay when their address is empty
+func stubUpstreams(t *testing.T, upstreams map[string]time.Duration, addrs map[string]string) *[]string {
+	exchange, health := exchangeUpstream, dnsUpstreamHealth
+	t.Cleanup(func() { exchangeUpstream, dnsUpstreamHealth = exchange, health })
+	dnsUpstreamHealth = &upstreamHealth{stats: make(map[string]*upstreamStats)}
+	var mu sync.Mutex
+	queried := []string{}
+	exchangeUpstream = func(r *dns.Msg, ns string) (*dns.Msg, error) {
+		mu.Lock()
+		queried = append(queried, ns)
+		mu.Unlock()
+		time.Sleep(upstreams[ns])
+		if addrs[ns] == "" {
+			return nil, errors.New("i/o timeout")
+		}
+		m := &dns.Msg{}
+		m.SetReply(r)
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(addrs[ns]),
+		}}
+		return m, nil
+	}
+	return &queried
+}
+
+func TestForwardStrategies(t *testing.T) {
+	nameservers := []models.Nameserver{
+		{MatchDomain: "corp.example", IPs: []string{"10.0.0.1", "10.0.0.2"}},
+		{MatchDomain: "corp.example", IPs: []string{"8.8.8.8"}, IsFallback: true},
+	}
+	delays := map[string]time.Duration{"10.0.0.1": time.Second, "10.0.0.2": 10 * time.Millisecond, "8.8.8.8": 0}
+	r := &dns.Msg{}
+	r.SetQuestion("db.corp.example.", dns.TypeA)
+
+	t.Run("race", func(t *testing.T) {
+		stubUpstreams(t, delays, map[string]string{"10.0.0.1": "10.1.1.1", "10.0.0.2": "10.2.2.2"})
+		start := time.Now()
+		resp, upstream := forwardDNSQuery(r, nameservers, DNS_FORWARD_RACE)
+		require.NotNil(t, resp)
+		assert.Equal(t, "10.0.0.2", upstream)
+		assert.Equal(t, "10.2.2.2", resp.Answer[0].(*dns.A).A.String())
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+	t.Run("stagger past a dead nameserver", func(t *testing.T) {
+		stubUpstreams(t, delays, map[string]string{"10.0.0.2": "10.2.2.2"})
+		resp, _ := forwardDNSQuery(r, nameservers, "")
+		require.NotNil(t, resp)
+		assert.Equal(t, "10.2.2.2", resp.Answer[0].(*dns.A).A.String())
+	})
+	t.Run("sequential falls back", func(t *testing.T) {
+		queried := stubUpstreams(t, map[string]time.Duration{}, map[string]string{"8.8.8.8": "10.8.8.8"})
+		resp, upstream := forwardDNSQuery(r, nameservers, DNS_FORWARD_SEQUENTIAL)
+		require.NotNil(t, resp)
+		assert.Equal(t, "8.8.8.8", upstream)
+		assert.Equal(t, "10.8.8.8", resp.Answer[0].(*dns.A).A.String())
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "8.8.8.8"}, *queried)
+	})
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	h := &upstreamHealth{stats: make(map[string]*upstreamStats)}
+	now := time.Now()
+	h.success("10.0.0.1", 40*time.Millisecond)
+	h.success("10.0.0.2", 10*time.Millisecond)
+	assert.Equal(t, []string{"10.0.0.3", "10.0.0.2", "10.0.0.1"}, h.order([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, now))
+
+	for i := 0; i < upstreamDemoteFailures; i++ {
+		h.failure("10.0.0.2", now)
+	}
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, h.order([]string{"10.0.0.2", "10.0.0.1"}, now))
+	assert.Equal(t, []string{"10.0.0.2", "10.0.0.1"}, h.order([]string{"10.0.0.2", "10.0.0.1"}, now.Add(upstreamDemoteTime)), "demotion expired")
+
+	for i := 0; i < 10; i++ {
+		h.failure("10.0.0.2", now)
+	}
+	assert.Equal(t, now.Add(upstreamMaxDemoteTime), h.stats["10.0.0.2"].demotedUntil)
+}
+
+func TestForwardStrategy(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	cfg := host
+	cfg.DNSForwardStrategy = ""
+	config.UpdateNetclient(cfg)
+
+	// servers configured before the strategy existed keep forwarding sequentially
+	assert.Equal(t, DNS_FORWARD_SEQUENTIAL, ForwardStrategy(nil))
+	assert.Equal(t, DNS_FORWARD_SEQUENTIAL, ForwardStrategy(&config.Server{}))
+	assert.Negative(t, forwardStagger(ForwardStrategy(&config.Server{})))
+
+	cfg.DNSForwardStrategy = DNS_FORWARD_RACE
+	config.UpdateNetclient(cfg)
+	assert.Equal(t, DNS_FORWARD_RACE, ForwardStrategy(&config.Server{}))
+	assert.Equal(t, DNS_FORWARD_RACE, ForwardStrategy(&config.Server{DNSForwardStrategy: "fastest"}))
+	assert.Equal(t, DNS_FORWARD_STAGGER, ForwardStrategy(&config.Server{DNSForwardStrategy: DNS_FORWARD_STAGGER}))
+}
@@ -2,6 +2,8 @@ package dns
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"runtime"
 	"slices"
 	"sync"
@@ -24,6 +26,7 @@ var dnsServer *DNSServer
 
 func init() {
 	dnsServer = &DNSServer{}
+	dns.HandleFunc(".", handleDNSRequest)
 }
 
 // GetInstance
@@ -31,6 +34,9 @@ func GetDNSServerInstance() *DNSServer {
 	return dnsServer
 }
 
+// listenTimeout - how long a listener has to bind its address
+const listenTimeout = time.Second
+
 // Start the DNS listener
 func (dnsServer *DNSServer) Start() {
 	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
@@ -46,68 +52,192 @@ func (dnsServer *DNSServer) Start() {
 		return
 	}
 
-	for _, v := range config.GetNodes() {
-		node := v
-		if v.Connected {
-			lAddr := []string{}
-			if node.Address.IP != nil {
-				lAddr = append(lAddr, node.Address.IP.String()+":53")
-			}
-			if node.Address6.IP != nil {
-				lAddr = append(lAddr, "["+node.Address6.IP.String()+"]:53")
-			}
+	dnsServer.reconcile()
+}
 
-			if len(lAddr) == 0 {
-				continue
-			}
-			for _, lIp := range lAddr {
-				dns.HandleFunc(".", handleDNSRequest)
-				srv := &dns.Server{
-					Net:       "udp",
-					Addr:      lIp,
-					UDPSize:   65535,
-					ReusePort: true,
-					ReuseAddr: true,
-				}
-
-				dnsServer.AddrStr = lIp
-				dnsServer.AddrList = append(dnsServer.AddrList, lIp)
-				dnsServer.DnsServer = append(dnsServer.DnsServer, srv)
-
-				go func(dnsServer *DNSServer) {
-					err := srv.ListenAndServe()
-					if err != nil {
-						slog.Error("error in starting dns server", "error", lIp, err.Error())
-						dnsServer.AddrStr = ""
-						dnsServer.AddrList = slices.Delete(dnsServer.AddrList, len(dnsServer.AddrList)-1, len(dnsServer.AddrList))
-						dnsServer.DnsServer = slices.Delete(dnsServer.DnsServer, len(dnsServer.DnsServer)-1, len(dnsServer.DnsServer))
-					}
-				}(dnsServer)
-			}
+// Reconcile - binds the DNS listener to the current addresses of the connected nodes, starting the
+// listeners of new addresses and stopping those of removed ones, and updates the host dns config
+func (dnsServer *DNSServer) Reconcile() {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		return
+	}
+	dnsMutex.Lock()
+	defer dnsMutex.Unlock()
+	dnsServer.reconcile()
+}
 
+// DNSServer.reconcile - callers hold dnsMutex
+func (dnsServer *DNSServer) reconcile() {
+	ReloadLocalRecords()
+	ReloadPolicy()
+
+	wanted := listenAddrs()
+	changed := false
+	for _, addr := range slices.Clone(dnsServer.AddrList) {
+		if !slices.Contains(wanted, addr) {
+			slog.Info("stopping dns listener of removed address", "address", addr)
+			dnsServer.shutdown(addr)
+			changed = true
+		}
+	}
+	for _, addr := range wanted {
+		if slices.Contains(dnsServer.AddrList, addr) {
+			continue
 		}
+		if err := dnsServer.listen(addr); err != nil {
+			slog.Error("error in starting dns server", "address", addr, "error", err.Error())
+			continue
+		}
+		changed = true
 	}
 
-	time.Sleep(time.Second * 1)
-	//if listener failed to start, do not make DNS changes
-	if len(dnsServer.AddrList) == 0 || len(dnsServer.DnsServer) == 0 {
+	//if no listener is left, do not make DNS changes
+	if len(dnsServer.AddrList) == 0 {
+		if changed {
+			dnsServer.updateHostDNS()
+		}
 		return
 	}
+	dnsServer.updateHostDNS()
 
-	//Setup DNS config for Linux
-	if config.Netclient().Host.OS == "linux" || config.Netclient().Host.OS == "windows" {
-		err := SetupDNSConfig()
-		if err != nil {
-			slog.Error("setup DNS config failed", "error", err.Error())
+	if changed {
+		slog.Info("DNS server listens on: ", "Info", dnsServer.AddrStr)
+	}
+}
+
+// listenAddrs - listen addresses of the connected nodes
+func listenAddrs() []string {
+	addrs := []string{}
+	for _, node := range config.GetNodes() {
+		if !node.Connected {
+			continue
 		}
+		if node.Address.IP != nil {
+			addrs = append(addrs, node.Address.IP.String()+":53")
+		}
+		if node.Address6.IP != nil {
+			addrs = append(addrs, "["+node.Address6.IP.String()+"]:53")
+		}
+	}
+	return addrs
+}
 
-		err = FlushLocalDnsCache()
-		if err != nil {
-			slog.Error("flush local DNS cache failed", "error", err.Error())
+// DNSServer.listen - starts the udp and tcp listeners of an address, callers hold dnsMutex
+func (dnsServer *DNSServer) listen(addr string) error {
+	srv := &dns.Server{
+		Net:       "udp",
+		Addr:      addr,
+		UDPSize:   65535,
+		ReusePort: true,
+		ReuseAddr: true,
+	}
+	// clients retry over tcp when a udp answer is truncated
+	tcpSrv := &dns.Server{
+		Net:       "tcp",
+		Addr:      addr,
+		ReusePort: true,
+		ReuseAddr: true,
+	}
+	if err := dnsServer.serve(srv); err != nil {
+		return err
+	}
+	dnsServer.AddrStr = addr
+	dnsServer.AddrList = append(dnsServer.AddrList, addr)
+	dnsServer.DnsServer = append(dnsServer.DnsServer, srv)
+	if err := dnsServer.serve(tcpSrv); err != nil {
+		slog.Error("error in starting dns tcp server", "address", addr, "error", err.Error())
+		return nil
+	}
+	dnsServer.DnsServer = append(dnsServer.DnsServer, tcpSrv)
+	return nil
+}
+
+// DNSServer.serve - serves srv in the background once it is bound to its address. A listener that
+// fails later on stops the listeners of its address
+func (dnsServer *DNSServer) serve(srv *dns.Server) error {
+	started := make(chan struct{})
+	failed := make(chan error, 1)
+	srv.NotifyStartedFunc = func() { close(started) }
+	go func() {
+		err := srv.ListenAndServe()
+		select {
+		case <-started:
+			if err == nil {
+				return
+			}
+			slog.Error("dns server failed", "address", srv.Addr, "net", srv.Net, "error", err.Error())
+			dnsMutex.Lock()
+			defer dnsMutex.Unlock()
+			if slices.Contains(dnsServer.DnsServer, srv) {
+				dnsServer.shutdown(srv.Addr)
+				dnsServer.updateHostDNS()
+			}
+		default:
+			if err == nil {
+				err = errors.New("dns server stopped")
+			}
+			failed <- err
 		}
+	}()
+	select {
+	case <-started:
+		return nil
+	case err := <-failed:
+		return err
+	case <-time.After(listenTimeout):
+		_ = srv.Shutdown()
+		return fmt.Errorf("dns server did not start within %s", listenTimeout)
 	}
+}
 
-	slog.Info("DNS server listens on: ", "Info", dnsServer.AddrStr)
+// DNSServer.shutdown - stops the listeners of an address, callers hold dnsMutex
+func (dnsServer *DNSServer) shutdown(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	dnsServer.DnsServer = slices.DeleteFunc(dnsServer.DnsServer, func(s *dns.Server) bool {
+		if s.Addr != addr {
+			return false
+		}
+		if err := s.ShutdownContext(ctx); err != nil {
+			slog.Debug("could not shutdown DNS server", "address", addr, "error", err.Error())
+		}
+		return true
+	})
+	dnsServer.AddrList = slices.DeleteFunc(dnsServer.AddrList, func(a string) bool { return a == addr })
+	dnsServer.AddrStr = ""
+	if len(dnsServer.AddrList) > 0 {
+		dnsServer.AddrStr = dnsServer.AddrList[len(dnsServer.AddrList)-1]
+	}
+}
+
+// DNSServer.updateHostDNS - points the host dns config at the listener, or restores it when no
+// listener is left. Callers hold dnsMutex
+func (dnsServer *DNSServer) updateHostDNS() {
+	if config.Netclient().Host.OS != "linux" && config.Netclient().Host.OS != "windows" {
+		return
+	}
+	if len(dnsServer.AddrList) == 0 {
+		if err := RestoreDNSConfig(); err != nil {
+			slog.Warn("Restore DNS conig failed", "error", err.Error())
+		}
+		return
+	}
+	err := SetupDNSConfig()
+	if err != nil {
+		slog.Error("setup DNS config failed", "error", err.Error())
+	}
+
+	err = FlushLocalDnsCache()
+	if err != nil {
+		slog.Error("flush local DNS cache failed", "error", err.Error())
+	}
+}
+
+// ListenAddrs returns the addresses the DNS listener is bound to
+func (dnsServer *DNSServer) ListenAddrs() []string {
+	dnsMutex.Lock()
+	defer dnsMutex.Unlock()
+	return slices.Clone(dnsServer.AddrList)
 }
 
 // Stop the DNS listener
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAddrs(t *testing.T) {
+	addr := func(cidr string) net.IPNet {
+		ip, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		network.IP = ip
+		return *network
+	}
+	config.UpdateNodeMap("net-a", config.Node{CommonNode: models.CommonNode{Network: "net-a", Connected: true,
+		Address: addr("100.64.0.1/16"), Address6: addr("fd00::1/64")}})
+	config.UpdateNodeMap("net-b", config.Node{CommonNode: models.CommonNode{Network: "net-b", Connected: false,
+		Address: addr("100.65.0.1/16")}})
+	t.Cleanup(func() {
+		config.DeleteNode("net-a")
+		config.DeleteNode("net-b")
+	})
+
+	assert.ElementsMatch(t, []string{"100.64.0.1:53", "[fd00::1]:53"}, listenAddrs(), "disconnected nodes are not bound")
+}
+
+func TestServe(t *testing.T) {
+	s := &DNSServer{}
+	srv := &dns.Server{Net: "udp", Addr: "127.0.0.1:0"}
+	require.NoError(t, s.serve(srv))
+	s.DnsServer = append(s.DnsServer, srv)
+	s.AddrList = append(s.AddrList, srv.Addr)
+	s.AddrStr = srv.Addr
+	s.shutdown(srv.Addr)
+	assert.Empty(t, s.DnsServer)
+	assert.Empty(t, s.AddrList)
+	assert.Empty(t, s.AddrStr)
+
+	assert.Error(t, s.serve(&dns.Server{Net: "udp", Addr: "256.0.0.1:53"}), "bind failure reported")
+}
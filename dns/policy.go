@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slog"
+)
+
+// answers to a blocked query, set per host
+const (
+	DNS_BLOCK_NXDOMAIN = "nxdomain" // authoritative NXDOMAIN
+	DNS_BLOCK_NULL     = "null"     // 0.0.0.0 for A and :: for AAAA queries, no data for other types
+)
+
+const (
+	// upstreamBlocked - upstream of the queries answered by the policy
+	upstreamBlocked = "blocked"
+	// blockedTTL - ttl of blocked answers, kept short so unblocked names resolve soon
+	blockedTTL = 60
+)
+
+// hostsOnlyNames - names of hosts files that are not blocked
+var hostsOnlyNames = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+}
+
+// dnsPolicy - names blocked by the blocklist files
+type dnsPolicy struct {
+	mu      sync.RWMutex
+	blocked map[string]struct{}
+}
+
+var queryPolicy = &dnsPolicy{blocked: make(map[string]struct{})}
+
+// ReloadPolicy - reloads the configured blocklist files, files that fail to load are skipped
+func ReloadPolicy() {
+	blocked := make(map[string]struct{})
+	for _, file := range config.Netclient().DNSBlocklists {
+		f, err := os.Open(file)
+		if err != nil {
+			slog.Warn("failed to open dns blocklist", "file", file, "error", err)
+			continue
+		}
+		err = loadBlocklist(f, blocked)
+		f.Close()
+		if err != nil {
+			slog.Warn("failed to read dns blocklist", "file", file, "error", err)
+		}
+	}
+	queryPolicy.mu.Lock()
+	queryPolicy.blocked = blocked
+	queryPolicy.mu.Unlock()
+	if len(blocked) > 0 {
+		slog.Info("loaded dns blocklists", "names", len(blocked))
+	}
+}
+
+// loadBlocklist - adds the names of a blocklist to blocked. Lines are either in hosts format,
+// an address followed by names, or a single domain. Comments start with #
+func loadBlocklist(r io.Reader, blocked map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		} else if len(fields) != 1 {
+			continue
+		}
+		for _, name := range fields {
+			name = recordName(name)
+			if _, ok := hostsOnlyNames[name]; ok || name == "" || net.ParseIP(name) != nil {
+				continue
+			}
+			blocked[name] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// dnsPolicy.blocks - reports if a query of the hosts of network is blocked by its rules or the blocklists
+func (p *dnsPolicy) blocks(name, network string) bool {
+	name = recordName(name)
+	policy := config.Netclient().DNSPolicies[network]
+	if matchesRule(name, policy.Allow) {
+		return false
+	}
+	if matchesRule(name, policy.Deny) {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.blocked) == 0 {
+		return false
+	}
+	for {
+		if _, ok := p.blocked[name]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(name, ".")
+		if !found {
+			return false
+		}
+		name = parent
+	}
+}
+
+// matchesRule - reports if name is one of the names of the rules or their subdomain
+func matchesRule(name string, rules []string) bool {
+	for _, rule := range rules {
+		rule = recordName(strings.TrimPrefix(rule, "*."))
+		if rule != "" && (name == rule || strings.HasSuffix(name, "."+rule)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockReply - answers a blocked query with the configured block response
+func blockReply(r, reply *dns.Msg) {
+	reply.Authoritative = true
+	if config.Netclient().DNSBlockResponse != DNS_BLOCK_NULL {
+		reply.Rcode = dns.RcodeNameError
+		return
+	}
+	q := r.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: blockedTTL}
+	switch q.Qtype {
+	case dns.TypeA:
+		reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: net.IPv4zero})
+	case dns.TypeAAAA:
+		reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero})
+	}
+}
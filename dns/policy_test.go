@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBlocklist(t *testing.T) {
+	blocked := make(map[string]struct{})
+	require.NoError(t, loadBlocklist(strings.NewReader(`# hosts format
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # inline comment
+:: ipv6.example.net
+0.0.0.0 0.0.0.0
+# domain list
+Malware.Example.org.
+
+not a valid line
+`), blocked))
+	assert.Equal(t, map[string]struct{}{
+		"ads.example.com":     {},
+		"tracker.example.com": {},
+		"ipv6.example.net":    {},
+		"malware.example.org": {},
+	}, blocked)
+}
+
+func TestPolicyBlocks(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	cfg := host
+	cfg.DNSPolicies = map[string]config.DNSPolicy{
+		"netmaker": {Allow: []string{"cdn.ads.example.com"}, Deny: []string{"*.social.example"}},
+	}
+	config.UpdateNetclient(cfg)
+	p := &dnsPolicy{blocked: map[string]struct{}{"ads.example.com": {}}}
+
+	assert.True(t, p.blocks("ads.example.com.", ""))
+	assert.True(t, p.blocks("x.ads.example.com.", "netmaker"), "subdomains blocked")
+	assert.False(t, p.blocks("example.com.", "netmaker"))
+	assert.False(t, p.blocks("cdn.ads.example.com.", "netmaker"), "allowed over the blocklist")
+	assert.True(t, p.blocks("cdn.ads.example.com.", "other"), "allow rule of another network")
+	assert.True(t, p.blocks("www.social.example.", "netmaker"))
+	assert.True(t, p.blocks("social.example.", "netmaker"))
+	assert.False(t, p.blocks("www.social.example.", "other"))
+}
+
+func TestBlockReply(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	reply := func(qtype uint16) *dns.Msg {
+		r := query("ads.example.com.", qtype)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		blockReply(r, m)
+		return m
+	}
+
+	m := reply(dns.TypeA)
+	assert.Equal(t, dns.RcodeNameError, m.Rcode)
+	assert.Empty(t, m.Answer)
+
+	cfg := host
+	cfg.DNSBlockResponse = DNS_BLOCK_NULL
+	config.UpdateNetclient(cfg)
+	m = reply(dns.TypeA)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	require.Len(t, m.Answer, 1)
+	assert.Equal(t, "0.0.0.0", m.Answer[0].(*dns.A).A.String())
+	m = reply(dns.TypeAAAA)
+	require.Len(t, m.Answer, 1)
+	assert.Equal(t, "::", m.Answer[0].(*dns.AAAA).AAAA.String())
+	m = reply(dns.TypeTXT)
+	assert.Equal(t, dns.RcodeSuccess, m.Rcode)
+	assert.Empty(t, m.Answer)
+}
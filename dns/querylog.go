@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"encoding/json"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/ncutils"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// upstreamLocal - upstream of the queries answered from the local records
+	upstreamLocal = "local"
+	// queryLogSize - the query log is rotated when it grows over this size
+	queryLogSize    = 10 << 20
+	queryLogBackups = 3
+	// queryLogQueue - records waiting to be written, records are dropped when the writer falls behind
+	queryLogQueue = 1024
+)
+
+// QueryLogFile - path of the local query log
+func QueryLogFile() string {
+	if runtime.GOOS == "linux" {
+		return "/var/log/netclient-dns.log"
+	}
+	return config.GetNetclientPath() + "netclient-dns.log"
+}
+
+// QueryRecord - a query answered by the resolver
+type QueryRecord struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Server    string    `json:"server"`
+	Proto     string    `json:"proto"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Rcode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Upstream  string    `json:"upstream,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	CacheHit  bool      `json:"cache_hit"`
+}
+
+var (
+	queryLogOnce sync.Once
+	queryLogCh   chan QueryRecord
+)
+
+// recordQuery - counts the query in the stats and, when enabled, logs it
+func recordQuery(w dns.ResponseWriter, r, reply *dns.Msg, upstream string, cacheHit bool, latency time.Duration) {
+	record := newQueryRecord(w.RemoteAddr(), w.LocalAddr(), r, reply, upstream, cacheHit, latency)
+	dnsQueryStats.record(record)
+	if !config.Netclient().DNSQueryLog {
+		return
+	}
+	queryLogOnce.Do(startQueryLog)
+	select {
+	case queryLogCh <- record:
+	default:
+		slog.Debug("dns query log is behind, dropping record", "name", record.Name)
+	}
+}
+
+func newQueryRecord(client, server net.Addr, r, reply *dns.Msg, upstream string, cacheHit bool, latency time.Duration) QueryRecord {
+	record := QueryRecord{
+		Time:      time.Now().Add(-latency),
+		Proto:     server.Network(),
+		Rcode:     dns.RcodeToString[reply.Rcode],
+		Answers:   len(reply.Answer),
+		Upstream:  upstream,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		CacheHit:  cacheHit,
+	}
+	if host, _, err := net.SplitHostPort(client.String()); err == nil {
+		record.Client = host
+	}
+	if host, _, err := net.SplitHostPort(server.String()); err == nil {
+		record.Server = host
+	}
+	if len(r.Question) > 0 {
+		record.Name = r.Question[0].Name
+		record.Type = dns.TypeToString[r.Question[0].Qtype]
+	}
+	return record
+}
+
+// startQueryLog - starts writing the queued records to the query log
+func startQueryLog() {
+	queryLogCh = make(chan QueryRecord, queryLogQueue)
+	logFile, err := ncutils.OpenRotatingFile(QueryLogFile(), queryLogSize, queryLogBackups)
+	if err != nil {
+		slog.Error("failed to open dns query log, records are dropped", "file", QueryLogFile(), "error", err)
+		return
+	}
+	go func() {
+		for record := range queryLogCh {
+			data, err := json.Marshal(record)
+			if err != nil {
+				continue
+			}
+			if _, err := logFile.Write(append(data, '\n')); err != nil {
+				slog.Debug("failed to write dns query log", "error", err)
+			}
+		}
+	}()
+}
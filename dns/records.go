@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/miekg/dns"
+	"golang.org/x/exp/slog"
+)
+
+// localRecordTypes - types of the records held by the local directory
+var localRecordTypes = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeSRV, dns.TypeTXT, dns.TypePTR}
+
+// recordName - key form of a record name, lower case without the trailing dot
+func recordName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Register a record of any type, records of the same name and type are served together
+func (d *DNSResolver) RegisterRecord(rr dns.RR) error {
+	dnsMapMutex.Lock()
+	defer dnsMapMutex.Unlock()
+
+	key := buildDNSEntryKey(rr.Header().Name, rr.Header().Rrtype)
+	d.DnsEntriesCacheStore[key] = append(d.DnsEntriesCacheStore[key], rr)
+
+	slog.Debug("registering record successfully", "Info", rr.String())
+
+	return nil
+}
+
+// Register the PTR record of the address of an A or AAAA record
+func (d *DNSResolver) RegisterPTR(record dnsRecord) error {
+	reverse, err := dns.ReverseAddr(record.RData)
+	if err != nil {
+		return err
+	}
+	r := new(dns.PTR)
+	r.Hdr = dns.RR_Header{Name: reverse, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttlTimeout}
+	r.Ptr = dns.Fqdn(record.Name)
+
+	return d.RegisterRecord(r)
+}
+
+// localRecords - parses the records configured for the local directory
+func localRecords() []dns.RR {
+	records := []dns.RR{}
+	for _, record := range config.Netclient().DNSRecords {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(record.Name), ttlTimeout, strings.ToUpper(record.Type), record.Value))
+		if err != nil || rr == nil {
+			slog.Warn("skipping invalid dns record", "name", record.Name, "type", record.Type, "error", err)
+			continue
+		}
+		records = append(records, rr)
+	}
+	return records
+}
+
+// inNetworkReverseZone - reports if name is the reverse name of an address in one of the
+// networks of the host
+func inNetworkReverseZone(name string) bool {
+	ip := reverseNameIP(name)
+	if ip == nil {
+		return false
+	}
+	for _, node := range config.GetNodes() {
+		if node.NetworkRange.IP != nil && node.NetworkRange.Contains(ip) {
+			return true
+		}
+		if node.NetworkRange6.IP != nil && node.NetworkRange6.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkOf - returns the network of the host whose address range holds ip
+func networkOf(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	for _, node := range config.GetNodes() {
+		if (node.NetworkRange.IP != nil && node.NetworkRange.Contains(ip)) ||
+			(node.NetworkRange6.IP != nil && node.NetworkRange6.Contains(ip)) {
+			return node.Network
+		}
+	}
+	return ""
+}
+
+// reverseNameIP - returns the address of a full in-addr.arpa or ip6.arpa name, nil for other names
+func reverseNameIP(name string) net.IP {
+	name = recordName(name)
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != net.IPv4len {
+			return nil
+		}
+		octets := make([]string, 0, net.IPv4len)
+		for i := len(labels) - 1; i >= 0; i-- {
+			octets = append(octets, labels[i])
+		}
+		return net.ParseIP(strings.Join(octets, ".")).To4()
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(labels) != net.IPv6len*2 {
+			return nil
+		}
+		var hex strings.Builder
+		for i := len(labels) - 1; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return nil
+			}
+			hex.WriteString(labels[i])
+			if i%4 == 0 && i > 0 {
+				hex.WriteString(":")
+			}
+		}
+		return net.ParseIP(hex.String())
+	}
+	return nil
+}
+
+// networkReverseZones - reverse zones covering the address ranges of the networks of the host
+func networkReverseZones() []string {
+	zones := []string{}
+	for _, node := range config.GetNodes() {
+		for _, network := range []net.IPNet{node.NetworkRange, node.NetworkRange6} {
+			for _, zone := range reverseZones(network) {
+				if !slices.Contains(zones, zone) {
+					zones = append(zones, zone)
+				}
+			}
+		}
+	}
+	return zones
+}
+
+// reverseZones - reverse zones covering exactly the address range. A range that does not end on an
+// octet (ipv4) or nibble (ipv6) boundary is split into the zones of its subnets at the next boundary,
+// so reverse lookups of addresses next to the range are left to the other nameservers of the host
+func reverseZones(network net.IPNet) []string {
+	if network.IP == nil {
+		return nil
+	}
+	ones, bits := network.Mask.Size()
+	ip := network.IP.Mask(network.Mask)
+	step := 4
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, step = ip4, 8
+	}
+	aligned := (ones + step - 1) / step * step
+	zones := []string{}
+	for i := 0; i < 1<<(aligned-ones); i++ {
+		subnet := slices.Clone(ip)
+		if aligned > ones {
+			subnet[(aligned-1)/8] |= byte(i) << ((8 - aligned%8) % 8)
+		}
+		if zone := reverseZone(net.IPNet{IP: subnet, Mask: net.CIDRMask(aligned, bits)}); zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+func reverseZone(network net.IPNet) string {
+	if network.IP == nil {
+		return ""
+	}
+	ones, _ := network.Mask.Size()
+	labels := []string{}
+	if ip := network.IP.To4(); ip != nil {
+		for i := ones/8 - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprint(ip[i]))
+		}
+		return strings.Join(append(labels, "in-addr.arpa"), ".")
+	}
+	ip := network.IP.To16()
+	if ip == nil {
+		return ""
+	}
+	for i := ones/4 - 1; i >= 0; i-- {
+		nibble := ip[i/2] >> 4
+		if i%2 == 1 {
+			nibble = ip[i/2] & 0x0f
+		}
+		labels = append(labels, fmt.Sprintf("%x", nibble))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func query(name string, t uint16) *dns.Msg {
+	m := &dns.Msg{}
+	m.SetQuestion(name, t)
+	return m
+}
+
+func TestLookupRecords(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	cfg := host
+	cfg.DNSRecords = []config.DNSRecord{
+		{Name: "db.netmaker", Type: "cname", Value: "node-1.netmaker."},
+		{Name: "www.netmaker", Type: "CNAME", Value: "db.netmaker."},
+		{Name: "ext.netmaker", Type: "CNAME", Value: "example.com."},
+		{Name: "_pg._tcp.netmaker", Type: "SRV", Value: "10 5 5432 node-1.netmaker."},
+		{Name: "_pg._tcp.netmaker", Type: "SRV", Value: "20 5 5432 node-2.netmaker."},
+		{Name: "node-1.netmaker", Type: "TXT", Value: `"role=primary"`},
+		{Name: "bad.netmaker", Type: "SRV", Value: "not a port"},
+	}
+	config.UpdateNetclient(cfg)
+	_, network, _ := net.ParseCIDR("100.64.0.0/16")
+	config.UpdateNodeMap("netmaker", config.Node{CommonNode: models.CommonNode{NetworkRange: *network}})
+	t.Cleanup(func() { config.DeleteNode("netmaker") })
+
+	d := &DNSResolver{DnsEntriesCacheStore: make(map[string][]dns.RR)}
+	record := newDNSRecord("node-1.netmaker", dns.TypeA, "100.64.0.1")
+	require.NoError(t, d.RegisterA(record))
+	require.NoError(t, d.RegisterPTR(record))
+	records := localRecords()
+	assert.Len(t, records, 6, "invalid record skipped")
+	for _, rr := range records {
+		require.NoError(t, d.RegisterRecord(rr))
+	}
+
+	answer, err := d.Lookup(query("WWW.netmaker.", dns.TypeA))
+	require.NoError(t, err)
+	require.Len(t, answer, 3, "cname chain followed to the address")
+	assert.Equal(t, "100.64.0.1", answer[2].(*dns.A).A.String())
+
+	answer, err = d.Lookup(query("ext.netmaker.", dns.TypeA))
+	require.NoError(t, err)
+	assert.Len(t, answer, 1, "target outside of the local records")
+
+	answer, err = d.Lookup(query("_pg._tcp.netmaker.", dns.TypeSRV))
+	require.NoError(t, err)
+	assert.Len(t, answer, 2)
+
+	_, err = d.Lookup(query("node-1.netmaker.", dns.TypeAAAA))
+	assert.ErrorIs(t, err, ErrNoQTypeRecord)
+
+	answer, err = d.Lookup(query("1.0.64.100.in-addr.arpa.", dns.TypePTR))
+	require.NoError(t, err)
+	assert.Equal(t, "node-1.netmaker.", answer[0].(*dns.PTR).Ptr)
+
+	_, err = d.Lookup(query("2.0.64.100.in-addr.arpa.", dns.TypePTR))
+	assert.ErrorIs(t, err, ErrNXReverseZone)
+	_, err = d.Lookup(query("2.0.0.10.in-addr.arpa.", dns.TypePTR))
+	assert.ErrorIs(t, err, ErrNXDomain)
+}
+
+func TestReverseNameIP(t *testing.T) {
+	for _, addr := range []string{"100.64.0.1", "fd00::1:2"} {
+		reverse, err := dns.ReverseAddr(addr)
+		require.NoError(t, err)
+		assert.Equal(t, net.ParseIP(addr).String(), reverseNameIP(reverse).String())
+	}
+	assert.Nil(t, reverseNameIP("64.100.in-addr.arpa."))
+	assert.Nil(t, reverseNameIP("node-1.netmaker."))
+}
+
+func TestReverseZone(t *testing.T) {
+	for cidr, zone := range map[string]string{
+		"100.64.0.0/16":  "64.100.in-addr.arpa",
+		"10.20.16.0/20":  "20.10.in-addr.arpa",
+		"192.168.1.0/24": "1.168.192.in-addr.arpa",
+		"fd00:ab::/64":   "0.0.0.0.0.0.0.0.b.a.0.0.0.0.d.f.ip6.arpa",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		assert.Equal(t, zone, reverseZone(*network), cidr)
+	}
+	assert.Empty(t, reverseZone(net.IPNet{}))
+}
+
+func TestReverseZones(t *testing.T) {
+	for cidr, zones := range map[string][]string{
+		"100.64.0.0/16": {"64.100.in-addr.arpa"},
+		"10.100.0.0/20": {
+			"0.100.10.in-addr.arpa", "1.100.10.in-addr.arpa", "2.100.10.in-addr.arpa", "3.100.10.in-addr.arpa",
+			"4.100.10.in-addr.arpa", "5.100.10.in-addr.arpa", "6.100.10.in-addr.arpa", "7.100.10.in-addr.arpa",
+			"8.100.10.in-addr.arpa", "9.100.10.in-addr.arpa", "10.100.10.in-addr.arpa", "11.100.10.in-addr.arpa",
+			"12.100.10.in-addr.arpa", "13.100.10.in-addr.arpa", "14.100.10.in-addr.arpa", "15.100.10.in-addr.arpa",
+		},
+		"10.20.128.0/17": nil,
+		"fd00:ab::/62": {
+			"0.0.0.0.0.0.0.0.b.a.0.0.0.0.d.f.ip6.arpa",
+			"1.0.0.0.0.0.0.0.b.a.0.0.0.0.d.f.ip6.arpa",
+			"2.0.0.0.0.0.0.0.b.a.0.0.0.0.d.f.ip6.arpa",
+			"3.0.0.0.0.0.0.0.b.a.0.0.0.0.d.f.ip6.arpa",
+		},
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		got := reverseZones(*network)
+		if zones == nil {
+			// a /17 is split into the 128 /24 zones of its upper half
+			require.Len(t, got, 128, cidr)
+			assert.Equal(t, "128.20.10.in-addr.arpa", got[0], cidr)
+			assert.Equal(t, "255.20.10.in-addr.arpa", got[127], cidr)
+			continue
+		}
+		assert.Equal(t, zones, got, cidr)
+	}
+	assert.Empty(t, reverseZones(net.IPNet{}))
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/ncutils"
+)
+
+// systemd-resolved manager on the system bus
+const (
+	resolvedBusName   = "org.freedesktop.resolve1"
+	resolvedPath      = "/org/freedesktop/resolve1"
+	resolvedInterface = "org.freedesktop.resolve1.Manager"
+)
+
+// resolvedObject - the calls made on the systemd-resolved manager, implemented by dbus.BusObject
+type resolvedObject interface {
+	Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call
+}
+
+// connectResolved - returns the systemd-resolved manager and a func closing its connection
+var connectResolved = func() (resolvedObject, func(), error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	return conn.Object(resolvedBusName, dbus.ObjectPath(resolvedPath)), func() { conn.Close() }, nil
+}
+
+// resolvedAddress - a dns server of a link, (iay) in the systemd-resolved api
+type resolvedAddress struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedDomain - a search or routing only domain of a link, (sb) in the systemd-resolved api
+type resolvedDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// resolvedLink - the dns config of a link in systemd-resolved
+type resolvedLink struct {
+	obj     resolvedObject
+	ifindex int32
+}
+
+func (l resolvedLink) call(method string, args ...interface{}) error {
+	args = append([]interface{}{l.ifindex}, args...)
+	if err := l.obj.Call(resolvedInterface+"."+method, 0, args...).Err; err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	return nil
+}
+
+// resolvedLink.configure - points the link at the dns server for the domains, reverting the link
+// when a step fails so the link is either fully configured or left to systemd-resolved
+func (l resolvedLink) configure(server net.IP, domains []resolvedDomain, defaultRoute bool) error {
+	addr := resolvedAddress{Family: syscall.AF_INET, Address: server.To4()}
+	if addr.Address == nil {
+		addr = resolvedAddress{Family: syscall.AF_INET6, Address: server.To16()}
+	}
+	err := l.call("SetLinkDNS", []resolvedAddress{addr})
+	if err == nil {
+		err = l.call("SetLinkDomains", domains)
+	}
+	if err == nil {
+		err = l.call("SetLinkDefaultRoute", defaultRoute)
+	}
+	if err != nil {
+		if revertErr := l.call("RevertLink"); revertErr != nil {
+			return fmt.Errorf("%w, %v", err, revertErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// resolvedLinkDomains - domains of the netmaker link, and whether the link is the default route
+// for the names matching no domain of any link
+func resolvedLinkDomains() ([]resolvedDomain, bool) {
+	domains := []resolvedDomain{}
+	var matchAll bool
+	server := config.GetServer(config.CurrServer)
+	if server != nil {
+		if server.DefaultDomain != "" {
+			domains = append(domains, resolvedDomain{Domain: server.DefaultDomain})
+		}
+		for _, ns := range server.DnsNameservers {
+			if ns.IsFallback {
+				continue
+			}
+			if ns.MatchDomain == "." {
+				matchAll = true
+				continue
+			}
+			domains = append(domains, resolvedDomain{Domain: ns.MatchDomain, RoutingOnly: !ns.IsSearchDomain})
+		}
+	}
+	// route reverse lookups of network addresses to the local records
+	for _, zone := range networkReverseZones() {
+		domains = append(domains, resolvedDomain{Domain: zone, RoutingOnly: true})
+	}
+	return domains, matchAll
+}
+
+func resolvedNetmakerLink(obj resolvedObject) (resolvedLink, error) {
+	iface, err := net.InterfaceByName(ncutils.GetInterfaceName())
+	if err != nil {
+		return resolvedLink{}, err
+	}
+	return resolvedLink{obj: obj, ifindex: int32(iface.Index)}, nil
+}
+
+// setupResolvedDBus - configures the dns of the netmaker link with systemd-resolved over d-bus
+func setupResolvedDBus() error {
+	dnsIp, err := getDnsIp()
+	if err != nil {
+		return err
+	}
+	server := net.ParseIP(dnsIp)
+	if server == nil {
+		return fmt.Errorf("invalid dns server address %q", dnsIp)
+	}
+	obj, closeConn, err := connectResolved()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+	link, err := resolvedNetmakerLink(obj)
+	if err != nil {
+		return err
+	}
+	domains, defaultRoute := resolvedLinkDomains()
+	if err := link.configure(server, domains, defaultRoute); err != nil {
+		return err
+	}
+	return obj.Call(resolvedInterface+".FlushCaches", 0).Err
+}
+
+// restoreResolvedDBus - drops the dns config of the netmaker link from systemd-resolved
+func restoreResolvedDBus() error {
+	obj, closeConn, err := connectResolved()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+	link, err := resolvedNetmakerLink(obj)
+	if err != nil {
+		// the link is gone along with its dns config
+		return nil
+	}
+	return link.call("RevertLink")
+}
+
+// flushResolvedCaches - flushes the caches of systemd-resolved over d-bus
+func flushResolvedCaches() error {
+	obj, closeConn, err := connectResolved()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+	return obj.Call(resolvedInterface+".FlushCaches", 0).Err
+}
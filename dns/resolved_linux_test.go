@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resolvedCall struct {
+	method string
+	args   []interface{}
+}
+
+// fakeResolved - records the calls made on the systemd-resolved manager, failing the named method
+type fakeResolved struct {
+	calls []resolvedCall
+	fail  string
+}
+
+func (f *fakeResolved) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	f.calls = append(f.calls, resolvedCall{method: method, args: args})
+	call := &dbus.Call{Method: method, Args: args}
+	if method == resolvedInterface+"."+f.fail {
+		call.Err = errors.New("access denied")
+	}
+	return call
+}
+
+func (f *fakeResolved) methods() []string {
+	methods := []string{}
+	for _, c := range f.calls {
+		methods = append(methods, c.method)
+	}
+	return methods
+}
+
+func TestResolvedLinkConfigure(t *testing.T) {
+	fake := &fakeResolved{}
+	link := resolvedLink{obj: fake, ifindex: 7}
+	domains := []resolvedDomain{{Domain: "netmaker"}, {Domain: "64.100.in-addr.arpa", RoutingOnly: true}}
+	require.NoError(t, link.configure(net.ParseIP("100.64.0.1"), domains, true))
+	require.Len(t, fake.calls, 3)
+	assert.Equal(t, resolvedCall{method: resolvedInterface + ".SetLinkDNS", args: []interface{}{int32(7),
+		[]resolvedAddress{{Family: syscall.AF_INET, Address: []byte{100, 64, 0, 1}}}}}, fake.calls[0])
+	assert.Equal(t, resolvedCall{method: resolvedInterface + ".SetLinkDomains", args: []interface{}{int32(7), domains}}, fake.calls[1])
+	assert.Equal(t, resolvedCall{method: resolvedInterface + ".SetLinkDefaultRoute", args: []interface{}{int32(7), true}}, fake.calls[2])
+
+	assert.Equal(t, "a(iay)a(sb)", dbus.SignatureOf([]resolvedAddress{}, domains).String(), "wire types of the resolved api")
+
+	fake = &fakeResolved{fail: "SetLinkDomains"}
+	link = resolvedLink{obj: fake, ifindex: 7}
+	err := link.configure(net.ParseIP("fd00::1"), domains, false)
+	assert.ErrorContains(t, err, "SetLinkDomains failed")
+	assert.Equal(t, []string{
+		resolvedInterface + ".SetLinkDNS",
+		resolvedInterface + ".SetLinkDomains",
+		resolvedInterface + ".RevertLink",
+	}, fake.methods(), "link reverted after a failed step")
+	assert.Equal(t, int32(syscall.AF_INET6), fake.calls[0].args[1].([]resolvedAddress)[0].Family)
+}
+
+func TestResolvedLinkDomains(t *testing.T) {
+	server := config.CurrServer
+	t.Cleanup(func() {
+		config.DeleteServer("test.netmaker")
+		config.CurrServer = server
+	})
+	config.CurrServer = "test.netmaker"
+	s := config.Server{}
+	s.DefaultDomain = "netmaker"
+	s.DnsNameservers = []models.Nameserver{
+		{MatchDomain: "corp.example", IsSearchDomain: true},
+		{MatchDomain: "internal.example"},
+		{MatchDomain: "fallback.example", IsFallback: true},
+	}
+	config.UpdateServer("test.netmaker", s)
+
+	domains, defaultRoute := resolvedLinkDomains()
+	assert.Equal(t, []resolvedDomain{
+		{Domain: "netmaker"},
+		{Domain: "corp.example"},
+		{Domain: "internal.example", RoutingOnly: true},
+	}, domains)
+	assert.False(t, defaultRoute)
+
+	s.DnsNameservers = append(s.DnsNameservers, models.Nameserver{MatchDomain: "."})
+	config.UpdateServer("test.netmaker", s)
+	_, defaultRoute = resolvedLinkDomains()
+	assert.True(t, defaultRoute, "match all domains")
+}
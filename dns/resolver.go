@@ -24,11 +24,12 @@ var dnsMapMutex sync.RWMutex // used to mutex functions of the DNS
 var (
 	ErrNXDomain      = errors.New("non existent domain")
 	ErrNoQTypeRecord = errors.New("domain exists but no record matching the question type")
+	ErrNXReverseZone = errors.New("no host with this address in the network")
 	dnsUDPConnPool   = newUDPConnPool()
 )
 
 type DNSResolver struct {
-	DnsEntriesCacheStore map[string]dns.RR
+	DnsEntriesCacheStore map[string][]dns.RR
 	DnsEntriesCacheMap   map[string][]dnsRecord
 }
 
@@ -36,7 +37,7 @@ var DnsResolver *DNSResolver
 
 func init() {
 	DnsResolver = &DNSResolver{
-		DnsEntriesCacheStore: make(map[string]dns.RR),
+		DnsEntriesCacheStore: make(map[string][]dns.RR),
 		DnsEntriesCacheMap:   make(map[string][]dnsRecord),
 	}
 }
@@ -48,25 +49,40 @@ func GetDNSResolverInstance() *DNSResolver {
 
 // ServeDNS handles a DNS request
 func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	var upstream string
+	var cacheHit bool
 	reply := &dns.Msg{}
 	reply.SetReply(r)
 	reply.RecursionAvailable = true
 	reply.RecursionDesired = true
 	reply.Rcode = dns.RcodeSuccess
 	logger.Log(4, fmt.Sprintf("resolving dns query %s", r.Question[0].Name))
-	if config.Netclient().CurrGwNmIP != nil {
-		logger.Log(4, fmt.Sprintf(
-			"connected to gw, forwarding dns query %s to gw %s",
-			r.Question[0].Name,
-			config.Netclient().CurrGwNmIP.String()),
-		)
-
-		resp, err := exchangeDNSQueryWithPool(r, config.Netclient().CurrGwNmIP.String())
-		if err != nil {
-			logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with gw %s: %v", r.Question[0].Name, config.Netclient().CurrGwNmIP.String(), err))
-		} else {
-			logger.Log(4, fmt.Sprintf("resolved dns query %s with gw %s: %v", r.Question[0].Name, config.Netclient().CurrGwNmIP.String(), resp.Answer))
-			reply.Answer = append(reply.Answer, resp.Answer...)
+	// the policy of the network the query was received on applies
+	var network string
+	if host, _, err := net.SplitHostPort(w.LocalAddr().String()); err == nil {
+		network = networkOf(net.ParseIP(host))
+	}
+	if queryPolicy.blocks(r.Question[0].Name, network) {
+		logger.Log(4, fmt.Sprintf("dns query %s blocked by policy", r.Question[0].Name))
+		blockReply(r, reply)
+		upstream = upstreamBlocked
+	} else if config.Netclient().CurrGwNmIP != nil {
+		gw := config.Netclient().CurrGwNmIP.String()
+		logger.Log(4, fmt.Sprintf("connected to gw, forwarding dns query %s to gw %s", r.Question[0].Name, gw))
+
+		var resp *dns.Msg
+		resp, upstream, cacheHit = resolveCached(r, "gw:"+gw, func(q *dns.Msg) (*dns.Msg, string) {
+			resp, err := exchangeDNSQueryWithPool(q, gw)
+			if err != nil {
+				logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with gw %s: %v", q.Question[0].Name, gw, err))
+				return nil, gw
+			}
+			return resp, gw
+		})
+		if resp != nil {
+			logger.Log(4, fmt.Sprintf("resolved dns query %s with gw %s: %v", r.Question[0].Name, gw, resp.Answer))
+			setForwardedReply(reply, resp)
 		}
 	} else {
 		query := canonicalizeDomainForMatching(r.Question[0].Name)
@@ -83,77 +99,36 @@ func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			} else {
 				logger.Log(4, fmt.Sprintf("resolved dns query %s with local records: %v", r.Question[0].Name, resp))
 				reply.Authoritative = true
-				reply.Answer = append(reply.Answer, resp)
+				reply.Answer = append(reply.Answer, resp...)
+				upstream = upstreamLocal
 			}
-			if len(reply.Answer) == 0 {
+			if errors.Is(err, ErrNXReverseZone) {
+				// reverse lookups of network addresses are answered locally only
+				reply.Authoritative = true
+				reply.Rcode = dns.RcodeNameError
+				upstream = upstreamLocal
+			} else if len(reply.Answer) == 0 {
 				bestMatchNameservers := findBestMatch(query, currServer.DnsNameservers)
-				for _, nameserver := range bestMatchNameservers {
-					if nameserver.IsFallback {
-						continue
-					}
-					var queryResolved bool
-					for _, ns := range nameserver.IPs {
-						logger.Log(4, fmt.Sprintf("found best match %s, forwarding dns query %s to nameserver %s", nameserver.MatchDomain, r.Question[0].Name, ns))
-
-						resp, err := exchangeDNSQueryWithPool(r, ns)
-						if err != nil || resp == nil || len(resp.Answer) == 0 {
-							if err != nil {
-								logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: %v", r.Question[0].Name, ns, err))
-							} else {
-								logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: no answer", r.Question[0].Name, ns))
-							}
-							continue
-						}
-
-						if resp.Rcode != dns.RcodeSuccess {
-							logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with nameserver %s: rcode %d", r.Question[0].Name, ns, resp.Rcode))
-							continue
-						}
-
-						if len(resp.Answer) > 0 {
-							logger.Log(4, fmt.Sprintf("resolved dns query %s with nameserver %s: %v", r.Question[0].Name, ns, resp.Answer))
-							reply.Answer = append(reply.Answer, resp.Answer...)
-							queryResolved = true
-							break
-						}
-					}
-					if queryResolved {
-						break
+				if len(bestMatchNameservers) > 0 {
+					matchDomain := canonicalizeDomainForMatching(bestMatchNameservers[0].MatchDomain)
+					validate := validatesDNSSEC(r)
+					cacheDomain := matchDomain
+					if r.CheckingDisabled && config.Netclient().DNSSECValidation {
+						// unvalidated responses are kept apart from the validated ones
+						cacheDomain = "cd:" + matchDomain
 					}
-				}
-
-				logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with configured nameservers, falling back to fallback nameservers", r.Question[0].Name))
-
-				for _, nameserver := range bestMatchNameservers {
-					if nameserver.IsFallback {
-						var queryResolved bool
-						for _, ns := range nameserver.IPs {
-							logger.Log(4, fmt.Sprintf("forwarding dns query %s to fallback nameserver %s", r.Question[0].Name, ns))
-
-							resp, err := exchangeDNSQueryWithPool(r, ns)
-							if err != nil || resp == nil || len(resp.Answer) == 0 {
-								if err != nil {
-									logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with fallback nameserver %s: %v", r.Question[0].Name, ns, err))
-								} else {
-									logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with fallback nameserver %s: no answer", r.Question[0].Name, ns))
-								}
-								continue
-							}
-
-							if resp.Rcode != dns.RcodeSuccess {
-								logger.Log(4, fmt.Sprintf("failed to resolve dns query %s with fallback nameserver %s: rcode %d", r.Question[0].Name, ns, resp.Rcode))
-								continue
-							}
-
-							if len(resp.Answer) > 0 {
-								logger.Log(4, fmt.Sprintf("resolved dns query %s with fallback nameserver %s: %v", r.Question[0].Name, ns, resp.Answer))
-								reply.Answer = append(reply.Answer, resp.Answer...)
-								queryResolved = true
-								break
-							}
+					strategy := ForwardStrategy(currServer)
+					var resp *dns.Msg
+					resp, upstream, cacheHit = resolveCached(r, cacheDomain, func(q *dns.Msg) (*dns.Msg, string) {
+						if validate {
+							return forwardValidated(q, bestMatchNameservers, strategy)
 						}
-						if queryResolved {
-							break
+						return forwardDNSQuery(q, bestMatchNameservers, strategy)
+					})
+					if resp != nil {
+						setForwardedReply(reply, resp)
+						if validate {
+							setValidatedReply(r, reply, resp)
 						}
 					}
 				}
@@ -161,7 +136,33 @@ func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		}
 	}
 
+	if w.LocalAddr().Network() == "udp" {
+		truncateReply(r, reply)
+	}
 	_ = w.WriteMsg(reply)
+	recordQuery(w, r, reply, upstream, cacheHit, time.Since(start))
+}
+
+// truncateReply - fits a udp reply in the payload size advertised by the client, setting the TC bit
+// when records had to be dropped so the client retries over tcp
+func truncateReply(r, reply *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+		reply.SetEdns0(dns.DefaultMsgSize, opt.Do())
+	}
+	reply.Truncate(size)
+}
+
+// setForwardedReply - copies the answer of a forwarded response into the reply, or its rcode and
+// authority records when it is a negative response
+func setForwardedReply(reply, resp *dns.Msg) {
+	if len(resp.Answer) > 0 {
+		reply.Answer = append(reply.Answer, resp.Answer...)
+		return
+	}
+	reply.Rcode = resp.Rcode
+	reply.Ns = append(reply.Ns, resp.Ns...)
 }
 
 // Register A record
@@ -173,7 +174,7 @@ func (d *DNSResolver) RegisterA(record dnsRecord) error {
 	r.Hdr = dns.RR_Header{Name: dns.Fqdn(record.Name), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttlTimeout}
 	r.A = net.ParseIP(record.RData)
 
-	d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)] = r
+	d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)] = []dns.RR{r}
 
 	slog.Debug("registering A record successfully", "Info", d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)])
 
@@ -189,42 +190,63 @@ func (d *DNSResolver) RegisterAAAA(record dnsRecord) error {
 	r.Hdr = dns.RR_Header{Name: dns.Fqdn(record.Name), Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttlTimeout}
 	r.AAAA = net.ParseIP(record.RData)
 
-	d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)] = r
+	d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)] = []dns.RR{r}
 
 	slog.Debug("registering AAAA record successfully", "Info", d.DnsEntriesCacheStore[buildDNSEntryKey(record.Name, record.Type)])
 
 	return nil
 }
 
-// Lookup DNS entry in local directory
-func (d *DNSResolver) Lookup(m *dns.Msg) (dns.RR, error) {
+// Lookup DNS entry in local directory, CNAME records are followed to their targets in the directory
+func (d *DNSResolver) Lookup(m *dns.Msg) ([]dns.RR, error) {
 	dnsMapMutex.RLock()
 	defer dnsMapMutex.RUnlock()
 	q := m.Question[0]
-	r, ok := d.DnsEntriesCacheStore[buildDNSEntryKey(strings.TrimSuffix(q.Name, "."), q.Qtype)]
-	if !ok {
-		switch q.Qtype {
-		case dns.TypeA:
-			_, ok = d.DnsEntriesCacheStore[buildDNSEntryKey(strings.TrimSuffix(q.Name, "."), dns.TypeAAAA)]
-			if ok {
-				// aware but no ipv6 address
-				return nil, ErrNoQTypeRecord
-			}
-		case dns.TypeAAAA:
-			_, ok = d.DnsEntriesCacheStore[buildDNSEntryKey(strings.TrimSuffix(q.Name, "."), dns.TypeA)]
-			if ok {
-				// aware but no ipv4 address
-				return nil, ErrNoQTypeRecord
-			}
+	name := recordName(q.Name)
+	answer := []dns.RR{}
+	visited := map[string]bool{}
+	for !visited[name] {
+		visited[name] = true
+		if rrs, ok := d.DnsEntriesCacheStore[buildDNSEntryKey(name, q.Qtype)]; ok {
+			return append(answer, rrs...), nil
 		}
-
-		return nil, ErrNXDomain
+		if q.Qtype == dns.TypeCNAME {
+			break
+		}
+		cname, ok := d.DnsEntriesCacheStore[buildDNSEntryKey(name, dns.TypeCNAME)]
+		if !ok {
+			break
+		}
+		answer = append(answer, cname...)
+		name = recordName(cname[0].(*dns.CNAME).Target)
+	}
+	if len(answer) > 0 {
+		// the chain leaves the local records, the client resolves the target
+		return answer, nil
+	}
+	for _, t := range localRecordTypes {
+		if _, ok := d.DnsEntriesCacheStore[buildDNSEntryKey(name, t)]; ok {
+			// aware but no record of the question type
+			return nil, ErrNoQTypeRecord
+		}
+	}
+	if q.Qtype == dns.TypePTR && inNetworkReverseZone(name) {
+		return nil, ErrNXReverseZone
 	}
 
-	return r, nil
+	return nil, ErrNXDomain
 }
 
+// exchangeDNSQueryWithPool - sends the query to the nameserver, over pooled UDP connections for an
+// ip and over TLS or HTTPS for a tls:// or https:// nameserver
 func exchangeDNSQueryWithPool(r *dns.Msg, ns string) (*dns.Msg, error) {
+	if isEncryptedUpstream(ns) {
+		return exchangeEncrypted(r, ns)
+	}
+	return exchangeDNSQueryUDP(r, ns)
+}
+
+func exchangeDNSQueryUDP(r *dns.Msg, ns string) (*dns.Msg, error) {
 	// Normalize IPv6 if needed
 	if strings.Contains(ns, ":") && !strings.HasPrefix(ns, "[") {
 		ns = "[" + ns + "]"
@@ -242,8 +264,21 @@ func exchangeDNSQueryWithPool(r *dns.Msg, ns string) (*dns.Msg, error) {
 		UDPSize: dns.DefaultMsgSize,
 	}
 
-	client := &dns.Client{Net: "udp", Timeout: time.Second * 3}
-	resp, _, err := client.ExchangeWithConn(r, dnsConn)
+	// advertise a large payload size so answers rarely need the tcp retry
+	q := r
+	if r.IsEdns0() == nil {
+		q = r.Copy()
+		q.SetEdns0(dns.DefaultMsgSize, false)
+	}
+
+	client := &dns.Client{Net: "udp", Timeout: upstreamTimeout}
+	resp, _, err := client.ExchangeWithConn(q, dnsConn)
+	if err != nil || !resp.Truncated {
+		return resp, err
+	}
+	logger.Log(4, fmt.Sprintf("dns response for %s from %s is truncated, retrying over tcp", r.Question[0].Name, serverAddr))
+	client = &dns.Client{Net: "tcp", Timeout: upstreamTimeout}
+	resp, _, err = client.Exchange(q, serverAddr)
 	return resp, err
 }
 
@@ -0,0 +1,38 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateReply(t *testing.T) {
+	reply := func(r *dns.Msg) *dns.Msg {
+		m := &dns.Msg{}
+		m.SetReply(r)
+		for i := 0; i < 20; i++ {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{strings.Repeat("x", 100)},
+			})
+		}
+		return m
+	}
+
+	r := &dns.Msg{}
+	r.SetQuestion("_svc._tcp.corp.example.", dns.TypeTXT)
+	m := reply(r)
+	truncateReply(r, m)
+	assert.True(t, m.Truncated)
+	assert.LessOrEqual(t, m.Len(), dns.MinMsgSize)
+
+	r.SetEdns0(4096, false)
+	m = reply(r)
+	truncateReply(r, m)
+	assert.False(t, m.Truncated)
+	assert.Len(t, m.Answer, 20)
+	require.NotNil(t, m.IsEdns0())
+}
@@ -0,0 +1,125 @@
+package dns
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxTrackedDomains - distinct names counted for the top domains, further names are not tracked
+const maxTrackedDomains = 10000
+
+// QueryStats - counters of the queries answered by the resolver since the daemon started
+type QueryStats struct {
+	Queries    uint64           `json:"queries"`
+	CacheHits  uint64           `json:"cache_hits"`
+	Local      uint64           `json:"local"`
+	Blocked    uint64           `json:"blocked"`
+	NXDomain   uint64           `json:"nxdomain"`
+	ServFail   uint64           `json:"servfail"`
+	TopDomains []DomainCount    `json:"top_domains"`
+	Upstreams  []UpstreamStatus `json:"upstreams"`
+}
+
+// DomainCount - queries for a name
+type DomainCount struct {
+	Name    string `json:"name"`
+	Queries uint64 `json:"queries"`
+}
+
+// UpstreamStatus - queries forwarded to a nameserver and its health
+type UpstreamStatus struct {
+	Nameserver string  `json:"nameserver"`
+	Queries    uint64  `json:"queries"`
+	Errors     uint64  `json:"errors"`
+	SRTTMs     float64 `json:"srtt_ms"`
+	Demoted    bool    `json:"demoted"`
+}
+
+type queryStats struct {
+	mu        sync.Mutex
+	queries   uint64
+	cacheHits uint64
+	local     uint64
+	blocked   uint64
+	nxdomain  uint64
+	servfail  uint64
+	domains   map[string]uint64
+}
+
+var dnsQueryStats = &queryStats{domains: make(map[string]uint64)}
+
+func (s *queryStats) record(record QueryRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	if record.CacheHit {
+		s.cacheHits++
+	}
+	switch record.Upstream {
+	case upstreamLocal:
+		s.local++
+	case upstreamBlocked:
+		s.blocked++
+	}
+	switch record.Rcode {
+	case dns.RcodeToString[dns.RcodeNameError]:
+		s.nxdomain++
+	case dns.RcodeToString[dns.RcodeServerFailure]:
+		s.servfail++
+	}
+	if _, ok := s.domains[record.Name]; ok || len(s.domains) < maxTrackedDomains {
+		s.domains[record.Name]++
+	}
+}
+
+// GetQueryStats - returns the counters of the resolver with the top most queried names
+func GetQueryStats(top int) QueryStats {
+	dnsQueryStats.mu.Lock()
+	stats := QueryStats{
+		Queries:    dnsQueryStats.queries,
+		CacheHits:  dnsQueryStats.cacheHits,
+		Local:      dnsQueryStats.local,
+		Blocked:    dnsQueryStats.blocked,
+		NXDomain:   dnsQueryStats.nxdomain,
+		ServFail:   dnsQueryStats.servfail,
+		TopDomains: []DomainCount{},
+	}
+	for name, queries := range dnsQueryStats.domains {
+		stats.TopDomains = append(stats.TopDomains, DomainCount{Name: name, Queries: queries})
+	}
+	dnsQueryStats.mu.Unlock()
+	sort.Slice(stats.TopDomains, func(i, j int) bool {
+		if stats.TopDomains[i].Queries != stats.TopDomains[j].Queries {
+			return stats.TopDomains[i].Queries > stats.TopDomains[j].Queries
+		}
+		return stats.TopDomains[i].Name < stats.TopDomains[j].Name
+	})
+	if top >= 0 && len(stats.TopDomains) > top {
+		stats.TopDomains = stats.TopDomains[:top]
+	}
+	stats.Upstreams = dnsUpstreamHealth.status(time.Now())
+	return stats
+}
+
+// upstreamHealth.status - the counters and health of the nameservers, sorted by nameserver
+func (h *upstreamHealth) status(now time.Time) []UpstreamStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := []UpstreamStatus{}
+	for ns, s := range h.stats {
+		status = append(status, UpstreamStatus{
+			Nameserver: ns,
+			Queries:    s.queries,
+			Errors:     s.errors,
+			SRTTMs:     float64(s.srtt.Microseconds()) / 1000,
+			Demoted:    now.Before(s.demotedUntil),
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Nameserver < status[j].Nameserver
+	})
+	return status
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryStats(t *testing.T) {
+	stats := dnsQueryStats
+	t.Cleanup(func() { dnsQueryStats = stats })
+	dnsQueryStats = &queryStats{domains: make(map[string]uint64)}
+
+	client := &net.UDPAddr{IP: net.ParseIP("100.64.0.2"), Port: 40000}
+	server := &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 53}
+	answered := func(name string, rcode int) (*dns.Msg, *dns.Msg) {
+		r := query(name, dns.TypeA)
+		reply := new(dns.Msg)
+		reply.SetRcode(r, rcode)
+		if rcode == dns.RcodeSuccess {
+			rr, err := dns.NewRR(name + " 60 IN A 100.64.0.3")
+			require.NoError(t, err)
+			reply.Answer = append(reply.Answer, rr)
+		}
+		return r, reply
+	}
+
+	r, reply := answered("node-1.netmaker.", dns.RcodeSuccess)
+	record := newQueryRecord(client, server, r, reply, upstreamLocal, false, time.Millisecond*2)
+	assert.Equal(t, "100.64.0.2", record.Client)
+	assert.Equal(t, "100.64.0.1", record.Server)
+	assert.Equal(t, "tcp", record.Proto)
+	assert.Equal(t, "A", record.Type)
+	assert.Equal(t, "NOERROR", record.Rcode)
+	assert.Equal(t, 1, record.Answers)
+	assert.Equal(t, 2.0, record.LatencyMs)
+	dnsQueryStats.record(record)
+	dnsQueryStats.record(record)
+
+	r, reply = answered("example.com.", dns.RcodeSuccess)
+	dnsQueryStats.record(newQueryRecord(client, server, r, reply, "1.1.1.1", true, 0))
+	r, reply = answered("missing.example.com.", dns.RcodeNameError)
+	dnsQueryStats.record(newQueryRecord(client, server, r, reply, "1.1.1.1", false, 0))
+	r, reply = answered("broken.example.com.", dns.RcodeServerFailure)
+	dnsQueryStats.record(newQueryRecord(client, server, r, reply, "", false, 0))
+
+	got := GetQueryStats(2)
+	assert.Equal(t, uint64(5), got.Queries)
+	assert.Equal(t, uint64(1), got.CacheHits)
+	assert.Equal(t, uint64(2), got.Local)
+	assert.Equal(t, uint64(1), got.NXDomain)
+	assert.Equal(t, uint64(1), got.ServFail)
+	require.Len(t, got.TopDomains, 2)
+	assert.Equal(t, DomainCount{Name: "node-1.netmaker.", Queries: 2}, got.TopDomains[0])
+	assert.Equal(t, "broken.example.com.", got.TopDomains[1].Name, "ties ordered by name")
+}
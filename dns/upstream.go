@@ -0,0 +1,259 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/logger"
+	"github.com/miekg/dns"
+)
+
+const (
+	upstreamSchemeTLS   = "tls"
+	upstreamSchemeHTTPS = "https"
+	// dotPort - default port of DNS-over-TLS upstreams
+	dotPort = "853"
+	// dohPath - default path of DNS-over-HTTPS upstreams
+	dohPath = "/dns-query"
+	// upstreamTimeout - timeout of a query to an upstream, including the connection setup
+	upstreamTimeout = time.Second * 3
+	// upstreamIdleTimeout - how long an idle connection to an encrypted upstream is reused
+	upstreamIdleTimeout = time.Second * 30
+	// upstreamMaxIdleConns - idle connections kept per encrypted upstream
+	upstreamMaxIdleConns = 4
+	// dohContentType - media type of DNS-over-HTTPS messages
+	dohContentType = "application/dns-message"
+)
+
+// upstream - an encrypted nameserver, given as tls://host[:port] or https://host[:port]/path
+type upstream struct {
+	raw    string
+	scheme string
+	// host - name or ip the certificate of the upstream is verified against
+	host string
+	// addr - host:port dialed for DNS-over-TLS
+	addr string
+	// url - endpoint of DNS-over-HTTPS
+	url string
+}
+
+// isEncryptedUpstream - reports if the nameserver is given as a tls:// or https:// url
+func isEncryptedUpstream(ns string) bool {
+	return strings.Contains(ns, "://")
+}
+
+// parseUpstream - parses a tls:// or https:// nameserver
+func parseUpstream(ns string) (upstream, error) {
+	u, err := url.Parse(ns)
+	if err != nil {
+		return upstream{}, fmt.Errorf("invalid nameserver %s: %w", ns, err)
+	}
+	if u.Hostname() == "" {
+		return upstream{}, fmt.Errorf("invalid nameserver %s: missing host", ns)
+	}
+	switch u.Scheme {
+	case upstreamSchemeTLS:
+		port := u.Port()
+		if port == "" {
+			port = dotPort
+		}
+		return upstream{raw: ns, scheme: u.Scheme, host: u.Hostname(), addr: net.JoinHostPort(u.Hostname(), port)}, nil
+	case upstreamSchemeHTTPS:
+		if u.Path == "" {
+			u.Path = dohPath
+		}
+		return upstream{raw: ns, scheme: u.Scheme, host: u.Hostname(), url: u.String()}, nil
+	default:
+		return upstream{}, fmt.Errorf("invalid nameserver %s: unsupported scheme %s", ns, u.Scheme)
+	}
+}
+
+// upstream.exchange - sends the query to the upstream over an encrypted connection
+func (u upstream) exchange(r *dns.Msg) (*dns.Msg, error) {
+	if u.scheme == upstreamSchemeHTTPS {
+		return exchangeHTTPS(r, u)
+	}
+	return exchangeTLS(r, u)
+}
+
+// upstream.fallbackAddr - ip of the upstream queried over UDP when the encrypted exchange fails
+func (u upstream) fallbackAddr() (string, error) {
+	if ip := net.ParseIP(u.host); ip != nil {
+		return u.host, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+	addrs, err := upstreamResolver.LookupHost(ctx, u.host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address found for %s", u.host)
+	}
+	return addrs[0], nil
+}
+
+// exchangeEncrypted - resolves the query with a tls:// or https:// nameserver, retrying it in
+// cleartext over UDP when the encrypted exchange fails and the fallback is enabled in the config
+func exchangeEncrypted(r *dns.Msg, ns string) (*dns.Msg, error) {
+	u, err := parseUpstream(ns)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.exchange(r)
+	if err == nil || !config.Netclient().DNSUDPFallback {
+		return resp, err
+	}
+	addr, lookupErr := u.fallbackAddr()
+	if lookupErr != nil {
+		return nil, errors.Join(err, lookupErr)
+	}
+	logger.Log(3, fmt.Sprintf("failed to resolve dns query %s with %s, falling back to udp %s: %v", r.Question[0].Name, ns, addr, err))
+	return exchangeDNSQueryUDP(r, addr)
+}
+
+// upstreamResolver - resolves the hostnames of encrypted upstreams with the nameservers the host
+// used before netclient managed its dns, so the lookups do not loop back through the local listener
+var upstreamResolver = &net.Resolver{
+	PreferGo: true,
+	Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		var d net.Dialer
+		for _, ns := range config.Netclient().NameServers {
+			if net.ParseIP(ns) != nil {
+				return d.DialContext(ctx, network, net.JoinHostPort(ns, "53"))
+			}
+		}
+		return d.DialContext(ctx, network, address)
+	},
+}
+
+var upstreamDialer = &net.Dialer{Timeout: upstreamTimeout, Resolver: upstreamResolver}
+
+func upstreamTLSConfig(serverName string) *tls.Config {
+	return &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+}
+
+// tlsConnPool - idle DNS-over-TLS connections, keyed by upstream
+type tlsConnPool struct {
+	mu   sync.Mutex
+	idle map[string][]idleConn
+}
+
+type idleConn struct {
+	conn  *dns.Conn
+	since time.Time
+}
+
+var dnsTLSConnPool = &tlsConnPool{idle: make(map[string][]idleConn)}
+
+// tlsConnPool.get - returns an idle connection to the upstream or dials a new one,
+// reused is set for a connection taken from the pool
+func (p *tlsConnPool) get(u upstream) (conn *dns.Conn, reused bool, err error) {
+	p.mu.Lock()
+	for conns := p.idle[u.raw]; len(conns) > 0; conns = p.idle[u.raw] {
+		c := conns[len(conns)-1]
+		p.idle[u.raw] = conns[:len(conns)-1]
+		if time.Since(c.since) < upstreamIdleTimeout {
+			p.mu.Unlock()
+			return c.conn, true, nil
+		}
+		c.conn.Close()
+	}
+	p.mu.Unlock()
+	tlsConn, err := tls.DialWithDialer(upstreamDialer, "tcp", u.addr, upstreamTLSConfig(u.host))
+	if err != nil {
+		return nil, false, err
+	}
+	return &dns.Conn{Conn: tlsConn}, false, nil
+}
+
+func (p *tlsConnPool) put(u upstream, conn *dns.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle[u.raw]) >= upstreamMaxIdleConns {
+		conn.Close()
+		return
+	}
+	p.idle[u.raw] = append(p.idle[u.raw], idleConn{conn: conn, since: time.Now()})
+}
+
+// exchangeTLS - sends the query over a pooled DNS-over-TLS connection. A reused connection the
+// upstream closed in the meantime is replaced by a new one
+func exchangeTLS(r *dns.Msg, u upstream) (*dns.Msg, error) {
+	client := &dns.Client{Net: "tcp-tls", Timeout: upstreamTimeout}
+	for {
+		conn, reused, err := dnsTLSConnPool.get(u)
+		if err != nil {
+			return nil, err
+		}
+		resp, _, err := client.ExchangeWithConn(r, conn)
+		if err != nil {
+			conn.Close()
+			if reused {
+				continue
+			}
+			return nil, err
+		}
+		dnsTLSConnPool.put(u, conn)
+		return resp, nil
+	}
+}
+
+// dohClient - http client of DNS-over-HTTPS upstreams, its transport keeps the connections alive
+var dohClient = &http.Client{
+	Timeout: upstreamTimeout,
+	Transport: &http.Transport{
+		DialContext:         upstreamDialer.DialContext,
+		TLSClientConfig:     &tls.Config{MinVersion: tls.VersionTLS12},
+		TLSHandshakeTimeout: upstreamTimeout,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConnsPerHost: upstreamMaxIdleConns,
+		IdleConnTimeout:     upstreamIdleTimeout,
+	},
+}
+
+// exchangeHTTPS - posts the query to a DNS-over-HTTPS endpoint as described in RFC 8484
+func exchangeHTTPS(r *dns.Msg, u upstream) (*dns.Msg, error) {
+	// the id is zeroed so responses stay cacheable by http caches
+	q := r.Copy()
+	q.Id = 0
+	data, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", u.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	m := &dns.Msg{}
+	if err := m.Unpack(body); err != nil {
+		return nil, err
+	}
+	m.Id = r.Id
+	return m, nil
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstream(t *testing.T) {
+	u, err := parseUpstream("tls://dns.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "dns.example.com", u.host)
+	assert.Equal(t, "dns.example.com:853", u.addr)
+
+	u, err = parseUpstream("tls://[2606:4700::1111]:8853")
+	require.NoError(t, err)
+	assert.Equal(t, "2606:4700::1111", u.host)
+	assert.Equal(t, "[2606:4700::1111]:8853", u.addr)
+
+	u, err = parseUpstream("https://1.1.1.1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://1.1.1.1/dns-query", u.url)
+
+	for _, ns := range []string{"quic://dns.example.com", "tls://", "https:///dns-query"} {
+		_, err := parseUpstream(ns)
+		assert.Error(t, err, ns)
+	}
+	assert.False(t, isEncryptedUpstream("10.0.0.53"))
+	assert.True(t, isEncryptedUpstream("https://dns.example.com/dns-query"))
+}
+
+func TestExchangeHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, dohContentType, r.Header.Get("Content-Type"))
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		q := &dns.Msg{}
+		require.NoError(t, q.Unpack(data))
+		assert.Zero(t, q.Id)
+		resp := &dns.Msg{}
+		resp.SetReply(q)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("10.0.0.1"),
+		}}
+		out, err := resp.Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(out)
+	}))
+	defer srv.Close()
+	client := dohClient
+	dohClient = srv.Client()
+	t.Cleanup(func() { dohClient = client })
+
+	u, err := parseUpstream(srv.URL)
+	require.NoError(t, err)
+	r := &dns.Msg{}
+	r.SetQuestion("db.corp.example.", dns.TypeA)
+	resp, err := u.exchange(r)
+	require.NoError(t, err)
+	assert.Equal(t, r.Id, resp.Id)
+	require.Len(t, resp.Answer, 1)
+	assert.Equal(t, "10.0.0.1", resp.Answer[0].(*dns.A).A.String())
+}
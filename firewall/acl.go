@@ -12,13 +12,24 @@ func ProcessAclRules(server string, fwUpdate *models.FwUpdate) {
 	if fwCrtl == nil {
 		return
 	}
-	if fwUpdate.AllowAll {
-		fwCrtl.ChangeACLInTarget(targetAccept)
-		fwCrtl.ChangeACLFwdTarget(targetAccept)
-	} else {
-		fwCrtl.ChangeACLInTarget(targetDrop)
-		fwCrtl.ChangeACLFwdTarget(targetDrop)
+	processAclRules(fwCrtl, server, fwUpdate)
+	aclTarget = aclDefaultTarget(fwUpdate.AllowAll)
+}
+
+// aclTarget - default target set on the acl in/fwd chains of the host
+var aclTarget = targetDrop
+
+func aclDefaultTarget(allowAll bool) string {
+	if allowAll {
+		return targetAccept
 	}
+	return targetDrop
+}
+
+func processAclRules(fwCrtl firewallController, server string, fwUpdate *models.FwUpdate) {
+	target := aclDefaultTarget(fwUpdate.AllowAll)
+	fwCrtl.ChangeACLInTarget(target)
+	fwCrtl.ChangeACLFwdTarget(target)
 
 	aclRules := fwUpdate.AclRules
 	ruleTable := fwCrtl.FetchRuleTable(server, aclTable)
@@ -48,7 +59,8 @@ func ProcessAclRules(server string, fwUpdate *models.FwUpdate) {
 				(len(localAclRule.AllowedPorts) != len(aclRule.AllowedPorts)) ||
 				(!reflect.DeepEqual(localAclRule.AllowedPorts, aclRule.AllowedPorts)) ||
 				(aclRule.AllowedProtocol != localAclRule.AllowedProtocol) ||
-				(localAclRule.Direction != aclRule.Direction) {
+				(localAclRule.Direction != aclRule.Direction) ||
+				(installedAclLimit(ruleCfg.rulesMap[aclRule.ID]) != aclLimit(aclRule.ID)) {
 				fwCrtl.DeleteAclRule(server, aclRule.ID)
 				fwCrtl.UpsertAclRule(server, aclRule)
 			}
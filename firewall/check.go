@@ -0,0 +1,225 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+)
+
+// Flow - a connection to evaluate against the installed acl rules
+type Flow struct {
+	Src   net.IP `json:"src"`
+	Dst   net.IP `json:"dst"`
+	Proto string `json:"proto"`
+	Port  int    `json:"port"`
+	// Forward - the flow is routed out of the netmaker interface, as for an egress range
+	Forward bool `json:"forward"`
+}
+
+// CheckResult - verdict of a flow and the rule deciding it, the rule is empty
+// when the flow falls through to the default target of the chain
+type CheckResult struct {
+	Chain     string          `json:"chain"`
+	Verdict   string          `json:"verdict"`
+	RuleTable string          `json:"rule_table,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	AclRule   *models.AclRule `json:"acl_rule,omitempty"`
+	Rule      string          `json:"rule,omitempty"`
+	Notes     []string        `json:"notes,omitempty"`
+}
+
+// Check - evaluates a new flow against the acl rules installed for the server and the default targets
+func Check(server string, flow Flow) (CheckResult, error) {
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if fwCrtl == nil {
+		return CheckResult{}, errors.New("firewall is not initialized yet")
+	}
+	return checkFlow(fwCrtl, server, aclTarget, flow), nil
+}
+
+func checkFlow(fwCrtl firewallController, server, target string, flow Flow) CheckResult {
+	result := CheckResult{
+		Chain:   aclInputRulesChain,
+		Verdict: target,
+	}
+	if flow.Forward {
+		result.Chain = aclFwdRulesChain
+	}
+	// acl policies only accept, so the first match decides.
+	// Static node drops are installed after them, ahead of the default target
+	if flow.Forward {
+		egressRules := fwCrtl.FetchRuleTable(server, egressTable)
+		for _, key := range sortedKeys(egressRules) {
+			aclRules, ok := egressRules[key].extraInfo.(map[string]models.AclRule)
+			if !ok {
+				continue
+			}
+			for _, aclID := range sortedKeys(aclRules) {
+				aclRule := aclRules[aclID]
+				if aclRuleMatch(aclRule, flow) {
+					return result.matched(egressTable, key, aclRule)
+				}
+				result.reverseNote(aclRule, flow)
+			}
+		}
+		return result
+	}
+	aclRules := fwCrtl.FetchRuleTable(server, aclTable)
+	for _, aclID := range sortedKeys(aclRules) {
+		aclRule, ok := aclRules[aclID].extraInfo.(models.AclRule)
+		if !ok {
+			continue
+		}
+		if aclRuleMatch(aclRule, flow) {
+			return result.matched(aclTable, aclID, aclRule)
+		}
+		result.reverseNote(aclRule, flow)
+	}
+	ingressRules := fwCrtl.FetchRuleTable(server, ingressTable)
+	for _, key := range sortedKeys(ingressRules) {
+		ingressInfo, ok := ingressRules[key].extraInfo.(models.IngressInfo)
+		if !ok {
+			continue
+		}
+		for _, rule := range ingressInfo.Rules {
+			if rule.Allow && fwRuleMatch(rule, flow) {
+				result.Verdict = targetAccept
+				result.RuleTable = ingressTable
+				result.Key = key
+				result.Rule = fmt.Sprintf("allow %s -> %s %s", rule.SrcIP.String(), rule.DstIP.String(), protoPorts(rule.AllowedProtocol, rule.AllowedPorts))
+				result.Notes = nil
+				return result
+			}
+		}
+	}
+	for _, key := range sortedKeys(ingressRules) {
+		ingressInfo, ok := ingressRules[key].extraInfo.(models.IngressInfo)
+		if !ok {
+			continue
+		}
+		for _, ip := range ingressInfo.StaticNodeIps {
+			if ip.Equal(flow.Src) || ip.Equal(flow.Dst) {
+				result.Verdict = targetDrop
+				result.RuleTable = ingressTable
+				result.Key = key
+				result.Rule = "drop static node " + ip.String()
+				return result
+			}
+		}
+	}
+	return result
+}
+
+func (r CheckResult) matched(ruleTable, key string, aclRule models.AclRule) CheckResult {
+	r.Verdict = targetAccept
+	r.RuleTable = ruleTable
+	r.Key = key
+	r.AclRule = &aclRule
+	direction := "one-way"
+	if aclRule.Direction == models.TrafficDirectionBi {
+		direction = "two-way"
+	}
+	r.Rule = fmt.Sprintf("policy %s %s, %s", aclRule.ID, protoPorts(aclRule.AllowedProtocol, aclRule.AllowedPorts), direction)
+	r.Notes = nil
+	if limit := aclLimit(aclRule.ID); limit != (config.AclLimit{}) {
+		r.Notes = append(r.Notes, fmt.Sprintf("accepted within the limits of policy %s: %d conns, %d packets/s, %d bytes/s (0 is unlimited)",
+			aclRule.ID, limit.MaxConns, limit.PacketsPerSec, limit.BytesPerSec))
+	}
+	return r
+}
+
+// CheckResult.reverseNote - notes a one way policy that would match the flow in the other direction
+func (r *CheckResult) reverseNote(aclRule models.AclRule, flow Flow) {
+	if aclRule.Direction != models.TrafficDirectionUni {
+		return
+	}
+	reverse := flow
+	reverse.Src, reverse.Dst = flow.Dst, flow.Src
+	if aclRuleMatch(aclRule, reverse) {
+		r.Notes = append(r.Notes, fmt.Sprintf("policy %s only allows %s -> %s", aclRule.ID, flow.Dst, flow.Src))
+	}
+}
+
+// aclRuleMatch - reports if the rules installed for the acl policy accept the flow
+func aclRuleMatch(aclRule models.AclRule, flow Flow) bool {
+	srcs, dsts := aclRule.IPList, aclRule.Dst
+	if flow.Src.To4() == nil {
+		srcs, dsts = aclRule.IP6List, aclRule.Dst6
+	}
+	if !ipNetsContain(srcs, flow.Src) {
+		return false
+	}
+	if len(dsts) > 0 && !ipNetsContain(dsts, flow.Dst) {
+		return false
+	}
+	if hasProtoMatch(aclRule) && !strings.EqualFold(aclRule.AllowedProtocol.String(), flow.Proto) {
+		return false
+	}
+	return portMatch(aclRule.AllowedPorts, flow.Port)
+}
+
+// fwRuleMatch - reports if the rule installed for an ingress fw rule accepts the flow
+func fwRuleMatch(rule models.FwRule, flow Flow) bool {
+	if !ipNetsContain([]net.IPNet{rule.SrcIP}, flow.Src) {
+		return false
+	}
+	if rule.DstIP.IP != nil && !ipNetsContain([]net.IPNet{rule.DstIP}, flow.Dst) {
+		return false
+	}
+	if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL &&
+		!strings.EqualFold(rule.AllowedProtocol.String(), flow.Proto) {
+		return false
+	}
+	return portMatch(rule.AllowedPorts, flow.Port)
+}
+
+func ipNetsContain(ipNets []net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ipNet.IP != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// portMatch - reports if the port is allowed, ports are single ports or ranges as "8000-9000"
+func portMatch(allowedPorts []string, port int) bool {
+	if len(allowedPorts) == 0 {
+		return true
+	}
+	for _, allowed := range allowedPorts {
+		first, last, isRange := strings.Cut(strings.ReplaceAll(allowed, ":", "-"), "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			continue
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil {
+			continue
+		}
+		if port >= start && port <= end {
+			return true
+		}
+	}
+	return false
+}
+
+func protoPorts(proto models.Protocol, ports []string) string {
+	desc := "any protocol"
+	if proto.String() != "" && proto != models.ALL {
+		desc = proto.String()
+	}
+	if len(ports) > 0 {
+		desc += " ports " + strings.Join(ports, ",")
+	}
+	return desc
+}
@@ -0,0 +1,133 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckFlow(t *testing.T) {
+	m := newTestFirewall(t, models.FIREWALL_NFTABLES)
+	applyFwUpdate(m, testServer, &models.FwUpdate{
+		AclRules: map[string]models.AclRule{
+			"ssh": {
+				ID:              "ssh",
+				IPList:          []net.IPNet{cidr(t, "100.64.0.0/24")},
+				Dst:             []net.IPNet{cidr(t, "100.64.0.1/32")},
+				AllowedProtocol: models.TCP,
+				AllowedPorts:    []string{"22"},
+				Direction:       models.TrafficDirectionBi,
+			},
+			"monitor": {
+				ID:              "monitor",
+				IPList:          []net.IPNet{cidr(t, "100.64.0.1/32")},
+				AllowedProtocol: models.UDP,
+				AllowedPorts:    []string{"9000-9100"},
+				Direction:       models.TrafficDirectionUni,
+			},
+		},
+		IsEgressGw: true,
+		EgressInfo: map[string]models.EgressInfo{
+			"gw1": {
+				EgressID:     "gw1",
+				EgressGwAddr: cidr(t, "100.64.0.1/32"),
+				EgressGWCfg: models.EgressGatewayRequest{
+					RangesWithMetric: []models.EgressRangeMetric{{Network: "10.10.0.0/24"}},
+				},
+				EgressFwRules: map[string]models.AclRule{
+					"db": {
+						ID:              "db",
+						IPList:          []net.IPNet{cidr(t, "100.64.0.2/32")},
+						Dst:             []net.IPNet{cidr(t, "10.10.0.5/32")},
+						AllowedProtocol: models.TCP,
+						AllowedPorts:    []string{"5432"},
+					},
+				},
+			},
+		},
+		IsIngressGw: true,
+		IngressInfo: map[string]models.IngressInfo{
+			"ingress1": {
+				IngressID:     "ingress1",
+				StaticNodeIps: []net.IP{net.ParseIP("100.64.0.50")},
+			},
+		},
+	})
+
+	cases := []struct {
+		name    string
+		flow    Flow
+		chain   string
+		verdict string
+		key     string
+		notes   int
+	}{
+		{
+			name:    "policy allows port",
+			flow:    Flow{Src: net.ParseIP("100.64.0.7"), Dst: net.ParseIP("100.64.0.1"), Proto: "tcp", Port: 22},
+			chain:   aclInputRulesChain,
+			verdict: targetAccept,
+			key:     "ssh",
+		},
+		{
+			name:    "port not allowed by policy",
+			flow:    Flow{Src: net.ParseIP("100.64.0.7"), Dst: net.ParseIP("100.64.0.1"), Proto: "tcp", Port: 80},
+			chain:   aclInputRulesChain,
+			verdict: targetDrop,
+		},
+		{
+			name:    "protocol not allowed by policy",
+			flow:    Flow{Src: net.ParseIP("100.64.0.7"), Dst: net.ParseIP("100.64.0.1"), Proto: "udp", Port: 22},
+			chain:   aclInputRulesChain,
+			verdict: targetDrop,
+		},
+		{
+			name:    "port within range",
+			flow:    Flow{Src: net.ParseIP("100.64.0.1"), Dst: net.ParseIP("100.64.0.9"), Proto: "udp", Port: 9050},
+			chain:   aclInputRulesChain,
+			verdict: targetAccept,
+			key:     "monitor",
+		},
+		{
+			name:    "one way policy in reverse direction",
+			flow:    Flow{Src: net.ParseIP("100.64.0.9"), Dst: net.ParseIP("100.64.0.1"), Proto: "udp", Port: 9050},
+			chain:   aclInputRulesChain,
+			verdict: targetDrop,
+			notes:   1,
+		},
+		{
+			name:    "static node is dropped",
+			flow:    Flow{Src: net.ParseIP("100.64.0.50"), Dst: net.ParseIP("100.64.1.1"), Proto: "tcp", Port: 443},
+			chain:   aclInputRulesChain,
+			verdict: targetDrop,
+			key:     "ingress1",
+		},
+		{
+			name:    "egress policy allows forwarded flow",
+			flow:    Flow{Src: net.ParseIP("100.64.0.2"), Dst: net.ParseIP("10.10.0.5"), Proto: "tcp", Port: 5432, Forward: true},
+			chain:   aclFwdRulesChain,
+			verdict: targetAccept,
+			key:     "acl#gw1",
+		},
+		{
+			name:    "egress destination not allowed",
+			flow:    Flow{Src: net.ParseIP("100.64.0.2"), Dst: net.ParseIP("10.10.0.6"), Proto: "tcp", Port: 5432, Forward: true},
+			chain:   aclFwdRulesChain,
+			verdict: targetDrop,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := checkFlow(m, testServer, targetDrop, tc.flow)
+			assert.Equal(t, tc.chain, result.Chain)
+			assert.Equal(t, tc.verdict, result.Verdict)
+			assert.Equal(t, tc.key, result.Key)
+			assert.Len(t, result.Notes, tc.notes)
+		})
+	}
+	result := checkFlow(m, testServer, targetAccept, Flow{Src: net.ParseIP("100.64.0.7"), Dst: net.ParseIP("100.64.0.1"), Proto: "tcp", Port: 80})
+	assert.Equal(t, targetAccept, result.Verdict, "allow all target")
+	assert.Empty(t, result.Rule)
+}
@@ -10,7 +10,7 @@ import (
 	"golang.org/x/exp/slog"
 )
 
-func processEgressFwRules(server string, egressUpdate map[string]models.EgressInfo) {
+func processEgressFwRules(fwCrtl firewallController, server string, egressUpdate map[string]models.EgressInfo) {
 	ruleTable := fwCrtl.FetchRuleTable(server, egressTable)
 	for _, egressInfoI := range egressUpdate {
 		aclRules := egressInfoI.EgressFwRules
@@ -41,7 +41,8 @@ func processEgressFwRules(server string, egressUpdate map[string]models.EgressIn
 					(aclRule.AllowedProtocol != localAclRule.AllowedProtocol) ||
 					(localAclRule.Direction != aclRule.Direction) ||
 					(!reflect.DeepEqual(localAclRule.Dst, aclRule.Dst)) ||
-					(len(localAclRule.Dst6) != len(aclRule.Dst6)) {
+					(len(localAclRule.Dst6) != len(aclRule.Dst6)) ||
+					(installedAclLimit(ruleCfg.rulesMap[aclRule.ID]) != aclLimit(aclRule.ID)) {
 					fwCrtl.DeleteAclEgressRule(server, egressAclID, aclRule.ID)
 					fwCrtl.UpsertAclEgressRule(server, egressAclID, aclRule)
 				}
@@ -74,6 +75,10 @@ func SetEgressRoutes(server string, egressUpdate map[string]models.EgressInfo) e
 	if fwCrtl == nil {
 		return errors.New("firewall is not initialized yet")
 	}
+	return setEgressRoutes(fwCrtl, server, egressUpdate)
+}
+
+func setEgressRoutes(fwCrtl firewallController, server string, egressUpdate map[string]models.EgressInfo) error {
 	ruleTable := fwCrtl.FetchRuleTable(server, egressTable)
 	for egressNodeID := range ruleTable {
 		if _, ok := egressUpdate[egressNodeID]; !ok {
@@ -96,7 +101,7 @@ func SetEgressRoutes(server string, egressUpdate map[string]models.EgressInfo) e
 			}
 		}
 	}
-	processEgressFwRules(server, egressUpdate)
+	processEgressFwRules(fwCrtl, server, egressUpdate)
 	return nil
 }
 
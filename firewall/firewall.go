@@ -1,8 +1,10 @@
 package firewall
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/gravitl/netclient/config"
 	"github.com/gravitl/netmaker/logger"
 	"github.com/gravitl/netmaker/models"
 )
@@ -24,12 +26,64 @@ type ruleInfo struct {
 	nfRule       any
 	table        string
 	chain        string
-	isDockerRule bool // indicates if rule is in Docker's 'ip' family table instead of 'inet'
+	isDockerRule bool            // indicates if rule is in Docker's 'ip' family table instead of 'inet'
+	limit        config.AclLimit // limits of the acl policy the rule was installed for
 }
 type ruletable map[string]rulesCfg
 
 type serverrulestable map[string]ruletable
 
+// copyRuleTable - copies a rule table, changes to the rules of the copy leave the original untouched
+func copyRuleTable(ruleTable ruletable) ruletable {
+	copied := make(ruletable, len(ruleTable))
+	for key, rCfg := range ruleTable {
+		cfg := rulesCfg{
+			isIpv4:    rCfg.isIpv4,
+			rulesMap:  make(map[string][]ruleInfo, len(rCfg.rulesMap)),
+			extraInfo: rCfg.extraInfo,
+		}
+		if aclRules, ok := rCfg.extraInfo.(map[string]models.AclRule); ok {
+			extraInfo := make(map[string]models.AclRule, len(aclRules))
+			for id, aclRule := range aclRules {
+				extraInfo[id] = aclRule
+			}
+			cfg.extraInfo = extraInfo
+		}
+		for id, rules := range rCfg.rulesMap {
+			cfg.rulesMap[id] = append([]ruleInfo{}, rules...)
+		}
+		copied[key] = cfg
+	}
+	return copied
+}
+
+func copyServerRuleTables(tables serverrulestable) serverrulestable {
+	copied := make(serverrulestable, len(tables))
+	for server, ruleTable := range tables {
+		copied[server] = copyRuleTable(ruleTable)
+	}
+	return copied
+}
+
+// constants needed to manage and create iptable rules
+const (
+	ipv6                = "ipv6"
+	ipv4                = "ipv4"
+	defaultIpTable      = "filter"
+	netmakerFilterChain = "netmakerfilter"
+	defaultNatTable     = "nat"
+	netmakerNatChain    = "netmakernat"
+	iptableFWDChain     = "FORWARD"
+	iptableINChain      = "INPUT"
+	nattablePRTChain    = "POSTROUTING"
+	netmakerSignature   = "NETMAKER"
+	aclInputRulesChain  = "NETMAKER-ACL-IN"
+	aclFwdRulesChain    = "NETMAKER-ACL-FWD"
+	aclOutputRulesChain = "NETMAKER-ACL-OUT"
+	aclInputLimitChain  = "NETMAKER-LIMIT-IN"
+	aclFwdLimitChain    = "NETMAKER-LIMIT-FWD"
+)
+
 const (
 	ingressTable = "ingress"
 	egressTable  = "egress"
@@ -83,6 +137,19 @@ type firewallController interface {
 	SaveRules(server, ruleTableName string, ruleTable ruletable)
 	// FlushAll - clears all rules from netmaker chains and deletes the chains
 	FlushAll()
+	// Reconcile - re-applies the chains, jump rules and installed rules missing from the live firewall
+	// and reports the drift found, target is the default target expected on the acl chains
+	Reconcile(target string) []Drift
+	// RuleCounters - returns the packets and bytes matched by each of the installed rules
+	RuleCounters(rules []ruleInfo) []ruleCounter
+}
+
+// transactionalFirewall - firewallController applying the rule changes of an update all or nothing
+type transactionalFirewall interface {
+	// Begin - snapshots the ruleset and batches the rule changes that follow
+	Begin()
+	// Commit - applies the batched rule changes, restoring the snapshot when they fail
+	Commit() error
 }
 
 // Init - initialises the firewall controller,return a close func to flush all rules
@@ -94,6 +161,7 @@ func Init() (func(), error) {
 		return func() {}, err
 	}
 	fwCrtl.FlushAll()
+	aclTarget = targetDrop
 	if err := fwCrtl.CreateChains(); err != nil {
 		return fwCrtl.FlushAll, err
 	}
@@ -103,3 +171,43 @@ func Init() (func(), error) {
 	}
 	return fwCrtl.FlushAll, nil
 }
+
+// ApplyFwUpdate - applies the egress, ingress and acl rules of a firewall update. On a backend
+// supporting transactions (nftables) the rule changes go in as one batch and a failed batch is
+// rolled back to the previous rules. Only rule changes are batched, the sets and chains created
+// for the update are kept. The iptables backend applies each change as it goes and a failure
+// there leaves the update partially applied
+func ApplyFwUpdate(server string, fwUpdate *models.FwUpdate) error {
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if fwCrtl == nil {
+		return nil
+	}
+	tx, ok := fwCrtl.(transactionalFirewall)
+	if !ok {
+		applyFwUpdate(fwCrtl, server, fwUpdate)
+		aclTarget = aclDefaultTarget(fwUpdate.AllowAll)
+		return nil
+	}
+	tx.Begin()
+	applyFwUpdate(fwCrtl, server, fwUpdate)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("firewall update rolled back: %w", err)
+	}
+	aclTarget = aclDefaultTarget(fwUpdate.AllowAll)
+	return nil
+}
+
+func applyFwUpdate(fwCrtl firewallController, server string, fwUpdate *models.FwUpdate) {
+	if fwUpdate.IsEgressGw {
+		setEgressRoutes(fwCrtl, server, fwUpdate.EgressInfo)
+	} else {
+		fwCrtl.CleanRoutingRules(server, egressTable)
+	}
+	if fwUpdate.IsIngressGw {
+		processIngressUpdate(fwCrtl, server, fwUpdate.IngressInfo)
+	} else {
+		fwCrtl.CleanRoutingRules(server, ingressTable)
+	}
+	processAclRules(fwCrtl, server, fwUpdate)
+}
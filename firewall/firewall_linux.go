@@ -42,7 +42,7 @@ func newFirewall() (firewallController, error) {
 	if config.Netclient().FirewallInUse == models.FIREWALL_NFTABLES {
 		logger.Log(0, "nftables is supported")
 		manager = &nftablesManager{
-			conn:         &nftables.Conn{},
+			conn:         &nftConn{Conn: &nftables.Conn{}},
 			ingRules:     make(serverrulestable),
 			engressRules: make(serverrulestable),
 			aclRules:     make(serverrulestable),
@@ -4,6 +4,9 @@
 package firewall
 
 import (
+	"errors"
+	"net"
+
 	"github.com/gravitl/netmaker/models"
 )
 
@@ -73,8 +76,16 @@ func (unimplementedFirewall) DeleteAclEgressRule(server, nodeID, aclID string)
 func (unimplementedFirewall) UpsertAclEgressRule(server, nodeID string, aclRule models.AclRule) {}
 func (unimplementedFirewall) DeleteAllAclEgressRules(server, egressID string)                   {}
 func (unimplementedFirewall) AddDropRules([]ruleInfo)                                           {}
+func (unimplementedFirewall) Reconcile(target string) []Drift                                   { return nil }
+func (unimplementedFirewall) RuleCounters(rules []ruleInfo) []ruleCounter {
+	return make([]ruleCounter, len(rules))
+}
 
 // newFirewall returns an unimplemented Firewall manager
 func newFirewall() (firewallController, error) {
 	return unimplementedFirewall{}, nil
 }
+
+func getInterfaceName(dst net.IPNet) (string, error) {
+	return "", errors.New("interface lookup is only supported on linux")
+}
@@ -14,6 +14,10 @@ func ProcessIngressUpdate(server string, ingressUpdate map[string]models.Ingress
 	if fwCrtl == nil {
 		return errors.New("firewall is not initialized yet")
 	}
+	return processIngressUpdate(fwCrtl, server, ingressUpdate)
+}
+
+func processIngressUpdate(fwCrtl firewallController, server string, ingressUpdate map[string]models.IngressInfo) error {
 	ruleTable := fwCrtl.FetchRuleTable(server, ingressTable)
 	for nodeID := range ruleTable {
 		if _, ok := ingressUpdate[nodeID]; !ok {
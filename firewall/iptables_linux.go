@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -16,23 +19,6 @@ import (
 	"github.com/gravitl/netmaker/models"
 )
 
-// constants needed to manage and create iptable rules
-const (
-	ipv6                = "ipv6"
-	ipv4                = "ipv4"
-	defaultIpTable      = "filter"
-	netmakerFilterChain = "netmakerfilter"
-	defaultNatTable     = "nat"
-	netmakerNatChain    = "netmakernat"
-	iptableFWDChain     = "FORWARD"
-	iptableINChain      = "INPUT"
-	nattablePRTChain    = "POSTROUTING"
-	netmakerSignature   = "NETMAKER"
-	aclInputRulesChain  = "NETMAKER-ACL-IN"
-	aclFwdRulesChain    = "NETMAKER-ACL-FWD"
-	aclOutputRulesChain = "NETMAKER-ACL-OUT"
-)
-
 type iptablesManager struct {
 	ipv4Client   *iptables.IPTables
 	ipv6Client   *iptables.IPTables
@@ -68,6 +54,13 @@ var (
 
 	// filter table netmaker jump rules
 	filterNmJumpRules = []ruleInfo{
+		// acl limits are enforced ahead of the conntrack accept rules
+		{
+			rule: []string{"-i", ncutils.GetInterfaceName(), "-j", aclInputLimitChain,
+				"-m", "comment", "--comment", netmakerSignature},
+			table: defaultIpTable,
+			chain: iptableINChain,
+		},
 		//iptables -A INPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
 		{
 			rule: []string{"-i", ncutils.GetInterfaceName(), "-m", "conntrack",
@@ -108,6 +101,19 @@ var (
 			table: defaultIpTable,
 			chain: iptableFWDChain,
 		},
+		// FORWARD rules are inserted on top, the limit jumps are listed last to end up first
+		{
+			rule: []string{"-i", ncutils.GetInterfaceName(), "!", "-o", ncutils.GetInterfaceName(), "-j", aclFwdLimitChain,
+				"-m", "comment", "--comment", netmakerSignature},
+			table: defaultIpTable,
+			chain: iptableFWDChain,
+		},
+		{
+			rule: []string{"-i", ncutils.GetInterfaceName(), "-o", ncutils.GetInterfaceName(), "-j", aclInputLimitChain,
+				"-m", "comment", "--comment", netmakerSignature},
+			table: defaultIpTable,
+			chain: iptableFWDChain,
+		},
 		{
 			rule:  []string{"-m", "comment", "--comment", netmakerSignature, "-j", "ACCEPT"},
 			table: defaultIpTable,
@@ -154,70 +160,38 @@ func createChain(iptables *iptables.IPTables, table, newChain string) error {
 }
 
 func (i *iptablesManager) ChangeACLInTarget(target string) {
-
-	ruleSpec := aclInChainDropRule.rule
-	table := aclInChainDropRule.table
-	chain := aclInChainDropRule.chain
-	ruleSpec[len(ruleSpec)-1] = target
-	ok4, _ := i.ipv4Client.Exists(table, chain, ruleSpec...)
-	ok6, _ := i.ipv6Client.Exists(table, chain, ruleSpec...)
-	if ok4 && ok6 {
-		return
-	}
-	slog.Debug("setting acl input chain target to", "target", target)
-	if target == targetAccept {
-
-		// remove any DROP rule
-		ruleSpec[len(ruleSpec)-1] = targetDrop
-		i.ipv4Client.DeleteIfExists(table, chain, ruleSpec...)
-		i.ipv6Client.DeleteIfExists(table, chain, ruleSpec...)
-		// Add ACCEPT RULE
-		ruleSpec[len(ruleSpec)-1] = targetAccept
-		i.ipv4Client.Append(table, chain, ruleSpec...)
-		i.ipv6Client.Append(table, chain, ruleSpec...)
-	} else {
-		// remove any ACCEPT rule
-		ruleSpec[len(ruleSpec)-1] = targetAccept
-		i.ipv4Client.DeleteIfExists(table, chain, ruleSpec...)
-		i.ipv6Client.DeleteIfExists(table, chain, ruleSpec...)
-		// Add DROP RULE
-		ruleSpec[len(ruleSpec)-1] = targetDrop
-		i.ipv4Client.Append(table, chain, ruleSpec...)
-		i.ipv6Client.Append(table, chain, ruleSpec...)
-	}
+	i.changeACLTarget(aclInputRulesChain, target)
 }
 
 func (i *iptablesManager) ChangeACLFwdTarget(target string) {
+	i.changeACLTarget(aclFwdRulesChain, target)
+}
 
-	ruleSpec := aclFwdChainDropRule.rule
-	table := aclFwdChainDropRule.table
-	chain := aclFwdChainDropRule.chain
-	ruleSpec[len(ruleSpec)-1] = target
-	ok4, _ := i.ipv4Client.Exists(table, chain, ruleSpec...)
-	ok6, _ := i.ipv6Client.Exists(table, chain, ruleSpec...)
-	if ok4 && ok6 {
+// iptablesManager.changeACLTarget - replaces the default target at the end of an acl chain,
+// a drop target is preceded by the drop log rule when the drop log is enabled
+func (i *iptablesManager) changeACLTarget(chain, target string) {
+	ruleSpec := iptablesTargetRuleSpec(target)
+	logSpec := iptablesDropLogRuleSpec(chain)
+	logDrops := target == targetDrop && dropLogEnabled()
+	upToDate := true
+	for _, client := range []*iptables.IPTables{i.ipv4Client, i.ipv6Client} {
+		ok, _ := client.Exists(defaultIpTable, chain, ruleSpec...)
+		logged, _ := client.Exists(defaultIpTable, chain, logSpec...)
+		upToDate = upToDate && ok && logged == logDrops
+	}
+	if upToDate {
 		return
 	}
-	slog.Debug("setting acl forward chain target to", "target", target)
-	if target == targetAccept {
-
-		// remove any DROP rule
-		ruleSpec[len(ruleSpec)-1] = targetDrop
-		i.ipv4Client.DeleteIfExists(table, chain, ruleSpec...)
-		i.ipv6Client.DeleteIfExists(table, chain, ruleSpec...)
-		// Add ACCEPT RULE
-		ruleSpec[len(ruleSpec)-1] = targetAccept
-		i.ipv4Client.Append(table, chain, ruleSpec...)
-		i.ipv6Client.Append(table, chain, ruleSpec...)
-	} else {
-		// remove any ACCEPT rule
-		ruleSpec[len(ruleSpec)-1] = targetAccept
-		i.ipv4Client.DeleteIfExists(table, chain, ruleSpec...)
-		i.ipv6Client.DeleteIfExists(table, chain, ruleSpec...)
-		// Add DROP RULE
-		ruleSpec[len(ruleSpec)-1] = targetDrop
-		i.ipv4Client.Append(table, chain, ruleSpec...)
-		i.ipv6Client.Append(table, chain, ruleSpec...)
+	slog.Debug("setting acl chain target to", "chain", chain, "target", target, "log", logDrops)
+	for _, client := range []*iptables.IPTables{i.ipv4Client, i.ipv6Client} {
+		// remove the current target along with its drop log rule
+		client.DeleteIfExists(defaultIpTable, chain, logSpec...)
+		client.DeleteIfExists(defaultIpTable, chain, iptablesTargetRuleSpec(targetAccept)...)
+		client.DeleteIfExists(defaultIpTable, chain, iptablesTargetRuleSpec(targetDrop)...)
+		if logDrops {
+			client.Append(defaultIpTable, chain, logSpec...)
+		}
+		client.Append(defaultIpTable, chain, ruleSpec...)
 	}
 }
 
@@ -286,6 +260,13 @@ func (i *iptablesManager) CreateChains() error {
 		logger.Log(1, "failed to create netmaker chain: ", err.Error())
 		return err
 	}
+	for _, chain := range []string{aclInputLimitChain, aclFwdLimitChain} {
+		err = createChain(i.ipv4Client, defaultIpTable, chain)
+		if err != nil {
+			logger.Log(1, "failed to create netmaker chain: ", err.Error())
+			return err
+		}
+	}
 	err = createChain(i.ipv6Client, defaultIpTable, netmakerFilterChain)
 	if err != nil {
 		logger.Log(1, "failed to create netmaker chain: ", err.Error())
@@ -311,6 +292,13 @@ func (i *iptablesManager) CreateChains() error {
 		logger.Log(1, "failed to create netmaker chain: ", err.Error())
 		return err
 	}
+	for _, chain := range []string{aclInputLimitChain, aclFwdLimitChain} {
+		err = createChain(i.ipv6Client, defaultIpTable, chain)
+		if err != nil {
+			logger.Log(1, "failed to create netmaker chain: ", err.Error())
+			return err
+		}
+	}
 	// add jump rules
 	i.addJumpRules()
 	return nil
@@ -319,7 +307,7 @@ func (i *iptablesManager) CreateChains() error {
 func (i *iptablesManager) addJumpRules() {
 
 	for _, rule := range filterNmJumpRules {
-		if rule.chain == iptableFWDChain {
+		if jumpRuleAtTop(rule) {
 			err := i.ipv4Client.InsertUnique(rule.table, rule.chain, 1, rule.rule...)
 			if err != nil {
 				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
@@ -350,16 +338,8 @@ func (i *iptablesManager) addJumpRules() {
 			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
 		}
 	}
-	// add metrics rule
-	server := config.GetServer(config.CurrServer)
-	if server != nil {
-		port := server.MetricsPort
-		rule := ruleInfo{
-			rule: []string{"-i", ncutils.GetInterfaceName(), "-p", "tcp", "--dport", fmt.Sprint(port), "-m", "comment",
-				"--comment", netmakerSignature, "-j", "ACCEPT"},
-			table: defaultIpTable,
-			chain: aclInputRulesChain,
-		}
+	// add metrics and dns rules
+	for _, rule := range serviceRules() {
 		err := i.ipv4Client.Insert(rule.table, rule.chain, 1, rule.rule...)
 		if err != nil {
 			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
@@ -368,24 +348,38 @@ func (i *iptablesManager) addJumpRules() {
 		if err != nil {
 			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
 		}
-		rule = ruleInfo{
+	}
+
+	i.AddDropRules(dropRules)
+
+}
+
+// serviceRules - rules accepting metrics and dns traffic on the netmaker interface
+func serviceRules() []ruleInfo {
+	server := config.GetServer(config.CurrServer)
+	if server == nil {
+		return nil
+	}
+	return []ruleInfo{
+		{
+			rule: []string{"-i", ncutils.GetInterfaceName(), "-p", "tcp", "--dport", fmt.Sprint(server.MetricsPort), "-m", "comment",
+				"--comment", netmakerSignature, "-j", "ACCEPT"},
+			table: defaultIpTable,
+			chain: aclInputRulesChain,
+		},
+		{
 			rule: []string{"-i", ncutils.GetInterfaceName(), "-p", "udp", "--dport", "53", "-m", "comment",
 				"--comment", netmakerSignature, "-j", "ACCEPT"},
 			table: defaultIpTable,
 			chain: aclInputRulesChain,
-		}
-		err = i.ipv4Client.Insert(rule.table, rule.chain, 1, rule.rule...)
-		if err != nil {
-			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
-		}
-		err = i.ipv6Client.Insert(rule.table, rule.chain, 1, rule.rule...)
-		if err != nil {
-			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", rule.rule, err.Error()))
-		}
+		},
+		{
+			rule: []string{"-i", ncutils.GetInterfaceName(), "-p", "tcp", "--dport", "53", "-m", "comment",
+				"--comment", netmakerSignature, "-j", "ACCEPT"},
+			table: defaultIpTable,
+			chain: aclInputRulesChain,
+		},
 	}
-
-	i.AddDropRules(dropRules)
-
 }
 
 func (i *iptablesManager) removeJumpRules() {
@@ -483,11 +477,7 @@ func (i *iptablesManager) InsertEgressRoutingRules(server string, egressInfo mod
 			if err != nil {
 				logger.Log(0, "failed to get interface name: ", egressRangeIface, err.Error())
 			} else {
-				ruleSpec := []string{"-s", source, "-o", egressRangeIface, "-j", "MASQUERADE"}
-				if len(config.GetNodes()) == 1 {
-					ruleSpec = []string{"-o", egressRangeIface, "-j", "MASQUERADE"}
-				}
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
+				ruleSpec := iptablesEgressNatRuleSpec(source, egressRangeIface, len(config.GetNodes()) == 1)
 				// to avoid duplicate iface route rule,delete if exists
 				iptablesClient.DeleteIfExists(defaultNatTable, nattablePRTChain, ruleSpec...)
 				err := iptablesClient.Insert(defaultNatTable, nattablePRTChain, 1, ruleSpec...)
@@ -495,16 +485,16 @@ func (i *iptablesManager) InsertEgressRoutingRules(server string, egressInfo mod
 					logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
 				} else {
 					egressGwRoutes = append(egressGwRoutes, ruleInfo{
-						table: defaultNatTable,
-						chain: nattablePRTChain,
-						rule:  ruleSpec,
+						isIpv4: iptablesClient == i.ipv4Client,
+						table:  defaultNatTable,
+						chain:  nattablePRTChain,
+						rule:   ruleSpec,
 					})
 				}
 
 				// Add Docker-specific rule if egress interface is a Docker network
 				if isDockerInterface(egressRangeIface) {
-					dockerRuleSpec := []string{"-i", ncutils.GetInterfaceName(), "-o", egressRangeIface, "-j", aclInputRulesChain}
-					dockerRuleSpec = appendNetmakerCommentToRule(dockerRuleSpec)
+					dockerRuleSpec := iptablesDockerRuleSpec(egressRangeIface)
 					// Check if DOCKER-USER chain exists, only add rule if it does
 					exists, err := iptablesClient.ChainExists(defaultIpTable, "DOCKER-USER")
 					if err == nil && exists {
@@ -515,9 +505,10 @@ func (i *iptablesManager) InsertEgressRoutingRules(server string, egressInfo mod
 							logger.Log(1, fmt.Sprintf("failed to add Docker rule: %v, Err: %v ", dockerRuleSpec, err.Error()))
 						} else {
 							egressGwRoutes = append(egressGwRoutes, ruleInfo{
-								table: defaultIpTable,
-								chain: "DOCKER-USER",
-								rule:  dockerRuleSpec,
+								isIpv4: iptablesClient == i.ipv4Client,
+								table:  defaultIpTable,
+								chain:  "DOCKER-USER",
+								rule:   dockerRuleSpec,
 							})
 							logger.Log(0, fmt.Sprintf("added Docker network rule for interface: %s", egressRangeIface))
 						}
@@ -581,8 +572,7 @@ func (i *iptablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 		if cnt <= 0 {
 			cnt = 1
 		}
-		ruleSpec := []string{"-s", ip.String(), "-j", targetDrop}
-		ruleSpec = appendNetmakerCommentToRule(ruleSpec)
+		ruleSpec, druleSpec := staticNodeRuleSpecs(ip)
 		err := iptablesClient.InsertUnique(defaultIpTable, aclInputRulesChain,
 			cnt, ruleSpec...)
 		if err != nil {
@@ -599,17 +589,15 @@ func (i *iptablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 		if cnt <= 0 {
 			cnt = 1
 		}
-		ruleSpec = []string{"-d", ip.String(), "-j", targetDrop}
-		ruleSpec = appendNetmakerCommentToRule(ruleSpec)
 		err = iptablesClient.InsertUnique(defaultIpTable, aclInputRulesChain,
-			cnt, ruleSpec...)
+			cnt, druleSpec...)
 		if err != nil {
-			logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
+			logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", druleSpec, err.Error()))
 		} else {
 			ingressGwRoutes = append(ingressGwRoutes, ruleInfo{
 				table: defaultIpTable,
 				chain: aclInputRulesChain,
-				rule:  ruleSpec,
+				rule:  druleSpec,
 			})
 		}
 
@@ -622,41 +610,7 @@ func (i *iptablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 		if rule.SrcIP.IP.To4() == nil {
 			iptablesClient = i.ipv6Client
 		}
-		rulesSpec := [][]string{}
-		if len(rule.AllowedPorts) > 0 {
-
-			for _, port := range rule.AllowedPorts {
-				if port == "" {
-					continue
-				}
-				ruleSpec := []string{"-s", rule.SrcIP.String()}
-				if rule.DstIP.IP != nil {
-					ruleSpec = append(ruleSpec, "-d", rule.DstIP.String())
-				}
-				if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", rule.AllowedProtocol.String())
-				}
-				if strings.Contains(port, "-") {
-					port = strings.ReplaceAll(port, "-", ":")
-				}
-				ruleSpec = append(ruleSpec, "--dport", port)
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-		} else {
-			ruleSpec := []string{"-s", rule.SrcIP.String()}
-			if rule.DstIP.IP != nil {
-				ruleSpec = append(ruleSpec, "-d", rule.DstIP.String())
-			}
-			if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL {
-				ruleSpec = append(ruleSpec, "-p", rule.AllowedProtocol.String())
-			}
-			ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-			ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-			rulesSpec = append(rulesSpec, ruleSpec)
-		}
+		rulesSpec := iptablesIngressRuleSpecs(rule)
 		for _, ruleSpec := range rulesSpec {
 			// to avoid duplicate iface route rule,delete if exists
 			iptablesClient.DeleteIfExists(defaultIpTable, aclInputRulesChain, ruleSpec...)
@@ -748,142 +702,12 @@ func (i *iptablesManager) AddAclRules(server string, aclRules map[string]models.
 		ruleTable = make(ruletable)
 	}
 	for _, aclRule := range aclRules {
-		rules := []ruleInfo{}
 		if _, ok := ruleTable[aclRule.ID]; !ok {
 			ruleTable[aclRule.ID] = rulesCfg{
 				rulesMap: make(map[string][]ruleInfo),
 			}
 		}
-		if len(aclRule.IPList) > 0 {
-			allowedIps := []string{}
-			dstAllowedIps := []string{}
-			for _, ip := range aclRule.IPList {
-				allowedIps = append(allowedIps, ip.String())
-			}
-			if len(aclRule.Dst) > 0 {
-				for _, ip := range aclRule.Dst {
-					dstAllowedIps = append(dstAllowedIps, ip.String())
-				}
-			}
-
-			rulesSpec := [][]string{}
-			if len(aclRule.AllowedPorts) > 0 {
-
-				for _, port := range aclRule.AllowedPorts {
-					if port == "" {
-						continue
-					}
-					ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-					if len(dstAllowedIps) > 0 {
-						ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-					}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if strings.Contains(port, "-") {
-						port = strings.ReplaceAll(port, "-", ":")
-					}
-					ruleSpec = append(ruleSpec, "--dport", port)
-					//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					rulesSpec = append(rulesSpec, ruleSpec)
-				}
-
-			} else {
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if len(dstAllowedIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-				}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-			for _, ruleSpec := range rulesSpec {
-				err := i.ipv4Client.Insert(defaultIpTable, aclInputRulesChain, 1, ruleSpec...)
-				if err != nil {
-					logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: true,
-						table:  defaultIpTable,
-						chain:  aclInputRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-		}
-
-		if len(aclRule.IP6List) > 0 {
-			allowedIps := []string{}
-			dstAllowedIps := []string{}
-			for _, ip := range aclRule.IP6List {
-				allowedIps = append(allowedIps, ip.String())
-			}
-			if len(aclRule.Dst6) > 0 {
-				for _, ip := range aclRule.Dst6 {
-					dstAllowedIps = append(dstAllowedIps, ip.String())
-				}
-			}
-			rulesSpec := [][]string{}
-			if len(aclRule.AllowedPorts) > 0 {
-
-				for _, port := range aclRule.AllowedPorts {
-					if port == "" {
-						continue
-					}
-					ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-					if len(dstAllowedIps) > 0 {
-						ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-					}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if strings.Contains(port, "-") {
-						port = strings.ReplaceAll(port, "-", ":")
-					}
-					ruleSpec = append(ruleSpec, "--dport", port)
-					//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					rulesSpec = append(rulesSpec, ruleSpec)
-				}
-
-			} else {
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if len(dstAllowedIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-				}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-			for _, ruleSpec := range rulesSpec {
-				err := i.ipv6Client.Insert(defaultIpTable, aclInputRulesChain, 1, ruleSpec...)
-				if err != nil {
-					logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: false,
-						table:  defaultIpTable,
-						chain:  aclInputRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-		}
+		rules := i.insertAclRuleSpecs(aclInputRulesChain, iptablesAclRuleSpecs(aclRule, false))
 		if len(rules) > 0 {
 			rCfg := rulesCfg{
 				rulesMap: map[string][]ruleInfo{
@@ -896,143 +720,54 @@ func (i *iptablesManager) AddAclRules(server string, aclRules map[string]models.
 	}
 }
 
-func (i *iptablesManager) UpsertAclRule(server string, aclRule models.AclRule) {
-	ruleTable := i.FetchRuleTable(server, aclTable)
-	defer i.SaveRules(server, aclTable, ruleTable)
-	i.mux.Lock()
-	defer i.mux.Unlock()
-	ruleTable[aclRule.ID] = rulesCfg{
-		rulesMap: make(map[string][]ruleInfo),
-	}
+// iptablesManager.insertAclRuleSpecs - inserts the rules of an acl policy at the top of the chain,
+// the rules enforcing its limits go to the top of the matching limit chain
+func (i *iptablesManager) insertAclRuleSpecs(chain string, specs []aclRuleSpec) []ruleInfo {
 	rules := []ruleInfo{}
-	if _, ok := ruleTable[aclRule.ID]; !ok {
-		ruleTable[aclRule.ID] = rulesCfg{
-			rulesMap: make(map[string][]ruleInfo),
-		}
-	}
-	if len(aclRule.IPList) > 0 {
-		allowedIps := []string{}
-		dstAllowedIps := []string{}
-		for _, ip := range aclRule.IPList {
-			allowedIps = append(allowedIps, ip.String())
-		}
-		if len(aclRule.Dst) > 0 {
-			for _, ip := range aclRule.Dst {
-				dstAllowedIps = append(dstAllowedIps, ip.String())
-			}
+	for _, spec := range specs {
+		iptablesClient := i.ipv4Client
+		if !spec.isIpv4 {
+			iptablesClient = i.ipv6Client
 		}
-		rulesSpec := [][]string{}
-		if len(aclRule.AllowedPorts) > 0 {
-			for _, port := range aclRule.AllowedPorts {
-				if port == "" {
-					continue
-				}
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if len(dstAllowedIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-				}
-				if aclRule.AllowedProtocol.String() != "" {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if strings.Contains(port, "-") {
-					port = strings.ReplaceAll(port, "-", ":")
-				}
-				ruleSpec = append(ruleSpec, "--dport", port)
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-		} else {
-			ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-			if len(dstAllowedIps) > 0 {
-				ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-			}
-			if aclRule.AllowedProtocol.String() != "" {
-				ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-			}
-			//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-			ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-			ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-			rulesSpec = append(rulesSpec, ruleSpec)
+		err := iptablesClient.Insert(defaultIpTable, chain, 1, spec.spec...)
+		if err != nil {
+			logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", spec.spec, err.Error()))
+			continue
 		}
-		for _, ruleSpec := range rulesSpec {
-			err := i.ipv4Client.Insert(defaultIpTable, aclInputRulesChain, 1, ruleSpec...)
+		rules = append(rules, ruleInfo{
+			isIpv4: spec.isIpv4,
+			table:  defaultIpTable,
+			chain:  chain,
+			rule:   spec.spec,
+			limit:  spec.limit,
+		})
+		for _, limit := range spec.limits {
+			err := iptablesClient.Insert(defaultIpTable, aclLimitChain(chain), 1, limit.spec...)
 			if err != nil {
-				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-			} else {
-				rules = append(rules, ruleInfo{
-					isIpv4: true,
-					table:  defaultIpTable,
-					chain:  aclInputRulesChain,
-					rule:   ruleSpec,
-				})
-
+				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", limit.spec, err.Error()))
+				continue
 			}
+			rules = append(rules, ruleInfo{
+				isIpv4: spec.isIpv4,
+				table:  defaultIpTable,
+				chain:  aclLimitChain(chain),
+				rule:   limit.spec,
+				limit:  spec.limit,
+			})
 		}
-
 	}
-	if len(aclRule.IP6List) > 0 {
-		allowedIps := []string{}
-		dstAllowedIps := []string{}
-		for _, ip := range aclRule.IP6List {
-			allowedIps = append(allowedIps, ip.String())
-		}
-		if len(aclRule.Dst6) > 0 {
-			for _, ip := range aclRule.Dst6 {
-				dstAllowedIps = append(dstAllowedIps, ip.String())
-			}
-		}
-		rulesSpec := [][]string{}
-		if len(aclRule.AllowedPorts) > 0 {
-
-			for _, port := range aclRule.AllowedPorts {
-				if port == "" {
-					continue
-				}
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if len(dstAllowedIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-				}
-				if aclRule.AllowedProtocol.String() != "" {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if strings.Contains(port, "-") {
-					port = strings.ReplaceAll(port, "-", ":")
-				}
-				ruleSpec = append(ruleSpec, "--dport", port)
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
+	return rules
+}
 
-		} else {
-			ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-			if len(dstAllowedIps) > 0 {
-				ruleSpec = append(ruleSpec, "-d", strings.Join(dstAllowedIps, ","))
-			}
-			if aclRule.AllowedProtocol.String() != "" {
-				ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-			}
-			//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-			ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-			rulesSpec = append(rulesSpec, ruleSpec)
-		}
-		for _, ruleSpec := range rulesSpec {
-			err := i.ipv6Client.Insert(defaultIpTable, aclInputRulesChain, 1, ruleSpec...)
-			if err != nil {
-				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-			} else {
-				rules = append(rules, ruleInfo{
-					isIpv4: false,
-					table:  defaultIpTable,
-					chain:  aclInputRulesChain,
-					rule:   ruleSpec,
-				})
-			}
-		}
+func (i *iptablesManager) UpsertAclRule(server string, aclRule models.AclRule) {
+	ruleTable := i.FetchRuleTable(server, aclTable)
+	defer i.SaveRules(server, aclTable, ruleTable)
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	ruleTable[aclRule.ID] = rulesCfg{
+		rulesMap: make(map[string][]ruleInfo),
 	}
+	rules := i.insertAclRuleSpecs(aclInputRulesChain, iptablesAclRuleSpecs(aclRule, false))
 	if len(rules) > 0 {
 		rCfg := rulesCfg{
 			rulesMap: map[string][]ruleInfo{
@@ -1079,146 +814,7 @@ func (i *iptablesManager) AddAclEgressRules(server string, egressInfo models.Egr
 		rulesMap: make(map[string][]ruleInfo),
 	}
 	for _, aclRule := range aclRules {
-		rules := []ruleInfo{}
-		if len(aclRule.IPList) > 0 {
-			allowedIps := []string{}
-			for _, ip := range aclRule.IPList {
-				if ip.IP == nil {
-					continue
-				}
-				allowedIps = append(allowedIps, ip.String())
-			}
-			allowedDstIps := []string{}
-			for _, ip := range aclRule.Dst {
-				if ip.IP == nil {
-					continue
-				}
-				allowedDstIps = append(allowedDstIps, ip.String())
-			}
-			rulesSpec := [][]string{}
-			if len(aclRule.AllowedPorts) > 0 {
-
-				for _, port := range aclRule.AllowedPorts {
-					if port == "" {
-						continue
-					}
-					ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if strings.Contains(port, "-") {
-						port = strings.ReplaceAll(port, "-", ":")
-					}
-
-					if len(allowedDstIps) > 0 {
-						ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-					}
-
-					ruleSpec = append(ruleSpec, "--dport", port)
-					//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					rulesSpec = append(rulesSpec, ruleSpec)
-				}
-
-			} else {
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(allowedDstIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-				}
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-			for _, ruleSpec := range rulesSpec {
-				err := i.ipv4Client.Insert(defaultIpTable, aclFwdRulesChain, 1, ruleSpec...)
-				if err != nil {
-					logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: true,
-						table:  defaultIpTable,
-						chain:  aclFwdRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-		}
-
-		if len(aclRule.IP6List) > 0 {
-			allowedIps := []string{}
-			for _, ip := range aclRule.IP6List {
-				if ip.IP == nil {
-					continue
-				}
-				allowedIps = append(allowedIps, ip.String())
-			}
-			allowedDstIps := []string{}
-			for _, ip := range aclRule.Dst6 {
-				if ip.IP == nil {
-					continue
-				}
-				allowedDstIps = append(allowedDstIps, ip.String())
-			}
-			rulesSpec := [][]string{}
-			if len(aclRule.AllowedPorts) > 0 {
-
-				for _, port := range aclRule.AllowedPorts {
-					if port == "" {
-						continue
-					}
-					ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if strings.Contains(port, "-") {
-						port = strings.ReplaceAll(port, "-", ":")
-					}
-					if len(allowedDstIps) > 0 {
-						ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-					}
-					ruleSpec = append(ruleSpec, "--dport", port)
-					//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					rulesSpec = append(rulesSpec, ruleSpec)
-				}
-
-			} else {
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(allowedDstIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-				}
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-			for _, ruleSpec := range rulesSpec {
-				err := i.ipv6Client.Insert(defaultIpTable, aclFwdRulesChain, 1, ruleSpec...)
-				if err != nil {
-					logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: false,
-						table:  defaultIpTable,
-						chain:  aclFwdRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-		}
+		rules := i.insertAclRuleSpecs(aclFwdRulesChain, iptablesAclRuleSpecs(aclRule, true))
 		if len(rules) > 0 {
 			rCfg.rulesMap[aclRule.ID] = rules
 
@@ -1237,135 +833,7 @@ func (i *iptablesManager) UpsertAclEgressRule(server, egressID string, aclRule m
 	defer i.mux.Unlock()
 	rCfg := ruleTable[egressID]
 	extraInfo := rCfg.extraInfo.(map[string]models.AclRule)
-	rules := []ruleInfo{}
-	if len(aclRule.IPList) > 0 {
-		allowedIps := []string{}
-		for _, ip := range aclRule.IPList {
-			if ip.IP == nil {
-				continue
-			}
-			allowedIps = append(allowedIps, ip.String())
-		}
-		allowedDstIps := []string{}
-		for _, ip := range aclRule.Dst {
-			if ip.IP == nil {
-				continue
-			}
-			allowedDstIps = append(allowedDstIps, ip.String())
-		}
-		rulesSpec := [][]string{}
-		if len(aclRule.AllowedPorts) > 0 {
-			for _, port := range aclRule.AllowedPorts {
-				if port == "" {
-					continue
-				}
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if aclRule.AllowedProtocol.String() != "" {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if strings.Contains(port, "-") {
-					port = strings.ReplaceAll(port, "-", ":")
-				}
-				if len(allowedDstIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-				}
-				ruleSpec = append(ruleSpec, "--dport", port)
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-		} else {
-			ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-			if aclRule.AllowedProtocol.String() != "" {
-				ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-			}
-			if len(allowedDstIps) > 0 {
-				ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-			}
-			//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-			ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-			ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-			rulesSpec = append(rulesSpec, ruleSpec)
-		}
-		for _, ruleSpec := range rulesSpec {
-			err := i.ipv4Client.Insert(defaultIpTable, aclFwdRulesChain, 1, ruleSpec...)
-			if err != nil {
-				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-			} else {
-				rules = append(rules, ruleInfo{
-					isIpv4: true,
-					table:  defaultIpTable,
-					chain:  aclFwdRulesChain,
-					rule:   ruleSpec,
-				})
-
-			}
-		}
-
-	}
-	if len(aclRule.IP6List) > 0 {
-		allowedIps := []string{}
-		for _, ip := range aclRule.IP6List {
-			allowedIps = append(allowedIps, ip.String())
-		}
-		allowedDstIps := []string{}
-		for _, ip := range aclRule.Dst6 {
-			if ip.IP == nil {
-				continue
-			}
-			allowedDstIps = append(allowedDstIps, ip.String())
-		}
-		rulesSpec := [][]string{}
-		if len(aclRule.AllowedPorts) > 0 {
-
-			for _, port := range aclRule.AllowedPorts {
-				if port == "" {
-					continue
-				}
-				ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-				if aclRule.AllowedProtocol.String() != "" {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(allowedDstIps) > 0 {
-					ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-				}
-				if strings.Contains(port, "-") {
-					port = strings.ReplaceAll(port, "-", ":")
-				}
-				ruleSpec = append(ruleSpec, "--dport", port)
-				//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				rulesSpec = append(rulesSpec, ruleSpec)
-			}
-
-		} else {
-			ruleSpec := []string{"-s", strings.Join(allowedIps, ",")}
-			if aclRule.AllowedProtocol.String() != "" {
-				ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-			}
-			if len(allowedDstIps) > 0 {
-				ruleSpec = append(ruleSpec, "-d", strings.Join(allowedDstIps, ","))
-			}
-			//ruleSpec = append(ruleSpec, "-m", "addrtype", "--dst-type", "LOCAL")
-			ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-			rulesSpec = append(rulesSpec, ruleSpec)
-		}
-		for _, ruleSpec := range rulesSpec {
-			err := i.ipv6Client.Insert(defaultIpTable, aclFwdRulesChain, 1, ruleSpec...)
-			if err != nil {
-				logger.Log(1, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-			} else {
-				rules = append(rules, ruleInfo{
-					isIpv4: false,
-					table:  defaultIpTable,
-					chain:  aclFwdRulesChain,
-					rule:   ruleSpec,
-				})
-			}
-		}
-	}
+	rules := i.insertAclRuleSpecs(aclFwdRulesChain, iptablesAclRuleSpecs(aclRule, true))
 	if len(rules) > 0 {
 		rCfg.rulesMap[aclRule.ID] = rules
 		extraInfo[aclRule.ID] = aclRule
@@ -1594,6 +1062,8 @@ func (i *iptablesManager) FlushAll() {
 	i.cleanup(defaultIpTable, aclInputRulesChain)
 	i.cleanup(defaultIpTable, aclFwdRulesChain)
 	i.cleanup(defaultIpTable, aclOutputRulesChain)
+	i.cleanup(defaultIpTable, aclInputLimitChain)
+	i.cleanup(defaultIpTable, aclFwdLimitChain)
 	i.cleanup(defaultIpTable, netmakerFilterChain)
 	i.cleanup(defaultNatTable, netmakerNatChain)
 }
@@ -1605,7 +1075,246 @@ func iptablesProtoToString(proto iptables.Protocol) string {
 	return ipv4
 }
 
-func appendNetmakerCommentToRule(ruleSpec []string) []string {
-	ruleSpec = append(ruleSpec, "-m", "comment", "--comment", netmakerSignature)
-	return ruleSpec
+// chains created by the iptables manager, by table
+var iptManagedChains = map[string][]string{
+	defaultIpTable: {netmakerFilterChain, aclInputRulesChain, aclFwdRulesChain, aclOutputRulesChain,
+		aclInputLimitChain, aclFwdLimitChain},
+	defaultNatTable: {netmakerNatChain},
+}
+
+// iptablesManager.Reconcile - compares the live netmaker chains with the installed rules, re-adding what is missing.
+// iptables rules are matched by their spec, so a modified rule shows up as a missing and an extra rule
+func (i *iptablesManager) Reconcile(target string) []Drift {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	drift := []Drift{}
+	installed := installedRules(i.aclRules, i.engressRules, i.ingRules)
+	for _, family := range []string{ipv4, ipv6} {
+		client := i.ipv4Client
+		if family == ipv6 {
+			client = i.ipv6Client
+		}
+		for table, chains := range iptManagedChains {
+			for _, chain := range chains {
+				if ok, err := client.ChainExists(table, chain); err != nil || ok {
+					continue
+				}
+				err := client.NewChain(table, chain)
+				drift = append(drift, Drift{Kind: DriftMissingChain, Family: family, Table: table, Chain: chain, Repaired: err == nil})
+			}
+		}
+
+		// jump rules into FORWARD, the limit jumps and the service rules go on top, the rest is appended
+		defaultRules := []ruleInfo{
+			dropRules[0],
+			{rule: iptablesTargetRuleSpec(target), table: defaultIpTable, chain: aclInputRulesChain},
+			{rule: iptablesTargetRuleSpec(target), table: defaultIpTable, chain: aclFwdRulesChain},
+		}
+		services := serviceRules()
+		expected := []ruleInfo{}
+		for _, rules := range [][]ruleInfo{filterNmJumpRules, natNmJumpRules, services, defaultRules} {
+			expected = append(expected, rules...)
+		}
+		for idx, rule := range expected {
+			if ok, err := client.Exists(rule.table, rule.chain, rule.rule...); err != nil || ok {
+				continue
+			}
+			isService := idx >= len(filterNmJumpRules)+len(natNmJumpRules) &&
+				idx < len(filterNmJumpRules)+len(natNmJumpRules)+len(services)
+			var err error
+			if jumpRuleAtTop(rule) || isService {
+				err = client.Insert(rule.table, rule.chain, 1, rule.rule...)
+			} else {
+				err = client.Append(rule.table, rule.chain, rule.rule...)
+			}
+			kind := DriftMissingJump
+			if idx >= len(expected)-len(defaultRules) {
+				kind = DriftMissingTarget
+			}
+			drift = append(drift, Drift{Kind: kind, Family: family, Table: rule.table, Chain: rule.chain,
+				Rule: strings.Join(rule.rule, " "), Repaired: err == nil})
+		}
+		if target == targetDrop && dropLogEnabled() {
+			for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+				rule := ruleInfo{rule: iptablesDropLogRuleSpec(chain), table: defaultIpTable, chain: chain}
+				expected = append(expected, rule)
+				if ok, err := client.Exists(rule.table, rule.chain, rule.rule...); err != nil || ok {
+					continue
+				}
+				// the drop target is the last rule of the chain
+				err := client.Insert(rule.table, rule.chain, i.getLastRuleCnt(rule.table, rule.chain, family == ipv4), rule.rule...)
+				drift = append(drift, Drift{Kind: DriftMissingTarget, Family: family, Table: rule.table, Chain: rule.chain,
+					Rule: strings.Join(rule.rule, " "), Repaired: err == nil})
+			}
+		}
+
+		for _, rule := range installed {
+			isIpv4, ok := ruleAddrFamily(rule.rule)
+			if !ok {
+				isIpv4 = rule.isIpv4
+			}
+			if isIpv4 != (family == ipv4) {
+				continue
+			}
+			expected = append(expected, rule.ruleInfo)
+			if ok, err := client.ChainExists(rule.table, rule.chain); err != nil || !ok {
+				// docker chain is gone along with the docker network
+				continue
+			}
+			if ok, err := client.Exists(rule.table, rule.chain, rule.rule...); err != nil || ok {
+				continue
+			}
+			pos := 1
+			if isStaticNodeRule(rule) {
+				// keep static node rules ahead of the default target
+				pos = i.getLastRuleCnt(rule.table, rule.chain, isIpv4) - 1
+				if pos <= 0 {
+					pos = 1
+				}
+			}
+			err := client.Insert(rule.table, rule.chain, pos, rule.rule...)
+			drift = append(drift, rule.drift(DriftMissing, family, err == nil))
+		}
+
+		expectedSpecs := make(map[string]bool)
+		for _, rule := range expected {
+			for _, spec := range expandIptablesRule(rule.rule) {
+				expectedSpecs[rule.table+" "+rule.chain+" "+canonicalIptablesRule(spec)] = true
+			}
+		}
+		for table, chains := range iptManagedChains {
+			for _, chain := range chains {
+				rules, err := client.List(table, chain)
+				if err != nil {
+					continue
+				}
+				for _, rule := range rules {
+					fields := strings.Fields(rule)
+					if len(fields) < 3 || fields[0] != "-A" || !containsComment(rule, netmakerSignature) {
+						continue
+					}
+					if expectedSpecs[table+" "+chain+" "+canonicalIptablesRule(fields[2:])] {
+						continue
+					}
+					drift = append(drift, Drift{Kind: DriftExtra, Family: family, Table: table, Chain: chain,
+						Rule: strings.Join(fields[2:], " ")})
+				}
+			}
+		}
+	}
+	return drift
+}
+
+// expandIptablesRule - iptables installs a rule per address of a comma separated source or destination
+func expandIptablesRule(spec []string) [][]string {
+	specs := [][]string{spec}
+	for idx := 0; idx < len(spec)-1; idx++ {
+		if spec[idx] != "-s" && spec[idx] != "-d" {
+			continue
+		}
+		expanded := [][]string{}
+		for _, s := range specs {
+			for _, addr := range strings.Split(spec[idx+1], ",") {
+				e := append([]string{}, s...)
+				e[idx+1] = addr
+				expanded = append(expanded, e)
+			}
+		}
+		specs = expanded
+	}
+	return specs
+}
+
+// canonicalIptablesRule - normalizes a rule spec so it can be compared with the output of iptables -S,
+// which adds prefix lengths, loads match modules and orders the options its own way
+func canonicalIptablesRule(spec []string) string {
+	units := []string{}
+	for idx := 0; idx < len(spec); idx++ {
+		negate := ""
+		if spec[idx] == "!" && idx+1 < len(spec) {
+			negate = "! "
+			idx++
+		}
+		option := spec[idx]
+		values := []string{}
+		for idx+1 < len(spec) && !strings.HasPrefix(spec[idx+1], "-") && spec[idx+1] != "!" {
+			idx++
+			values = append(values, spec[idx])
+		}
+		if option == "-m" || option == "--connlimit-saddr" {
+			continue
+		}
+		for v := range values {
+			switch option {
+			case "-s", "-d":
+				if !strings.Contains(values[v], "/") {
+					if ip := net.ParseIP(values[v]); ip != nil {
+						values[v] = ip.String() + "/128"
+						if ip.To4() != nil {
+							values[v] = ip.String() + "/32"
+						}
+					}
+				}
+			case "--ctstate":
+				states := strings.Split(values[v], ",")
+				sort.Strings(states)
+				values[v] = strings.Join(states, ",")
+			}
+		}
+		units = append(units, negate+option+" "+strings.Join(values, " "))
+	}
+	sort.Strings(units)
+	return strings.Join(units, " ")
+}
+
+// iptablesManager.RuleCounters - reads the counters of the acl chain rules. A rule matching a list
+// of addresses is installed once per address, its counters are summed
+func (i *iptablesManager) RuleCounters(rules []ruleInfo) []ruleCounter {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	counters := make(map[string]ruleCounter)
+	for _, client := range []*iptables.IPTables{i.ipv4Client, i.ipv6Client} {
+		for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+			listed, err := client.ListWithCounters(defaultIpTable, chain)
+			if err != nil {
+				logger.Log(1, "failed to read rules of chain", chain, err.Error())
+				continue
+			}
+			for _, rule := range listed {
+				fields := strings.Fields(rule)
+				if len(fields) < 3 || fields[0] != "-A" {
+					continue
+				}
+				spec, counter := splitIptablesCounters(fields[2:])
+				key := chain + " " + canonicalIptablesRule(spec)
+				c := counters[key]
+				c.packets += counter.packets
+				c.bytes += counter.bytes
+				counters[key] = c
+			}
+		}
+	}
+	result := make([]ruleCounter, len(rules))
+	for idx, rule := range rules {
+		for _, spec := range expandIptablesRule(rule.rule) {
+			c := counters[rule.chain+" "+canonicalIptablesRule(spec)]
+			result[idx].packets += c.packets
+			result[idx].bytes += c.bytes
+		}
+	}
+	return result
+}
+
+// splitIptablesCounters - removes the "-c packets bytes" option iptables -S -v adds to a rule
+func splitIptablesCounters(spec []string) ([]string, ruleCounter) {
+	var counter ruleCounter
+	for idx := 0; idx+2 < len(spec); idx++ {
+		if spec[idx] != "-c" {
+			continue
+		}
+		counter.packets, _ = strconv.ParseUint(spec[idx+1], 10, 64)
+		counter.bytes, _ = strconv.ParseUint(spec[idx+2], 10, 64)
+		return append(append([]string{}, spec[:idx]...), spec[idx+3:]...), counter
+	}
+	return spec, counter
 }
@@ -0,0 +1,71 @@
+package firewall
+
+// memFirewall - firewallController that keeps the netmaker chains in memory instead of the kernel.
+// Chains hold rule keys in the order the backend would install them, so the acl, egress and
+// ingress logic can be exercised without root
+type memFirewall struct {
+	*planFirewall
+	counters map[string]ruleCounter // chain and rule key -> counters
+}
+
+func newMemFirewall(backend string) *memFirewall {
+	return &memFirewall{
+		planFirewall: newPlanFirewall(backend),
+		counters:     make(map[string]ruleCounter),
+	}
+}
+
+// memFirewall.CreateChains - creates the empty netmaker chains with the default drop target
+func (m *memFirewall) CreateChains() error {
+	for _, family := range m.families() {
+		for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain, aclOutputRulesChain,
+			aclInputLimitChain, aclFwdLimitChain} {
+			k := m.chainKey(family, ruleInfo{table: defaultIpTable, chain: chain})
+			if _, ok := m.chains[k]; !ok {
+				m.chains[k] = []string{}
+			}
+		}
+	}
+	if len(m.targets) == 0 {
+		m.seedTargets(targetDrop)
+	}
+	return nil
+}
+
+// memFirewall.FlushAll - deletes the chains and the rule tables of all servers
+func (m *memFirewall) FlushAll() {
+	for _, tables := range m.tables {
+		for server := range tables {
+			delete(tables, server)
+		}
+	}
+	m.chains = make(map[string][]string)
+	m.targets = make(map[string]string)
+	m.logged = make(map[string]bool)
+}
+
+// memFirewall.rules - returns the rule keys of a chain in order
+func (m *memFirewall) rules(family, table, chain string) []string {
+	return m.chains[m.chainKey(family, ruleInfo{table: table, chain: chain})]
+}
+
+// memFirewall.target - returns the default target of an acl chain
+func (m *memFirewall) target(chain string) string {
+	return m.targets[chain]
+}
+
+// memFirewall.ops - returns the rule operations performed since the last call
+func (m *memFirewall) ops() []PlanOp {
+	ops := m.plan.Ops
+	m.plan.Ops = []PlanOp{}
+	return ops
+}
+
+// memFirewall.RuleCounters - returns the counters set for the rules
+func (m *memFirewall) RuleCounters(rules []ruleInfo) []ruleCounter {
+	counters := make([]ruleCounter, len(rules))
+	for idx, rule := range rules {
+		counters[idx] = m.counters[rule.chain+" "+genRuleKey(rule.rule...)]
+	}
+	return counters
+}
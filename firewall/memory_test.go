@@ -0,0 +1,625 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/ncutils"
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServer = "api.netmaker.test"
+
+func newTestFirewall(t *testing.T, backend string) *memFirewall {
+	t.Helper()
+	m := newMemFirewall(backend)
+	require.NoError(t, m.CreateChains())
+	m.ops()
+	return m
+}
+
+func cidr(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	return *ipNet
+}
+
+// ruleKey - key of a rule spec carrying the netmaker comment
+func ruleKey(spec ...string) string {
+	return genRuleKey(appendNetmakerCommentToRule(spec)...)
+}
+
+func (m *memFirewall) targetKey(target string) string {
+	return genRuleKey(m.targetRuleSpec(target)...)
+}
+
+func countOps(ops []PlanOp) (adds, deletes int) {
+	for _, op := range ops {
+		if op.Action == PlanActionDelete {
+			deletes++
+		} else {
+			adds++
+		}
+	}
+	return adds, deletes
+}
+
+func TestProcessAclRules(t *testing.T) {
+	web := models.AclRule{
+		ID:              "web",
+		IPList:          []net.IPNet{cidr(t, "100.64.0.2/32")},
+		IP6List:         []net.IPNet{cidr(t, "fd00::2/128")},
+		AllowedProtocol: models.TCP,
+		AllowedPorts:    []string{"443"},
+		Direction:       models.TrafficDirectionBi,
+		Allowed:         true,
+	}
+	webPorts := web
+	webPorts.AllowedPorts = []string{"443", "8443"}
+	dns := models.AclRule{
+		ID:              "dns",
+		IPList:          []net.IPNet{cidr(t, "100.64.0.3/32")},
+		Dst:             []net.IPNet{cidr(t, "100.64.0.1/32")},
+		AllowedProtocol: models.UDP,
+		AllowedPorts:    []string{"53"},
+		Allowed:         true,
+	}
+
+	cases := []struct {
+		name    string
+		backend string
+		before  map[string]models.AclRule
+		after   map[string]models.AclRule
+		want    map[string][]string // family -> acl input rule keys ahead of the target
+		adds    int
+		deletes int
+	}{
+		{
+			name:    "nftables adds new policy",
+			backend: models.FIREWALL_NFTABLES,
+			after:   map[string]models.AclRule{"web": web},
+			want: map[string][]string{familyInet: {
+				ruleKey("-s", "fd00::2/128", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+			}},
+			adds: 2,
+		},
+		{
+			name:    "nftables keeps unchanged policy",
+			backend: models.FIREWALL_NFTABLES,
+			before:  map[string]models.AclRule{"web": web, "dns": dns},
+			after:   map[string]models.AclRule{"web": web, "dns": dns},
+			want: map[string][]string{familyInet: {
+				ruleKey("-s", "fd00::2/128", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+				ruleKey("-s", "100.64.0.3/32", "-d", "100.64.0.1/32", "-p", "udp", "--dport", "53", "-j", "ACCEPT"),
+			}},
+		},
+		{
+			name:    "nftables replaces changed policy",
+			backend: models.FIREWALL_NFTABLES,
+			before:  map[string]models.AclRule{"web": web},
+			after:   map[string]models.AclRule{"web": webPorts},
+			want: map[string][]string{familyInet: {
+				ruleKey("-s", "fd00::2/128", "-p", "tcp", "--dport", "443,8443", "-j", "ACCEPT"),
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443,8443", "-j", "ACCEPT"),
+			}},
+			adds:    2,
+			deletes: 2,
+		},
+		{
+			name:    "nftables deletes removed policy",
+			backend: models.FIREWALL_NFTABLES,
+			before:  map[string]models.AclRule{"web": web, "dns": dns},
+			after:   map[string]models.AclRule{"dns": dns},
+			want: map[string][]string{familyInet: {
+				ruleKey("-s", "100.64.0.3/32", "-d", "100.64.0.1/32", "-p", "udp", "--dport", "53", "-j", "ACCEPT"),
+			}},
+			deletes: 2,
+		},
+		{
+			name:    "iptables splits policy by family and port",
+			backend: models.FIREWALL_IPTABLES,
+			after:   map[string]models.AclRule{"web": webPorts},
+			want: map[string][]string{
+				ipv4: {
+					ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "8443", "-j", "ACCEPT"),
+					ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+				},
+				ipv6: {
+					ruleKey("-s", "fd00::2/128", "-p", "tcp", "--dport", "8443", "-j", "ACCEPT"),
+					ruleKey("-s", "fd00::2/128", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+				},
+			},
+			adds: 4,
+		},
+		{
+			name:    "iptables deletes all policies",
+			backend: models.FIREWALL_IPTABLES,
+			before:  map[string]models.AclRule{"web": web, "dns": dns},
+			after:   map[string]models.AclRule{},
+			want:    map[string][]string{ipv4: {}, ipv6: {}},
+			deletes: 3,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestFirewall(t, tc.backend)
+			processAclRules(m, testServer, &models.FwUpdate{AclRules: tc.before})
+			m.ops()
+			processAclRules(m, testServer, &models.FwUpdate{AclRules: tc.after})
+			adds, deletes := countOps(m.ops())
+			assert.Equal(t, tc.adds, adds, "adds")
+			assert.Equal(t, tc.deletes, deletes, "deletes")
+			for family, want := range tc.want {
+				want = append(want, m.targetKey(targetDrop))
+				assert.Equal(t, want, m.rules(family, defaultIpTable, aclInputRulesChain), family)
+			}
+			assert.Len(t, m.FetchRuleTable(testServer, aclTable), len(tc.after))
+		})
+	}
+}
+
+func TestAllowAllTarget(t *testing.T) {
+	for _, backend := range []string{models.FIREWALL_NFTABLES, models.FIREWALL_IPTABLES} {
+		t.Run(backend, func(t *testing.T) {
+			m := newTestFirewall(t, backend)
+			for _, step := range []struct {
+				allowAll bool
+				target   string
+				ops      int
+			}{
+				{allowAll: false, target: targetDrop, ops: 0},
+				{allowAll: true, target: targetAccept, ops: 4 * len(m.families())},
+				{allowAll: true, target: targetAccept, ops: 0},
+				{allowAll: false, target: targetDrop, ops: 4 * len(m.families())},
+			} {
+				processAclRules(m, testServer, &models.FwUpdate{AllowAll: step.allowAll})
+				assert.Len(t, m.ops(), step.ops, "allow all %t", step.allowAll)
+				for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+					assert.Equal(t, step.target, m.target(chain))
+					for _, family := range m.families() {
+						assert.Equal(t, []string{m.targetKey(step.target)}, m.rules(family, defaultIpTable, chain))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEgressAclRules(t *testing.T) {
+	db := models.AclRule{
+		ID:              "db",
+		IPList:          []net.IPNet{cidr(t, "100.64.0.2/32")},
+		Dst:             []net.IPNet{cidr(t, "10.10.0.0/24")},
+		AllowedProtocol: models.TCP,
+		AllowedPorts:    []string{"5432"},
+		Allowed:         true,
+	}
+	dbKey := ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "5432", "-d", "10.10.0.0/24", "-j", "ACCEPT")
+	ssh := models.AclRule{
+		ID:              "ssh",
+		IPList:          []net.IPNet{cidr(t, "100.64.0.3/32")},
+		Dst:             []net.IPNet{cidr(t, "10.10.0.0/24")},
+		AllowedProtocol: models.TCP,
+		AllowedPorts:    []string{"22"},
+		Allowed:         true,
+	}
+	sshKey := ruleKey("-s", "100.64.0.3/32", "-p", "tcp", "--dport", "22", "-d", "10.10.0.0/24", "-j", "ACCEPT")
+	egressInfo := func(rules ...models.AclRule) models.EgressInfo {
+		info := models.EgressInfo{
+			EgressID:     "gw1",
+			Network:      cidr(t, "100.64.0.0/16"),
+			EgressGwAddr: cidr(t, "100.64.0.1/32"),
+			EgressGWCfg: models.EgressGatewayRequest{
+				RangesWithMetric: []models.EgressRangeMetric{{Network: "10.10.0.0/24"}},
+			},
+			EgressFwRules: make(map[string]models.AclRule),
+		}
+		for _, rule := range rules {
+			info.EgressFwRules[rule.ID] = rule
+		}
+		return info
+	}
+
+	cases := []struct {
+		name    string
+		updates []*models.FwUpdate
+		want    []string // acl forward rule keys ahead of the target
+		tables  []string // keys of the egress rule table
+	}{
+		{
+			name: "adds egress acl rules",
+			updates: []*models.FwUpdate{
+				{IsEgressGw: true, EgressInfo: map[string]models.EgressInfo{"gw1": egressInfo(db)}},
+			},
+			want:   []string{dbKey},
+			tables: []string{"acl#gw1", "gw1"},
+		},
+		{
+			name: "upserts and deletes egress acl rules",
+			updates: []*models.FwUpdate{
+				{IsEgressGw: true, EgressInfo: map[string]models.EgressInfo{"gw1": egressInfo(db)}},
+				{IsEgressGw: true, EgressInfo: map[string]models.EgressInfo{"gw1": egressInfo(ssh)}},
+			},
+			want:   []string{sshKey},
+			tables: []string{"acl#gw1", "gw1"},
+		},
+		{
+			name: "removes egress gateway",
+			updates: []*models.FwUpdate{
+				{IsEgressGw: true, EgressInfo: map[string]models.EgressInfo{"gw1": egressInfo(db, ssh)}},
+				{IsEgressGw: false},
+			},
+			want:   []string{},
+			tables: []string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTestFirewall(t, models.FIREWALL_NFTABLES)
+			for _, update := range tc.updates {
+				applyFwUpdate(m, testServer, update)
+			}
+			want := append(tc.want, m.targetKey(targetDrop))
+			assert.Equal(t, want, m.rules(familyInet, defaultIpTable, aclFwdRulesChain))
+			assert.Equal(t, tc.tables, sortedKeys(m.FetchRuleTable(testServer, egressTable)))
+		})
+	}
+}
+
+func TestIngressStaticNodeRules(t *testing.T) {
+	ingressInfo := func(staticIps ...string) models.IngressInfo {
+		info := models.IngressInfo{
+			IngressID: "ingress1",
+			Network:   cidr(t, "100.64.0.0/16"),
+			Rules: []models.FwRule{{
+				SrcIP:           cidr(t, "100.64.0.5/32"),
+				DstIP:           cidr(t, "100.64.0.8/32"),
+				AllowedProtocol: models.TCP,
+				AllowedPorts:    []string{"80"},
+				Allow:           true,
+			}},
+		}
+		for _, ip := range staticIps {
+			info.StaticNodeIps = append(info.StaticNodeIps, net.ParseIP(ip))
+		}
+		return info
+	}
+	allowKeys := map[string]string{
+		models.FIREWALL_NFTABLES: ruleKey("-s", "100.64.0.5/32", "-p", "tcp", "-d", "100.64.0.8/32", "--dport", "80", "-j", "ACCEPT"),
+		models.FIREWALL_IPTABLES: ruleKey("-s", "100.64.0.5/32", "-d", "100.64.0.8/32", "-p", "tcp", "--dport", "80", "-j", "ACCEPT"),
+	}
+
+	cases := []struct {
+		name    string
+		updates []*models.FwUpdate
+		target  string
+		allowed bool     // the ingress allow rule is ahead of the static node rules
+		want    []string // static node rule keys ahead of the target
+	}{
+		{
+			name: "static node rules are kept ahead of the target",
+			updates: []*models.FwUpdate{
+				{IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.5")}},
+			},
+			target:  targetDrop,
+			allowed: true,
+			want: []string{
+				ruleKey("-s", "100.64.0.5", "-j", "DROP"),
+				ruleKey("-d", "100.64.0.5", "-j", "DROP"),
+			},
+		},
+		{
+			name: "allow all keeps static node rules ahead of the target",
+			updates: []*models.FwUpdate{
+				{IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.5")}},
+				{AllowAll: true, IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.5")}},
+			},
+			target:  targetAccept,
+			allowed: true,
+			want: []string{
+				ruleKey("-s", "100.64.0.5", "-j", "DROP"),
+				ruleKey("-d", "100.64.0.5", "-j", "DROP"),
+			},
+		},
+		{
+			name: "refreshes rules of a changed static node",
+			updates: []*models.FwUpdate{
+				{IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.5")}},
+				{IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.6")}},
+			},
+			target:  targetDrop,
+			allowed: true,
+			want: []string{
+				ruleKey("-s", "100.64.0.6", "-j", "DROP"),
+				ruleKey("-d", "100.64.0.6", "-j", "DROP"),
+			},
+		},
+		{
+			name: "removes ingress gateway",
+			updates: []*models.FwUpdate{
+				{IsIngressGw: true, IngressInfo: map[string]models.IngressInfo{"ingress1": ingressInfo("100.64.0.5")}},
+				{IsIngressGw: false},
+			},
+			target: targetDrop,
+			want:   []string{},
+		},
+	}
+	for _, tc := range cases {
+		for backend, family := range map[string]string{models.FIREWALL_NFTABLES: familyInet, models.FIREWALL_IPTABLES: ipv4} {
+			t.Run(tc.name+"/"+backend, func(t *testing.T) {
+				m := newTestFirewall(t, backend)
+				for _, update := range tc.updates {
+					applyFwUpdate(m, testServer, update)
+				}
+				want := []string{}
+				if tc.allowed {
+					want = append(want, allowKeys[backend])
+				}
+				want = append(want, tc.want...)
+				assert.Equal(t, append(want, m.targetKey(tc.target)), m.rules(family, defaultIpTable, aclInputRulesChain))
+				// both drop rules of a static node are tracked so they are removed with it
+				tracked := m.FetchRuleTable(testServer, ingressTable)["ingress1"].rulesMap[staticNodeRules]
+				assert.Len(t, tracked, len(want))
+			})
+		}
+	}
+}
+
+func TestAclRuleDestinations(t *testing.T) {
+	src := []net.IPNet{cidr(t, "100.64.0.2/32")}
+	cases := []struct {
+		name   string
+		egress bool
+		ipv6   bool
+		rule   models.AclRule
+		want   map[string][]string // backend -> acl rule keys ahead of the target
+	}{
+		{
+			name: "ipv6 policy keeps a rule per destination",
+			ipv6: true,
+			rule: models.AclRule{
+				ID:      "v6",
+				IP6List: []net.IPNet{cidr(t, "fd00::2/128")},
+				Dst6:    []net.IPNet{cidr(t, "fd00::10/128"), cidr(t, "fd00::11/128")},
+			},
+			want: map[string][]string{
+				models.FIREWALL_NFTABLES: {
+					ruleKey("-s", "fd00::2/128", "-d", "fd00::11/128", "-j", "ACCEPT"),
+					ruleKey("-s", "fd00::2/128", "-d", "fd00::10/128", "-j", "ACCEPT"),
+				},
+				models.FIREWALL_IPTABLES: {
+					ruleKey("-s", "fd00::2/128", "-d", "fd00::10/128,fd00::11/128", "-j", "ACCEPT"),
+				},
+			},
+		},
+		{
+			name:   "egress policy ignores an unset destination",
+			egress: true,
+			rule: models.AclRule{
+				ID:     "db",
+				IPList: src,
+				Dst:    []net.IPNet{{}, cidr(t, "10.10.0.0/24")},
+			},
+			want: map[string][]string{
+				models.FIREWALL_NFTABLES: {ruleKey("-s", "100.64.0.2/32", "-d", "10.10.0.0/24", "-j", "ACCEPT")},
+				models.FIREWALL_IPTABLES: {ruleKey("-s", "100.64.0.2/32", "-d", "10.10.0.0/24", "-j", "ACCEPT")},
+			},
+		},
+		{
+			name:   "egress policy without a set destination matches the source",
+			egress: true,
+			rule: models.AclRule{
+				ID:     "db",
+				IPList: src,
+				Dst:    []net.IPNet{{}},
+			},
+			want: map[string][]string{
+				models.FIREWALL_NFTABLES: {ruleKey("-s", "100.64.0.2/32", "-j", "ACCEPT")},
+				models.FIREWALL_IPTABLES: {ruleKey("-s", "100.64.0.2/32", "-j", "ACCEPT")},
+			},
+		},
+	}
+	for _, tc := range cases {
+		for backend, want := range tc.want {
+			t.Run(tc.name+"/"+backend, func(t *testing.T) {
+				m := newTestFirewall(t, backend)
+				family := familyInet
+				if backend == models.FIREWALL_IPTABLES {
+					family = ipv4
+					if tc.ipv6 {
+						family = ipv6
+					}
+				}
+				chain := aclInputRulesChain
+				if tc.egress {
+					chain = aclFwdRulesChain
+					m.UpsertAclEgressRule(testServer, "acl#gw1", tc.rule)
+				} else {
+					m.UpsertAclRule(testServer, tc.rule)
+				}
+				assert.Equal(t, append(want, m.targetKey(targetDrop)), m.rules(family, defaultIpTable, chain))
+			})
+		}
+	}
+}
+
+func TestApplyFwUpdate(t *testing.T) {
+	m := newTestFirewall(t, models.FIREWALL_NFTABLES)
+	orig, origTarget := fwCrtl, aclTarget
+	fwCrtl = m
+	t.Cleanup(func() { fwCrtl, aclTarget = orig, origTarget })
+
+	update := &models.FwUpdate{
+		IsEgressGw: true,
+		EgressInfo: map[string]models.EgressInfo{"gw1": {
+			EgressID:     "gw1",
+			Network:      cidr(t, "100.64.0.0/16"),
+			EgressGwAddr: cidr(t, "100.64.0.1/32"),
+			EgressGWCfg: models.EgressGatewayRequest{
+				RangesWithMetric: []models.EgressRangeMetric{{Network: "10.10.0.0/24"}},
+			},
+			EgressFwRules: map[string]models.AclRule{"db": {
+				ID:     "db",
+				IPList: []net.IPNet{cidr(t, "100.64.0.2/32")},
+				Dst:    []net.IPNet{cidr(t, "10.10.0.0/24")},
+			}},
+		}},
+		IsIngressGw: true,
+		IngressInfo: map[string]models.IngressInfo{"ingress1": {
+			IngressID:     "ingress1",
+			Network:       cidr(t, "100.64.0.0/16"),
+			StaticNodeIps: []net.IP{net.ParseIP("100.64.0.5")},
+		}},
+		AclRules: map[string]models.AclRule{"web": {
+			ID:     "web",
+			IPList: []net.IPNet{cidr(t, "100.64.0.3/32")},
+		}},
+	}
+	require.NoError(t, ApplyFwUpdate(testServer, update))
+	assert.NotEmpty(t, m.FetchRuleTable(testServer, egressTable))
+	assert.NotEmpty(t, m.FetchRuleTable(testServer, ingressTable))
+	assert.Len(t, m.FetchRuleTable(testServer, aclTable), 1)
+
+	// gateways that are gone are cleaned up along with the acl rules
+	require.NoError(t, ApplyFwUpdate(testServer, &models.FwUpdate{AllowAll: true}))
+	assert.Empty(t, m.FetchRuleTable(testServer, egressTable))
+	assert.Empty(t, m.FetchRuleTable(testServer, ingressTable))
+	assert.Empty(t, m.FetchRuleTable(testServer, aclTable))
+	for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+		assert.Equal(t, []string{m.targetKey(targetAccept)}, m.rules(familyInet, defaultIpTable, chain))
+	}
+	assert.Equal(t, targetAccept, aclTarget)
+}
+
+func TestAclLimits(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	setLimits := func(limit config.AclLimit) {
+		cfg := host
+		cfg.AclLimits = map[string]config.AclLimit{"web": limit}
+		config.UpdateNetclient(cfg)
+	}
+	web := models.AclRule{
+		ID:              "web",
+		IPList:          []net.IPNet{cidr(t, "100.64.0.2/32")},
+		AllowedProtocol: models.TCP,
+		AllowedPorts:    []string{"443"},
+	}
+	update := &models.FwUpdate{AclRules: map[string]models.AclRule{"web": web}}
+
+	accept := ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT")
+	cases := []struct {
+		backend string
+		limit   config.AclLimit
+		want    []string // rules of the input limit chain, the last inserted first
+	}{
+		{
+			backend: models.FIREWALL_NFTABLES,
+			limit:   config.AclLimit{MaxConns: 10, PacketsPerSec: 100},
+			want: []string{
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443",
+					"--meter", hashlimitName("web")+"-p4-100", "--limit-above", "100/second", "-j", targetDrop),
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-m", "conntrack", "--ctstate", "NEW",
+					"--meter", hashlimitName("web")+"-c4-10", "--connlimit-above", "10", "-j", targetDrop),
+			},
+		},
+		{
+			backend: models.FIREWALL_IPTABLES,
+			limit:   config.AclLimit{MaxConns: 10, BytesPerSec: 2048},
+			want: []string{
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443",
+					"-m", "hashlimit", "--hashlimit-above", "2kb/s", "--hashlimit-mode", "srcip",
+					"--hashlimit-name", hashlimitName("web")+"b", "-j", targetDrop),
+				ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-m", "conntrack", "--ctstate", "NEW",
+					"-m", "connlimit", "--connlimit-above", "10", "--connlimit-mask", "32", "-j", targetDrop),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.backend, func(t *testing.T) {
+			setLimits(config.AclLimit{})
+			m := newTestFirewall(t, tc.backend)
+			processAclRules(m, testServer, update)
+			m.ops()
+
+			// a limit change replaces the rules of the policy, the accept rule is left without limits
+			// so the limit rules see the established traffic of the policy too
+			setLimits(tc.limit)
+			processAclRules(m, testServer, update)
+			adds, deletes := countOps(m.ops())
+			assert.Equal(t, 3, adds)
+			assert.Equal(t, 1, deletes)
+			family := m.families()[0]
+			assert.Equal(t, []string{accept, m.targetKey(targetDrop)}, m.rules(family, defaultIpTable, aclInputRulesChain))
+			assert.Equal(t, tc.want, m.rules(family, defaultIpTable, aclInputLimitChain))
+
+			processAclRules(m, testServer, update)
+			assert.Empty(t, m.ops(), "unchanged limits")
+
+			setLimits(config.AclLimit{})
+			processAclRules(m, testServer, update)
+			assert.Empty(t, m.rules(family, defaultIpTable, aclInputLimitChain))
+		})
+	}
+}
+
+func TestDropLog(t *testing.T) {
+	host := *config.Netclient()
+	t.Cleanup(func() { config.UpdateNetclient(host) })
+	setDropLog := func(enabled bool) {
+		cfg := host
+		cfg.DropLog = enabled
+		config.UpdateNetclient(cfg)
+	}
+	iface := ncutils.GetInterfaceName()
+	cases := []struct {
+		backend string
+		want    []string
+	}{
+		{
+			backend: models.FIREWALL_NFTABLES,
+			want: []string{
+				genRuleKey("-i", iface, "--limit", "10/second", "--limit-burst", "20",
+					"--nflog-group", "100", "--nflog-prefix", aclInputRulesChain),
+				genRuleKey("-i", iface, "-j", targetDrop),
+			},
+		},
+		{
+			backend: models.FIREWALL_IPTABLES,
+			want: []string{
+				genRuleKey("-i", iface, "-m", "limit", "--limit", "10/sec", "--limit-burst", "20",
+					"-m", "comment", "--comment", netmakerSignature, "-j", "NFLOG",
+					"--nflog-group", "100", "--nflog-prefix", aclInputRulesChain),
+				genRuleKey("-i", iface, "-m", "comment", "--comment", netmakerSignature, "-j", targetDrop),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.backend, func(t *testing.T) {
+			setDropLog(false)
+			m := newTestFirewall(t, tc.backend)
+			family := m.families()[0]
+
+			setDropLog(true)
+			processAclRules(m, testServer, &models.FwUpdate{})
+			assert.Equal(t, tc.want, m.rules(family, defaultIpTable, aclInputRulesChain))
+			m.ops()
+			processAclRules(m, testServer, &models.FwUpdate{})
+			assert.Empty(t, m.ops(), "unchanged drop log")
+
+			// an accept target has nothing to log
+			processAclRules(m, testServer, &models.FwUpdate{AllowAll: true})
+			assert.Equal(t, []string{m.targetKey(targetAccept)}, m.rules(family, defaultIpTable, aclInputRulesChain))
+
+			setDropLog(false)
+			processAclRules(m, testServer, &models.FwUpdate{})
+			assert.Equal(t, []string{m.targetKey(targetDrop)}, m.rules(family, defaultIpTable, aclInputRulesChain))
+		})
+	}
+}
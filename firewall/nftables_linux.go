@@ -1,6 +1,7 @@
 package firewall
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -22,10 +23,11 @@ import (
 )
 
 type nftablesManager struct {
-	conn         *nftables.Conn
+	conn         *nftConn
 	ingRules     serverrulestable
 	engressRules serverrulestable
 	aclRules     serverrulestable
+	snapshot     *nftSnapshot // ruleset before the current transaction
 	mux          sync.Mutex
 }
 
@@ -64,6 +66,8 @@ var (
 		},
 	}
 	nfFilterJumpRules = []ruleInfo{
+		// acl limits are enforced ahead of the conntrack accept rules
+		nfLimitJumpRule(iptableINChain, []string{"-i", ncutils.GetInterfaceName(), "-j", aclInputLimitChain}),
 		{
 			nfRule: &nftables.Rule{
 				Table: filterTable,
@@ -304,6 +308,11 @@ var (
 			table: defaultIpTable,
 			chain: iptableFWDChain,
 		},
+		// FORWARD rules are inserted on top, the limit jumps are listed last to end up first
+		nfLimitJumpRule(iptableFWDChain, []string{"-i", ncutils.GetInterfaceName(), "!", "-o", ncutils.GetInterfaceName(),
+			"-j", aclFwdLimitChain}),
+		nfLimitJumpRule(iptableFWDChain, []string{"-i", ncutils.GetInterfaceName(), "-o", ncutils.GetInterfaceName(),
+			"-j", aclInputLimitChain}),
 		{
 			nfRule: &nftables.Rule{
 				Table: filterTable,
@@ -382,6 +391,16 @@ func (n *nftablesManager) AddDropRules(dropRules []ruleInfo) {
 func (n *nftablesManager) CreateChains() error {
 	n.mux.Lock()
 	defer n.mux.Unlock()
+	if err := n.addChains(); err != nil {
+		return err
+	}
+	// add jump rules
+	n.addJumpRules()
+	return nil
+}
+
+// nftables.addChains - creates the netmaker tables and chains, existing ones are left untouched
+func (n *nftablesManager) addChains() error {
 	n.conn.AddTable(filterTable)
 	n.conn.AddTable(natTable)
 
@@ -466,18 +485,19 @@ func (n *nftablesManager) CreateChains() error {
 		Name:  aclOutputRulesChain,
 		Table: filterTable,
 	})
+	for _, chain := range []string{aclInputLimitChain, aclFwdLimitChain} {
+		n.conn.AddChain(&nftables.Chain{
+			Name:  chain,
+			Table: filterTable,
+		})
+	}
 	natChain := &nftables.Chain{
 		Name:  netmakerNatChain,
 		Table: natTable,
 	}
 	n.conn.AddChain(natChain)
 
-	if err := n.conn.Flush(); err != nil {
-		return err
-	}
-	// add jump rules
-	n.addJumpRules()
-	return nil
+	return n.conn.Flush()
 }
 
 // nftables.ForwardRule - forward netmaker traffic (not implemented)
@@ -552,11 +572,11 @@ func (n *nftablesManager) InsertEgressRoutingRules(server string, egressInfo mod
 				logger.Log(0, "failed to get interface name: ", egressRangeIface, err.Error())
 			} else {
 				logger.Log(0, fmt.Sprintf("Egress range %s uses interface: %s", egressGwRange.Network, egressRangeIface))
-				ruleSpec := []string{"-s", source, "-o", egressRangeIface, "-j", "MASQUERADE"}
+				singleNode := len(config.GetNodes()) == 1
+				ruleSpec := egressNatRuleSpec(source, egressRangeIface, singleNode)
 				// to avoid duplicate iface route rule,delete if exists
 				var exp []expr.Any
-				if len(config.GetNodes()) == 1 {
-					ruleSpec = []string{"-o", egressRangeIface, "-j", "MASQUERADE"}
+				if singleNode {
 					exp = []expr.Any{
 
 						// Match outgoing interface by index
@@ -666,7 +686,7 @@ func (n *nftablesManager) InsertEgressRoutingRules(server string, egressInfo mod
 				// Add Docker-specific rule if egress interface is a Docker network
 				if isDockerInterface(egressRangeIface) {
 					logger.Log(0, fmt.Sprintf("Detected Docker interface: %s", egressRangeIface))
-					dockerRuleSpec := []string{"-i", ncutils.GetInterfaceName(), "-o", egressRangeIface, "-j", "ACCEPT"}
+					dockerRuleSpec := nftDockerRuleSpec(egressRangeIface)
 					// Check if DOCKER-USER chain exists in IPv4 filter table (Docker uses 'ip' family, not 'inet')
 					dockerUserChain := &nftables.Chain{
 						Name:  "DOCKER-USER",
@@ -897,10 +917,46 @@ func (n *nftablesManager) FlushAll() {
 	if err := n.conn.Flush(); err != nil {
 		logger.Log(0, "Error flushing tables: ", err.Error())
 	}
+	n.deleteLimitSets(nil)
 }
 
 // private functions
 
+// nfLimitJumpRule - returns the jump rule into an acl limit chain, for a rule spec matching on interfaces
+func nfLimitJumpRule(chain string, spec []string) ruleInfo {
+	e := []expr.Any{}
+	op := expr.CmpOpEq
+	for i := 0; i < len(spec)-1; i++ {
+		switch spec[i] {
+		case "!":
+			op = expr.CmpOpNeq
+		case "-i", "-o":
+			key := expr.MetaKeyIIFNAME
+			if spec[i] == "-o" {
+				key = expr.MetaKeyOIFNAME
+			}
+			e = append(e,
+				&expr.Meta{Key: key, Register: 1},
+				&expr.Cmp{Op: op, Register: 1, Data: []byte(spec[i+1] + "\x00")},
+			)
+			op = expr.CmpOpEq
+		case "-j":
+			e = append(e, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictJump, Chain: spec[i+1]})
+		}
+	}
+	return ruleInfo{
+		nfRule: &nftables.Rule{
+			Table:    filterTable,
+			Chain:    &nftables.Chain{Name: chain},
+			Exprs:    e,
+			UserData: []byte(genRuleKey(spec...)),
+		},
+		rule:  spec,
+		table: defaultIpTable,
+		chain: chain,
+	}
+}
+
 //lint:ignore U1000 might be useful in future
 func (n *nftablesManager) getTable(tableName string) (*nftables.Table, error) {
 	tables, err := n.conn.ListTables()
@@ -988,9 +1044,10 @@ func (n *nftablesManager) addJumpRules() {
 
 	for _, rule := range nfFilterJumpRules {
 		nfRule := rule.nfRule.(*nftables.Rule)
-		if nfRule.Chain.Name == iptableFWDChain {
+		if jumpRuleAtTop(rule) {
 			nfRule.Position = 0
 			n.conn.InsertRule(nfRule)
+			continue
 		}
 		n.conn.AddRule(nfRule)
 	}
@@ -1061,6 +1118,27 @@ func (n *nftablesManager) addJumpRules() {
 				},
 			},
 		})
+		// dns over tcp, for answers too large for udp
+		n.conn.InsertRule(&nftables.Rule{
+			Table: filterTable,
+			Chain: &nftables.Chain{Name: aclInputRulesChain},
+			Exprs: []expr.Any{
+				// [ meta load iifname => reg 1 ]
+				&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+				// [ cmp eq reg 1 netmaker ]
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte("netmaker\x00")},
+				// [ meta load l4proto => reg 1 ]
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				// [ cmp eq reg 1 0x06 ]  (TCP)
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+				// [ payload load 2b @ transport header + 2 => reg 1 ] (dport)
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				// [ cmp eq reg 1 53 ]
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x00, 0x35}},
+				// [ verdict accept ]
+				&expr.Verdict{Kind: expr.VerdictAccept},
+			},
+		})
 	}
 	if err := n.conn.Flush(); err != nil {
 		logger.Log(0, fmt.Sprintf("failed to add jump rules, Err: %s", err.Error()))
@@ -1078,10 +1156,6 @@ func (n *nftablesManager) removeJumpRules() {
 	}
 }
 
-func genRuleKey(rule ...string) string {
-	return strings.Join(rule, ":")
-}
-
 func (n *nftablesManager) getExprForProto(proto models.Protocol, isv4 bool) []expr.Any {
 
 	ipNetHeaderExpr := &expr.Payload{
@@ -1214,12 +1288,9 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 	}
 	ingressGwRoutes := []ruleInfo{}
 	for _, ip := range ingressInfo.StaticNodeIps {
-		ruleSpec := []string{"-s", ip.String(), "-j", targetDrop}
-		ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-		n.deleteRule(defaultIpTable, iptableINChain, genRuleKey(ruleSpec...))
-		druleSpec := []string{"-d", ip.String(), "-j", targetDrop}
-		druleSpec = appendNetmakerCommentToRule(druleSpec)
-		n.deleteRule(defaultIpTable, iptableINChain, genRuleKey(druleSpec...))
+		ruleSpec, druleSpec := staticNodeRuleSpecs(ip)
+		n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
+		n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(druleSpec...))
 		// to avoid duplicate iface route rule,delete if exists
 		rule := &nftables.Rule{}
 		drule := &nftables.Rule{}
@@ -1240,6 +1311,7 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 						Register: 1,
 						Data:     ip.To4(),
 					},
+					&expr.Counter{},
 					// Jump to the netmakerfilter chain
 					&expr.Verdict{
 						Kind: expr.VerdictDrop,
@@ -1263,6 +1335,7 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 						Register: 1,
 						Data:     ip.To4(),
 					},
+					&expr.Counter{},
 					// Drop the packet
 					&expr.Verdict{
 						Kind: expr.VerdictDrop,
@@ -1288,6 +1361,7 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 						Data:     ip.To16(), // IPv6 source address
 					},
 
+					&expr.Counter{},
 					// Jump to the netmakerfilter chain
 					&expr.Verdict{
 						Kind: expr.VerdictDrop,
@@ -1311,6 +1385,7 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 						Register: 1,
 						Data:     ip.To16(),
 					},
+					&expr.Counter{},
 					// Drop the packet
 					&expr.Verdict{
 						Kind: expr.VerdictDrop,
@@ -1338,6 +1413,11 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 				table:  defaultIpTable,
 				chain:  aclInputRulesChain,
 				rule:   ruleSpec,
+			}, ruleInfo{
+				nfRule: drule,
+				table:  defaultIpTable,
+				chain:  aclInputRulesChain,
+				rule:   druleSpec,
 			})
 		}
 	}
@@ -1345,17 +1425,7 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 		if !rule.Allow {
 			continue
 		}
-		ruleSpec := []string{"-s", rule.SrcIP.String()}
-		if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL {
-			ruleSpec = append(ruleSpec, "-p", rule.AllowedProtocol.String())
-		}
-		ruleSpec = append(ruleSpec, "-d", rule.DstIP.String())
-		if len(rule.AllowedPorts) > 0 {
-			ruleSpec = append(ruleSpec, "--dport",
-				strings.Join(rule.AllowedPorts, ","))
-		}
-		ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-		ruleSpec = appendNetmakerCommentToRule(ruleSpec)
+		ruleSpec := nftIngressRuleSpec(rule)
 		n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
 		var nfRule *nftables.Rule
 		if rule.SrcIP.IP.To4() != nil {
@@ -1391,7 +1461,9 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 			if len(rule.AllowedPorts) > 0 {
 				e = append(e, n.getExprForPort(rule.AllowedPorts)...)
 			}
-			e = append(e, // Accept the packet
+			e = append(e,
+				&expr.Counter{},
+				// Accept the packet
 				&expr.Verdict{
 					Kind: expr.VerdictAccept, // ACCEPT verdict
 				})
@@ -1435,7 +1507,9 @@ func (n *nftablesManager) InsertIngressRoutingRules(server string, ingressInfo m
 			if len(rule.AllowedPorts) > 0 {
 				e = append(e, n.getExprForPort(rule.AllowedPorts)...)
 			}
-			e = append(e, // Accept the packet
+			e = append(e,
+				&expr.Counter{},
+				// Accept the packet
 				&expr.Verdict{
 					Kind: expr.VerdictAccept, // ACCEPT verdict
 				})
@@ -1569,211 +1643,12 @@ func (n *nftablesManager) AddAclRules(server string, aclRules map[string]models.
 		ruleTable = make(ruletable)
 	}
 	for _, aclRule := range aclRules {
-		rules := []ruleInfo{}
 		if _, ok := ruleTable[aclRule.ID]; !ok {
 			ruleTable[aclRule.ID] = rulesCfg{
 				rulesMap: make(map[string][]ruleInfo),
 			}
 		}
-		if len(aclRule.IPList) > 0 {
-			for _, ip := range aclRule.IPList {
-				if len(aclRule.Dst) > 0 {
-					for _, dstI := range aclRule.Dst {
-						if dstI.IP == nil {
-							continue
-						}
-						ruleSpec := []string{"-s", ip.String(), "-d", dstI.String()}
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							ruleSpec = append(ruleSpec, "--dport",
-								strings.Join(aclRule.AllowedPorts, ","))
-						}
-						ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-						ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-						n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-						e := []expr.Any{}
-						e = append(e, n.GetIpExpr(ip, dstI)...)
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-						}
-
-						e = append(e, // Accept the packet
-							&expr.Verdict{
-								Kind: expr.VerdictAccept, // ACCEPT verdict
-							})
-						nfRule := &nftables.Rule{
-							Table:    filterTable,
-							Chain:    &nftables.Chain{Name: aclInputRulesChain},
-							Exprs:    e,
-							UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-						}
-						n.conn.InsertRule(nfRule)
-						if err := n.conn.Flush(); err != nil {
-							logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-						} else {
-							rules = append(rules, ruleInfo{
-								isIpv4: true,
-								nfRule: nfRule,
-								table:  defaultIpTable,
-								chain:  aclInputRulesChain,
-								rule:   ruleSpec,
-							})
-
-						}
-					}
-				} else {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclInputRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: true,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclInputRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-			}
-
-		}
-
-		if len(aclRule.IP6List) > 0 {
-			for _, ip := range aclRule.IP6List {
-				if len(aclRule.Dst6) > 0 {
-					for _, dstI := range aclRule.Dst6 {
-						if dstI.IP == nil {
-							continue
-						}
-						ruleSpec := []string{"-s", ip.String(), "-d", dstI.String()}
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							ruleSpec = append(ruleSpec, "--dport",
-								strings.Join(aclRule.AllowedPorts, ","))
-						}
-						ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-						ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-						n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-						e := []expr.Any{}
-						e = append(e, n.GetIpExpr(ip, dstI)...)
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-						}
-
-						e = append(e, // Accept the packet
-							&expr.Verdict{
-								Kind: expr.VerdictAccept, // ACCEPT verdict
-							})
-						nfRule := &nftables.Rule{
-							Table:    filterTable,
-							Chain:    &nftables.Chain{Name: aclInputRulesChain},
-							Exprs:    e,
-							UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-						}
-						n.conn.InsertRule(nfRule)
-						if err := n.conn.Flush(); err != nil {
-							logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-						} else {
-							rules = append(rules, ruleInfo{
-								isIpv4: false,
-								nfRule: nfRule,
-								table:  defaultIpTable,
-								chain:  aclInputRulesChain,
-								rule:   ruleSpec,
-							})
-
-						}
-					}
-				} else {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclInputRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: false,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclInputRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-
-			}
-		}
+		rules := n.insertAclRuleSpecs(aclInputRulesChain, aclRule, nftAclRuleSpecs(aclRule, false))
 		if len(rules) > 0 {
 			rCfg := rulesCfg{
 				rulesMap: map[string][]ruleInfo{
@@ -1786,221 +1661,227 @@ func (n *nftablesManager) AddAclRules(server string, aclRules map[string]models.
 	}
 }
 
-func (n *nftablesManager) UpsertAclRule(server string, aclRule models.AclRule) {
-	ruleTable := n.FetchRuleTable(server, aclTable)
-	defer n.SaveRules(server, aclTable, ruleTable)
-	n.mux.Lock()
-	defer n.mux.Unlock()
-	ruleTable[aclRule.ID] = rulesCfg{
-		rulesMap: make(map[string][]ruleInfo),
-	}
+// nftablesManager.insertAclRuleSpecs - inserts the rules of an acl policy at the top of the chain,
+// the rules enforcing its limits go to the top of the matching limit chain
+func (n *nftablesManager) insertAclRuleSpecs(chain string, aclRule models.AclRule, specs []aclRuleSpec) []ruleInfo {
 	rules := []ruleInfo{}
-	if _, ok := ruleTable[aclRule.ID]; !ok {
-		ruleTable[aclRule.ID] = rulesCfg{
-			rulesMap: make(map[string][]ruleInfo),
+	for _, spec := range specs {
+		n.deleteRule(defaultIpTable, chain, genRuleKey(spec.spec...))
+		e := n.aclMatchExprs(aclRule, spec)
+		e = append(e,
+			&expr.Counter{},
+			// Accept the packet
+			&expr.Verdict{
+				Kind: expr.VerdictAccept, // ACCEPT verdict
+			})
+		nfRule := &nftables.Rule{
+			Table:    filterTable,
+			Chain:    &nftables.Chain{Name: chain},
+			Exprs:    e,
+			UserData: []byte(genRuleKey(spec.spec...)), // Equivalent to the comment in iptables
 		}
-	}
-	if len(aclRule.IPList) > 0 {
-		for _, ip := range aclRule.IPList {
-			if len(aclRule.Dst) > 0 {
-				for _, dstI := range aclRule.Dst {
-					if dstI.IP == nil {
-						continue
-					}
-					ruleSpec := []string{"-s", ip.String(), "-d", dstI.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, dstI)...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclInputRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: true,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclInputRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-			} else {
-				ruleSpec := []string{"-s", ip.String()}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					ruleSpec = append(ruleSpec, "--dport",
-						strings.Join(aclRule.AllowedPorts, ","))
-				}
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-				e := []expr.Any{}
-				e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-				}
-
-				e = append(e, // Accept the packet
-					&expr.Verdict{
-						Kind: expr.VerdictAccept, // ACCEPT verdict
-					})
-				nfRule := &nftables.Rule{
-					Table:    filterTable,
-					Chain:    &nftables.Chain{Name: aclInputRulesChain},
-					Exprs:    e,
-					UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-				}
-				n.conn.InsertRule(nfRule)
-				if err := n.conn.Flush(); err != nil {
-					logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: true,
-						nfRule: nfRule,
-						table:  defaultIpTable,
-						chain:  aclInputRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
+		n.conn.InsertRule(nfRule)
+		if err := n.conn.Flush(); err != nil {
+			logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", spec.spec, err.Error()))
+			continue
+		}
+		rules = append(rules, ruleInfo{
+			isIpv4: spec.isIpv4,
+			nfRule: nfRule,
+			table:  defaultIpTable,
+			chain:  chain,
+			rule:   spec.spec,
+			limit:  spec.limit,
+		})
+		limitChain := aclLimitChain(chain)
+		for _, limit := range spec.limits {
+			n.deleteRule(defaultIpTable, limitChain, genRuleKey(limit.spec...))
+			set := nftLimitSet(limit.set)
+			if err := n.conn.AddSet(set, nil); err != nil {
+				logger.Log(0, fmt.Sprintf("failed to add meter: %v, Err: %v ", limit.set, err.Error()))
+				continue
 			}
-
+			e := n.aclMatchExprs(aclRule, spec)
+			e = append(e, nftLimitExprs(limit.kind, set, spec.isIpv4, spec.limit)...)
+			e = append(e, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictDrop})
+			nfRule := &nftables.Rule{
+				Table:    filterTable,
+				Chain:    &nftables.Chain{Name: limitChain},
+				Exprs:    e,
+				UserData: []byte(genRuleKey(limit.spec...)),
+			}
+			n.conn.InsertRule(nfRule)
+			if err := n.conn.Flush(); err != nil {
+				logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", limit.spec, err.Error()))
+				continue
+			}
+			rules = append(rules, ruleInfo{
+				isIpv4: spec.isIpv4,
+				nfRule: nfRule,
+				table:  defaultIpTable,
+				chain:  limitChain,
+				rule:   limit.spec,
+				limit:  spec.limit,
+			})
 		}
-
 	}
+	return rules
+}
 
-	if len(aclRule.IP6List) > 0 {
-		for _, ip := range aclRule.IP6List {
-			if len(aclRule.Dst6) > 0 {
-				for _, dstI := range aclRule.Dst6 {
-					if dstI.IP == nil {
-						continue
-					}
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, dstI)...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
+// nftablesManager.aclMatchExprs - returns the expressions matching the traffic of an acl rule
+func (n *nftablesManager) aclMatchExprs(aclRule models.AclRule, spec aclRuleSpec) []expr.Any {
+	e := []expr.Any{}
+	e = append(e, n.GetIpExpr(spec.src, spec.dst)...)
+	if hasProtoMatch(aclRule) {
+		e = append(e, n.getExprForProto(aclRule.AllowedProtocol, spec.isIpv4)...)
+	}
+	if len(aclRule.AllowedPorts) > 0 {
+		e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
+	}
+	return e
+}
 
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclInputRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: false,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclInputRulesChain,
-							rule:   ruleSpec,
-						})
+// nftLimitSet - returns the meter of an acl limit, keyed by source address. Connection counts
+// are dropped by the kernel along with the connections, rate states expire once a source goes idle
+func nftLimitSet(name string) *nftables.Set {
+	kind, isIpv4, _ := limitSetKind(name)
+	set := &nftables.Set{
+		Table:   filterTable,
+		Name:    name,
+		Dynamic: true,
+		KeyType: nftables.TypeIP6Addr,
+	}
+	if isIpv4 {
+		set.KeyType = nftables.TypeIPAddr
+	}
+	if kind != limitConns {
+		set.HasTimeout = true
+		set.Timeout = nftLimitTimeout
+	}
+	return set
+}
 
-					}
-				}
-			} else {
-				ruleSpec := []string{"-s", ip.String()}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					ruleSpec = append(ruleSpec, "--dport",
-						strings.Join(aclRule.AllowedPorts, ","))
-				}
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				n.deleteRule(defaultIpTable, aclInputRulesChain, genRuleKey(ruleSpec...))
-				e := []expr.Any{}
-				e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-				}
+// nftLimitExprs - returns the expressions matching the traffic of a source address over an acl limit,
+// in the order of nftLimitSpecs
+func nftLimitExprs(kind string, set *nftables.Set, isIpv4 bool, limit config.AclLimit) []expr.Any {
+	e := []expr.Any{}
+	if kind == limitConns {
+		// only new connections are counted against the limit
+		e = append(e,
+			&expr.Ct{Key: expr.CtKeySTATE, Register: 1},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            4,
+				Mask:           []byte{0x08, 0x00, 0x00, 0x00}, // Mask for NEW (8)
+				Xor:            []byte{0x00, 0x00, 0x00, 0x00},
+			},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x00, 0x00, 0x00, 0x00}},
+		)
+	}
+	// the source address keys the meter
+	if isIpv4 {
+		e = append(e, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4})
+	} else {
+		e = append(e, &expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 8, Len: 16})
+	}
+	dynset := &expr.Dynset{
+		SrcRegKey: 1,
+		SetName:   set.Name,
+		SetID:     set.ID,
+	}
+	switch kind {
+	case limitConns:
+		dynset.Operation = uint32(unix.NFT_DYNSET_OP_ADD)
+		dynset.Exprs = []expr.Any{&expr.Connlimit{Count: limit.MaxConns, Flags: expr.NFT_CONNLIMIT_F_INV}}
+	case limitPackets:
+		dynset.Operation = uint32(unix.NFT_DYNSET_OP_UPDATE)
+		dynset.Timeout = nftLimitTimeout
+		dynset.Exprs = []expr.Any{&expr.Limit{
+			Type:  expr.LimitTypePkts,
+			Rate:  uint64(limit.PacketsPerSec),
+			Over:  true,
+			Unit:  expr.LimitTimeSecond,
+			Burst: limit.PacketsPerSec,
+		}}
+	case limitBytes:
+		dynset.Operation = uint32(unix.NFT_DYNSET_OP_UPDATE)
+		dynset.Timeout = nftLimitTimeout
+		dynset.Exprs = []expr.Any{&expr.Limit{
+			Type: expr.LimitTypePktBytes,
+			Rate: uint64(limit.BytesPerSec),
+			Over: true,
+			Unit: expr.LimitTimeSecond,
+		}}
+	}
+	return append(e, dynset)
+}
 
-				e = append(e, // Accept the packet
-					&expr.Verdict{
-						Kind: expr.VerdictAccept, // ACCEPT verdict
-					})
-				nfRule := &nftables.Rule{
-					Table:    filterTable,
-					Chain:    &nftables.Chain{Name: aclInputRulesChain},
-					Exprs:    e,
-					UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-				}
-				n.conn.InsertRule(nfRule)
-				if err := n.conn.Flush(); err != nil {
-					logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: false,
-						nfRule: nfRule,
-						table:  defaultIpTable,
-						chain:  aclInputRulesChain,
-						rule:   ruleSpec,
-					})
+// nftablesManager.addLimitSets - adds the limit meters a rule refers to, when they are missing
+func (n *nftablesManager) addLimitSets(rule *nftables.Rule) {
+	for _, e := range rule.Exprs {
+		dynset, ok := e.(*expr.Dynset)
+		if !ok {
+			continue
+		}
+		set := nftLimitSet(dynset.SetName)
+		if err := n.conn.AddSet(set, nil); err != nil {
+			logger.Log(0, fmt.Sprintf("failed to add meter: %v, Err: %v ", dynset.SetName, err.Error()))
+			continue
+		}
+		dynset.SetID = set.ID
+	}
+}
 
-				}
+// nftablesManager.pruneLimitSets - deletes the limit meters no installed rule refers to anymore
+func (n *nftablesManager) pruneLimitSets() {
+	used := make(map[string]bool)
+	for _, rule := range installedRules(n.aclRules, n.engressRules, n.ingRules) {
+		nfRule, ok := rule.nfRule.(*nftables.Rule)
+		if !ok {
+			continue
+		}
+		for _, e := range nfRule.Exprs {
+			if dynset, ok := e.(*expr.Dynset); ok {
+				used[dynset.SetName] = true
 			}
+		}
+	}
+	n.deleteLimitSets(used)
+}
 
+// nftablesManager.deleteLimitSets - deletes the limit meters of the filter table, except the used ones
+func (n *nftablesManager) deleteLimitSets(used map[string]bool) {
+	sets, err := n.conn.GetSets(filterTable)
+	if err != nil {
+		logger.Log(1, "failed to list sets: ", err.Error())
+		return
+	}
+	deleted := false
+	for _, set := range sets {
+		if _, _, ok := limitSetKind(set.Name); !ok || used[set.Name] {
+			continue
 		}
+		n.conn.DelSet(set)
+		deleted = true
+	}
+	if !deleted {
+		return
 	}
+	if err := n.conn.Flush(); err != nil {
+		logger.Log(0, "failed to delete meters: ", err.Error())
+	}
+}
 
+func (n *nftablesManager) UpsertAclRule(server string, aclRule models.AclRule) {
+	ruleTable := n.FetchRuleTable(server, aclTable)
+	defer n.SaveRules(server, aclTable, ruleTable)
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	ruleTable[aclRule.ID] = rulesCfg{
+		rulesMap: make(map[string][]ruleInfo),
+	}
+	rules := n.insertAclRuleSpecs(aclInputRulesChain, aclRule, nftAclRuleSpecs(aclRule, false))
 	if len(rules) > 0 {
 		rCfg := rulesCfg{
 			rulesMap: map[string][]ruleInfo{
@@ -2010,7 +1891,6 @@ func (n *nftablesManager) UpsertAclRule(server string, aclRule models.AclRule) {
 		}
 		ruleTable[aclRule.ID] = rCfg
 	}
-
 }
 
 func (n *nftablesManager) DeleteAclRule(server, aclID string) {
@@ -2030,82 +1910,8 @@ func (n *nftablesManager) DeleteAclRule(server, aclID string) {
 	delete(ruleTable, aclID)
 }
 func (n *nftablesManager) ChangeACLFwdTarget(target string) {
-	// check if rule exists with current target
-	v := &expr.Verdict{
-		Kind: expr.VerdictAccept,
-	}
-	if target == targetDrop {
-		v = &expr.Verdict{
-			Kind: expr.VerdictDrop,
-		}
-	}
-
-	newRule := &nftables.Rule{
-		Table: filterTable,
-		Chain: &nftables.Chain{Name: aclFwdRulesChain},
-		Exprs: []expr.Any{
-			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     []byte(ncutils.GetInterfaceName() + "\x00"),
-			},
-			&expr.Counter{},
-			v,
-		},
-		UserData: []byte(genRuleKey("-i", ncutils.GetInterfaceName(), "-j", target)),
-	}
-	if n.ruleExists(newRule) {
-		return
-	}
-	slog.Info("setting acl input chain target to", "target", target)
-	// delete old target and insert new rule
-	oldVerdict := &expr.Verdict{
-		Kind: expr.VerdictAccept,
-	}
-	oldTarget := targetAccept
-	if target == targetAccept {
-		oldVerdict = &expr.Verdict{
-			Kind: expr.VerdictDrop,
-		}
-		oldTarget = targetDrop
-	}
-	oldRule := &nftables.Rule{
-		Table: filterTable,
-		Chain: &nftables.Chain{Name: aclFwdRulesChain},
-		Exprs: []expr.Any{
-			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     []byte(ncutils.GetInterfaceName() + "\x00"),
-			},
-			&expr.Counter{},
-			oldVerdict,
-		},
-		UserData: []byte(genRuleKey("-i", ncutils.GetInterfaceName(), "-j", oldTarget)),
-	}
-	rules, err := n.conn.GetRules(newRule.Table, newRule.Chain)
-	if err != nil {
-		slog.Error("Error fetching rules: %v", err.Error())
-	}
-	for _, rI := range rules {
-		if rulesEqual(rI, oldRule) {
-			logger.Log(0, "DELETING OLD TARGET ", oldTarget)
-			err = n.conn.DelRule(rI)
-			if err != nil {
-				logger.Log(0, "failed to delete old target ", err.Error())
-			}
-			break
-		}
-	}
-
-	n.conn.AddRule(newRule)
-	// Apply the changes
-	if err := n.conn.Flush(); err != nil {
-		logger.Log(0, "Error Changing ACL TArget: %v\n", err.Error())
-	}
-}
+	n.changeACLTarget(aclFwdRulesChain, target)
+}
 
 func (n *nftablesManager) AddAclEgressRules(server string, egressInfo models.EgressInfo) {
 	ruleTable := n.FetchRuleTable(server, egressTable)
@@ -2120,208 +1926,7 @@ func (n *nftablesManager) AddAclEgressRules(server string, egressInfo models.Egr
 		rulesMap: make(map[string][]ruleInfo),
 	}
 	for _, aclRule := range aclRules {
-		rules := []ruleInfo{}
-		if _, ok := ruleTable[aclRule.ID]; !ok {
-			ruleTable[aclRule.ID] = rulesCfg{
-				rulesMap: make(map[string][]ruleInfo),
-			}
-		}
-
-		if len(aclRule.IPList) > 0 {
-			for _, ip := range aclRule.IPList {
-
-				if len(aclRule.Dst) > 0 {
-					for _, dstI := range aclRule.Dst {
-						ruleSpec := []string{"-s", ip.String()}
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							ruleSpec = append(ruleSpec, "--dport",
-								strings.Join(aclRule.AllowedPorts, ","))
-						}
-						ruleSpec = append(ruleSpec, "-d", dstI.String())
-						ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-						ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-						n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-						e := []expr.Any{}
-						e = append(e, n.GetIpExpr(ip, dstI)...)
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-						}
-
-						e = append(e, // Accept the packet
-							&expr.Verdict{
-								Kind: expr.VerdictAccept, // ACCEPT verdict
-							})
-						nfRule := &nftables.Rule{
-							Table:    filterTable,
-							Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-							Exprs:    e,
-							UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-						}
-						n.conn.InsertRule(nfRule)
-						if err := n.conn.Flush(); err != nil {
-							logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-						} else {
-							rules = append(rules, ruleInfo{
-								isIpv4: true,
-								nfRule: nfRule,
-								table:  defaultIpTable,
-								chain:  aclFwdRulesChain,
-								rule:   ruleSpec,
-							})
-
-						}
-					}
-				} else {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: true,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclFwdRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-
-			}
-
-		}
-
-		if len(aclRule.IP6List) > 0 {
-			for _, ip := range aclRule.IP6List {
-
-				if len(aclRule.Dst6) > 0 {
-					for _, dstI := range aclRule.Dst6 {
-						ruleSpec := []string{"-s", ip.String()}
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							ruleSpec = append(ruleSpec, "--dport",
-								strings.Join(aclRule.AllowedPorts, ","))
-						}
-						ruleSpec = append(ruleSpec, "-d", dstI.String())
-						ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-						ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-						n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-						e := []expr.Any{}
-						e = append(e, n.GetIpExpr(ip, dstI)...)
-						if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-							e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-						}
-						if len(aclRule.AllowedPorts) > 0 {
-							e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-						}
-						e = append(e, // Accept the packet
-							&expr.Verdict{
-								Kind: expr.VerdictAccept, // ACCEPT verdict
-							})
-						nfRule := &nftables.Rule{
-							Table:    filterTable,
-							Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-							Exprs:    e,
-							UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-						}
-						n.conn.InsertRule(nfRule)
-						if err := n.conn.Flush(); err != nil {
-							logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-						} else {
-							rules = append(rules, ruleInfo{
-								isIpv4: false,
-								nfRule: nfRule,
-								table:  defaultIpTable,
-								chain:  aclFwdRulesChain,
-								rule:   ruleSpec,
-							})
-
-						}
-					}
-				} else {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: false,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclFwdRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-			}
-		}
+		rules := n.insertAclRuleSpecs(aclFwdRulesChain, aclRule, nftAclRuleSpecs(aclRule, true))
 		if len(rules) > 0 {
 			rCfg.rulesMap[aclRule.ID] = rules
 
@@ -2341,205 +1946,7 @@ func (n *nftablesManager) UpsertAclEgressRule(server, egressID string, aclRule m
 	defer n.mux.Unlock()
 	rCfg := ruleTable[egressID]
 	extraInfo := rCfg.extraInfo.(map[string]models.AclRule)
-	rules := []ruleInfo{}
-	if len(aclRule.IPList) > 0 {
-		for _, ip := range aclRule.IPList {
-
-			if len(aclRule.Dst) > 0 {
-				for _, dstI := range aclRule.Dst {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-d", dstI.String())
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, dstI)...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: true,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclFwdRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-			} else {
-				ruleSpec := []string{"-s", ip.String()}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					ruleSpec = append(ruleSpec, "--dport",
-						strings.Join(aclRule.AllowedPorts, ","))
-				}
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-				e := []expr.Any{}
-				e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					e = append(e, n.getExprForProto(aclRule.AllowedProtocol, true)...)
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-				}
-
-				e = append(e, // Accept the packet
-					&expr.Verdict{
-						Kind: expr.VerdictAccept, // ACCEPT verdict
-					})
-				nfRule := &nftables.Rule{
-					Table:    filterTable,
-					Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-					Exprs:    e,
-					UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-				}
-				n.conn.InsertRule(nfRule)
-				if err := n.conn.Flush(); err != nil {
-					logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: true,
-						nfRule: nfRule,
-						table:  defaultIpTable,
-						chain:  aclFwdRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-
-		}
-
-	}
-
-	if len(aclRule.IP6List) > 0 {
-		for _, ip := range aclRule.IP6List {
-
-			if len(aclRule.Dst6) > 0 {
-				for _, dstI := range aclRule.Dst6 {
-					ruleSpec := []string{"-s", ip.String()}
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						ruleSpec = append(ruleSpec, "--dport",
-							strings.Join(aclRule.AllowedPorts, ","))
-					}
-					ruleSpec = append(ruleSpec, "-d", dstI.String())
-					ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-					ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-					n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-					e := []expr.Any{}
-					e = append(e, n.GetIpExpr(ip, dstI)...)
-					if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-						e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-					}
-					if len(aclRule.AllowedPorts) > 0 {
-						e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-					}
-
-					e = append(e, // Accept the packet
-						&expr.Verdict{
-							Kind: expr.VerdictAccept, // ACCEPT verdict
-						})
-					nfRule := &nftables.Rule{
-						Table:    filterTable,
-						Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-						Exprs:    e,
-						UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-					}
-					n.conn.InsertRule(nfRule)
-					if err := n.conn.Flush(); err != nil {
-						logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-					} else {
-						rules = append(rules, ruleInfo{
-							isIpv4: false,
-							nfRule: nfRule,
-							table:  defaultIpTable,
-							chain:  aclFwdRulesChain,
-							rule:   ruleSpec,
-						})
-
-					}
-				}
-			} else {
-				ruleSpec := []string{"-s", ip.String()}
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					ruleSpec = append(ruleSpec, "--dport",
-						strings.Join(aclRule.AllowedPorts, ","))
-				}
-				ruleSpec = append(ruleSpec, "-j", "ACCEPT")
-				ruleSpec = appendNetmakerCommentToRule(ruleSpec)
-				n.deleteRule(defaultIpTable, aclFwdRulesChain, genRuleKey(ruleSpec...))
-				e := []expr.Any{}
-				e = append(e, n.GetIpExpr(ip, net.IPNet{})...)
-				if aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL {
-					e = append(e, n.getExprForProto(aclRule.AllowedProtocol, false)...)
-				}
-				if len(aclRule.AllowedPorts) > 0 {
-					e = append(e, n.getExprForPort(aclRule.AllowedPorts)...)
-				}
-
-				e = append(e, // Accept the packet
-					&expr.Verdict{
-						Kind: expr.VerdictAccept, // ACCEPT verdict
-					})
-				nfRule := &nftables.Rule{
-					Table:    filterTable,
-					Chain:    &nftables.Chain{Name: aclFwdRulesChain},
-					Exprs:    e,
-					UserData: []byte(genRuleKey(ruleSpec...)), // Equivalent to the comment in iptables
-				}
-				n.conn.InsertRule(nfRule)
-				if err := n.conn.Flush(); err != nil {
-					logger.Log(0, fmt.Sprintf("failed to add rule: %v, Err: %v ", ruleSpec, err.Error()))
-				} else {
-					rules = append(rules, ruleInfo{
-						isIpv4: false,
-						nfRule: nfRule,
-						table:  defaultIpTable,
-						chain:  aclFwdRulesChain,
-						rule:   ruleSpec,
-					})
-
-				}
-			}
-		}
-	}
-
+	rules := n.insertAclRuleSpecs(aclFwdRulesChain, aclRule, nftAclRuleSpecs(aclRule, true))
 	if len(rules) > 0 {
 		rCfg.rulesMap[aclRule.ID] = rules
 		extraInfo[aclRule.ID] = aclRule
@@ -2587,7 +1994,50 @@ func (n *nftablesManager) DeleteAllAclEgressRules(server, egressID string) {
 }
 
 func (n *nftablesManager) ChangeACLInTarget(target string) {
-	// check if rule exists with current target
+	n.changeACLTarget(aclInputRulesChain, target)
+}
+
+// nftablesManager.changeACLTarget - replaces the default target at the end of an acl chain,
+// a drop target is preceded by the drop log rule when the drop log is enabled
+func (n *nftablesManager) changeACLTarget(chain, target string) {
+	newRule := nftTargetRule(chain, target)
+	logRule := nftDropLogRule(chain)
+	logDrops := target == targetDrop && dropLogEnabled()
+	if n.ruleExists(newRule) && n.ruleExists(logRule) == logDrops {
+		return
+	}
+	slog.Info("setting acl chain target to", "chain", chain, "target", target, "log", logDrops)
+	// delete the current target along with its drop log rule and add the new one
+	oldKeys := map[string]bool{string(logRule.UserData): true}
+	for _, oldTarget := range []string{targetAccept, targetDrop} {
+		oldKeys[genRuleKey(nftTargetRuleSpec(oldTarget)...)] = true
+	}
+	rules, err := n.conn.GetRules(newRule.Table, newRule.Chain)
+	if err != nil {
+		slog.Error("Error fetching rules", "error", err.Error())
+	}
+	for _, rI := range rules {
+		if oldKeys[string(rI.UserData)] {
+			logger.Log(0, "DELETING OLD TARGET ", string(rI.UserData))
+			err = n.conn.DelRule(rI)
+			if err != nil {
+				logger.Log(0, "failed to delete old target ", err.Error())
+			}
+		}
+	}
+
+	if logDrops {
+		n.conn.AddRule(logRule)
+	}
+	n.conn.AddRule(newRule)
+	// Apply the changes
+	if err := n.conn.Flush(); err != nil {
+		logger.Log(0, "Error Changing ACL TArget: %v\n", err.Error())
+	}
+}
+
+// nftTargetRule - rule setting the default target of an acl chain, keyed by nftTargetRuleSpec
+func nftTargetRule(chain, target string) *nftables.Rule {
 	v := &expr.Verdict{
 		Kind: expr.VerdictAccept,
 	}
@@ -2596,10 +2046,9 @@ func (n *nftablesManager) ChangeACLInTarget(target string) {
 			Kind: expr.VerdictDrop,
 		}
 	}
-
-	newRule := &nftables.Rule{
+	return &nftables.Rule{
 		Table: filterTable,
-		Chain: &nftables.Chain{Name: aclInputRulesChain},
+		Chain: &nftables.Chain{Name: chain},
 		Exprs: []expr.Any{
 			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
 			&expr.Cmp{
@@ -2610,26 +2059,16 @@ func (n *nftablesManager) ChangeACLInTarget(target string) {
 			&expr.Counter{},
 			v,
 		},
-		UserData: []byte(genRuleKey("-i", ncutils.GetInterfaceName(), "-j", target)),
-	}
-	if n.ruleExists(newRule) {
-		return
-	}
-	slog.Info("setting acl input chain target to", "target", target)
-	// delete old target and insert new rule
-	oldVerdict := &expr.Verdict{
-		Kind: expr.VerdictAccept,
+		UserData: []byte(genRuleKey(nftTargetRuleSpec(target)...)),
 	}
-	oldTarget := targetAccept
-	if target == targetAccept {
-		oldVerdict = &expr.Verdict{
-			Kind: expr.VerdictDrop,
-		}
-		oldTarget = targetDrop
-	}
-	oldRule := &nftables.Rule{
+}
+
+// nftDropLogRule - rule logging the packets of an acl chain to the drop log group at a limited rate,
+// keyed by nftDropLogRuleSpec
+func nftDropLogRule(chain string) *nftables.Rule {
+	return &nftables.Rule{
 		Table: filterTable,
-		Chain: &nftables.Chain{Name: aclInputRulesChain},
+		Chain: &nftables.Chain{Name: chain},
 		Exprs: []expr.Any{
 			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
 			&expr.Cmp{
@@ -2637,30 +2076,19 @@ func (n *nftablesManager) ChangeACLInTarget(target string) {
 				Register: 1,
 				Data:     []byte(ncutils.GetInterfaceName() + "\x00"),
 			},
-			&expr.Counter{},
-			oldVerdict,
+			&expr.Limit{
+				Type:  expr.LimitTypePkts,
+				Rate:  dropLogRate,
+				Unit:  expr.LimitTimeSecond,
+				Burst: dropLogBurst,
+			},
+			&expr.Log{
+				Key:   1<<unix.NFTA_LOG_GROUP | 1<<unix.NFTA_LOG_PREFIX,
+				Group: DropLogGroup,
+				Data:  []byte(chain),
+			},
 		},
-		UserData: []byte(genRuleKey("-i", ncutils.GetInterfaceName(), "-j", oldTarget)),
-	}
-	rules, err := n.conn.GetRules(newRule.Table, newRule.Chain)
-	if err != nil {
-		slog.Error("Error fetching rules: %v", err.Error())
-	}
-	for _, rI := range rules {
-		if rulesEqual(rI, oldRule) {
-			logger.Log(0, "DELETING OLD TARGET ", oldTarget)
-			err = n.conn.DelRule(rI)
-			if err != nil {
-				logger.Log(0, "failed to delete old target ", err.Error())
-			}
-			break
-		}
-	}
-
-	n.conn.AddRule(newRule)
-	// Apply the changes
-	if err := n.conn.Flush(); err != nil {
-		logger.Log(0, "Error Changing ACL TArget: %v\n", err.Error())
+		UserData: []byte(genRuleKey(nftDropLogRuleSpec(chain)...)),
 	}
 }
 
@@ -2688,3 +2116,235 @@ func rulesEqual(rule1, rule2 *nftables.Rule) bool {
 
 	return false
 }
+
+// chains created by the nftables manager, by table
+var nfManagedChains = map[string][]string{
+	defaultIpTable: {iptableINChain, iptableFWDChain, aclInputRulesChain, aclFwdRulesChain, aclOutputRulesChain,
+		aclInputLimitChain, aclFwdLimitChain},
+	defaultNatTable: {nattablePRTChain, netmakerNatChain},
+}
+
+// nftables.Reconcile - compares the live netmaker chains with the installed rules, re-adding what is missing.
+// Rules are matched by the rule key kept in their user data
+func (n *nftablesManager) Reconcile(target string) []Drift {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	drift := []Drift{}
+	chains, err := n.conn.ListChains()
+	if err != nil {
+		logger.Log(0, "failed to list chains: ", err.Error())
+		return drift
+	}
+	liveChains := make(map[string]bool)
+	for _, chain := range chains {
+		if chain.Table.Family == nftables.TableFamilyINet {
+			liveChains[chain.Table.Name+"/"+chain.Name] = true
+		}
+	}
+	missingChains := []Drift{}
+	for table, tableChains := range nfManagedChains {
+		for _, chain := range tableChains {
+			if !liveChains[table+"/"+chain] {
+				missingChains = append(missingChains, Drift{Kind: DriftMissingChain, Family: familyInet, Table: table, Chain: chain})
+			}
+		}
+	}
+	if len(missingChains) > 0 {
+		err := n.addChains()
+		if err != nil {
+			logger.Log(0, "failed to recreate chains: ", err.Error())
+		}
+		for i := range missingChains {
+			missingChains[i].Repaired = err == nil
+		}
+		drift = append(drift, missingChains...)
+	}
+
+	knownKeys := make(map[string]bool)
+	for _, rule := range nfJumpRules {
+		nfRule := rule.nfRule.(*nftables.Rule)
+		knownKeys[string(nfRule.UserData)] = true
+		if n.ruleExists(nfRule) {
+			continue
+		}
+		if jumpRuleAtTop(rule) {
+			nfRule.Position = 0
+			n.conn.InsertRule(nfRule)
+		} else {
+			n.conn.AddRule(nfRule)
+		}
+		err := n.conn.Flush()
+		drift = append(drift, Drift{Kind: DriftMissingJump, Family: familyInet, Table: rule.table, Chain: rule.chain,
+			Rule: strings.Join(rule.rule, " "), Repaired: err == nil})
+	}
+	logDrops := target == targetDrop && dropLogEnabled()
+	targetKeys := make(map[string]string) // acl chain -> key of the default target
+	for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+		targetSpec := nftTargetRuleSpec(target)
+		targetKeys[chain] = genRuleKey(targetSpec...)
+		specs := [][]string{targetSpec}
+		if logDrops {
+			specs = append([][]string{nftDropLogRuleSpec(chain)}, specs...)
+		}
+		missing := [][]string{}
+		for _, spec := range specs {
+			knownKeys[genRuleKey(spec...)] = true
+			if _, err := n.getRule(defaultIpTable, chain, genRuleKey(spec...)); err != nil {
+				missing = append(missing, spec)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		n.changeACLTarget(chain, target)
+		for _, spec := range missing {
+			_, err := n.getRule(defaultIpTable, chain, genRuleKey(spec...))
+			drift = append(drift, Drift{Kind: DriftMissingTarget, Family: familyInet, Table: defaultIpTable, Chain: chain,
+				Rule: strings.Join(spec, " "), Repaired: err == nil})
+		}
+	}
+
+	for _, rule := range installedRules(n.aclRules, n.engressRules, n.ingRules) {
+		nfRule, ok := rule.nfRule.(*nftables.Rule)
+		if !ok {
+			continue
+		}
+		ruleKey := genRuleKey(rule.rule...)
+		family := familyInet
+		var live *nftables.Rule
+		if rule.isDockerRule {
+			family = familyIp
+			rules, err := n.conn.GetRules(ipFilterTable, &nftables.Chain{Name: rule.chain, Table: ipFilterTable})
+			if err != nil {
+				// docker chain is gone along with the docker network
+				continue
+			}
+			for _, r := range rules {
+				if string(r.UserData) == ruleKey {
+					live = r
+					break
+				}
+			}
+		} else {
+			knownKeys[ruleKey] = true
+			live, _ = n.getRule(rule.table, rule.chain, ruleKey)
+		}
+		kind := DriftMissing
+		if live != nil {
+			if nftExprsEqual(live.Exprs, nfRule.Exprs) {
+				continue
+			}
+			kind = DriftModified
+			if err := n.conn.DelRule(live); err != nil {
+				drift = append(drift, rule.drift(kind, family, false))
+				continue
+			}
+		}
+		newRule := *nfRule
+		newRule.Handle = 0
+		newRule.Position = 0
+		if isStaticNodeRule(rule) {
+			// keep static node rules ahead of the default target
+			if targetRule, err := n.getRule(defaultIpTable, aclInputRulesChain, targetKeys[aclInputRulesChain]); err == nil {
+				newRule.Position = targetRule.Handle
+			}
+		}
+		n.addLimitSets(&newRule)
+		n.conn.InsertRule(&newRule)
+		err := n.conn.Flush()
+		drift = append(drift, rule.drift(kind, family, err == nil))
+	}
+
+	for table, tableChains := range nfManagedChains {
+		for _, chain := range tableChains {
+			if chain == iptableINChain || chain == iptableFWDChain || chain == nattablePRTChain {
+				// shared with rules of other tools
+				continue
+			}
+			rules, err := n.conn.GetRules(&nftables.Table{Name: table, Family: nftables.TableFamilyINet},
+				&nftables.Chain{Name: chain})
+			if err != nil {
+				continue
+			}
+			for _, r := range rules {
+				if len(r.UserData) == 0 || knownKeys[string(r.UserData)] {
+					continue
+				}
+				drift = append(drift, Drift{Kind: DriftExtra, Family: familyInet, Table: table, Chain: chain,
+					Rule: string(r.UserData)})
+			}
+		}
+	}
+	n.pruneLimitSets()
+	return drift
+}
+
+// nftExprsEqual - reports if the expressions of a live rule match the installed ones. Expressions are
+// compared in their netlink encoding, the values counted by the kernel are left out
+func nftExprsEqual(live, installed []expr.Any) bool {
+	if len(live) != len(installed) {
+		return false
+	}
+	for idx := range live {
+		a, err := expr.Marshal(byte(nftables.TableFamilyINet), nftStaticExpr(live[idx]))
+		if err != nil {
+			return false
+		}
+		b, err := expr.Marshal(byte(nftables.TableFamilyINet), nftStaticExpr(installed[idx]))
+		if err != nil || !bytes.Equal(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// nftStaticExpr - returns the expression without the state the kernel keeps in it
+// and without the fields the kernel leaves out of a rule dump
+func nftStaticExpr(e expr.Any) expr.Any {
+	switch e := e.(type) {
+	case *expr.Counter:
+		return &expr.Counter{}
+	case *expr.Dynset:
+		// the set id only refers to a set within the batch creating it, the meter
+		// expressions are templates that may hold state of their own
+		static := *e
+		static.SetID = 0
+		static.Exprs = make([]expr.Any, len(e.Exprs))
+		for idx := range e.Exprs {
+			static.Exprs[idx] = nftStaticExpr(e.Exprs[idx])
+		}
+		return &static
+	case *expr.Lookup:
+		static := *e
+		static.SetID = 0
+		return &static
+	}
+	return e
+}
+
+// nftablesManager.RuleCounters - reads the counters of the acl chain rules, rules without a counter are skipped
+func (n *nftablesManager) RuleCounters(rules []ruleInfo) []ruleCounter {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	counters := make(map[string]ruleCounter)
+	for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+		nfRules, err := n.conn.GetRules(filterTable, &nftables.Chain{Name: chain, Table: filterTable})
+		if err != nil {
+			logger.Log(1, "failed to read rules of chain", chain, err.Error())
+			continue
+		}
+		for _, nfRule := range nfRules {
+			for _, e := range nfRule.Exprs {
+				if counter, ok := e.(*expr.Counter); ok {
+					counters[chain+" "+string(nfRule.UserData)] = ruleCounter{packets: counter.Packets, bytes: counter.Bytes}
+					break
+				}
+			}
+		}
+	}
+	result := make([]ruleCounter, len(rules))
+	for idx, rule := range rules {
+		result[idx] = counters[rule.chain+" "+genRuleKey(rule.rule...)]
+	}
+	return result
+}
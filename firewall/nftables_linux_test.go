@@ -0,0 +1,144 @@
+package firewall
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/gravitl/netclient/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestNftExprsEqual(t *testing.T) {
+	n := &nftablesManager{}
+	installed := func(src string, verdict expr.VerdictKind) []expr.Any {
+		e := n.GetIpExpr(net.IPNet{IP: net.ParseIP(src).To4(), Mask: net.CIDRMask(32, 32)}, net.IPNet{})
+		e = append(e, n.getExprForPort([]string{"443"})...)
+		return append(e, &expr.Counter{}, &expr.Verdict{Kind: verdict})
+	}
+	live := installed("100.64.0.2", expr.VerdictAccept)
+	live[len(live)-2] = &expr.Counter{Packets: 12, Bytes: 1500}
+
+	// counters move with the traffic
+	assert.True(t, nftExprsEqual(live, installed("100.64.0.2", expr.VerdictAccept)))
+	// rules of the same length are told apart by their values
+	assert.False(t, nftExprsEqual(live, installed("100.64.0.3", expr.VerdictAccept)))
+	assert.False(t, nftExprsEqual(live, installed("100.64.0.2", expr.VerdictDrop)))
+	assert.False(t, nftExprsEqual(live[:len(live)-1], installed("100.64.0.2", expr.VerdictAccept)))
+
+	// the set id of a limit meter is only known to the batch creating it
+	limit := config.AclLimit{PacketsPerSec: 100}
+	set := nftLimitSet(limitSetName("web", limitPackets, true, limit.PacketsPerSec))
+	set.ID = 7
+	meter := nftLimitExprs(limitPackets, set, true, limit)
+	set.ID = 0
+	assert.True(t, nftExprsEqual(meter, nftLimitExprs(limitPackets, set, true, limit)))
+	limit.PacketsPerSec = 200
+	assert.False(t, nftExprsEqual(meter, nftLimitExprs(limitPackets, set, true, limit)))
+}
+
+// dumpExprs - returns the expressions the way a rule dump of the kernel decodes them, without the
+// attributes the kernel does not report back and with the counters moved by traffic
+func dumpExprs(t *testing.T, exprs []expr.Any) []expr.Any {
+	t.Helper()
+	unreported := map[reflect.Type]uint16{
+		reflect.TypeOf(&expr.Dynset{}): unix.NFTA_DYNSET_SET_ID,
+		reflect.TypeOf(&expr.Lookup{}): unix.NFTA_LOOKUP_SET_ID,
+	}
+	dumped := []expr.Any{}
+	for _, e := range exprs {
+		data, err := expr.MarshalExprData(byte(nftables.TableFamilyINet), e)
+		require.NoError(t, err)
+		if attrType, ok := unreported[reflect.TypeOf(e)]; ok {
+			kept := []byte{}
+			for len(data) >= 4 {
+				size := int(binary.NativeEndian.Uint16(data[:2]))
+				next := min((size+3)&^3, len(data))
+				if binary.NativeEndian.Uint16(data[2:4])&^(unix.NLA_F_NESTED|unix.NLA_F_NET_BYTEORDER) != attrType {
+					kept = append(kept, data[:next]...)
+				}
+				data = data[next:]
+			}
+			data = kept
+		}
+		decoded := reflect.New(reflect.TypeOf(e).Elem()).Interface().(expr.Any)
+		require.NoError(t, expr.Unmarshal(byte(nftables.TableFamilyINet), data, decoded))
+		if counter, ok := decoded.(*expr.Counter); ok {
+			counter.Packets, counter.Bytes = 12, 1500
+		}
+		dumped = append(dumped, decoded)
+	}
+	return dumped
+}
+
+func TestNftExprsEqualDump(t *testing.T) {
+	n := &nftablesManager{}
+	aclRule := n.GetIpExpr(net.IPNet{IP: net.ParseIP("100.64.0.2").To4(), Mask: net.CIDRMask(32, 32)}, net.IPNet{})
+	aclRule = append(aclRule, n.getExprForPort([]string{"443"})...)
+	aclRule = append(aclRule, &expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept})
+	rules := map[string][]expr.Any{
+		"acl rule": aclRule,
+		"target":   nftTargetRule(aclInputRulesChain, targetDrop).Exprs,
+		"drop log": nftDropLogRule(aclInputRulesChain).Exprs,
+		"set lookup": {
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+			&expr.Lookup{SourceRegister: 1, SetName: "hosts", SetID: 3},
+		},
+	}
+	limit := config.AclLimit{MaxConns: 10, PacketsPerSec: 100, BytesPerSec: 2048}
+	for _, kind := range []string{limitConns, limitPackets, limitBytes} {
+		for _, isIpv4 := range []bool{true, false} {
+			set := nftLimitSet(limitSetName("web", kind, isIpv4, 1))
+			set.ID = 7
+			rules[set.Name] = nftLimitExprs(kind, set, isIpv4, limit)
+		}
+	}
+	for name, installed := range rules {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, nftExprsEqual(dumpExprs(t, installed), installed))
+		})
+	}
+}
+
+func TestLimitJumpOrder(t *testing.T) {
+	// chainOrder - installs jump rules the way addJumpRules does and returns the chains in order
+	chainOrder := func(rules []ruleInfo) map[string][]string {
+		chains := make(map[string][]string)
+		for _, rule := range rules {
+			key := genRuleKey(rule.rule...)
+			if jumpRuleAtTop(rule) {
+				chains[rule.chain] = append([]string{key}, chains[rule.chain]...)
+				continue
+			}
+			chains[rule.chain] = append(chains[rule.chain], key)
+		}
+		return chains
+	}
+	for backend, rules := range map[string][]ruleInfo{"iptables": filterNmJumpRules, "nftables": nfFilterJumpRules} {
+		t.Run(backend, func(t *testing.T) {
+			for chain, order := range chainOrder(rules) {
+				if chain != iptableINChain && chain != iptableFWDChain {
+					continue
+				}
+				limitJumps, lastLimitJump, firstAccept := 0, -1, len(order)
+				for idx, key := range order {
+					switch {
+					case strings.Contains(key, aclInputLimitChain) || strings.Contains(key, aclFwdLimitChain):
+						limitJumps++
+						lastLimitJump = idx
+					case strings.Contains(key, "ESTABLISHED,RELATED") && idx < firstAccept:
+						firstAccept = idx
+					}
+				}
+				assert.NotZero(t, limitJumps, chain)
+				assert.Less(t, lastLimitJump, firstAccept, "%s: %v", chain, order)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/nftables"
+	"golang.org/x/exp/slog"
+)
+
+// nftConn - the nftables connection of the manager. Within a transaction rule changes are kept
+// pending and sent to the kernel as a single batch on commit, which nftables applies all or nothing.
+// Rule listings include the pending changes, so lookups by rule key see the state being built
+type nftConn struct {
+	*nftables.Conn
+	inTx    bool
+	pending []nftRuleOp
+}
+
+type nftRuleOp struct {
+	op   string
+	rule *nftables.Rule
+}
+
+const (
+	nftOpAdd    = "add"
+	nftOpInsert = "insert"
+	nftOpDelete = "delete"
+)
+
+func (c *nftConn) AddRule(r *nftables.Rule) *nftables.Rule {
+	if !c.inTx {
+		return c.Conn.AddRule(r)
+	}
+	c.pending = append(c.pending, nftRuleOp{op: nftOpAdd, rule: r})
+	return r
+}
+
+func (c *nftConn) InsertRule(r *nftables.Rule) *nftables.Rule {
+	if !c.inTx {
+		return c.Conn.InsertRule(r)
+	}
+	c.pending = append(c.pending, nftRuleOp{op: nftOpInsert, rule: r})
+	return r
+}
+
+// nftConn.DelRule - deletes a rule, within a transaction a rule added by the transaction is
+// dropped from it and a rule already deleted is left alone
+func (c *nftConn) DelRule(r *nftables.Rule) error {
+	if !c.inTx {
+		return c.Conn.DelRule(r)
+	}
+	for idx, pending := range c.pending {
+		if !sameChain(pending.rule, r.Table, r.Chain) {
+			continue
+		}
+		switch {
+		case pending.op != nftOpDelete && r.Handle == 0 && string(pending.rule.UserData) == string(r.UserData):
+			c.pending = append(c.pending[:idx], c.pending[idx+1:]...)
+			return nil
+		case pending.op == nftOpDelete && r.Handle != 0 && pending.rule.Handle == r.Handle:
+			return nil
+		}
+	}
+	if r.Handle == 0 {
+		return errors.New("rule's handle cannot be 0")
+	}
+	c.pending = append(c.pending, nftRuleOp{op: nftOpDelete, rule: r})
+	return nil
+}
+
+// nftConn.Flush - sends the queued changes, within a transaction they wait for the commit
+func (c *nftConn) Flush() error {
+	if c.inTx {
+		return nil
+	}
+	return c.Conn.Flush()
+}
+
+// nftConn.GetRules - lists the rules of a chain, with the pending changes of the transaction applied
+func (c *nftConn) GetRules(t *nftables.Table, ch *nftables.Chain) ([]*nftables.Rule, error) {
+	rules, err := c.Conn.GetRules(t, ch)
+	if err != nil || !c.inTx {
+		return rules, err
+	}
+	for _, pending := range c.pending {
+		if !sameChain(pending.rule, t, ch) {
+			continue
+		}
+		switch pending.op {
+		case nftOpDelete:
+			for idx, rule := range rules {
+				if rule.Handle == pending.rule.Handle {
+					rules = append(rules[:idx], rules[idx+1:]...)
+					break
+				}
+			}
+		case nftOpInsert:
+			rules = append([]*nftables.Rule{pending.rule}, rules...)
+		default:
+			rules = append(rules, pending.rule)
+		}
+	}
+	return rules, nil
+}
+
+func sameChain(r *nftables.Rule, t *nftables.Table, ch *nftables.Chain) bool {
+	return r.Table != nil && t != nil && r.Chain != nil && ch != nil &&
+		r.Table.Name == t.Name && r.Table.Family == t.Family && r.Chain.Name == ch.Name
+}
+
+// nftConn.begin - starts collecting rule changes instead of applying them
+func (c *nftConn) begin() {
+	c.inTx = true
+	c.pending = nil
+}
+
+// nftConn.commit - applies the rule changes of the transaction as one batch
+func (c *nftConn) commit() error {
+	pending := c.pending
+	c.inTx = false
+	c.pending = nil
+	for _, pending := range pending {
+		switch pending.op {
+		case nftOpDelete:
+			if err := c.Conn.DelRule(pending.rule); err != nil {
+				// drop the queued batch without sending it, a fresh connection starts with an empty queue
+				c.Conn = &nftables.Conn{}
+				return err
+			}
+		case nftOpInsert:
+			c.Conn.InsertRule(pending.rule)
+		default:
+			c.Conn.AddRule(pending.rule)
+		}
+	}
+	return c.Conn.Flush()
+}
+
+// nftSnapshot - the rule tables and the live rules of the netmaker chains before a transaction
+type nftSnapshot struct {
+	ingRules     serverrulestable
+	engressRules serverrulestable
+	aclRules     serverrulestable
+	chains       map[*nftables.Table]map[string][]*nftables.Rule
+}
+
+// nftablesManager.Begin - snapshots the current ruleset and batches the following rule changes
+func (n *nftablesManager) Begin() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	snapshot := &nftSnapshot{
+		ingRules:     copyServerRuleTables(n.ingRules),
+		engressRules: copyServerRuleTables(n.engressRules),
+		aclRules:     copyServerRuleTables(n.aclRules),
+		chains:       make(map[*nftables.Table]map[string][]*nftables.Rule),
+	}
+	for _, table := range []*nftables.Table{filterTable, natTable} {
+		snapshot.chains[table] = make(map[string][]*nftables.Rule)
+		for _, chain := range nfManagedChains[table.Name] {
+			rules, err := n.conn.GetRules(table, &nftables.Chain{Name: chain})
+			if err != nil {
+				continue
+			}
+			snapshot.chains[table][chain] = rules
+		}
+	}
+	n.snapshot = snapshot
+	n.conn.begin()
+}
+
+// nftablesManager.Commit - applies the batched rule changes, restoring the snapshot taken by Begin
+// when the kernel rejects them
+func (n *nftablesManager) Commit() error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	snapshot := n.snapshot
+	n.snapshot = nil
+	err := n.conn.commit()
+	if err == nil {
+		// meters of the replaced limit rules go once the batch is in
+		n.pruneLimitSets()
+		return nil
+	}
+	if snapshot == nil {
+		return err
+	}
+	slog.Error("firewall update failed, restoring previous rules", "error", err)
+	n.ingRules = snapshot.ingRules
+	n.engressRules = snapshot.engressRules
+	n.aclRules = snapshot.aclRules
+	if restoreErr := n.restoreChains(snapshot); restoreErr != nil {
+		return fmt.Errorf("%w, restoring previous rules: %v", err, restoreErr)
+	}
+	return err
+}
+
+// nftablesManager.restoreChains - puts back the netmaker rules of the snapshot in chains that no
+// longer hold them. A batch is atomic, so this only repairs changes made outside of it
+func (n *nftablesManager) restoreChains(snapshot *nftSnapshot) error {
+	for table, chains := range snapshot.chains {
+		for chain, want := range chains {
+			live, err := n.conn.GetRules(table, &nftables.Chain{Name: chain})
+			if err != nil {
+				return err
+			}
+			if sameRuleKeys(netmakerRules(live), netmakerRules(want)) {
+				continue
+			}
+			for _, rule := range netmakerRules(live) {
+				if err := n.conn.DelRule(rule); err != nil {
+					return err
+				}
+			}
+			for _, rule := range netmakerRules(want) {
+				restored := *rule
+				restored.Handle = 0
+				restored.Position = 0
+				restored.Table = table
+				n.conn.AddRule(&restored)
+			}
+		}
+	}
+	return n.conn.Flush()
+}
+
+// netmakerRules - rules carrying a netmaker rule key, the chains hooked into INPUT and FORWARD
+// are shared with the rules of other tools
+func netmakerRules(rules []*nftables.Rule) []*nftables.Rule {
+	keyed := []*nftables.Rule{}
+	for _, rule := range rules {
+		if len(rule.UserData) > 0 {
+			keyed = append(keyed, rule)
+		}
+	}
+	return keyed
+}
+
+func sameRuleKeys(a, b []*nftables.Rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if string(a[idx].UserData) != string(b[idx].UserData) {
+			return false
+		}
+	}
+	return true
+}
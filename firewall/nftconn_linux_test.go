@@ -0,0 +1,50 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNftConnPendingRules(t *testing.T) {
+	c := &nftConn{Conn: &nftables.Conn{}}
+	chain := &nftables.Chain{Name: aclInputRulesChain}
+	rule := func(key string, handle uint64) *nftables.Rule {
+		return &nftables.Rule{Table: filterTable, Chain: chain, UserData: []byte(key), Handle: handle}
+	}
+
+	c.begin()
+	c.InsertRule(rule("web", 0))
+	c.AddRule(rule("target", 0))
+	assert.NoError(t, c.Flush(), "flush waits for the commit")
+	assert.Len(t, c.pending, 2)
+
+	// a rule added by the transaction is dropped from it
+	assert.NoError(t, c.DelRule(rule("web", 0)))
+	assert.Len(t, c.pending, 1)
+
+	// a live rule is deleted once
+	assert.NoError(t, c.DelRule(rule("ssh", 7)))
+	assert.NoError(t, c.DelRule(rule("ssh", 7)))
+	assert.Len(t, c.pending, 2)
+
+	assert.Error(t, c.DelRule(rule("unknown", 0)))
+	assert.True(t, sameChain(c.pending[0].rule, &nftables.Table{Name: defaultIpTable, Family: nftables.TableFamilyINet}, chain))
+	assert.False(t, sameChain(c.pending[0].rule, ipFilterTable, chain))
+}
+
+func TestNftConnCommitDropsBatch(t *testing.T) {
+	conn := &nftables.Conn{}
+	c := &nftConn{Conn: conn}
+	c.begin()
+	c.InsertRule(&nftables.Rule{Table: filterTable, Chain: &nftables.Chain{Name: aclInputRulesChain}})
+	c.pending = append(c.pending, nftRuleOp{op: nftOpDelete, rule: &nftables.Rule{Table: filterTable,
+		Chain: &nftables.Chain{Name: aclInputRulesChain}}})
+
+	// the invalid delete fails the commit before anything is sent
+	assert.Error(t, c.commit())
+	assert.NotSame(t, conn, c.Conn, "queued batch is dropped")
+	assert.False(t, c.inTx)
+	assert.Empty(t, c.pending)
+}
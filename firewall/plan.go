@@ -0,0 +1,737 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netmaker/models"
+)
+
+const (
+	// PlanActionAdd - rule is inserted or appended to a chain
+	PlanActionAdd = "add"
+	// PlanActionDelete - rule is removed from a chain
+	PlanActionDelete = "delete"
+)
+
+// Plan - rule operations a firewall update would perform, in order
+type Plan struct {
+	Backend  string   `json:"backend"`
+	Ops      []PlanOp `json:"ops"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PlanOp - a single rule operation, Command holds it in the syntax of the backend.
+// nftables deletes are rendered against a rule handle which is only known on the host
+type PlanOp struct {
+	Action    string `json:"action"`
+	RuleTable string `json:"rule_table,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Family    string `json:"family"`
+	Table     string `json:"table"`
+	Chain     string `json:"chain"`
+	Rule      string `json:"rule"`
+	Command   string `json:"command"`
+}
+
+// rulePosition - where a rule is placed in its chain
+type rulePosition int
+
+const (
+	positionTop rulePosition = iota
+	positionBottom
+	positionBeforeLast // ahead of the default target at the end of the chain
+)
+
+const (
+	familyInet      = "inet"
+	familyIp        = "ip"
+	dockerUserChain = "DOCKER-USER"
+)
+
+// planFirewall - firewallController that records the rule operations instead of applying them
+type planFirewall struct {
+	backend string
+	tables  map[string]serverrulestable // rule table name -> server -> rules
+	chains  map[string][]string         // family/table/chain -> rule keys in chain order
+	targets map[string]string           // acl chain -> default target
+	logged  map[string]bool             // acl chain -> drops of the default target are logged
+	plan    *Plan
+}
+
+// PlanFwUpdate - returns the rule operations the firewall update would perform on the given backend,
+// starting from the rules currently installed by the daemon
+func PlanFwUpdate(server string, fwUpdate *models.FwUpdate, backend string) Plan {
+	p := newPlanFirewall(backend)
+	fwMutex.Lock()
+	if fwCrtl != nil {
+		for _, tableName := range []string{aclTable, egressTable, ingressTable} {
+			p.seed(server, tableName, fwCrtl.FetchRuleTable(server, tableName))
+		}
+	}
+	p.seedTargets(aclTarget)
+	fwMutex.Unlock()
+	applyFwUpdate(p, server, fwUpdate)
+	return *p.plan
+}
+
+func newPlanFirewall(backend string) *planFirewall {
+	if backend != models.FIREWALL_IPTABLES {
+		backend = models.FIREWALL_NFTABLES
+	}
+	return &planFirewall{
+		backend: backend,
+		tables: map[string]serverrulestable{
+			aclTable:     make(serverrulestable),
+			egressTable:  make(serverrulestable),
+			ingressTable: make(serverrulestable),
+		},
+		chains:  make(map[string][]string),
+		targets: make(map[string]string),
+		logged:  make(map[string]bool),
+		plan:    &Plan{Backend: backend, Ops: []PlanOp{}},
+	}
+}
+
+// planFirewall.seed - copies an installed rule table, so the plan can diff against it without changing it
+func (p *planFirewall) seed(server, tableName string, ruleTable ruletable) {
+	seeded := copyRuleTable(ruleTable)
+	for _, rCfg := range seeded {
+		for _, rules := range rCfg.rulesMap {
+			for _, rule := range rules {
+				for _, family := range p.ruleFamilies(rule) {
+					k := p.chainKey(family, rule)
+					p.chains[k] = append(p.chains[k], genRuleKey(rule.rule...))
+				}
+			}
+		}
+	}
+	p.tables[tableName][server] = seeded
+}
+
+// planFirewall.seedTargets - sets the default target installed at the end of the acl chains
+func (p *planFirewall) seedTargets(target string) {
+	logDrops := target == targetDrop && dropLogEnabled()
+	for _, chain := range []string{aclInputRulesChain, aclFwdRulesChain} {
+		p.targets[chain] = target
+		p.logged[chain] = logDrops
+		for _, family := range p.families() {
+			for _, spec := range p.targetRuleSpecs(chain, target, logDrops) {
+				rule := ruleInfo{table: defaultIpTable, chain: chain, rule: spec}
+				k := p.chainKey(family, rule)
+				p.chains[k] = append(p.chains[k], genRuleKey(rule.rule...))
+			}
+		}
+	}
+}
+
+func (p *planFirewall) warn(format string, a ...any) {
+	p.plan.Warnings = append(p.plan.Warnings, fmt.Sprintf(format, a...))
+}
+
+func (p *planFirewall) isNft() bool {
+	return p.backend == models.FIREWALL_NFTABLES
+}
+
+// planFirewall.families - address families a rule without addresses is installed for
+func (p *planFirewall) families() []string {
+	if p.isNft() {
+		return []string{familyInet}
+	}
+	return []string{ipv4, ipv6}
+}
+
+// planFirewall.ruleFamilies - address families the rule is installed for, derived from its addresses
+func (p *planFirewall) ruleFamilies(rule ruleInfo) []string {
+	if p.isNft() {
+		if rule.isDockerRule {
+			return []string{familyIp}
+		}
+		return []string{familyInet}
+	}
+	isIpv4, ok := ruleAddrFamily(rule.rule)
+	if !ok {
+		return p.families()
+	}
+	if isIpv4 {
+		return []string{ipv4}
+	}
+	return []string{ipv6}
+}
+
+func (p *planFirewall) chainKey(family string, rule ruleInfo) string {
+	return family + " " + rule.table + " " + rule.chain
+}
+
+// planFirewall.addRule - records the insertion of a rule, removing an identical rule first as the backends do
+func (p *planFirewall) addRule(ruleTable, key, family string, rule ruleInfo, pos rulePosition) ruleInfo {
+	k := p.chainKey(family, rule)
+	ruleKey := genRuleKey(rule.rule...)
+	p.removeFromChain(ruleTable, key, family, rule)
+	chain := p.chains[k]
+	index := 0
+	switch pos {
+	case positionBottom:
+		index = len(chain)
+	case positionBeforeLast:
+		if len(chain) > 0 {
+			index = len(chain) - 1
+		}
+	}
+	chain = append(chain, "")
+	copy(chain[index+1:], chain[index:])
+	chain[index] = ruleKey
+	p.chains[k] = chain
+	p.record(PlanActionAdd, ruleTable, key, family, rule, p.renderAdd(family, rule, pos, index))
+	if p.backend == models.FIREWALL_IPTABLES {
+		rule.isIpv4 = family == ipv4
+	}
+	return rule
+}
+
+// planFirewall.deleteRule - records the removal of a rule from every family it is installed in
+func (p *planFirewall) deleteRule(ruleTable, key string, rule ruleInfo) {
+	for _, family := range p.ruleFamilies(rule) {
+		p.removeFromChain(ruleTable, key, family, rule)
+	}
+}
+
+func (p *planFirewall) removeFromChain(ruleTable, key, family string, rule ruleInfo) {
+	k := p.chainKey(family, rule)
+	ruleKey := genRuleKey(rule.rule...)
+	for i, existing := range p.chains[k] {
+		if existing != ruleKey {
+			continue
+		}
+		p.chains[k] = append(p.chains[k][:i], p.chains[k][i+1:]...)
+		p.record(PlanActionDelete, ruleTable, key, family, rule, p.renderDelete(family, rule))
+		return
+	}
+}
+
+func (p *planFirewall) record(action, ruleTable, key, family string, rule ruleInfo, command string) {
+	p.plan.Ops = append(p.plan.Ops, PlanOp{
+		Action:    action,
+		RuleTable: ruleTable,
+		Key:       key,
+		Family:    family,
+		Table:     rule.table,
+		Chain:     rule.chain,
+		Rule:      strings.Join(rule.rule, " "),
+		Command:   command,
+	})
+}
+
+func (p *planFirewall) renderAdd(family string, rule ruleInfo, pos rulePosition, index int) string {
+	if p.isNft() {
+		verb := "insert rule"
+		position := ""
+		switch {
+		case pos == positionBottom:
+			verb = "add rule"
+		case pos == positionBeforeLast && index > 0:
+			position = " index " + strconv.Itoa(index)
+		}
+		return fmt.Sprintf("nft %s %s %s %s%s %s", verb, family, rule.table, rule.chain, position, nftRuleExpr(rule.rule))
+	}
+	op := fmt.Sprintf("-I %s 1", rule.chain)
+	switch pos {
+	case positionBottom:
+		op = "-A " + rule.chain
+	case positionBeforeLast:
+		op = fmt.Sprintf("-I %s %d", rule.chain, index+1)
+	}
+	return fmt.Sprintf("%s -t %s %s %s", iptablesBinary(family), rule.table, op, strings.Join(rule.rule, " "))
+}
+
+func (p *planFirewall) renderDelete(family string, rule ruleInfo) string {
+	if p.isNft() {
+		return fmt.Sprintf("nft delete rule %s %s %s handle <handle> # %s", family, rule.table, rule.chain, nftRuleExpr(rule.rule))
+	}
+	return fmt.Sprintf("%s -t %s -D %s %s", iptablesBinary(family), rule.table, rule.chain, strings.Join(rule.rule, " "))
+}
+
+func iptablesBinary(family string) string {
+	if family == ipv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// nftRuleExpr - renders an iptables style rule spec in nft syntax
+func nftRuleExpr(spec []string) string {
+	var proto, dport, meter string
+	family := "ip"
+	for i := 0; i < len(spec)-1; i++ {
+		switch spec[i] {
+		case "-p":
+			proto = spec[i+1]
+		case "--dport":
+			dport = spec[i+1]
+		case "-s":
+			if !isAddrIpv4(strings.Split(spec[i+1], ",")[0]) {
+				family = "ip6"
+			}
+		}
+	}
+	parts := []string{}
+	for i := 0; i < len(spec); i++ {
+		token := spec[i]
+		value := ""
+		if i+1 < len(spec) {
+			value = spec[i+1]
+		}
+		switch token {
+		case "-s", "-d":
+			field := "saddr"
+			if token == "-d" {
+				field = "daddr"
+			}
+			family := "ip"
+			if !isAddrIpv4(strings.Split(value, ",")[0]) {
+				family = "ip6"
+			}
+			parts = append(parts, fmt.Sprintf("%s %s %s", family, field, nftSet(value)))
+			i++
+		case "-p":
+			if dport == "" {
+				parts = append(parts, "meta l4proto "+proto)
+			}
+			i++
+		case "--dport":
+			l4 := proto
+			if l4 == "" {
+				l4 = "th"
+			}
+			parts = append(parts, fmt.Sprintf("%s dport %s", l4, nftSet(strings.ReplaceAll(value, ":", "-"))))
+			i++
+		case "-i":
+			parts = append(parts, fmt.Sprintf("iifname %q", value))
+			i++
+		case "-o":
+			parts = append(parts, fmt.Sprintf("oifname %q", value))
+			i++
+		case "-m", "--comment":
+			// rules are keyed by user data rather than a comment match
+			i++
+		case "--meter":
+			meter = value
+			i++
+		case "--connlimit-above":
+			parts = append(parts, fmt.Sprintf("add @%s { %s saddr ct count over %s }", meter, family, value))
+			i++
+		case "--limit-above":
+			parts = append(parts, fmt.Sprintf("update @%s { %s saddr timeout %ds limit rate over %s }",
+				meter, family, int(nftLimitTimeout.Seconds()), value))
+			i++
+		case "--limit":
+			parts = append(parts, "limit rate "+value)
+			i++
+		case "--limit-burst":
+			parts = append(parts, "burst "+value+" packets")
+			i++
+		case "--ctstate":
+			parts = append(parts, "ct state "+nftSet(strings.ToLower(value)))
+			i++
+		case "--nflog-group":
+			parts = append(parts, "log group "+value)
+			i++
+		case "--nflog-prefix":
+			parts = append(parts, fmt.Sprintf("prefix %q", value))
+			i++
+		case "-j":
+			switch value {
+			case targetAccept, targetDrop, "RETURN", "MASQUERADE":
+				parts = append(parts, strings.ToLower(value))
+			default:
+				parts = append(parts, "jump "+value)
+			}
+			i++
+		default:
+			parts = append(parts, token)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func nftSet(value string) string {
+	if !strings.Contains(value, ",") {
+		return value
+	}
+	return "{ " + strings.Join(strings.Split(value, ","), ", ") + " }"
+}
+
+// planFirewall.targetRuleSpec - rule setting the default target of an acl chain
+func (p *planFirewall) targetRuleSpec(target string) []string {
+	if p.isNft() {
+		return nftTargetRuleSpec(target)
+	}
+	return iptablesTargetRuleSpec(target)
+}
+
+// planFirewall.targetRuleSpecs - rules setting the default target of an acl chain, in chain order
+func (p *planFirewall) targetRuleSpecs(chain, target string, logDrops bool) [][]string {
+	if target == targetDrop && logDrops {
+		if p.isNft() {
+			return [][]string{nftDropLogRuleSpec(chain), p.targetRuleSpec(target)}
+		}
+		return [][]string{iptablesDropLogRuleSpec(chain), p.targetRuleSpec(target)}
+	}
+	return [][]string{p.targetRuleSpec(target)}
+}
+
+func (p *planFirewall) changeTarget(chain, target string) {
+	current, logged := p.targets[chain], p.logged[chain]
+	logDrops := target == targetDrop && dropLogEnabled()
+	if current == target && logged == logDrops {
+		return
+	}
+	for _, family := range p.families() {
+		if current != "" {
+			for _, spec := range p.targetRuleSpecs(chain, current, logged) {
+				p.removeFromChain("", "", family, ruleInfo{table: defaultIpTable, chain: chain, rule: spec})
+			}
+		}
+		for _, spec := range p.targetRuleSpecs(chain, target, logDrops) {
+			p.addRule("", "", family, ruleInfo{table: defaultIpTable, chain: chain, rule: spec}, positionBottom)
+		}
+	}
+	p.targets[chain] = target
+	p.logged[chain] = logDrops
+}
+
+// planFirewall.aclRuleInfos - records the rules of an acl policy, inserted at the top of the chain
+func (p *planFirewall) aclRuleInfos(ruleTable, key, chain string, aclRule models.AclRule, egress bool) []ruleInfo {
+	var specs []aclRuleSpec
+	if p.isNft() {
+		specs = nftAclRuleSpecs(aclRule, egress)
+	} else {
+		specs = iptablesAclRuleSpecs(aclRule, egress)
+	}
+	rules := []ruleInfo{}
+	for _, spec := range specs {
+		family := familyInet
+		if !p.isNft() {
+			family = ipv6
+			if spec.isIpv4 {
+				family = ipv4
+			}
+		}
+		rule := p.addRule(ruleTable, key, family, ruleInfo{
+			isIpv4: spec.isIpv4,
+			table:  defaultIpTable,
+			chain:  chain,
+			rule:   spec.spec,
+			limit:  spec.limit,
+		}, positionTop)
+		rules = append(rules, rule)
+		for _, limit := range spec.limits {
+			rule := p.addRule(ruleTable, key, family, ruleInfo{
+				isIpv4: spec.isIpv4,
+				table:  defaultIpTable,
+				chain:  aclLimitChain(chain),
+				rule:   limit.spec,
+				limit:  spec.limit,
+			}, positionTop)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (p *planFirewall) CreateChains() error { return nil }
+
+func (p *planFirewall) ForwardRule() error { return nil }
+
+func (p *planFirewall) AddDropRules([]ruleInfo) {}
+
+func (p *planFirewall) FlushAll() {}
+
+func (p *planFirewall) Reconcile(target string) []Drift { return nil }
+
+func (p *planFirewall) RuleCounters(rules []ruleInfo) []ruleCounter {
+	return make([]ruleCounter, len(rules))
+}
+
+func (p *planFirewall) ChangeACLInTarget(target string) {
+	p.changeTarget(aclInputRulesChain, target)
+}
+
+func (p *planFirewall) ChangeACLFwdTarget(target string) {
+	p.changeTarget(aclFwdRulesChain, target)
+}
+
+func (p *planFirewall) InsertEgressRoutingRules(server string, egressInfo models.EgressInfo) error {
+	ruleTable := p.FetchRuleTable(server, egressTable)
+	defer p.SaveRules(server, egressTable, ruleTable)
+	ruleTable[egressInfo.EgressID] = rulesCfg{
+		isIpv4:    isAddrIpv4(egressInfo.EgressGwAddr.String()),
+		rulesMap:  make(map[string][]ruleInfo),
+		extraInfo: egressInfo.EgressGWCfg,
+	}
+	egressGwRoutes := []ruleInfo{}
+	for _, egressGwRange := range egressInfo.EgressGWCfg.RangesWithMetric {
+		if !egressGwRange.Nat {
+			continue
+		}
+		source := egressInfo.Network.String()
+		family := ipv4
+		if !isAddrIpv4(egressGwRange.Network) {
+			source = egressInfo.Network6.String()
+			family = ipv6
+		}
+		if p.isNft() {
+			family = familyInet
+		}
+		egressRangeIface, err := getInterfaceName(config.ToIPNet(egressGwRange.Network))
+		if err != nil {
+			p.warn("skipped nat rule for egress range %s: failed to get interface name: %v", egressGwRange.Network, err)
+			continue
+		}
+		singleNode := len(config.GetNodes()) == 1
+		ruleSpec := egressNatRuleSpec(source, egressRangeIface, singleNode)
+		if !p.isNft() {
+			ruleSpec = iptablesEgressNatRuleSpec(source, egressRangeIface, singleNode)
+		}
+		egressGwRoutes = append(egressGwRoutes, p.addRule(egressTable, egressInfo.EgressID, family, ruleInfo{
+			table: defaultNatTable,
+			chain: nattablePRTChain,
+			rule:  ruleSpec,
+		}, positionTop))
+		if isDockerInterface(egressRangeIface) {
+			p.warn("docker rule for %s is only added when the %s chain exists", egressRangeIface, dockerUserChain)
+			dockerRule := ruleInfo{
+				table: defaultIpTable,
+				chain: dockerUserChain,
+				rule:  iptablesDockerRuleSpec(egressRangeIface),
+			}
+			if p.isNft() {
+				family = familyIp
+				dockerRule.isDockerRule = true
+				dockerRule.rule = nftDockerRuleSpec(egressRangeIface)
+			}
+			egressGwRoutes = append(egressGwRoutes, p.addRule(egressTable, egressInfo.EgressID, family, dockerRule, positionTop))
+		}
+	}
+	ruleTable[egressInfo.EgressID].rulesMap[egressInfo.EgressID] = egressGwRoutes
+	return nil
+}
+
+func (p *planFirewall) InsertIngressRoutingRules(server string, ingressInfo models.IngressInfo) error {
+	ruleTable := p.FetchRuleTable(server, ingressTable)
+	defer p.SaveRules(server, ingressTable, ruleTable)
+	ingressRules, ok := ruleTable[ingressInfo.IngressID]
+	if !ok {
+		ingressRules = rulesCfg{
+			rulesMap: make(map[string][]ruleInfo),
+		}
+	}
+	ingressGwRoutes := []ruleInfo{}
+	for _, ip := range ingressInfo.StaticNodeIps {
+		family := familyInet
+		if !p.isNft() {
+			family = ipv6
+			if ip.To4() != nil {
+				family = ipv4
+			}
+		}
+		ruleSpec, druleSpec := staticNodeRuleSpecs(ip)
+		for _, spec := range [][]string{ruleSpec, druleSpec} {
+			ingressGwRoutes = append(ingressGwRoutes, p.addRule(ingressTable, ingressInfo.IngressID, family, ruleInfo{
+				table: defaultIpTable,
+				chain: aclInputRulesChain,
+				rule:  spec,
+			}, positionBeforeLast))
+		}
+	}
+	for _, rule := range ingressInfo.Rules {
+		if !rule.Allow {
+			continue
+		}
+		family := familyInet
+		rulesSpec := [][]string{nftIngressRuleSpec(rule)}
+		if !p.isNft() {
+			family = ipv6
+			if rule.SrcIP.IP.To4() != nil {
+				family = ipv4
+			}
+			rulesSpec = iptablesIngressRuleSpecs(rule)
+		}
+		for _, ruleSpec := range rulesSpec {
+			ingressGwRoutes = append(ingressGwRoutes, p.addRule(ingressTable, ingressInfo.IngressID, family, ruleInfo{
+				table: defaultIpTable,
+				chain: aclInputRulesChain,
+				rule:  ruleSpec,
+			}, positionTop))
+		}
+	}
+	ingressRules.rulesMap[staticNodeRules] = ingressGwRoutes
+	ingressRules.extraInfo = ingressInfo
+	ruleTable[ingressInfo.IngressID] = ingressRules
+	return nil
+}
+
+func (p *planFirewall) AddAclRules(server string, aclRules map[string]models.AclRule) {
+	for _, aclID := range sortedKeys(aclRules) {
+		p.UpsertAclRule(server, aclRules[aclID])
+	}
+}
+
+func (p *planFirewall) UpsertAclRule(server string, aclRule models.AclRule) {
+	ruleTable := p.FetchRuleTable(server, aclTable)
+	defer p.SaveRules(server, aclTable, ruleTable)
+	ruleTable[aclRule.ID] = rulesCfg{
+		rulesMap: make(map[string][]ruleInfo),
+	}
+	rules := p.aclRuleInfos(aclTable, aclRule.ID, aclInputRulesChain, aclRule, false)
+	if len(rules) > 0 {
+		ruleTable[aclRule.ID] = rulesCfg{
+			rulesMap: map[string][]ruleInfo{
+				aclRule.ID: rules,
+			},
+			extraInfo: aclRule,
+		}
+	}
+}
+
+func (p *planFirewall) DeleteAclRule(server, aclID string) {
+	ruleTable := p.FetchRuleTable(server, aclTable)
+	defer p.SaveRules(server, aclTable, ruleTable)
+	rCfg, ok := ruleTable[aclID]
+	if !ok {
+		return
+	}
+	for _, rule := range rCfg.rulesMap[aclID] {
+		p.deleteRule(aclTable, aclID, rule)
+	}
+	delete(ruleTable, aclID)
+}
+
+func (p *planFirewall) AddAclEgressRules(server string, egressInfo models.EgressInfo) {
+	ruleTable := p.FetchRuleTable(server, egressTable)
+	defer p.SaveRules(server, egressTable, ruleTable)
+	egressAclID := fmt.Sprintf("acl#%s", egressInfo.EgressID)
+	aclRules := make(map[string]models.AclRule)
+	rCfg := rulesCfg{
+		rulesMap: make(map[string][]ruleInfo),
+	}
+	for _, aclID := range sortedKeys(egressInfo.EgressFwRules) {
+		aclRule := egressInfo.EgressFwRules[aclID]
+		rules := p.aclRuleInfos(egressTable, egressAclID, aclFwdRulesChain, aclRule, true)
+		if len(rules) > 0 {
+			rCfg.rulesMap[aclRule.ID] = rules
+			aclRules[aclRule.ID] = aclRule
+		}
+	}
+	rCfg.extraInfo = aclRules
+	ruleTable[egressAclID] = rCfg
+}
+
+func (p *planFirewall) UpsertAclEgressRule(server, egressID string, aclRule models.AclRule) {
+	ruleTable := p.FetchRuleTable(server, egressTable)
+	defer p.SaveRules(server, egressTable, ruleTable)
+	rCfg := ruleTable[egressID]
+	extraInfo, _ := rCfg.extraInfo.(map[string]models.AclRule)
+	if extraInfo == nil {
+		extraInfo = make(map[string]models.AclRule)
+	}
+	if rCfg.rulesMap == nil {
+		rCfg.rulesMap = make(map[string][]ruleInfo)
+	}
+	rules := p.aclRuleInfos(egressTable, egressID, aclFwdRulesChain, aclRule, true)
+	if len(rules) > 0 {
+		rCfg.rulesMap[aclRule.ID] = rules
+		extraInfo[aclRule.ID] = aclRule
+		rCfg.extraInfo = extraInfo
+		ruleTable[egressID] = rCfg
+	}
+}
+
+func (p *planFirewall) DeleteAclEgressRule(server, egressID, aclID string) {
+	ruleTable := p.FetchRuleTable(server, egressTable)
+	defer p.SaveRules(server, egressTable, ruleTable)
+	rCfg, ok := ruleTable[egressID]
+	if !ok {
+		return
+	}
+	for _, rule := range rCfg.rulesMap[aclID] {
+		p.deleteRule(egressTable, egressID, rule)
+	}
+	delete(rCfg.rulesMap, aclID)
+	ruleTable[egressID] = rCfg
+}
+
+func (p *planFirewall) DeleteAllAclEgressRules(server, egressID string) {
+	p.RemoveRoutingRules(server, egressTable, egressID)
+}
+
+func (p *planFirewall) RemoveRoutingRules(server, tableName, peerKey string) error {
+	ruleTable := p.FetchRuleTable(server, tableName)
+	defer p.SaveRules(server, tableName, ruleTable)
+	rCfg, ok := ruleTable[peerKey]
+	if !ok {
+		return fmt.Errorf("peer not found in rule table: %s", peerKey)
+	}
+	for _, rules := range rCfg.rulesMap {
+		for _, rule := range rules {
+			p.deleteRule(tableName, peerKey, rule)
+		}
+	}
+	delete(ruleTable, peerKey)
+	return nil
+}
+
+func (p *planFirewall) DeleteRoutingRule(server, tableName, srcPeer, dstPeer string) error {
+	ruleTable := p.FetchRuleTable(server, tableName)
+	rCfg, ok := ruleTable[srcPeer]
+	if !ok {
+		return fmt.Errorf("peer not found in rule table: %s", srcPeer)
+	}
+	rules, ok := rCfg.rulesMap[dstPeer]
+	if !ok {
+		return fmt.Errorf("rules not found for: %s", dstPeer)
+	}
+	for _, rule := range rules {
+		p.deleteRule(tableName, srcPeer, rule)
+	}
+	return nil
+}
+
+func (p *planFirewall) CleanRoutingRules(server, tableName string) {
+	for key, rCfg := range p.FetchRuleTable(server, tableName) {
+		for _, rules := range rCfg.rulesMap {
+			for _, rule := range rules {
+				p.deleteRule(tableName, key, rule)
+			}
+		}
+	}
+	p.DeleteRuleTable(server, tableName)
+}
+
+func (p *planFirewall) FetchRuleTable(server, tableName string) ruletable {
+	rules := p.tables[tableName][server]
+	if rules == nil {
+		rules = make(ruletable)
+	}
+	return rules
+}
+
+func (p *planFirewall) DeleteRuleTable(server, tableName string) {
+	delete(p.tables[tableName], server)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (p *planFirewall) SaveRules(server, tableName string, ruleTable ruletable) {
+	p.tables[tableName][server] = ruleTable
+}
@@ -0,0 +1,101 @@
+package firewall
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// kinds of drift between the live firewall and the rules installed by netclient
+const (
+	DriftMissingChain  = "missing_chain"
+	DriftMissingJump   = "missing_jump"
+	DriftMissingTarget = "missing_target"
+	DriftMissing       = "missing"
+	DriftModified      = "modified"
+	DriftExtra         = "extra"
+)
+
+// Drift - a difference between the live firewall and the rules netclient installed.
+// Missing and modified rules are re-applied, extra rules are only reported
+type Drift struct {
+	Kind      string `json:"kind"`
+	Server    string `json:"server,omitempty"`
+	RuleTable string `json:"rule_table,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Family    string `json:"family,omitempty"`
+	Table     string `json:"table"`
+	Chain     string `json:"chain"`
+	Rule      string `json:"rule,omitempty"`
+	Repaired  bool   `json:"repaired"`
+}
+
+// Reconcile - compares the live firewall with the rules installed for every server,
+// re-applies what is missing and returns the drift found
+func Reconcile() []Drift {
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if fwCrtl == nil {
+		return nil
+	}
+	drift := fwCrtl.Reconcile(aclTarget)
+	for _, d := range drift {
+		slog.Warn("firewall drift detected", "kind", d.Kind, "table", d.Table, "chain", d.Chain,
+			"rule", d.Rule, "server", d.Server, "ruletable", d.RuleTable, "key", d.Key, "repaired", d.Repaired)
+	}
+	return drift
+}
+
+// installedRule - a rule recorded in a rule table along with its owner
+type installedRule struct {
+	server    string
+	ruleTable string
+	key       string
+	ruleInfo
+}
+
+// installedRules - returns the rules recorded in the acl, egress and ingress tables of all servers
+func installedRules(aclRules, egressRules, ingressRules serverrulestable) []installedRule {
+	rules := []installedRule{}
+	for tableName, tables := range map[string]serverrulestable{
+		aclTable:     aclRules,
+		egressTable:  egressRules,
+		ingressTable: ingressRules,
+	} {
+		for server, ruleTable := range tables {
+			for key, rCfg := range ruleTable {
+				for _, ruleInfos := range rCfg.rulesMap {
+					for _, rule := range ruleInfos {
+						rules = append(rules, installedRule{
+							server:    server,
+							ruleTable: tableName,
+							key:       key,
+							ruleInfo:  rule,
+						})
+					}
+				}
+			}
+		}
+	}
+	return rules
+}
+
+// isStaticNodeRule - static node rules are kept ahead of the default target at the end of the acl input chain
+func isStaticNodeRule(rule installedRule) bool {
+	return rule.ruleTable == ingressTable && rule.chain == aclInputRulesChain &&
+		len(rule.rule) > 3 && rule.rule[2] == "-j" && rule.rule[3] == targetDrop
+}
+
+func (r installedRule) drift(kind, family string, repaired bool) Drift {
+	return Drift{
+		Kind:      kind,
+		Server:    r.server,
+		RuleTable: r.ruleTable,
+		Key:       r.key,
+		Family:    family,
+		Table:     r.table,
+		Chain:     r.chain,
+		Rule:      strings.Join(r.rule, " "),
+		Repaired:  repaired,
+	}
+}
@@ -0,0 +1,469 @@
+package firewall
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/ncutils"
+	"github.com/gravitl/netmaker/models"
+)
+
+// aclRuleSpec - a rule installed for an acl policy, along with the rules enforcing its limits
+type aclRuleSpec struct {
+	isIpv4 bool
+	src    net.IPNet
+	dst    net.IPNet // unset when the rule does not match on destination
+	limit  config.AclLimit
+	spec   []string
+	limits []aclLimitSpec
+}
+
+// aclLimitSpec - a rule dropping the traffic of an acl rule over one of the policy limits
+type aclLimitSpec struct {
+	kind string
+	set  string // nftables meter keeping the limit state per source address
+	spec []string
+}
+
+// kinds of acl limits
+const (
+	limitConns   = "c"
+	limitPackets = "p"
+	limitBytes   = "b"
+)
+
+func appendNetmakerCommentToRule(ruleSpec []string) []string {
+	ruleSpec = append(ruleSpec, "-m", "comment", "--comment", netmakerSignature)
+	return ruleSpec
+}
+
+func genRuleKey(rule ...string) string {
+	return strings.Join(rule, ":")
+}
+
+// ruleAddrFamily - reports if the addresses matched by a rule spec are ipv4,
+// ok is false when the rule does not match on addresses
+func ruleAddrFamily(spec []string) (isIpv4, ok bool) {
+	for i := 0; i < len(spec)-1; i++ {
+		if spec[i] != "-s" && spec[i] != "-d" {
+			continue
+		}
+		return isAddrIpv4(strings.Split(spec[i+1], ",")[0]), true
+	}
+	return false, false
+}
+
+// hasProtoMatch - reports if the acl rule is restricted to a protocol
+func hasProtoMatch(aclRule models.AclRule) bool {
+	return aclRule.AllowedProtocol.String() != "" && aclRule.AllowedProtocol != models.ALL
+}
+
+// nftAclRuleSpecs - returns the rules the nftables backend installs for an acl policy,
+// one per source/destination pair. Egress rules carry the destination after the ports.
+// Unset destinations are ignored, an egress policy left without any allows its sources
+// to every destination like the iptables backend does
+func nftAclRuleSpecs(aclRule models.AclRule, egress bool) []aclRuleSpec {
+	specs := []aclRuleSpec{}
+	limit := aclLimit(aclRule.ID)
+	build := func(isIpv4 bool, srcs, dsts []net.IPNet) {
+		if egress {
+			dsts = setIPNets(dsts)
+		}
+		for _, src := range srcs {
+			if src.IP == nil {
+				continue
+			}
+			if len(dsts) == 0 {
+				specs = append(specs, aclRuleSpec{
+					isIpv4: isIpv4,
+					src:    src,
+					limit:  limit,
+					spec:   nftAclRuleSpec(aclRule, src, net.IPNet{}, egress),
+					limits: nftLimitSpecs(aclRule.ID, nftAclRuleMatches(aclRule, src, net.IPNet{}, egress), isIpv4, limit),
+				})
+				continue
+			}
+			for _, dst := range dsts {
+				if dst.IP == nil {
+					continue
+				}
+				specs = append(specs, aclRuleSpec{
+					isIpv4: isIpv4,
+					src:    src,
+					dst:    dst,
+					limit:  limit,
+					spec:   nftAclRuleSpec(aclRule, src, dst, egress),
+					limits: nftLimitSpecs(aclRule.ID, nftAclRuleMatches(aclRule, src, dst, egress), isIpv4, limit),
+				})
+			}
+		}
+	}
+	build(true, aclRule.IPList, aclRule.Dst)
+	build(false, aclRule.IP6List, aclRule.Dst6)
+	return specs
+}
+
+func nftAclRuleSpec(aclRule models.AclRule, src, dst net.IPNet, egress bool) []string {
+	ruleSpec := append(nftAclRuleMatches(aclRule, src, dst, egress), "-j", "ACCEPT")
+	return appendNetmakerCommentToRule(ruleSpec)
+}
+
+// nftAclRuleMatches - returns the matches of an acl rule on nftables
+func nftAclRuleMatches(aclRule models.AclRule, src, dst net.IPNet, egress bool) []string {
+	ruleSpec := []string{"-s", src.String()}
+	if dst.IP != nil && !egress {
+		ruleSpec = append(ruleSpec, "-d", dst.String())
+	}
+	if hasProtoMatch(aclRule) {
+		ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
+	}
+	if len(aclRule.AllowedPorts) > 0 {
+		ruleSpec = append(ruleSpec, "--dport",
+			strings.Join(aclRule.AllowedPorts, ","))
+	}
+	if dst.IP != nil && egress {
+		ruleSpec = append(ruleSpec, "-d", dst.String())
+	}
+	return ruleSpec
+}
+
+// iptablesAclRuleSpecs - returns the rules the iptables backend installs for an acl policy,
+// one per port with the source and destination addresses joined.
+func iptablesAclRuleSpecs(aclRule models.AclRule, egress bool) []aclRuleSpec {
+	specs := []aclRuleSpec{}
+	limit := aclLimit(aclRule.ID)
+	build := func(isIpv4 bool, srcs, dsts []net.IPNet) {
+		allowedIps := joinIPNets(srcs)
+		if allowedIps == "" {
+			return
+		}
+		dstAllowedIps := joinIPNets(dsts)
+		if len(aclRule.AllowedPorts) == 0 {
+			matches := iptablesAclRuleMatches(aclRule, allowedIps, dstAllowedIps, "", egress)
+			specs = append(specs, aclRuleSpec{
+				isIpv4: isIpv4,
+				limit:  limit,
+				spec:   iptablesAclRuleSpec(matches),
+				limits: iptablesLimitSpecs(aclRule.ID, matches, isIpv4, limit),
+			})
+			return
+		}
+		for _, port := range aclRule.AllowedPorts {
+			if port == "" {
+				continue
+			}
+			matches := iptablesAclRuleMatches(aclRule, allowedIps, dstAllowedIps, strings.ReplaceAll(port, "-", ":"), egress)
+			specs = append(specs, aclRuleSpec{
+				isIpv4: isIpv4,
+				limit:  limit,
+				spec:   iptablesAclRuleSpec(matches),
+				limits: iptablesLimitSpecs(aclRule.ID, matches, isIpv4, limit),
+			})
+		}
+	}
+	build(true, aclRule.IPList, aclRule.Dst)
+	build(false, aclRule.IP6List, aclRule.Dst6)
+	return specs
+}
+
+func iptablesAclRuleSpec(matches []string) []string {
+	ruleSpec := append(append([]string{}, matches...), "-j", "ACCEPT")
+	return appendNetmakerCommentToRule(ruleSpec)
+}
+
+// iptablesAclRuleMatches - returns the matches of an acl rule on iptables
+func iptablesAclRuleMatches(aclRule models.AclRule, src, dst, port string, egress bool) []string {
+	ruleSpec := []string{"-s", src}
+	if dst != "" && !egress {
+		ruleSpec = append(ruleSpec, "-d", dst)
+	}
+	if hasProtoMatch(aclRule) {
+		ruleSpec = append(ruleSpec, "-p", aclRule.AllowedProtocol.String())
+	}
+	if dst != "" && egress {
+		ruleSpec = append(ruleSpec, "-d", dst)
+	}
+	if port != "" {
+		ruleSpec = append(ruleSpec, "--dport", port)
+	}
+	return ruleSpec
+}
+
+// aclLimit - returns the limits configured on the host for an acl policy
+func aclLimit(aclID string) config.AclLimit {
+	return config.Netclient().AclLimits[aclID]
+}
+
+// installedAclLimit - returns the limits the rules of an acl policy were installed with
+func installedAclLimit(rules []ruleInfo) config.AclLimit {
+	if len(rules) == 0 {
+		return config.AclLimit{}
+	}
+	return rules[0].limit
+}
+
+// limitRuleSpec - returns a rule dropping the traffic matched by an acl rule over a limit
+func limitRuleSpec(matches []string, limit ...string) []string {
+	ruleSpec := append(append([]string{}, matches...), limit...)
+	ruleSpec = append(ruleSpec, "-j", targetDrop)
+	return appendNetmakerCommentToRule(ruleSpec)
+}
+
+// nftLimitSpecs - returns the rules enforcing acl limits on nftables. Each limit is kept
+// per source address in a meter named after the acl id, the kind and the value of the limit
+func nftLimitSpecs(aclID string, matches []string, isIpv4 bool, limit config.AclLimit) []aclLimitSpec {
+	specs := []aclLimitSpec{}
+	if limit.MaxConns > 0 {
+		set := limitSetName(aclID, limitConns, isIpv4, limit.MaxConns)
+		specs = append(specs, aclLimitSpec{kind: limitConns, set: set, spec: limitRuleSpec(matches,
+			"-m", "conntrack", "--ctstate", "NEW", "--meter", set, "--connlimit-above", fmt.Sprint(limit.MaxConns))})
+	}
+	if limit.PacketsPerSec > 0 {
+		set := limitSetName(aclID, limitPackets, isIpv4, limit.PacketsPerSec)
+		specs = append(specs, aclLimitSpec{kind: limitPackets, set: set, spec: limitRuleSpec(matches,
+			"--meter", set, "--limit-above", fmt.Sprintf("%d/second", limit.PacketsPerSec))})
+	}
+	if limit.BytesPerSec > 0 {
+		set := limitSetName(aclID, limitBytes, isIpv4, limit.BytesPerSec)
+		specs = append(specs, aclLimitSpec{kind: limitBytes, set: set, spec: limitRuleSpec(matches,
+			"--meter", set, "--limit-above", fmt.Sprintf("%d bytes/second", limit.BytesPerSec))})
+	}
+	return specs
+}
+
+// iptablesLimitSpecs - returns the rules enforcing acl limits on iptables, per source address.
+// Rate limits of a policy share a hashlimit table named after the acl id
+func iptablesLimitSpecs(aclID string, matches []string, isIpv4 bool, limit config.AclLimit) []aclLimitSpec {
+	specs := []aclLimitSpec{}
+	if limit.MaxConns > 0 {
+		mask := "128"
+		if isIpv4 {
+			mask = "32"
+		}
+		specs = append(specs, aclLimitSpec{kind: limitConns, spec: limitRuleSpec(matches,
+			"-m", "conntrack", "--ctstate", "NEW", "-m", "connlimit", "--connlimit-above", fmt.Sprint(limit.MaxConns),
+			"--connlimit-mask", mask)})
+	}
+	name := hashlimitName(aclID)
+	if limit.PacketsPerSec > 0 {
+		specs = append(specs, aclLimitSpec{kind: limitPackets, spec: limitRuleSpec(matches,
+			"-m", "hashlimit", "--hashlimit-above", fmt.Sprintf("%d/sec", limit.PacketsPerSec),
+			"--hashlimit-burst", fmt.Sprint(limit.PacketsPerSec), "--hashlimit-mode", "srcip", "--hashlimit-name", name+limitPackets)})
+	}
+	if limit.BytesPerSec > 0 {
+		specs = append(specs, aclLimitSpec{kind: limitBytes, spec: limitRuleSpec(matches,
+			"-m", "hashlimit", "--hashlimit-above", hashlimitBytes(limit.BytesPerSec),
+			"--hashlimit-mode", "srcip", "--hashlimit-name", name+limitBytes)})
+	}
+	return specs
+}
+
+// nftLimitTimeout - time a rate limit state is kept for an idle source address
+const nftLimitTimeout = time.Minute
+
+// limitSetName - names the nftables meter of an acl limit, the meter is replaced when the limit changes
+func limitSetName(aclID, kind string, isIpv4 bool, value uint32) string {
+	family := 6
+	if isIpv4 {
+		family = 4
+	}
+	return fmt.Sprintf("%s-%s%d-%d", hashlimitName(aclID), kind, family, value)
+}
+
+// limitSetKind - returns the kind and address family of an acl limit meter from its name
+func limitSetKind(name string) (kind string, isIpv4, ok bool) {
+	parts := strings.Split(name, "-")
+	if len(parts) != 4 || parts[0] != "nm" || len(parts[2]) != 2 {
+		return "", false, false
+	}
+	return parts[2][:1], parts[2][1] == '4', true
+}
+
+// jumpRuleAtTop - reports if a jump rule goes on top of its chain. Besides the FORWARD rules, the
+// jumps to the limit chains are placed ahead of the conntrack accept rules to see established traffic
+func jumpRuleAtTop(rule ruleInfo) bool {
+	if rule.chain == iptableFWDChain {
+		return true
+	}
+	for i := 0; i < len(rule.rule)-1; i++ {
+		if rule.rule[i] == "-j" && (rule.rule[i+1] == aclInputLimitChain || rule.rule[i+1] == aclFwdLimitChain) {
+			return true
+		}
+	}
+	return false
+}
+
+// aclLimitChain - returns the chain holding the limit rules of the policies installed in an acl chain
+func aclLimitChain(chain string) string {
+	if chain == aclFwdRulesChain {
+		return aclFwdLimitChain
+	}
+	return aclInputLimitChain
+}
+
+// hashlimitName - names the hashlimit tables of an acl policy, names are limited to 15 characters
+func hashlimitName(aclID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(aclID))
+	return fmt.Sprintf("nm-%08x", h.Sum32())
+}
+
+// hashlimitBytes - formats a byte rate in the largest unit hashlimit accepts without rounding
+func hashlimitBytes(bytesPerSec uint32) string {
+	switch {
+	case bytesPerSec%(1<<20) == 0:
+		return fmt.Sprintf("%dmb/s", bytesPerSec>>20)
+	case bytesPerSec%(1<<10) == 0:
+		return fmt.Sprintf("%dkb/s", bytesPerSec>>10)
+	}
+	return fmt.Sprintf("%db/s", bytesPerSec)
+}
+
+// setIPNets - returns the ip nets that are set
+func setIPNets(ipNets []net.IPNet) []net.IPNet {
+	set := []net.IPNet{}
+	for _, ip := range ipNets {
+		if ip.IP != nil {
+			set = append(set, ip)
+		}
+	}
+	return set
+}
+
+func joinIPNets(ipNets []net.IPNet) string {
+	ips := []string{}
+	for _, ip := range ipNets {
+		if ip.IP == nil {
+			continue
+		}
+		ips = append(ips, ip.String())
+	}
+	return strings.Join(ips, ",")
+}
+
+// staticNodeRuleSpecs - returns the rules dropping traffic from and to a static node on an ingress gw
+func staticNodeRuleSpecs(ip net.IP) (src, dst []string) {
+	src = appendNetmakerCommentToRule([]string{"-s", ip.String(), "-j", targetDrop})
+	dst = appendNetmakerCommentToRule([]string{"-d", ip.String(), "-j", targetDrop})
+	return src, dst
+}
+
+// nftIngressRuleSpec - returns the rule the nftables backend installs for an ingress fw rule
+func nftIngressRuleSpec(rule models.FwRule) []string {
+	ruleSpec := []string{"-s", rule.SrcIP.String()}
+	if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL {
+		ruleSpec = append(ruleSpec, "-p", rule.AllowedProtocol.String())
+	}
+	ruleSpec = append(ruleSpec, "-d", rule.DstIP.String())
+	if len(rule.AllowedPorts) > 0 {
+		ruleSpec = append(ruleSpec, "--dport",
+			strings.Join(rule.AllowedPorts, ","))
+	}
+	ruleSpec = append(ruleSpec, "-j", "ACCEPT")
+	return appendNetmakerCommentToRule(ruleSpec)
+}
+
+// iptablesIngressRuleSpecs - returns the rules the iptables backend installs for an ingress fw rule, one per port
+func iptablesIngressRuleSpecs(rule models.FwRule) [][]string {
+	build := func(port string) []string {
+		ruleSpec := []string{"-s", rule.SrcIP.String()}
+		if rule.DstIP.IP != nil {
+			ruleSpec = append(ruleSpec, "-d", rule.DstIP.String())
+		}
+		if rule.AllowedProtocol.String() != "" && rule.AllowedProtocol != models.ALL {
+			ruleSpec = append(ruleSpec, "-p", rule.AllowedProtocol.String())
+		}
+		if port != "" {
+			ruleSpec = append(ruleSpec, "--dport", strings.ReplaceAll(port, "-", ":"))
+		}
+		ruleSpec = append(ruleSpec, "-j", "ACCEPT")
+		return appendNetmakerCommentToRule(ruleSpec)
+	}
+	if len(rule.AllowedPorts) == 0 {
+		return [][]string{build("")}
+	}
+	rulesSpec := [][]string{}
+	for _, port := range rule.AllowedPorts {
+		if port == "" {
+			continue
+		}
+		rulesSpec = append(rulesSpec, build(port))
+	}
+	return rulesSpec
+}
+
+// egressNatRuleSpec - returns the masquerade rule for a nat enabled egress range,
+// the source match is left out when the host is in a single network
+func egressNatRuleSpec(source, egressRangeIface string, singleNode bool) []string {
+	if singleNode {
+		return []string{"-o", egressRangeIface, "-j", "MASQUERADE"}
+	}
+	return []string{"-s", source, "-o", egressRangeIface, "-j", "MASQUERADE"}
+}
+
+// iptablesEgressNatRuleSpec - egressNatRuleSpec as installed by the iptables backend, with the netmaker comment
+func iptablesEgressNatRuleSpec(source, egressRangeIface string, singleNode bool) []string {
+	return appendNetmakerCommentToRule(egressNatRuleSpec(source, egressRangeIface, singleNode))
+}
+
+// nftDockerRuleSpec - rule in the DOCKER-USER chain accepting the traffic of the netmaker interface
+// to a docker egress range on nftables
+func nftDockerRuleSpec(egressRangeIface string) []string {
+	return []string{"-i", ncutils.GetInterfaceName(), "-o", egressRangeIface, "-j", targetAccept}
+}
+
+// iptablesDockerRuleSpec - rule in the DOCKER-USER chain sending the traffic of the netmaker interface
+// to a docker egress range through the acl input chain on iptables
+func iptablesDockerRuleSpec(egressRangeIface string) []string {
+	return appendNetmakerCommentToRule([]string{"-i", ncutils.GetInterfaceName(), "-o", egressRangeIface,
+		"-j", aclInputRulesChain})
+}
+
+// DropLogGroup - netlink log group the packets dropped by the default target of the acl chains
+// are logged to, when the drop log is enabled
+const DropLogGroup = 100
+
+// packets per second logged to the drop log group per acl chain, a flood of drops is not logged in full
+const (
+	dropLogRate  = 10
+	dropLogBurst = 20
+)
+
+// dropLogEnabled - reports if packets dropped by the default target of the acl chains are logged
+func dropLogEnabled() bool {
+	return config.Netclient().DropLog
+}
+
+// dropLogRuleSpec - returns the options logging a packet to the drop log group, prefixed with the
+// chain dropping it so the daemon can tell the acl chains apart
+func dropLogRuleSpec(chain string) []string {
+	return []string{"--nflog-group", fmt.Sprint(DropLogGroup), "--nflog-prefix", chain}
+}
+
+// nftTargetRuleSpec - rule setting the default target of an acl chain on nftables
+func nftTargetRuleSpec(target string) []string {
+	return []string{"-i", ncutils.GetInterfaceName(), "-j", target}
+}
+
+// iptablesTargetRuleSpec - rule setting the default target of an acl chain on iptables
+func iptablesTargetRuleSpec(target string) []string {
+	return []string{"-i", ncutils.GetInterfaceName(), "-m", "comment", "--comment", netmakerSignature, "-j", target}
+}
+
+// nftDropLogRuleSpec - rule logging the packets of an acl chain ahead of its drop target on nftables.
+// The rule has no verdict, packets over the log rate go on to the drop target unlogged
+func nftDropLogRuleSpec(chain string) []string {
+	return append([]string{"-i", ncutils.GetInterfaceName(), "--limit", fmt.Sprintf("%d/second", dropLogRate),
+		"--limit-burst", fmt.Sprint(dropLogBurst)}, dropLogRuleSpec(chain)...)
+}
+
+// iptablesDropLogRuleSpec - rule logging the packets of an acl chain ahead of its drop target on iptables
+func iptablesDropLogRuleSpec(chain string) []string {
+	return append([]string{"-i", ncutils.GetInterfaceName(), "-m", "limit", "--limit", fmt.Sprintf("%d/sec", dropLogRate),
+		"--limit-burst", fmt.Sprint(dropLogBurst), "-m", "comment", "--comment", netmakerSignature,
+		"-j", "NFLOG"}, dropLogRuleSpec(chain)...)
+}
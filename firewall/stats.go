@@ -0,0 +1,86 @@
+package firewall
+
+import (
+	"sort"
+	"strings"
+)
+
+// RuleStats - packets and bytes accepted or dropped by the rules of an acl policy,
+// egress gateway or ingress node
+type RuleStats struct {
+	Table   string `json:"table"`
+	Key     string `json:"key"`
+	AclID   string `json:"acl_id,omitempty"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// ruleCounter - counters of an installed rule
+type ruleCounter struct {
+	packets uint64
+	bytes   uint64
+}
+
+// GetRuleStats - returns the hits of the acl chain rules installed for the server, summed per acl policy
+// for the acl table, per egress gateway and acl policy for the egress table and per node for the ingress table
+func GetRuleStats(server string) []RuleStats {
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	if fwCrtl == nil {
+		return []RuleStats{}
+	}
+	return ruleStats(fwCrtl, server)
+}
+
+func ruleStats(fwCrtl firewallController, server string) []RuleStats {
+	type statsKey struct{ table, key, aclID string }
+	keys := []statsKey{}
+	rules := []ruleInfo{}
+	for _, tableName := range []string{aclTable, egressTable, ingressTable} {
+		for key, rCfg := range fwCrtl.FetchRuleTable(server, tableName) {
+			for id, ruleInfos := range rCfg.rulesMap {
+				k := statsKey{table: tableName, key: key}
+				if tableName == egressTable {
+					egressID, isAcl := strings.CutPrefix(key, "acl#")
+					if !isAcl {
+						// nat rules of the gateway
+						continue
+					}
+					k.key, k.aclID = egressID, id
+				}
+				for _, rule := range ruleInfos {
+					if rule.chain != aclInputRulesChain && rule.chain != aclFwdRulesChain {
+						continue
+					}
+					keys = append(keys, k)
+					rules = append(rules, rule)
+				}
+			}
+		}
+	}
+	summed := make(map[statsKey]*RuleStats)
+	for idx, counter := range fwCrtl.RuleCounters(rules) {
+		k := keys[idx]
+		stats, ok := summed[k]
+		if !ok {
+			stats = &RuleStats{Table: k.table, Key: k.key, AclID: k.aclID}
+			summed[k] = stats
+		}
+		stats.Packets += counter.packets
+		stats.Bytes += counter.bytes
+	}
+	result := make([]RuleStats, 0, len(summed))
+	for _, stats := range summed {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Table != result[j].Table {
+			return result[i].Table < result[j].Table
+		}
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].AclID < result[j].AclID
+	})
+	return result
+}
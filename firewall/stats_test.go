@@ -0,0 +1,61 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleStats(t *testing.T) {
+	m := newTestFirewall(t, models.FIREWALL_NFTABLES)
+	applyFwUpdate(m, testServer, &models.FwUpdate{
+		AclRules: map[string]models.AclRule{
+			"web": {
+				ID:              "web",
+				IPList:          []net.IPNet{cidr(t, "100.64.0.2/32"), cidr(t, "100.64.0.3/32")},
+				AllowedProtocol: models.TCP,
+				AllowedPorts:    []string{"443"},
+			},
+		},
+		IsEgressGw: true,
+		EgressInfo: map[string]models.EgressInfo{
+			"gw1": {
+				EgressID:     "gw1",
+				EgressGwAddr: cidr(t, "100.64.0.1/32"),
+				EgressGWCfg: models.EgressGatewayRequest{
+					RangesWithMetric: []models.EgressRangeMetric{{Network: "10.10.0.0/24"}},
+				},
+				EgressFwRules: map[string]models.AclRule{
+					"db": {
+						ID:     "db",
+						IPList: []net.IPNet{cidr(t, "100.64.0.2/32")},
+						Dst:    []net.IPNet{cidr(t, "10.10.0.5/32")},
+					},
+				},
+			},
+		},
+		IsIngressGw: true,
+		IngressInfo: map[string]models.IngressInfo{
+			"ingress1": {
+				IngressID:     "ingress1",
+				StaticNodeIps: []net.IP{net.ParseIP("100.64.0.50")},
+			},
+		},
+	})
+	for spec, counter := range map[string]ruleCounter{
+		ruleKey("-s", "100.64.0.2/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"): {packets: 2, bytes: 200},
+		ruleKey("-s", "100.64.0.3/32", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"): {packets: 3, bytes: 300},
+		ruleKey("-s", "100.64.0.50", "-j", "DROP"):                                    {packets: 1, bytes: 60},
+	} {
+		m.counters[aclInputRulesChain+" "+spec] = counter
+	}
+	m.counters[aclFwdRulesChain+" "+ruleKey("-s", "100.64.0.2/32", "-d", "10.10.0.5/32", "-j", "ACCEPT")] = ruleCounter{packets: 7, bytes: 700}
+
+	assert.Equal(t, []RuleStats{
+		{Table: aclTable, Key: "web", Packets: 5, Bytes: 500},
+		{Table: egressTable, Key: "gw1", AclID: "db", Packets: 7, Bytes: 700},
+		{Table: ingressTable, Key: "ingress1", Packets: 1, Bytes: 60},
+	}, ruleStats(m, testServer))
+}
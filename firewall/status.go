@@ -0,0 +1,61 @@
+package firewall
+
+import (
+	"sort"
+	"strings"
+)
+
+// RuleSet - rules installed for a single key (acl id, peer, egress id) of a rule table
+type RuleSet struct {
+	Table  string     `json:"table"`
+	Key    string     `json:"key"`
+	IsIpv4 bool       `json:"is_ipv4"`
+	Rules  []RuleSpec `json:"rules"`
+}
+
+// RuleSpec - a single installed rule
+type RuleSpec struct {
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	Rule  string `json:"rule"`
+}
+
+// GetRuleTables - returns the rules the firewall controller believes are installed for the server
+func GetRuleTables(server string) []RuleSet {
+	fwMutex.Lock()
+	defer fwMutex.Unlock()
+	ruleSets := []RuleSet{}
+	if fwCrtl == nil {
+		return ruleSets
+	}
+	for _, tableName := range []string{aclTable, egressTable, ingressTable} {
+		for key, rCfg := range fwCrtl.FetchRuleTable(server, tableName) {
+			ruleSet := RuleSet{
+				Table:  tableName,
+				Key:    key,
+				IsIpv4: rCfg.isIpv4,
+				Rules:  []RuleSpec{},
+			}
+			for _, rules := range rCfg.rulesMap {
+				for _, rule := range rules {
+					ruleSet.Rules = append(ruleSet.Rules, RuleSpec{
+						Table: rule.table,
+						Chain: rule.chain,
+						Rule:  strings.Join(rule.rule, " "),
+					})
+				}
+			}
+			sort.Slice(ruleSet.Rules, func(i, j int) bool {
+				return ruleSet.Rules[i].Rule < ruleSet.Rules[j].Rule
+			})
+			ruleSets = append(ruleSets, ruleSet)
+		}
+	}
+	sort.Slice(ruleSets, func(i, j int) bool {
+		if ruleSets[i].Table != ruleSets[j].Table {
+			return ruleSets[i].Table < ruleSets[j].Table
+		}
+		return ruleSets[i].Key < ruleSets[j].Key
+	})
+	return ruleSets
+}
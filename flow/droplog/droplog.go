@@ -0,0 +1,174 @@
+// Package droplog records the packets dropped by the default target of the netmaker acl chains.
+// The firewall logs them to a netlink log group, the daemon turns them into records written
+// to a local rotating file and, optionally, streamed with the flow logs.
+package droplog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+const (
+	// LogFile - the local drop log, rotated when it grows over maxLogSize
+	LogFile    = "/var/log/netclient-drops.log"
+	maxLogSize = 10 << 20
+	maxBackups = 3
+)
+
+// Peer - an end of a dropped packet, the type and id identify the netmaker peer owning the address
+type Peer struct {
+	IP   string `json:"ip"`
+	Port uint16 `json:"port,omitempty"`
+	Type string `json:"type,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// Record - a packet dropped by an acl chain
+type Record struct {
+	Time     time.Time `json:"time"`
+	Chain    string    `json:"chain"`
+	Proto    string    `json:"proto"`
+	Length   int       `json:"length"`
+	Src      Peer      `json:"src"`
+	Dst      Peer      `json:"dst"`
+	IcmpType *uint8    `json:"icmp_type,omitempty"`
+	IcmpCode *uint8    `json:"icmp_code,omitempty"`
+}
+
+// packet - the headers of a logged packet
+type packet struct {
+	proto    uint8
+	src      netip.Addr
+	dst      netip.Addr
+	srcPort  uint16
+	dstPort  uint16
+	icmp     bool
+	icmpType uint8
+	icmpCode uint8
+	length   int
+}
+
+var errShortPacket = errors.New("packet too short")
+
+// parsePacket - decodes the ip and transport headers of a packet, the payload may be truncated
+// after the transport header
+func parsePacket(b []byte) (packet, error) {
+	var p packet
+	if len(b) < 1 {
+		return p, errShortPacket
+	}
+	var l4 []byte
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return p, errShortPacket
+		}
+		ihl := int(b[0]&0x0f) * 4
+		p.length = int(binary.BigEndian.Uint16(b[2:4]))
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		// only the first fragment carries the transport header
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff == 0 && len(b) > ihl {
+			l4 = b[ihl:]
+		}
+	case 6:
+		if len(b) < 40 {
+			return p, errShortPacket
+		}
+		p.length = int(binary.BigEndian.Uint16(b[4:6])) + 40
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		next, offset := b[6], 40
+	headers:
+		for {
+			switch next {
+			case 0, 43, 60: // hop by hop, routing and destination options
+				if len(b) < offset+2 {
+					return p, errShortPacket
+				}
+				next, offset = b[offset], offset+(int(b[offset+1])+1)*8
+			case 44: // fragment
+				if len(b) < offset+8 {
+					return p, errShortPacket
+				}
+				if binary.BigEndian.Uint16(b[offset+2:offset+4])&0xfff8 != 0 {
+					// only the first fragment carries the transport header
+					p.proto = b[offset]
+					return p, nil
+				}
+				next, offset = b[offset], offset+8
+			default:
+				break headers
+			}
+		}
+		p.proto = next
+		if len(b) > offset {
+			l4 = b[offset:]
+		}
+	default:
+		return p, fmt.Errorf("unknown ip version %d", b[0]>>4)
+	}
+	switch p.proto {
+	case 6, 17, 132: // tcp, udp and sctp
+		if len(l4) >= 4 {
+			p.srcPort = binary.BigEndian.Uint16(l4[0:2])
+			p.dstPort = binary.BigEndian.Uint16(l4[2:4])
+		}
+	case 1, 58: // icmp and icmpv6
+		if len(l4) >= 2 {
+			p.icmp = true
+			p.icmpType, p.icmpCode = l4[0], l4[1]
+		}
+	}
+	return p, nil
+}
+
+// protoName - names the common ip protocols the way acl policies do
+func protoName(proto uint8) string {
+	switch proto {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 58:
+		return "icmpv6"
+	case 132:
+		return "sctp"
+	}
+	return strconv.Itoa(int(proto))
+}
+
+// newRecord - builds the record of a packet dropped by the chain
+func newRecord(chain string, p packet, now time.Time) Record {
+	r := Record{
+		Time:   now,
+		Chain:  chain,
+		Proto:  protoName(p.proto),
+		Length: p.length,
+		Src:    Peer{IP: p.src.String(), Port: p.srcPort},
+		Dst:    Peer{IP: p.dst.String(), Port: p.dstPort},
+	}
+	if p.icmp {
+		r.IcmpType, r.IcmpCode = &p.icmpType, &p.icmpCode
+	}
+	return r
+}
+
+// writeRecord - appends the record to the log as a line of json
+func writeRecord(w io.Writer, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,64 @@
+package droplog
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ipv4Packet(proto byte, l4 []byte) []byte {
+	b := make([]byte, 20, 20+len(l4))
+	b[0] = 0x45
+	b[2], b[3] = 0, byte(20+len(l4))
+	b[9] = proto
+	copy(b[12:16], []byte{100, 64, 0, 2})
+	copy(b[16:20], []byte{100, 64, 0, 1})
+	return append(b, l4...)
+}
+
+func TestParsePacket(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		p, err := parsePacket(ipv4Packet(6, []byte{0xc3, 0x50, 0x00, 0x16, 0, 0, 0, 0}))
+		require.NoError(t, err)
+		assert.Equal(t, netip.MustParseAddr("100.64.0.2"), p.src)
+		assert.Equal(t, netip.MustParseAddr("100.64.0.1"), p.dst)
+		assert.Equal(t, uint16(50000), p.srcPort)
+		assert.Equal(t, uint16(22), p.dstPort)
+		assert.Equal(t, 28, p.length)
+
+		r := newRecord("NETMAKER-ACL-IN", p, time.Now())
+		assert.Equal(t, "tcp", r.Proto)
+		assert.Nil(t, r.IcmpType)
+	})
+	t.Run("icmp", func(t *testing.T) {
+		p, err := parsePacket(ipv4Packet(1, []byte{8, 0, 0, 0}))
+		require.NoError(t, err)
+		r := newRecord("NETMAKER-ACL-IN", p, time.Now())
+		assert.Equal(t, "icmp", r.Proto)
+		require.NotNil(t, r.IcmpType)
+		assert.Equal(t, uint8(8), *r.IcmpType)
+	})
+	t.Run("ipv6 extension header", func(t *testing.T) {
+		b := make([]byte, 40)
+		b[0] = 0x60
+		b[5] = 16
+		b[6] = 0 // hop by hop options
+		copy(b[8:24], netip.MustParseAddr("fd00::2").AsSlice())
+		copy(b[24:40], netip.MustParseAddr("fd00::1").AsSlice())
+		b = append(b, 17, 0, 0, 0, 0, 0, 0, 0) // next header udp
+		b = append(b, 0x13, 0x88, 0x00, 0x35)
+		p, err := parsePacket(b)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(17), p.proto)
+		assert.Equal(t, netip.MustParseAddr("fd00::2"), p.src)
+		assert.Equal(t, uint16(53), p.dstPort)
+		assert.Equal(t, 56, p.length)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		_, err := parsePacket([]byte{0x45, 0})
+		assert.ErrorIs(t, err, errShortPacket)
+	})
+}
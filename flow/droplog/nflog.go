@@ -0,0 +1,13 @@
+//go:build !linux
+
+package droplog
+
+import (
+	"context"
+	"sync"
+)
+
+// Run - the drop log needs the netfilter log of linux
+func Run(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Done()
+}
@@ -0,0 +1,247 @@
+//go:build linux
+
+package droplog
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/firewall"
+	"github.com/gravitl/netclient/flow"
+	"github.com/gravitl/netclient/ncutils"
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/netfilter"
+)
+
+// nfnetlink_log message types, config commands and attributes
+const (
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	nfulnlCfgCmdBind   = 1
+	nfulnlCfgCmdUnbind = 2
+	nfulnlCopyPacket   = 2
+
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+	nfulaCfgCmd  = 1
+	nfulaCfgMode = 2
+
+	// enough of a packet for the ip and transport headers
+	copyRange = 128
+)
+
+// time waited before listening to the drop log group again after a failure, doubled up to the max
+const (
+	retryInterval    = time.Second
+	maxRetryInterval = time.Minute
+)
+
+// Run - logs the packets the firewall sends to the drop log group until the context is done.
+// The group is listened to again when the socket fails
+func Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	logFile, err := ncutils.OpenRotatingFile(LogFile, maxLogSize, maxBackups)
+	if err != nil {
+		slog.Error("failed to open drop log", "file", LogFile, "error", err)
+		return
+	}
+	defer logFile.Close()
+	slog.Info("logging dropped packets", "file", LogFile)
+	retry := retryInterval
+	for {
+		conn, err := listen(firewall.DropLogGroup)
+		if err == nil {
+			var received bool
+			received, err = receive(ctx, conn, logFile)
+			if received {
+				retry = retryInterval
+			}
+		}
+		if ctx.Err() != nil {
+			slog.Info("drop log routine stop")
+			return
+		}
+		slog.Warn("drop log interrupted, listening again", "group", firewall.DropLogGroup, "retry", retry, "error", err)
+		select {
+		case <-ctx.Done():
+			slog.Info("drop log routine stop")
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, maxRetryInterval)
+	}
+}
+
+// receive - logs the packets received on the socket until it fails or the context is done,
+// received reports if any packet came through. The socket is unbound and closed on return
+func receive(ctx context.Context, conn *netfilter.Conn, logFile io.Writer) (received bool, err error) {
+	done := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = configure(conn, firewall.DropLogGroup, netfilter.Attribute{Type: nfulaCfgCmd, Data: []byte{nfulnlCfgCmdUnbind}})
+		conn.Close()
+		close(closed)
+	}()
+	// the group is bound again only once this socket is gone
+	defer func() {
+		close(done)
+		<-closed
+	}()
+	for {
+		msgs, err := conn.Receive()
+		if err != nil {
+			return received, err
+		}
+		for _, msg := range msgs {
+			record, p, ok := decode(msg)
+			if !ok {
+				continue
+			}
+			received = true
+			if err := writeRecord(logFile, record); err != nil {
+				slog.Warn("failed to write drop log", "error", err)
+			}
+			if config.Netclient().DropLogExport {
+				if err := flow.GetManager().Export(flowEvent(record, p)); err != nil {
+					slog.Debug("failed to export dropped packet", "error", err)
+				}
+			}
+		}
+	}
+}
+
+// listen - binds a netfilter socket to the log group, with packets copied up to copyRange
+func listen(group uint16) (*netfilter.Conn, error) {
+	conn, err := netfilter.Dial(nil)
+	if err != nil {
+		return nil, err
+	}
+	// a burst of drops overruns the socket buffer, skip the lost packets instead of failing
+	if err := conn.SetOption(netlink.NoENOBUFS, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, copyRange)
+	mode[4] = nfulnlCopyPacket
+	for _, attr := range []netfilter.Attribute{
+		{Type: nfulaCfgCmd, Data: []byte{nfulnlCfgCmdBind}},
+		{Type: nfulaCfgMode, Data: mode},
+	} {
+		if err := configure(conn, group, attr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func configure(conn *netfilter.Conn, group uint16, attr netfilter.Attribute) error {
+	msg, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysULOG,
+		MessageType: nfulnlMsgConfig,
+		Family:      netfilter.ProtoUnspec,
+		ResourceID:  group,
+		Flags:       netlink.Request | netlink.Acknowledge,
+	}, []netfilter.Attribute{attr})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Query(msg)
+	return err
+}
+
+// decode - builds the record of a logged packet, identifying the peers owning its addresses
+func decode(msg netlink.Message) (Record, packet, bool) {
+	header, attrs, err := netfilter.UnmarshalNetlink(msg)
+	if err != nil || header.SubsystemID != netfilter.NFSubsysULOG || header.MessageType != nfulnlMsgPacket {
+		return Record{}, packet{}, false
+	}
+	var chain string
+	var payload []byte
+	for _, attr := range attrs {
+		switch attr.Type {
+		case nfulaPrefix:
+			chain = string(bytes.TrimRight(attr.Data, "\x00"))
+		case nfulaPayload:
+			payload = attr.Data
+		}
+	}
+	p, err := parsePacket(payload)
+	if err != nil {
+		slog.Debug("failed to parse dropped packet", "error", err)
+		return Record{}, packet{}, false
+	}
+	record := newRecord(chain, p, time.Now())
+	identify(&record.Src, p.src)
+	identify(&record.Dst, p.dst)
+	return record, p, true
+}
+
+func identify(peer *Peer, addr netip.Addr) {
+	participant := flow.GetManager().Participant(addr)
+	peer.Type = participant.Type.String()
+	peer.ID = participant.Id
+}
+
+// flowEvent - a dropped packet as a flow that ended with its first packet
+func flowEvent(r Record, p packet) *pbflow.FlowEvent {
+	event := &pbflow.FlowEvent{
+		Type:        pbflow.EventType_EVENT_DESTROY,
+		FlowId:      "drop-" + uuid.NewString(),
+		NetworkId:   networkOf(p.src, p.dst),
+		HostId:      config.Netclient().ID.String(),
+		Protocol:    uint32(p.proto),
+		SrcPort:     uint32(p.srcPort),
+		DstPort:     uint32(p.dstPort),
+		Direction:   pbflow.Direction_DIR_INGRESS,
+		Src:         participant(r.Src),
+		Dst:         participant(r.Dst),
+		StartTsMs:   r.Time.UnixMilli(),
+		EndTsMs:     r.Time.UnixMilli(),
+		BytesSent:   uint64(p.length),
+		PacketsSent: 1,
+		Version:     r.Time.UnixMilli(),
+	}
+	if p.icmp {
+		event.IcmpType, event.IcmpCode = uint32(p.icmpType), uint32(p.icmpCode)
+	}
+	return event
+}
+
+func participant(peer Peer) *pbflow.FlowParticipant {
+	return &pbflow.FlowParticipant{
+		Ip:   peer.IP,
+		Type: pbflow.ParticipantType(pbflow.ParticipantType_value[peer.Type]),
+		Id:   peer.ID,
+	}
+}
+
+// networkOf - returns the network the addresses of a dropped packet belong to
+func networkOf(addrs ...netip.Addr) string {
+	for _, addr := range addrs {
+		ip := net.IP(addr.AsSlice())
+		for _, node := range config.GetNodes() {
+			if (node.NetworkRange.IP != nil && node.NetworkRange.Contains(ip)) ||
+				(node.NetworkRange6.IP != nil && node.NetworkRange6.Contains(ip)) {
+				return node.Network
+			}
+		}
+	}
+	return ""
+}
@@ -1,7 +1,51 @@
 package exporter
 
-import pbflow "github.com/gravitl/netmaker/grpc/flow"
+import (
+	"net/netip"
+
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+)
 
 type Exporter interface {
 	Export(event *pbflow.FlowEvent) error
 }
+
+// FlowNAT - details of a flow the flow event schema has no fields for: its addresses before and
+// after the host translated them, and whether the host forwarded it as a gateway, to or from which
+// egress range
+type FlowNAT struct {
+	PreNATSrc, PreNATDst   netip.AddrPort
+	PostNATSrc, PostNATDst netip.AddrPort
+	Forwarded              bool
+	EgressRangeID          string
+}
+
+// NATExporter - exporters recording the nat details of the flows along with their events
+type NATExporter interface {
+	ExportNAT(event *pbflow.FlowEvent, nat *FlowNAT) error
+}
+
+// ExportNAT - exports the event with its nat details when the exporter records them, the event alone otherwise
+func ExportNAT(e Exporter, event *pbflow.FlowEvent, nat *FlowNAT) error {
+	if natExporter, ok := e.(NATExporter); ok {
+		return natExporter.ExportNAT(event, nat)
+	}
+	return e.Export(event)
+}
+
+// MultiExporter - exports the events to each of the exporters
+type MultiExporter []Exporter
+
+func (m MultiExporter) Export(event *pbflow.FlowEvent) error {
+	return m.ExportNAT(event, nil)
+}
+
+func (m MultiExporter) ExportNAT(event *pbflow.FlowEvent, nat *FlowNAT) error {
+	var err error
+	for _, e := range m {
+		if exportErr := ExportNAT(e, event, nat); exportErr != nil {
+			err = exportErr
+		}
+	}
+	return err
+}
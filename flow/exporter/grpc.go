@@ -20,6 +20,11 @@ const (
 	DefaultBatchTime    = 30 * time.Second
 	DefaultRetryCount   = 3
 	DefaultRetryBackoff = 300 * time.Millisecond
+
+	// maxPendingBatches - events kept in memory while a flush is sending, in batches
+	maxPendingBatches = 10
+	// spoolReplayBatches - spooled batches replayed per flush
+	spoolReplayBatches = 20
 )
 
 type Options struct {
@@ -28,6 +33,9 @@ type Options struct {
 	batchTime    time.Duration
 	retryCount   int
 	retryBackoff time.Duration
+	spoolDir     string
+	spoolMaxSize int64
+	spoolMaxAge  time.Duration
 }
 
 func WithTLS(cfg *tls.Config) func(*Options) {
@@ -46,6 +54,16 @@ func WithRetryPolicy(count int, backoff time.Duration) func(*Options) {
 	}
 }
 
+// WithSpool - spools the batches the server does not take to dir instead of dropping them,
+// replaying them in order once the server is reachable
+func WithSpool(dir string, maxSize int64, maxAge time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.spoolDir = dir
+		o.spoolMaxSize = maxSize
+		o.spoolMaxAge = maxAge
+	}
+}
+
 type FlowGrpcClient struct {
 	serverAddr string
 	opts       Options
@@ -53,11 +71,16 @@ type FlowGrpcClient struct {
 	conn   *grpc.ClientConn
 	stream pbflow.FlowService_StreamFlowsClient
 
-	mu     sync.Mutex
-	events []*pbflow.FlowEvent
+	mu      sync.Mutex
+	events  []*pbflow.FlowEvent
+	dropped int
+
+	// batches are sent one at a time and in order by the batch loop
+	spool *Spool
 
-	stopCh chan struct{}
-	wg     sync.WaitGroup
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
 }
 
 func NewFlowGrpcClient(serverURL string, optFns ...func(*Options)) *FlowGrpcClient {
@@ -75,13 +98,26 @@ func NewFlowGrpcClient(serverURL string, optFns ...func(*Options)) *FlowGrpcClie
 	return &FlowGrpcClient{
 		serverAddr: serverURL,
 		opts:       opts,
+		flushCh:    make(chan struct{}, 1),
 		stopCh:     make(chan struct{}),
 	}
 }
 
 func (c *FlowGrpcClient) Start() error {
+	if c.opts.spoolDir != "" {
+		spool, err := OpenSpool(c.opts.spoolDir, c.opts.spoolMaxSize, c.opts.spoolMaxAge)
+		if err != nil {
+			return fmt.Errorf("open spool: %w", err)
+		}
+		c.spool = spool
+	}
+
 	if err := c.connect(); err != nil {
-		return err
+		if c.spool == nil {
+			return err
+		}
+		// the batches are spooled until the server is reachable
+		fmt.Println("[flow] server unreachable, spooling flows:", err)
 	}
 
 	c.wg.Add(1)
@@ -94,6 +130,9 @@ func (c *FlowGrpcClient) Stop() error {
 	close(c.stopCh)
 	c.wg.Wait()
 
+	if c.spool != nil {
+		_ = c.spool.Close()
+	}
 	if c.conn != nil {
 		return c.conn.Close()
 	}
@@ -104,9 +143,17 @@ func (c *FlowGrpcClient) Export(event *pbflow.FlowEvent) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if len(c.events) >= c.opts.batchSize*maxPendingBatches {
+		// the batch loop is stuck sending, drop instead of growing without bound
+		c.dropped++
+		return nil
+	}
 	c.events = append(c.events, event)
 	if len(c.events) >= c.opts.batchSize {
-		go c.flush()
+		select {
+		case c.flushCh <- struct{}{}:
+		default:
+		}
 	}
 	return nil
 }
@@ -121,6 +168,8 @@ func (c *FlowGrpcClient) batchLoop() {
 		select {
 		case <-ticker.C:
 			c.flush()
+		case <-c.flushCh:
+			c.flush()
 		case <-c.stopCh:
 			c.flush()
 			return
@@ -130,14 +179,25 @@ func (c *FlowGrpcClient) batchLoop() {
 
 func (c *FlowGrpcClient) flush() {
 	c.mu.Lock()
-	if len(c.events) == 0 {
+	if len(c.events) == 0 && c.spool == nil {
 		c.mu.Unlock()
 		return
 	}
 	evs := c.events
 	c.events = nil
+	dropped := c.dropped
+	c.dropped = 0
 	c.mu.Unlock()
 
+	if dropped > 0 {
+		fmt.Printf("[flow] dropped %d events while sending\n", dropped)
+	}
+
+	if c.spool != nil {
+		c.flushSpooled(evs)
+		return
+	}
+
 	env := &pbflow.FlowEnvelope{
 		Events: evs,
 	}
@@ -148,6 +208,31 @@ func (c *FlowGrpcClient) flush() {
 	}
 }
 
+// FlowGrpcClient.flushSpooled - sends the batch after the spooled ones, spooling it when the server
+// does not take it, then replays a bounded number of the spooled batches
+func (c *FlowGrpcClient) flushSpooled(evs []*pbflow.FlowEvent) {
+	if len(evs) > 0 {
+		env := &pbflow.FlowEnvelope{
+			Events: evs,
+		}
+		if c.spool.Empty() {
+			err := c.sendWithRetries(env)
+			if err == nil {
+				return
+			}
+			fmt.Println("[flow] spooling batch:", err)
+		}
+		// batches are queued behind the spooled ones to keep their order
+		if err := c.spool.Append(env); err != nil {
+			fmt.Println("[flow] permanently dropped batch:", err)
+		}
+	}
+
+	if err := c.spool.Replay(c.sendOnce, spoolReplayBatches); err != nil {
+		fmt.Println("[flow] spool replay paused:", err)
+	}
+}
+
 func (c *FlowGrpcClient) sendWithRetries(env *pbflow.FlowEnvelope) error {
 	var err error
 
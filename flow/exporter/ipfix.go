@@ -0,0 +1,569 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+)
+
+// protocols of the flow record exporter
+const (
+	ProtocolIPFIX     = "ipfix"    // RFC 7011
+	ProtocolNetFlowV9 = "netflow9" // RFC 3954
+)
+
+const (
+	ipfixVersion     = 10
+	netflowV9Version = 9
+
+	// DefaultTemplateRefresh - templates are resent periodically for collectors started later on
+	DefaultTemplateRefresh = time.Minute
+	// maxPacketSize - records are split over packets fitting the path mtu
+	maxPacketSize = 1400
+
+	templateIDv4 = 256
+	templateIDv6 = 257
+
+	// variableLength - length of the variable length fields in an IPFIX template
+	variableLength = 0xffff
+)
+
+// information elements of the flow records, the NetFlow v9 field types share the numbers
+const (
+	ieOctetDeltaCount        = 1
+	iePacketDeltaCount       = 2
+	ieProtocolIdentifier     = 4
+	ieSourceTransportPort    = 7
+	ieSourceIPv4Address      = 8
+	ieDestinationTransport   = 11
+	ieDestinationIPv4Address = 12
+	ieLastSwitched           = 21
+	ieFirstSwitched          = 22
+	ieSourceIPv6Address      = 27
+	ieDestinationIPv6Address = 28
+	ieICMPTypeCodeIPv4       = 32
+	ieFlowDirection          = 61
+	ieICMPTypeCodeIPv6       = 139
+	ieFlowStartMilliseconds  = 152
+	ieFlowEndMilliseconds    = 153
+	iePostNATSourceIPv4      = 225
+	iePostNATDestinationIPv4 = 226
+	iePostNAPTSourcePort     = 227
+	iePostNAPTDestination    = 228
+	ieVRFName                = 236
+	iePostNATSourceIPv6      = 281
+	iePostNATDestinationIPv6 = 282
+
+	// enterprise specific elements of the peer identities
+	ieSourceParticipantType      = 1
+	ieDestinationParticipantType = 2
+	ieSourceParticipantID        = 3
+	ieDestinationParticipantID   = 4
+	// enterprise specific elements of the flows forwarded by a gateway
+	ieGatewayForwarded = 5
+	ieEgressRangeID    = 6
+)
+
+// flowRecord - one direction of a flow
+type flowRecord struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+	// the addresses after the host translated them, the same as the above without nat
+	postSrc, postDst         netip.Addr
+	postSrcPort, postDstPort uint16
+	proto                    uint8
+	direction                uint8
+	icmpTypeCode             uint16
+	bytes, packets           uint64
+	start, end               time.Time
+	network                  string
+	srcType, dstType         uint8
+	srcID, dstID             string
+	forwarded                bool
+	egressRangeID            string
+}
+
+type templateField struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+// IPFIXExporter - exports the flows as IPFIX or NetFlow v9 records to a collector over udp.
+// Each ended flow is exported as a record per direction, started flows are not exported
+type IPFIXExporter struct {
+	collector  string
+	protocol   string
+	enterprise uint32
+	opts       Options
+
+	conn          net.Conn
+	start         time.Time
+	sequence      uint32
+	templatesSent time.Time
+
+	mu      sync.Mutex
+	records []flowRecord
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewIPFIXExporter - exporter of the flows to the collector address, in the IPFIX or NetFlow v9
+// protocol. The peer identities are exported as IPFIX enterprise specific elements when an
+// enterprise number is given
+func NewIPFIXExporter(collector, protocol string, enterprise uint32, optFns ...func(*Options)) *IPFIXExporter {
+	opts := Options{
+		batchSize: DefaultBatchSize,
+		batchTime: DefaultBatchTime,
+	}
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if protocol != ProtocolNetFlowV9 {
+		protocol = ProtocolIPFIX
+	}
+	return &IPFIXExporter{
+		collector:  collector,
+		protocol:   protocol,
+		enterprise: enterprise,
+		opts:       opts,
+		flushCh:    make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+func (e *IPFIXExporter) Start() error {
+	conn, err := net.Dial("udp", e.collector)
+	if err != nil {
+		return fmt.Errorf("dial collector: %w", err)
+	}
+	e.conn = conn
+	e.start = time.Now()
+
+	e.wg.Add(1)
+	go e.batchLoop()
+
+	return nil
+}
+
+func (e *IPFIXExporter) Stop() error {
+	close(e.stopCh)
+	e.wg.Wait()
+
+	if e.conn != nil {
+		return e.conn.Close()
+	}
+	return nil
+}
+
+func (e *IPFIXExporter) Export(event *pbflow.FlowEvent) error {
+	return e.ExportNAT(event, nil)
+}
+
+// IPFIXExporter.ExportNAT - exports the ended flow with the addresses the host translated it to as
+// the post nat elements, and its gateway forwarding as enterprise specific elements
+func (e *IPFIXExporter) ExportNAT(event *pbflow.FlowEvent, nat *FlowNAT) error {
+	if event.Type != pbflow.EventType_EVENT_DESTROY {
+		return nil
+	}
+	records := flowRecords(event, nat)
+	if len(records) == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.records = append(e.records, records...)
+	if len(e.records) >= e.opts.batchSize {
+		// the batch loop is the only sender, so the packets go out in sequence order
+		// and none is sent after the connection is closed
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (e *IPFIXExporter) batchLoop() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.opts.batchTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.flushCh:
+			e.flush()
+		case <-e.stopCh:
+			e.flush()
+			return
+		}
+	}
+}
+
+func (e *IPFIXExporter) flush() {
+	e.mu.Lock()
+	records := e.records
+	e.records = nil
+	packets := e.encode(records, time.Now())
+	e.mu.Unlock()
+
+	for _, packet := range packets {
+		if _, err := e.conn.Write(packet); err != nil {
+			fmt.Println("[flow] failed to send flow records to collector:", err)
+			return
+		}
+	}
+}
+
+// flowRecords - the records of both directions of an ended flow. The records carry the addresses
+// before nat, the reported ends of the event are used without nat details
+func flowRecords(event *pbflow.FlowEvent, nat *FlowNAT) []flowRecord {
+	src, err := netip.ParseAddr(event.GetSrc().GetIp())
+	if err != nil {
+		return nil
+	}
+	dst, err := netip.ParseAddr(event.GetDst().GetIp())
+	if err != nil || src.Unmap().Is4() != dst.Unmap().Is4() {
+		return nil
+	}
+	var direction uint8 // ingress
+	if event.Direction == pbflow.Direction_DIR_EGRESS {
+		direction = 1
+	}
+	forward := flowRecord{
+		src:          src.Unmap(),
+		dst:          dst.Unmap(),
+		srcPort:      uint16(event.SrcPort),
+		dstPort:      uint16(event.DstPort),
+		proto:        uint8(event.Protocol),
+		direction:    direction,
+		icmpTypeCode: uint16(event.IcmpType)<<8 | uint16(event.IcmpCode),
+		bytes:        event.BytesSent,
+		packets:      event.PacketsSent,
+		start:        time.UnixMilli(event.StartTsMs),
+		end:          time.UnixMilli(event.EndTsMs),
+		network:      event.NetworkId,
+		srcType:      uint8(event.GetSrc().GetType()),
+		dstType:      uint8(event.GetDst().GetType()),
+		srcID:        event.GetSrc().GetId(),
+		dstID:        event.GetDst().GetId(),
+	}
+	forward.postSrc, forward.postDst = forward.src, forward.dst
+	forward.postSrcPort, forward.postDstPort = forward.srcPort, forward.dstPort
+	if nat != nil && sameFamily(forward.src.Is4(), nat.PreNATSrc, nat.PreNATDst, nat.PostNATSrc, nat.PostNATDst) {
+		forward.src, forward.dst = nat.PreNATSrc.Addr().Unmap(), nat.PreNATDst.Addr().Unmap()
+		forward.srcPort, forward.dstPort = nat.PreNATSrc.Port(), nat.PreNATDst.Port()
+		forward.postSrc, forward.postDst = nat.PostNATSrc.Addr().Unmap(), nat.PostNATDst.Addr().Unmap()
+		forward.postSrcPort, forward.postDstPort = nat.PostNATSrc.Port(), nat.PostNATDst.Port()
+	}
+	if nat != nil {
+		forward.forwarded = nat.Forwarded
+		forward.egressRangeID = nat.EgressRangeID
+	}
+	records := []flowRecord{forward}
+	if event.PacketsRecv > 0 {
+		reverse := forward
+		// replies arrive to the translated addresses and are translated back
+		reverse.src, reverse.dst = forward.postDst, forward.postSrc
+		reverse.srcPort, reverse.dstPort = forward.postDstPort, forward.postSrcPort
+		reverse.postSrc, reverse.postDst = forward.dst, forward.src
+		reverse.postSrcPort, reverse.postDstPort = forward.dstPort, forward.srcPort
+		reverse.srcType, reverse.dstType = forward.dstType, forward.srcType
+		reverse.srcID, reverse.dstID = forward.dstID, forward.srcID
+		reverse.direction = 1 - forward.direction
+		reverse.icmpTypeCode = 0
+		reverse.bytes, reverse.packets = event.BytesRecv, event.PacketsRecv
+		records = append(records, reverse)
+	}
+	return records
+}
+
+// sameFamily - whether the addresses are all valid and of the family, the records have no room for
+// addresses translated across families
+func sameFamily(v4 bool, addrs ...netip.AddrPort) bool {
+	for _, addr := range addrs {
+		if !addr.Addr().IsValid() || addr.Addr().Unmap().Is4() != v4 {
+			return false
+		}
+	}
+	return true
+}
+
+// IPFIXExporter.template - fields of the records of an address family
+func (e *IPFIXExporter) template(v4 bool) []templateField {
+	fields := []templateField{}
+	if v4 {
+		fields = append(fields, templateField{id: ieSourceIPv4Address, length: 4}, templateField{id: ieDestinationIPv4Address, length: 4})
+	} else {
+		fields = append(fields, templateField{id: ieSourceIPv6Address, length: 16}, templateField{id: ieDestinationIPv6Address, length: 16})
+	}
+	fields = append(fields,
+		templateField{id: ieSourceTransportPort, length: 2},
+		templateField{id: ieDestinationTransport, length: 2},
+		templateField{id: ieProtocolIdentifier, length: 1},
+		templateField{id: ieFlowDirection, length: 1},
+	)
+	if v4 || e.protocol == ProtocolNetFlowV9 {
+		fields = append(fields, templateField{id: ieICMPTypeCodeIPv4, length: 2})
+	} else {
+		fields = append(fields, templateField{id: ieICMPTypeCodeIPv6, length: 2})
+	}
+	fields = append(fields,
+		templateField{id: ieOctetDeltaCount, length: 8},
+		templateField{id: iePacketDeltaCount, length: 8},
+	)
+	if e.protocol == ProtocolNetFlowV9 {
+		// NetFlow v9 has neither absolute timestamps nor variable length fields
+		fields = append(fields, templateField{id: ieFirstSwitched, length: 4}, templateField{id: ieLastSwitched, length: 4})
+		return appendPostNATFields(fields, v4)
+	}
+	fields = append(fields,
+		templateField{id: ieFlowStartMilliseconds, length: 8},
+		templateField{id: ieFlowEndMilliseconds, length: 8},
+		templateField{id: ieVRFName, length: variableLength},
+	)
+	fields = appendPostNATFields(fields, v4)
+	if e.enterprise != 0 {
+		fields = append(fields,
+			templateField{id: ieSourceParticipantType, length: 1, enterprise: true},
+			templateField{id: ieDestinationParticipantType, length: 1, enterprise: true},
+			templateField{id: ieSourceParticipantID, length: variableLength, enterprise: true},
+			templateField{id: ieDestinationParticipantID, length: variableLength, enterprise: true},
+			templateField{id: ieGatewayForwarded, length: 1, enterprise: true},
+			templateField{id: ieEgressRangeID, length: variableLength, enterprise: true},
+		)
+	}
+	return fields
+}
+
+// appendPostNATFields - appends the fields of the addresses after nat
+func appendPostNATFields(fields []templateField, v4 bool) []templateField {
+	if v4 {
+		fields = append(fields, templateField{id: iePostNATSourceIPv4, length: 4}, templateField{id: iePostNATDestinationIPv4, length: 4})
+	} else {
+		fields = append(fields, templateField{id: iePostNATSourceIPv6, length: 16}, templateField{id: iePostNATDestinationIPv6, length: 16})
+	}
+	return append(fields,
+		templateField{id: iePostNAPTSourcePort, length: 2},
+		templateField{id: iePostNAPTDestination, length: 2},
+	)
+}
+
+// IPFIXExporter.encodeTemplates - template set of the records of both address families
+func (e *IPFIXExporter) encodeTemplates() []byte {
+	setID := uint16(2)
+	if e.protocol == ProtocolNetFlowV9 {
+		setID = 0
+	}
+	set := binary.BigEndian.AppendUint16(nil, setID)
+	set = append(set, 0, 0) // length
+	for _, t := range []struct {
+		id uint16
+		v4 bool
+	}{{templateIDv4, true}, {templateIDv6, false}} {
+		fields := e.template(t.v4)
+		set = binary.BigEndian.AppendUint16(set, t.id)
+		set = binary.BigEndian.AppendUint16(set, uint16(len(fields)))
+		for _, f := range fields {
+			if f.enterprise {
+				set = binary.BigEndian.AppendUint16(set, f.id|0x8000)
+				set = binary.BigEndian.AppendUint16(set, f.length)
+				set = binary.BigEndian.AppendUint32(set, e.enterprise)
+				continue
+			}
+			set = binary.BigEndian.AppendUint16(set, f.id)
+			set = binary.BigEndian.AppendUint16(set, f.length)
+		}
+	}
+	return e.finishSet(set)
+}
+
+// IPFIXExporter.encodeRecord - appends the record to a data set
+func (e *IPFIXExporter) encodeRecord(b []byte, r flowRecord) []byte {
+	for _, f := range e.template(r.src.Is4()) {
+		switch {
+		case f.enterprise && f.id == ieSourceParticipantType:
+			b = append(b, r.srcType)
+		case f.enterprise && f.id == ieDestinationParticipantType:
+			b = append(b, r.dstType)
+		case f.enterprise && f.id == ieSourceParticipantID:
+			b = appendVariable(b, r.srcID)
+		case f.enterprise && f.id == ieDestinationParticipantID:
+			b = appendVariable(b, r.dstID)
+		case f.enterprise && f.id == ieGatewayForwarded:
+			var forwarded uint8
+			if r.forwarded {
+				forwarded = 1
+			}
+			b = append(b, forwarded)
+		case f.enterprise && f.id == ieEgressRangeID:
+			b = appendVariable(b, r.egressRangeID)
+		case f.id == ieSourceIPv4Address || f.id == ieSourceIPv6Address:
+			b = append(b, r.src.AsSlice()...)
+		case f.id == ieDestinationIPv4Address || f.id == ieDestinationIPv6Address:
+			b = append(b, r.dst.AsSlice()...)
+		case f.id == iePostNATSourceIPv4 || f.id == iePostNATSourceIPv6:
+			b = append(b, r.postSrc.AsSlice()...)
+		case f.id == iePostNATDestinationIPv4 || f.id == iePostNATDestinationIPv6:
+			b = append(b, r.postDst.AsSlice()...)
+		case f.id == iePostNAPTSourcePort:
+			b = binary.BigEndian.AppendUint16(b, r.postSrcPort)
+		case f.id == iePostNAPTDestination:
+			b = binary.BigEndian.AppendUint16(b, r.postDstPort)
+		case f.id == ieSourceTransportPort:
+			b = binary.BigEndian.AppendUint16(b, r.srcPort)
+		case f.id == ieDestinationTransport:
+			b = binary.BigEndian.AppendUint16(b, r.dstPort)
+		case f.id == ieProtocolIdentifier:
+			b = append(b, r.proto)
+		case f.id == ieFlowDirection:
+			b = append(b, r.direction)
+		case f.id == ieICMPTypeCodeIPv4 || f.id == ieICMPTypeCodeIPv6:
+			b = binary.BigEndian.AppendUint16(b, r.icmpTypeCode)
+		case f.id == ieOctetDeltaCount:
+			b = binary.BigEndian.AppendUint64(b, r.bytes)
+		case f.id == iePacketDeltaCount:
+			b = binary.BigEndian.AppendUint64(b, r.packets)
+		case f.id == ieFirstSwitched:
+			b = binary.BigEndian.AppendUint32(b, e.uptime(r.start))
+		case f.id == ieLastSwitched:
+			b = binary.BigEndian.AppendUint32(b, e.uptime(r.end))
+		case f.id == ieFlowStartMilliseconds:
+			b = binary.BigEndian.AppendUint64(b, uint64(r.start.UnixMilli()))
+		case f.id == ieFlowEndMilliseconds:
+			b = binary.BigEndian.AppendUint64(b, uint64(r.end.UnixMilli()))
+		case f.id == ieVRFName:
+			b = appendVariable(b, r.network)
+		}
+	}
+	return b
+}
+
+// appendVariable - appends a variable length string field
+func appendVariable(b []byte, s string) []byte {
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	if len(s) < 255 {
+		b = append(b, uint8(len(s)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	}
+	return append(b, s...)
+}
+
+// IPFIXExporter.uptime - milliseconds from the start of the exporter to t, the NetFlow v9 time base
+func (e *IPFIXExporter) uptime(t time.Time) uint32 {
+	if t.Before(e.start) {
+		return 0
+	}
+	return uint32(t.Sub(e.start).Milliseconds())
+}
+
+// IPFIXExporter.finishSet - sets the length of a set, NetFlow v9 flowsets are padded to 4 bytes
+func (e *IPFIXExporter) finishSet(set []byte) []byte {
+	if e.protocol == ProtocolNetFlowV9 {
+		for len(set)%4 != 0 {
+			set = append(set, 0)
+		}
+	}
+	binary.BigEndian.PutUint16(set[2:4], uint16(len(set)))
+	return set
+}
+
+// IPFIXExporter.encode - packets of the records, led by the templates when they are due. Callers hold mu
+func (e *IPFIXExporter) encode(records []flowRecord, now time.Time) [][]byte {
+	packets := [][]byte{}
+	var templates []byte
+	if now.Sub(e.templatesSent) >= DefaultTemplateRefresh {
+		templates = e.encodeTemplates()
+		e.templatesSent = now
+	}
+	for len(records) > 0 || templates != nil {
+		sets := [][]byte{}
+		size := 20
+		count := 0 // records of the packet, counted by the NetFlow v9 header
+		if templates != nil {
+			sets = append(sets, templates)
+			size += len(templates)
+			count += 2
+			templates = nil
+		}
+		var set []byte
+		var setID uint16
+		data := 0
+		for len(records) > 0 {
+			r := records[0]
+			id := uint16(templateIDv6)
+			if r.src.Is4() {
+				id = templateIDv4
+			}
+			record := e.encodeRecord(nil, r)
+			if set != nil && id != setID {
+				sets = append(sets, e.finishSet(set))
+				set = nil
+			}
+			if size+len(set)+len(record)+4+3 > maxPacketSize && (set != nil || len(sets) > 0) {
+				break
+			}
+			if set == nil {
+				set = binary.BigEndian.AppendUint16(nil, id)
+				set = append(set, 0, 0)
+				setID = id
+				size += 4
+			}
+			set = append(set, record...)
+			size += len(record)
+			count++
+			data++
+			records = records[1:]
+		}
+		if set != nil {
+			sets = append(sets, e.finishSet(set))
+		}
+		packets = append(packets, e.header(sets, count, data, now))
+	}
+	return packets
+}
+
+// IPFIXExporter.header - prepends the message header to the sets of a packet
+func (e *IPFIXExporter) header(sets [][]byte, count, data int, now time.Time) []byte {
+	var packet []byte
+	if e.protocol == ProtocolNetFlowV9 {
+		e.sequence++
+		packet = binary.BigEndian.AppendUint16(packet, netflowV9Version)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(count))
+		packet = binary.BigEndian.AppendUint32(packet, e.uptime(now))
+		packet = binary.BigEndian.AppendUint32(packet, uint32(now.Unix()))
+		packet = binary.BigEndian.AppendUint32(packet, e.sequence)
+		packet = binary.BigEndian.AppendUint32(packet, 0) // source id
+	} else {
+		packet = binary.BigEndian.AppendUint16(packet, ipfixVersion)
+		packet = append(packet, 0, 0) // length
+		packet = binary.BigEndian.AppendUint32(packet, uint32(now.Unix()))
+		// the sequence counts the data records sent before the message
+		packet = binary.BigEndian.AppendUint32(packet, e.sequence)
+		packet = binary.BigEndian.AppendUint32(packet, 0) // observation domain
+		e.sequence += uint32(data)
+	}
+	for _, set := range sets {
+		packet = append(packet, set...)
+	}
+	if e.protocol != ProtocolNetFlowV9 {
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	}
+	return packet
+}
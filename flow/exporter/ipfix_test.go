@@ -0,0 +1,200 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func flowEvent() *pbflow.FlowEvent {
+	return &pbflow.FlowEvent{
+		Type:        pbflow.EventType_EVENT_DESTROY,
+		FlowId:      "flow",
+		NetworkId:   "netmaker",
+		Protocol:    6,
+		SrcPort:     40000,
+		DstPort:     443,
+		Direction:   pbflow.Direction_DIR_EGRESS,
+		Src:         &pbflow.FlowParticipant{Ip: "100.64.0.1", Type: pbflow.ParticipantType_PARTICIPANT_NODE, Id: "node-1"},
+		Dst:         &pbflow.FlowParticipant{Ip: "100.64.0.2", Type: pbflow.ParticipantType_PARTICIPANT_USER, Id: "user-1"},
+		StartTsMs:   1000,
+		EndTsMs:     2000,
+		BytesSent:   100,
+		BytesRecv:   200,
+		PacketsSent: 1,
+		PacketsRecv: 2,
+	}
+}
+
+func TestFlowRecords(t *testing.T) {
+	records := flowRecords(flowEvent(), nil)
+	require.Len(t, records, 2)
+	assert.Equal(t, "100.64.0.1", records[0].src.String())
+	assert.Equal(t, uint64(100), records[0].bytes)
+	assert.Equal(t, uint8(1), records[0].direction)
+	assert.Equal(t, "100.64.0.2", records[1].src.String())
+	assert.Equal(t, uint16(443), records[1].srcPort)
+	assert.Equal(t, uint64(200), records[1].bytes)
+	assert.Equal(t, uint8(0), records[1].direction)
+	assert.Equal(t, "user-1", records[1].srcID)
+
+	event := flowEvent()
+	event.PacketsRecv = 0
+	assert.Len(t, flowRecords(event, nil), 1)
+	event.Dst.Ip = "fd00::2"
+	assert.Empty(t, flowRecords(event, nil))
+}
+
+func TestFlowRecordsNAT(t *testing.T) {
+	// a port of the gateway forwarded to a host of its egress range
+	event := flowEvent()
+	event.Dst.Ip = "10.0.0.7"
+	event.DstPort = 8080
+	nat := &FlowNAT{
+		PreNATSrc:     netip.MustParseAddrPort("100.64.0.1:40000"),
+		PreNATDst:     netip.MustParseAddrPort("100.64.0.9:80"),
+		PostNATSrc:    netip.MustParseAddrPort("10.0.0.1:40000"),
+		PostNATDst:    netip.MustParseAddrPort("10.0.0.7:8080"),
+		Forwarded:     true,
+		EgressRangeID: "egress-1",
+	}
+	records := flowRecords(event, nat)
+	require.Len(t, records, 2)
+	assert.Equal(t, "100.64.0.9", records[0].dst.String())
+	assert.Equal(t, uint16(80), records[0].dstPort)
+	assert.Equal(t, "10.0.0.1", records[0].postSrc.String())
+	assert.Equal(t, "10.0.0.7", records[0].postDst.String())
+	assert.Equal(t, uint16(8080), records[0].postDstPort)
+	assert.True(t, records[0].forwarded)
+	assert.Equal(t, "egress-1", records[0].egressRangeID)
+	// the replies are translated back to the original tuple
+	assert.Equal(t, "10.0.0.7", records[1].src.String())
+	assert.Equal(t, "10.0.0.1", records[1].dst.String())
+	assert.Equal(t, "100.64.0.9", records[1].postSrc.String())
+	assert.Equal(t, uint16(80), records[1].postSrcPort)
+	assert.Equal(t, "100.64.0.1", records[1].postDst.String())
+	assert.Equal(t, "egress-1", records[1].egressRangeID)
+
+	packets := NewIPFIXExporter("", ProtocolIPFIX, 32473).encode(records, time.Now())
+	require.Len(t, packets, 1)
+	assert.Contains(t, string(packets[0]), "egress-1")
+
+	// addresses translated across families are left out
+	nat.PostNATDst = netip.MustParseAddrPort("[64:ff9b::a00:7]:8080")
+	records = flowRecords(event, nat)
+	assert.Equal(t, "10.0.0.7", records[0].dst.String())
+	assert.Equal(t, records[0].dst, records[0].postDst)
+	assert.True(t, records[0].forwarded)
+}
+
+func TestEncodeIPFIX(t *testing.T) {
+	e := NewIPFIXExporter("", ProtocolIPFIX, 32473)
+	now := time.Now()
+	packets := e.encode(flowRecords(flowEvent(), nil), now)
+	require.Len(t, packets, 1)
+	p := packets[0]
+	assert.Equal(t, uint16(ipfixVersion), binary.BigEndian.Uint16(p[0:2]))
+	assert.Equal(t, len(p), int(binary.BigEndian.Uint16(p[2:4])))
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(p[8:12]))
+
+	// template set, then the data set of the ipv4 records
+	templates := p[16:]
+	assert.Equal(t, uint16(2), binary.BigEndian.Uint16(templates[0:2]))
+	data := templates[binary.BigEndian.Uint16(templates[2:4]):]
+	assert.Equal(t, uint16(templateIDv4), binary.BigEndian.Uint16(data[0:2]))
+	assert.Equal(t, len(data), int(binary.BigEndian.Uint16(data[2:4])))
+	assert.Equal(t, net.IPv4(100, 64, 0, 1).To4(), net.IP(data[4:8]))
+	assert.Equal(t, net.IPv4(100, 64, 0, 2).To4(), net.IP(data[8:12]))
+	assert.Equal(t, uint16(40000), binary.BigEndian.Uint16(data[12:14]))
+
+	// templates are not resent before the refresh, the sequence counts the data records
+	packets = e.encode(flowRecords(flowEvent(), nil), now.Add(time.Second))
+	require.Len(t, packets, 1)
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(packets[0][8:12]))
+	assert.Equal(t, uint16(templateIDv4), binary.BigEndian.Uint16(packets[0][16:18]))
+	assert.Empty(t, e.encode(nil, now.Add(time.Second)))
+}
+
+func TestEncodeNetFlowV9(t *testing.T) {
+	e := NewIPFIXExporter("", ProtocolNetFlowV9, 0)
+	e.start = time.UnixMilli(500)
+	packets := e.encode(flowRecords(flowEvent(), nil), time.UnixMilli(3000))
+	require.Len(t, packets, 1)
+	p := packets[0]
+	assert.Equal(t, uint16(netflowV9Version), binary.BigEndian.Uint16(p[0:2]))
+	// two templates and two data records
+	assert.Equal(t, uint16(4), binary.BigEndian.Uint16(p[2:4]))
+	assert.Equal(t, uint32(2500), binary.BigEndian.Uint32(p[4:8]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(p[12:16]))
+
+	templates := p[20:]
+	assert.Equal(t, uint16(0), binary.BigEndian.Uint16(templates[0:2]))
+	length := binary.BigEndian.Uint16(templates[2:4])
+	assert.Zero(t, length%4)
+	data := templates[length:]
+	assert.Equal(t, uint16(templateIDv4), binary.BigEndian.Uint16(data[0:2]))
+	assert.Zero(t, binary.BigEndian.Uint16(data[2:4])%4)
+	// first and last switched close the record
+	record := data[4:]
+	assert.Equal(t, uint32(500), binary.BigEndian.Uint32(record[32:36]))
+	assert.Equal(t, uint32(1500), binary.BigEndian.Uint32(record[36:40]))
+}
+
+func TestEncodeSplitsPackets(t *testing.T) {
+	e := NewIPFIXExporter("", ProtocolIPFIX, 32473)
+	records := []flowRecord{}
+	for i := 0; i < 100; i++ {
+		records = append(records, flowRecords(flowEvent(), nil)...)
+	}
+	packets := e.encode(records, time.Now())
+	require.Greater(t, len(packets), 1)
+	for _, p := range packets {
+		assert.LessOrEqual(t, len(p), maxPacketSize)
+	}
+	assert.Equal(t, uint32(len(records)), e.sequence)
+}
+
+func TestExportFlushesFullBatches(t *testing.T) {
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer collector.Close()
+
+	e := NewIPFIXExporter(collector.LocalAddr().String(), ProtocolIPFIX, 32473, WithBatchSize(2), WithBatchTime(time.Hour))
+	require.NoError(t, e.Start())
+	for i := 0; i < 5; i++ {
+		require.NoError(t, e.Export(flowEvent()))
+	}
+	require.NoError(t, e.Stop())
+
+	// every record is sent once, in sequence order, before the connection is closed
+	buf := make([]byte, maxPacketSize)
+	var sequence uint32
+	for sequence < 10 {
+		require.NoError(t, collector.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := collector.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, sequence, binary.BigEndian.Uint32(buf[8:12]))
+		sets := buf[16:n]
+		if binary.BigEndian.Uint16(sets[0:2]) == 2 {
+			sets = sets[binary.BigEndian.Uint16(sets[2:4]):]
+		}
+		// each event is two records of the same length
+		sequence += 2 * uint32(binary.BigEndian.Uint16(sets[2:4])-4) / uint32(ipfixEventLength(t))
+	}
+	assert.Equal(t, uint32(10), e.sequence)
+}
+
+// ipfixEventLength - length of the data records of an event
+func ipfixEventLength(t *testing.T) int {
+	packets := NewIPFIXExporter("", ProtocolIPFIX, 32473).encode(flowRecords(flowEvent(), nil), time.Now())
+	require.Len(t, packets, 1)
+	sets := packets[0][16:]
+	data := sets[binary.BigEndian.Uint16(sets[2:4]):]
+	return int(binary.BigEndian.Uint16(data[2:4])) - 4
+}
@@ -0,0 +1,304 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	spoolSegmentExt = ".seg"
+	spoolCursorFile = "cursor"
+	// spoolSegments - the spool is split into about this many segments, so the size cap drops a
+	// fraction of it at a time
+	spoolSegments       = 8
+	maxSpoolSegmentSize = 4 << 20
+	// spoolHeaderSize - records are prefixed with their length and crc32
+	spoolHeaderSize = 8
+)
+
+type spoolSegment struct {
+	seq     uint64
+	size    int64
+	modTime time.Time
+}
+
+// Spool - write-ahead log of the flow batches the server did not take. Batches are appended to
+// segment files named by sequence and replayed oldest first, the replay position is kept in a
+// cursor file so a restarted daemon resumes where it stopped
+type Spool struct {
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	mu        sync.Mutex
+	segments  []spoolSegment
+	file      *os.File // last segment, open for appending
+	offset    int64    // replay position in the first segment
+	replaying bool
+}
+
+// OpenSpool - opens the spool in dir, capped to maxSize bytes and, when set, to segments younger than maxAge
+func OpenSpool(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		segmentSize: min(max(maxSize/spoolSegments, 1), maxSpoolSegmentSize),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{seq: seq, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+
+	// segments before the cursor were replayed before the daemon stopped
+	if seq, offset, err := s.readCursor(); err == nil {
+		for len(s.segments) > 0 && s.segments[0].seq < seq {
+			_ = os.Remove(s.segmentPath(s.segments[0].seq))
+			s.segments = s.segments[1:]
+		}
+		if len(s.segments) > 0 && s.segments[0].seq == seq {
+			s.offset = offset
+		}
+	}
+	s.enforceCaps()
+	return s, nil
+}
+
+// Spool.Empty - whether all the spooled batches were replayed
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) == 0 || (len(s.segments) == 1 && s.offset >= s.segments[0].size)
+}
+
+// Spool.Append - spools a batch after the batches spooled before
+func (s *Spool) Append(env *pbflow.FlowEnvelope) error {
+	data, err := proto.Marshal(env)
+	if err != nil {
+		return err
+	}
+	record := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(data))
+	record = append(record, data...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil || s.segments[len(s.segments)-1].size >= s.segmentSize {
+		if err := s.nextSegment(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.file.Write(record); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	last := &s.segments[len(s.segments)-1]
+	last.size += int64(len(record))
+	last.modTime = time.Now()
+	s.enforceCaps()
+	return nil
+}
+
+// Spool.Replay - sends the spooled batches in order, stopping at the first batch send fails on
+// or after limit batches when limit is set, so a flush does bounded work.
+// Callers must not replay concurrently
+func (s *Spool) Replay(send func(*pbflow.FlowEnvelope) error, limit int) error {
+	sent := 0
+	for limit <= 0 || sent < limit {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		segment, offset := s.segments[0], s.offset
+		if len(s.segments) == 1 && s.file != nil {
+			// the segment being replayed is not appended to anymore
+			_ = s.file.Close()
+			s.file = nil
+		}
+		s.replaying = true
+		s.mu.Unlock()
+
+		n, done, err := s.replaySegment(segment, offset, send, limit-sent)
+		sent += n
+
+		s.mu.Lock()
+		s.replaying = false
+		if err != nil || !done {
+			s.mu.Unlock()
+			return err
+		}
+		_ = os.Remove(s.segmentPath(segment.seq))
+		_ = os.Remove(filepath.Join(s.dir, spoolCursorFile))
+		s.segments = s.segments[1:]
+		s.offset = 0
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Spool.Close - closes the segment open for appending, the spooled batches are kept for the next open
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Spool.replaySegment - sends the batches of the segment from offset, at most limit of them when
+// limit is set. Returns the number of batches sent and whether the end of the segment was reached
+func (s *Spool) replaySegment(segment spoolSegment, offset int64, send func(*pbflow.FlowEnvelope) error, limit int) (int, bool, error) {
+	file, err := os.Open(s.segmentPath(segment.seq))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	reader := bufio.NewReader(file)
+	header := make([]byte, spoolHeaderSize)
+	sent := 0
+	for {
+		if limit > 0 && sent >= limit {
+			return sent, false, nil
+		}
+		if _, err := io.ReadFull(reader, header); err != nil {
+			// the end of the segment, or a record torn by a crash
+			return sent, true, nil
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return sent, true, nil
+		}
+		offset += spoolHeaderSize + int64(len(data))
+		env := &pbflow.FlowEnvelope{}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) || proto.Unmarshal(data, env) != nil {
+			fmt.Println("[flow] skipped corrupt spooled batch in segment", segment.seq)
+			continue
+		}
+		if err := send(env); err != nil {
+			return sent, false, err
+		}
+		sent++
+		s.mu.Lock()
+		// the segment may have been dropped by the caps meanwhile
+		if len(s.segments) > 0 && s.segments[0].seq == segment.seq {
+			s.offset = offset
+			_ = s.writeCursor(segment.seq, offset)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Spool.nextSegment - starts a new segment for appending. Callers hold mu
+func (s *Spool) nextSegment() error {
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+	seq := uint64(1)
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	file, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.segments = append(s.segments, spoolSegment{seq: seq, modTime: time.Now()})
+	return nil
+}
+
+// Spool.enforceCaps - drops the oldest segments over the size cap or the max age, the segment
+// appended to and the one being replayed are kept. Callers hold mu
+func (s *Spool) enforceCaps() {
+	var size int64
+	for _, segment := range s.segments {
+		size += segment.size
+	}
+	first := 0
+	if s.replaying {
+		first = 1
+	}
+	for len(s.segments) > first+1 {
+		segment := s.segments[first]
+		if size <= s.maxSize && (s.maxAge == 0 || time.Since(segment.modTime) <= s.maxAge) {
+			break
+		}
+		fmt.Printf("[flow] spool over its caps, dropped segment %d of %d bytes\n", segment.seq, segment.size)
+		_ = os.Remove(s.segmentPath(segment.seq))
+		size -= segment.size
+		s.segments = append(s.segments[:first], s.segments[first+1:]...)
+		if first == 0 {
+			s.offset = 0
+			_ = os.Remove(filepath.Join(s.dir, spoolCursorFile))
+		}
+	}
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSegmentExt))
+}
+
+func (s *Spool) readCursor() (uint64, int64, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		return 0, 0, err
+	}
+	return seq, offset, nil
+}
+
+func (s *Spool) writeCursor(seq uint64, offset int64) error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	if err := os.WriteFile(path+".tmp", []byte(fmt.Sprintf("%d %d", seq, offset)), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batch(id string) *pbflow.FlowEnvelope {
+	return &pbflow.FlowEnvelope{Events: []*pbflow.FlowEvent{{FlowId: id}}}
+}
+
+func replayed(t *testing.T, s *Spool, fail int) ([]string, error) {
+	t.Helper()
+	ids := []string{}
+	err := s.Replay(func(env *pbflow.FlowEnvelope) error {
+		if fail >= 0 && len(ids) == fail {
+			return errors.New("unreachable")
+		}
+		ids = append(ids, env.Events[0].FlowId)
+		return nil
+	}, 0)
+	return ids, err
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 0)
+	require.NoError(t, err)
+	assert.True(t, s.Empty())
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, s.Append(batch(id)))
+	}
+	assert.False(t, s.Empty())
+
+	ids, err := replayed(t, s, 1)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"1"}, ids)
+
+	// a restarted daemon resumes after the replayed batches
+	require.NoError(t, s.Close())
+	s, err = OpenSpool(dir, 1<<20, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Append(batch("4")))
+	ids, err = replayed(t, s, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "3", "4"}, ids)
+	assert.True(t, s.Empty())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpoolCaps(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 64, 0)
+	require.NoError(t, err)
+	for _, id := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"} {
+		require.NoError(t, s.Append(batch(id)))
+	}
+	ids, err := replayed(t, s, -1)
+	assert.NoError(t, err)
+	// the oldest batches were dropped, the newest are replayed in order
+	require.NotEmpty(t, ids)
+	assert.NotEqual(t, "1", ids[0])
+	assert.Equal(t, "9", ids[len(ids)-1])
+	for i := 1; i < len(ids); i++ {
+		assert.Less(t, ids[i-1], ids[i])
+	}
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Append(batch("1")))
+	require.NoError(t, s.Close())
+
+	// a crash in the middle of an append leaves a partial record
+	file, err := os.OpenFile(filepath.Join(dir, "00000000000000000001"+spoolSegmentExt), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	s, err = OpenSpool(dir, 1<<20, 0)
+	require.NoError(t, err)
+	ids, err := replayed(t, s, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, ids)
+}
+
+func TestSpoolReplayLimit(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 1024, 0)
+	require.NoError(t, err)
+	want := []string{}
+	for i := 0; i < 25; i++ {
+		id := fmt.Sprintf("%02d", i)
+		want = append(want, id)
+		require.NoError(t, s.Append(batch(id)))
+	}
+	require.Greater(t, len(s.segments), 1)
+
+	// each replay sends at most the limit, resuming where the previous one stopped
+	ids := []string{}
+	for replays := 0; !s.Empty(); replays++ {
+		require.Less(t, replays, 7)
+		sent := 0
+		err := s.Replay(func(env *pbflow.FlowEnvelope) error {
+			sent++
+			ids = append(ids, env.Events[0].FlowId)
+			return nil
+		}, 4)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, sent, 4)
+	}
+	assert.Equal(t, want, ids)
+}
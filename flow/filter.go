@@ -0,0 +1,264 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gravitl/netclient/config"
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+)
+
+// actions of the flow filter rules
+const (
+	FLOW_FILTER_INCLUDE = "include"
+	FLOW_FILTER_EXCLUDE = "exclude"
+)
+
+// sampleScale - resolution of the sample rates
+const sampleScale = 10000
+
+var (
+	protocolNumbers = map[string]uint32{
+		"icmp":   1,
+		"tcp":    6,
+		"udp":    17,
+		"icmpv6": 58,
+	}
+	participantTypes = map[string]pbflow.ParticipantType{
+		"node":         pbflow.ParticipantType_PARTICIPANT_NODE,
+		"user":         pbflow.ParticipantType_PARTICIPANT_USER,
+		"extclient":    pbflow.ParticipantType_PARTICIPANT_EXTCLIENT,
+		"egress_route": pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE,
+		"external":     pbflow.ParticipantType_PARTICIPANT_EXTERNAL,
+	}
+	directions = map[string]pbflow.Direction{
+		"ingress": pbflow.Direction_DIR_INGRESS,
+		"egress":  pbflow.Direction_DIR_EGRESS,
+	}
+)
+
+type portRange struct {
+	from, to uint32
+}
+
+type filterRule struct {
+	exclude          bool
+	protocols        []uint32
+	ports            []portRange
+	prefixes         []netip.Prefix
+	participantTypes []pbflow.ParticipantType
+	networks         []string
+	direction        pbflow.Direction
+	sample           uint32 // flows kept per sampleScale
+}
+
+// Filter - rules deciding the flows exported, the first matching rule applies and unmatched flows are exported
+type Filter struct {
+	rules []filterRule
+}
+
+// DefaultFlowFilters - exclusions applied after the configured rules, the dns, icmp and metrics flows
+func DefaultFlowFilters(metricsPort int) []config.FlowFilterRule {
+	rules := []config.FlowFilterRule{
+		{Action: FLOW_FILTER_EXCLUDE, Protocols: []string{"udp"}, Ports: []string{"53"}},
+		{Action: FLOW_FILTER_EXCLUDE, Protocols: []string{"icmp", "icmpv6"}},
+	}
+	if metricsPort > 0 {
+		rules = append(rules, config.FlowFilterRule{
+			Action:    FLOW_FILTER_EXCLUDE,
+			Protocols: []string{"tcp"},
+			Ports:     []string{strconv.Itoa(metricsPort)},
+		})
+	}
+	return rules
+}
+
+// CompileFilter - compiles the rules in order, invalid rules are skipped and reported in the error
+func CompileFilter(rules []config.FlowFilterRule) (*Filter, error) {
+	filter := &Filter{}
+	var errs []error
+	for i, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("flow filter rule %d: %w", i, err))
+			continue
+		}
+		filter.rules = append(filter.rules, compiled)
+	}
+	return filter, errors.Join(errs...)
+}
+
+func compileRule(rule config.FlowFilterRule) (filterRule, error) {
+	compiled := filterRule{networks: rule.Networks, sample: sampleScale}
+	switch strings.ToLower(rule.Action) {
+	case FLOW_FILTER_INCLUDE:
+	case FLOW_FILTER_EXCLUDE:
+		compiled.exclude = true
+	default:
+		return compiled, fmt.Errorf("invalid action %q", rule.Action)
+	}
+	for _, protocol := range rule.Protocols {
+		number, ok := protocolNumbers[strings.ToLower(protocol)]
+		if !ok {
+			n, err := strconv.ParseUint(protocol, 10, 8)
+			if err != nil {
+				return compiled, fmt.Errorf("invalid protocol %q", protocol)
+			}
+			number = uint32(n)
+		}
+		compiled.protocols = append(compiled.protocols, number)
+	}
+	for _, port := range rule.Ports {
+		from, to, isRange := strings.Cut(port, "-")
+		if !isRange {
+			to = from
+		}
+		start, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return compiled, fmt.Errorf("invalid port %q", port)
+		}
+		end, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil || end < start {
+			return compiled, fmt.Errorf("invalid port range %q", port)
+		}
+		compiled.ports = append(compiled.ports, portRange{from: uint32(start), to: uint32(end)})
+	}
+	for _, cidr := range rule.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return compiled, fmt.Errorf("invalid cidr %q", cidr)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		compiled.prefixes = append(compiled.prefixes, prefix.Masked())
+	}
+	for _, name := range rule.ParticipantTypes {
+		participantType, ok := participantTypes[strings.ToLower(name)]
+		if !ok {
+			return compiled, fmt.Errorf("invalid participant type %q", name)
+		}
+		compiled.participantTypes = append(compiled.participantTypes, participantType)
+	}
+	if rule.Direction != "" {
+		direction, ok := directions[strings.ToLower(rule.Direction)]
+		if !ok {
+			return compiled, fmt.Errorf("invalid direction %q", rule.Direction)
+		}
+		compiled.direction = direction
+	}
+	if rule.SampleRate < 0 || rule.SampleRate > 1 {
+		return compiled, fmt.Errorf("invalid sample rate %v", rule.SampleRate)
+	}
+	if rule.SampleRate > 0 {
+		compiled.sample = uint32(rule.SampleRate * sampleScale)
+	}
+	return compiled, nil
+}
+
+// Filter.Drops - whether the event is left out of the exported flows
+func (f *Filter) Drops(event *pbflow.FlowEvent) bool {
+	for _, rule := range f.rules {
+		if !rule.matches(event) {
+			continue
+		}
+		if rule.exclude {
+			return true
+		}
+		return !sampled(event.FlowId, rule.sample)
+	}
+	return false
+}
+
+// Filter.DropsTuple - whether the flow is left out on its tuple alone, before its event is enriched.
+// Flows reaching a rule that also matches on the participants, the network or the direction are left to Drops
+func (f *Filter) DropsTuple(flowID string, protocol uint32, src, dst netip.AddrPort) bool {
+	for _, rule := range f.rules {
+		if !rule.matchesTuple(protocol, uint32(src.Port()), uint32(dst.Port()), src.Addr(), dst.Addr()) {
+			continue
+		}
+		if rule.matchesEnriched() {
+			return false
+		}
+		if rule.exclude {
+			return true
+		}
+		return !sampled(flowID, rule.sample)
+	}
+	return false
+}
+
+func (r *filterRule) matches(event *pbflow.FlowEvent) bool {
+	src, _ := netip.ParseAddr(event.GetSrc().GetIp())
+	dst, _ := netip.ParseAddr(event.GetDst().GetIp())
+	if !r.matchesTuple(event.Protocol, event.SrcPort, event.DstPort, src, dst) {
+		return false
+	}
+	if len(r.participantTypes) > 0 &&
+		!slices.Contains(r.participantTypes, event.GetSrc().GetType()) && !slices.Contains(r.participantTypes, event.GetDst().GetType()) {
+		return false
+	}
+	if len(r.networks) > 0 && !slices.Contains(r.networks, event.NetworkId) {
+		return false
+	}
+	if r.direction != pbflow.Direction_DIR_UNSPECIFIED && r.direction != event.Direction {
+		return false
+	}
+	return true
+}
+
+// filterRule.matchesTuple - matches the protocol, the ports and the addresses of the flow
+func (r *filterRule) matchesTuple(protocol, srcPort, dstPort uint32, src, dst netip.Addr) bool {
+	if len(r.protocols) > 0 && !slices.Contains(r.protocols, protocol) {
+		return false
+	}
+	if len(r.ports) > 0 && !r.matchesPort(srcPort) && !r.matchesPort(dstPort) {
+		return false
+	}
+	if len(r.prefixes) > 0 && !r.matchesAddr(src) && !r.matchesAddr(dst) {
+		return false
+	}
+	return true
+}
+
+// filterRule.matchesEnriched - whether the rule matches on the parts of the event known after enrichment
+func (r *filterRule) matchesEnriched() bool {
+	return len(r.participantTypes) > 0 || len(r.networks) > 0 || r.direction != pbflow.Direction_DIR_UNSPECIFIED
+}
+
+func (r *filterRule) matchesPort(port uint32) bool {
+	for _, ports := range r.ports {
+		if port >= ports.from && port <= ports.to {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *filterRule) matchesAddr(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampled - whether the flow is in the sample, decided on the flow id so all the events of a flow agree
+func sampled(flowID string, sample uint32) bool {
+	if sample >= sampleScale {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flowID))
+	return h.Sum32()%sampleScale < sample
+}
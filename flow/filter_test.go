@@ -0,0 +1,107 @@
+package flow
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/gravitl/netclient/config"
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func filterEvent(protocol, dstPort uint32, dst string) *pbflow.FlowEvent {
+	return &pbflow.FlowEvent{
+		FlowId:    "flow",
+		NetworkId: "netmaker",
+		Protocol:  protocol,
+		SrcPort:   40000,
+		DstPort:   dstPort,
+		Direction: pbflow.Direction_DIR_EGRESS,
+		Src:       &pbflow.FlowParticipant{Ip: "100.64.0.1", Type: pbflow.ParticipantType_PARTICIPANT_NODE},
+		Dst:       &pbflow.FlowParticipant{Ip: dst, Type: pbflow.ParticipantType_PARTICIPANT_EXTERNAL},
+	}
+}
+
+func TestDefaultFilter(t *testing.T) {
+	filter, err := CompileFilter(DefaultFlowFilters(8889))
+	require.NoError(t, err)
+	assert.True(t, filter.Drops(filterEvent(17, 53, "1.1.1.1")))
+	assert.True(t, filter.Drops(filterEvent(1, 0, "1.1.1.1")))
+	assert.True(t, filter.Drops(filterEvent(6, 8889, "100.64.0.2")))
+	assert.False(t, filter.Drops(filterEvent(6, 443, "1.1.1.1")))
+	assert.False(t, filter.Drops(filterEvent(6, 53, "1.1.1.1")))
+}
+
+func TestFilterRules(t *testing.T) {
+	rules := []config.FlowFilterRule{
+		// icmp is captured for troubleshooting ahead of the default exclusion
+		{Action: "include", Protocols: []string{"icmp"}, Networks: []string{"netmaker"}},
+		// backups are noisy
+		{Action: "exclude", Protocols: []string{"tcp"}, Ports: []string{"9000-9100"}, CIDRs: []string{"10.0.0.0/8"}},
+		{Action: "exclude", ParticipantTypes: []string{"user"}, Direction: "ingress"},
+	}
+	filter, err := CompileFilter(append(rules, DefaultFlowFilters(0)...))
+	require.NoError(t, err)
+	assert.False(t, filter.Drops(filterEvent(1, 0, "1.1.1.1")))
+	assert.True(t, filter.Drops(filterEvent(58, 0, "fd00::1")))
+	assert.True(t, filter.Drops(filterEvent(6, 9050, "10.1.2.3")))
+	assert.False(t, filter.Drops(filterEvent(6, 9050, "192.168.1.1")))
+	assert.False(t, filter.Drops(filterEvent(6, 9200, "10.1.2.3")))
+
+	event := filterEvent(6, 443, "100.64.0.2")
+	event.Dst.Type = pbflow.ParticipantType_PARTICIPANT_USER
+	assert.False(t, filter.Drops(event))
+	event.Direction = pbflow.Direction_DIR_INGRESS
+	assert.True(t, filter.Drops(event))
+}
+
+func TestFilterDropsTuple(t *testing.T) {
+	rules := []config.FlowFilterRule{
+		{Action: "exclude", Protocols: []string{"tcp"}, Ports: []string{"9000-9100"}, CIDRs: []string{"10.0.0.0/8"}},
+		// decided once the participants are known
+		{Action: "include", ParticipantTypes: []string{"user"}},
+	}
+	filter, err := CompileFilter(append(rules, DefaultFlowFilters(0)...))
+	require.NoError(t, err)
+	src := netip.MustParseAddrPort("100.64.0.1:40000")
+	assert.True(t, filter.DropsTuple("flow", 6, src, netip.MustParseAddrPort("10.1.2.3:9050")))
+	assert.False(t, filter.DropsTuple("flow", 6, src, netip.MustParseAddrPort("192.168.1.1:9050")))
+	// the user rule comes first, a dns flow of a user is kept
+	assert.False(t, filter.DropsTuple("flow", 17, src, netip.MustParseAddrPort("1.1.1.1:53")))
+	assert.True(t, filter.Drops(filterEvent(17, 53, "1.1.1.1")))
+	assert.False(t, filter.DropsTuple("flow", 6, src, netip.MustParseAddrPort("1.1.1.1:443")))
+}
+
+func TestFilterSampling(t *testing.T) {
+	filter, err := CompileFilter([]config.FlowFilterRule{{Action: "include", Protocols: []string{"udp"}, SampleRate: 0.25}})
+	require.NoError(t, err)
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		event := filterEvent(17, 443, "1.1.1.1")
+		event.FlowId = fmt.Sprintf("flow-%d", i)
+		if !filter.Drops(event) {
+			kept++
+		}
+		// all the events of a flow are kept or dropped alike
+		assert.Equal(t, filter.Drops(event), filter.Drops(event))
+	}
+	assert.InDelta(t, 250, kept, 75)
+	assert.False(t, filter.Drops(filterEvent(6, 443, "1.1.1.1")))
+}
+
+func TestCompileFilterInvalid(t *testing.T) {
+	filter, err := CompileFilter([]config.FlowFilterRule{
+		{Action: "drop"},
+		{Action: "exclude", Protocols: []string{"sctp"}},
+		{Action: "exclude", Ports: []string{"100-10"}},
+		{Action: "exclude", CIDRs: []string{"10.0.0.0/33"}},
+		{Action: "include", SampleRate: 2},
+		{Action: "exclude", Protocols: []string{"132"}},
+	})
+	assert.Error(t, err)
+	// the valid rules are kept
+	assert.True(t, filter.Drops(filterEvent(132, 0, "1.1.1.1")))
+	assert.False(t, filter.Drops(filterEvent(6, 50, "1.1.1.1")))
+}
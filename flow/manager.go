@@ -16,10 +16,12 @@ func GetManager() *NoopManager {
 	return manager
 }
 
-func (m *NoopManager) Start(_ map[string]models.PeerIdentity) error {
+func (m *NoopManager) Start(_ map[string]models.PeerIdentity, _ bool) error {
 	return nil
 }
 
 func (m *NoopManager) Stop() error {
 	return nil
 }
+
+func (m *NoopManager) SetParticipantIdentifiers(_ map[string]models.PeerIdentity) {}
@@ -5,8 +5,9 @@ package flow
 import (
 	"crypto/tls"
 	"log/slog"
-	"net"
 	"net/netip"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,14 +16,21 @@ import (
 	"github.com/gravitl/netclient/flow/tracker"
 	pbflow "github.com/gravitl/netmaker/grpc/flow"
 	"github.com/gravitl/netmaker/models"
-	ct "github.com/ti-mo/conntrack"
 )
 
 const RefreshDuration = 10 * time.Minute
 
+// SpoolDir - directory of the flows spooled while the server is unreachable, kept per server
+func SpoolDir(server string) string {
+	return filepath.Join(config.GetNetclientPath(), "flow-spool", server)
+}
+
 type Manager struct {
 	participantIdentifiers map[string]models.PeerIdentity
 	flowClient             *exporter.FlowGrpcClient
+	collectorExporter      *exporter.IPFIXExporter
+	collector              string
+	filter                 *Filter
 	flowTracker            *tracker.FlowTracker
 	startOnce              sync.Once
 	mu                     sync.RWMutex
@@ -38,26 +46,67 @@ func GetManager() *Manager {
 	return manager
 }
 
-func (m *Manager) Start(participantIdentifiers map[string]models.PeerIdentity) error {
+// Manager.Start - starts tracking the flows, streaming them to the server when the flow logs are enabled
+// and exporting them to the configured collector. The manager is restarted when either changes
+func (m *Manager) Start(participantIdentifiers map[string]models.PeerIdentity, flowLogs bool) error {
+	m.UpdateFilter()
+
 	m.mu.Lock()
 	m.participantIdentifiers = participantIdentifiers
+	restart := m.flowTracker != nil &&
+		((m.flowClient != nil) != flowLogs || m.collector != config.Netclient().FlowExportCollector)
 	m.mu.Unlock()
+	if restart {
+		if err := m.Stop(); err != nil {
+			return err
+		}
+	}
 
 	var err error
 	m.startOnce.Do(func() {
 		slog.Info("[flow] starting flow manager")
 
-		flowClient := exporter.NewFlowGrpcClient(
-			config.GetServer(config.CurrServer).GRPC,
-			exporter.WithTLS(&tls.Config{}),
-		)
+		var exporters exporter.MultiExporter
+		if flowLogs {
+			opts := []func(*exporter.Options){exporter.WithTLS(&tls.Config{})}
+			if size := config.Netclient().FlowSpoolSize; size > 0 {
+				opts = append(opts, exporter.WithSpool(
+					SpoolDir(config.CurrServer),
+					int64(size)<<20,
+					time.Duration(config.Netclient().FlowSpoolMaxAge)*time.Hour,
+				))
+			}
+			flowClient := exporter.NewFlowGrpcClient(config.GetServer(config.CurrServer).GRPC, opts...)
 
-		err = flowClient.Start()
-		if err != nil {
-			return
+			err = flowClient.Start()
+			if err != nil {
+				return
+			}
+
+			m.mu.Lock()
+			m.flowClient = flowClient
+			m.mu.Unlock()
+			exporters = append(exporters, flowClient)
 		}
 
-		m.flowClient = flowClient
+		if collector := config.Netclient().FlowExportCollector; collector != "" {
+			collectorExporter := exporter.NewIPFIXExporter(
+				collector,
+				config.Netclient().FlowExportProtocol,
+				config.Netclient().FlowExportEnterprise,
+			)
+
+			err = collectorExporter.Start()
+			if err != nil {
+				return
+			}
+
+			m.mu.Lock()
+			m.collectorExporter = collectorExporter
+			m.collector = collector
+			m.mu.Unlock()
+			exporters = append(exporters, collectorExporter)
+		}
 
 		var flowTracker *tracker.FlowTracker
 		flowTracker, err = tracker.New(
@@ -70,73 +119,11 @@ func (m *Manager) Start(participantIdentifiers map[string]models.PeerIdentity) e
 					}
 				}
 			},
-			func(flow *ct.Flow) bool {
-				// filter out dns packet events.
-				if flow.TupleOrig.Proto.Protocol == 17 &&
-					(flow.TupleOrig.Proto.SourcePort == 53 ||
-						flow.TupleOrig.Proto.DestinationPort == 53) {
-					return true
-				}
-
-				// filter out icmp packet events.
-				if flow.TupleOrig.Proto.Protocol == 1 || flow.TupleOrig.Proto.Protocol == 58 {
-					return true
-				}
-
-				// filter out metrics events.
-				if flow.TupleOrig.Proto.Protocol == 6 &&
-					(flow.TupleOrig.Proto.SourcePort == uint16(config.GetServer(config.CurrServer).MetricsPort) ||
-						flow.TupleOrig.Proto.DestinationPort == uint16(config.GetServer(config.CurrServer).MetricsPort)) {
-					return true
-				}
-
-				return false
-			},
-			func(addr netip.Addr) *pbflow.FlowParticipant {
-				ip := addr.String()
-				ipCidr := netip.PrefixFrom(addr, addr.BitLen()).String()
-
-				m.mu.RLock()
-				identity, found := m.participantIdentifiers[ipCidr]
-				if !found {
-					for addr := range m.participantIdentifiers {
-						_, cidr, err := net.ParseCIDR(addr)
-						if err != nil {
-							continue
-						}
-						if cidr.Contains(net.ParseIP(ip)) {
-							identity, found = m.participantIdentifiers[addr]
-							break
-						}
-					}
-				}
-				m.mu.RUnlock()
-				if !found {
-					return &pbflow.FlowParticipant{
-						Ip:   ip,
-						Type: pbflow.ParticipantType_PARTICIPANT_EXTERNAL,
-					}
-				}
-
-				participantType := pbflow.ParticipantType_PARTICIPANT_UNSPECIFIED
-				switch identity.Type {
-				case models.PeerType_Node:
-					participantType = pbflow.ParticipantType_PARTICIPANT_NODE
-				case models.PeerType_User:
-					participantType = pbflow.ParticipantType_PARTICIPANT_USER
-				case models.PeerType_WireGuard:
-					participantType = pbflow.ParticipantType_PARTICIPANT_EXTCLIENT
-				case models.PeerType_EgressRoute:
-					participantType = pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE
-				}
-
-				return &pbflow.FlowParticipant{
-					Ip:   ip,
-					Type: participantType,
-					Id:   identity.ID,
-				}
-			},
-			flowClient,
+			m.DropsTuple,
+			m.Drops,
+			m.Participant,
+			exporters,
+			interimInterval(),
 		)
 		if err != nil {
 			return
@@ -151,21 +138,153 @@ func (m *Manager) Start(participantIdentifiers map[string]models.PeerIdentity) e
 	})
 	if err != nil {
 		slog.Debug("[flow] error starting flow manager: " + err.Error())
+		// stop what was started and reset start once, so the next call starts over
+		if stopErr := m.Stop(); stopErr != nil {
+			slog.Debug("[flow] error cleaning up flow manager: " + stopErr.Error())
+		}
 	}
 
 	return err
 }
 
+// interimInterval - interval of the interim updates of the active flows, 0 when disabled
+func interimInterval() time.Duration {
+	interval := config.Netclient().FlowInterimInterval
+	switch {
+	case interval < 0:
+		return 0
+	case interval == 0:
+		return tracker.DefaultInterimInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// Manager.UpdateFilter - compiles the configured flow filter rules ahead of the default exclusions,
+// invalid rules are left out
+func (m *Manager) UpdateFilter() {
+	var metricsPort int
+	if server := config.GetServer(config.CurrServer); server != nil {
+		metricsPort = server.MetricsPort
+	}
+	rules := append(slices.Clone(config.Netclient().FlowFilters), DefaultFlowFilters(metricsPort)...)
+	filter, err := CompileFilter(rules)
+	if err != nil {
+		slog.Warn("[flow] invalid flow filter rules", "error", err)
+	}
+
+	m.mu.Lock()
+	m.filter = filter
+	m.mu.Unlock()
+}
+
+// Manager.Drops - whether the flow filter leaves the event out of the exported flows
+func (m *Manager) Drops(event *pbflow.FlowEvent) bool {
+	m.mu.RLock()
+	filter := m.filter
+	m.mu.RUnlock()
+	return filter != nil && filter.Drops(event)
+}
+
+// Manager.DropsTuple - whether the flow filter leaves the flow out on its tuple, before its event is enriched
+func (m *Manager) DropsTuple(flowID string, protocol uint32, src, dst netip.AddrPort) bool {
+	m.mu.RLock()
+	filter := m.filter
+	m.mu.RUnlock()
+	return filter != nil && filter.DropsTuple(flowID, protocol, src, dst)
+}
+
+// Manager.SetParticipantIdentifiers - updates the identities of the peer addresses without starting the flow logs
+func (m *Manager) SetParticipantIdentifiers(participantIdentifiers map[string]models.PeerIdentity) {
+	m.mu.Lock()
+	m.participantIdentifiers = participantIdentifiers
+	m.mu.Unlock()
+}
+
+// Manager.Participant - returns the identity of the peer owning the address, addresses outside
+// of the netmaker networks are external participants
+func (m *Manager) Participant(addr netip.Addr) *pbflow.FlowParticipant {
+	ip := addr.String()
+	ipCidr := netip.PrefixFrom(addr, addr.BitLen()).String()
+
+	m.mu.RLock()
+	identity, found := m.participantIdentifiers[ipCidr]
+	if !found {
+		// the most specific range wins, so egress routes behind a default route are told apart
+		bits := -1
+		for cidr, rangeIdentity := range m.participantIdentifiers {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil || prefix.Bits() <= bits || !prefix.Contains(addr.Unmap()) {
+				continue
+			}
+			identity, found, bits = rangeIdentity, true, prefix.Bits()
+		}
+	}
+	m.mu.RUnlock()
+	if !found {
+		return &pbflow.FlowParticipant{
+			Ip:   ip,
+			Type: pbflow.ParticipantType_PARTICIPANT_EXTERNAL,
+		}
+	}
+
+	participantType := pbflow.ParticipantType_PARTICIPANT_UNSPECIFIED
+	switch identity.Type {
+	case models.PeerType_Node:
+		participantType = pbflow.ParticipantType_PARTICIPANT_NODE
+	case models.PeerType_User:
+		participantType = pbflow.ParticipantType_PARTICIPANT_USER
+	case models.PeerType_WireGuard:
+		participantType = pbflow.ParticipantType_PARTICIPANT_EXTCLIENT
+	case models.PeerType_EgressRoute:
+		participantType = pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE
+	}
+
+	return &pbflow.FlowParticipant{
+		Ip:   ip,
+		Type: participantType,
+		Id:   identity.ID,
+	}
+}
+
+// Manager.Export - streams an event along with the flow logs, events are dropped while the flow logs are off
+func (m *Manager) Export(event *pbflow.FlowEvent) error {
+	m.mu.RLock()
+	flowClient := m.flowClient
+	m.mu.RUnlock()
+	if flowClient == nil {
+		return nil
+	}
+	return flowClient.Export(event)
+}
+
 func (m *Manager) Stop() error {
 	slog.Debug("[flow] stopping flow manager")
 
-	if m.flowClient != nil {
-		err := m.flowClient.Stop()
+	m.mu.RLock()
+	flowClient, collectorExporter := m.flowClient, m.collectorExporter
+	m.mu.RUnlock()
+
+	if flowClient != nil {
+		err := flowClient.Stop()
 		if err != nil {
 			slog.Debug("[flow] error stopping flow manager: " + err.Error())
 			return err
 		}
+		m.mu.Lock()
 		m.flowClient = nil
+		m.mu.Unlock()
+	}
+
+	if collectorExporter != nil {
+		err := collectorExporter.Stop()
+		if err != nil {
+			slog.Debug("[flow] error stopping flow manager: " + err.Error())
+			return err
+		}
+		m.mu.Lock()
+		m.collectorExporter = nil
+		m.collector = ""
+		m.mu.Unlock()
 	}
 
 	if m.flowTracker != nil {
@@ -23,11 +23,20 @@ import (
 
 const (
 	ReadBufferSize = 32 * 1024 * 1024
+	// DefaultInterimInterval - interval of the interim events of the active flows
+	DefaultInterimInterval = time.Minute
+	// localAddrsRefresh - interval the addresses of the host are looked up again at
+	localAddrsRefresh = time.Minute
 )
 
 type NodeIterator func(func(node *models.CommonNode) bool)
 
-type FlowEventFilter func(flow *ct.Flow) bool
+// FlowTupleFilter - returns true for the flows left out of the exported flows on their tuple alone,
+// checked before the events are enriched
+type FlowTupleFilter func(flowID string, protocol uint32, src, dst netip.AddrPort) bool
+
+// FlowEventFilter - returns true for the events left out of the exported flows
+type FlowEventFilter func(event *pbflow.FlowEvent) bool
 
 type ParticipantEnricher func(addr netip.Addr) *pbflow.FlowParticipant
 
@@ -35,16 +44,25 @@ type FlowTracker struct {
 	hostID              uuid.UUID
 	hostIDStr           string
 	nodeIter            NodeIterator
+	tupleFilter         FlowTupleFilter
 	filter              FlowEventFilter
 	participantEnricher ParticipantEnricher
 	flowExporter        exporter.Exporter
 	restoreAccounting   bool
 	restoreTimestamp    bool
+	interimInterval     time.Duration
 	cancel              context.CancelFunc
 	mu                  sync.Mutex
+
+	// addresses of the host, telling the flows it forwards from the flows it ends
+	localMu      sync.Mutex
+	localAddrs   map[netip.Addr]bool
+	localAddrsAt time.Time
 }
 
-func New(nodeIter NodeIterator, filter FlowEventFilter, participantEnricher ParticipantEnricher, flowExporter exporter.Exporter) (*FlowTracker, error) {
+// New - tracker of the flows of the networks, active flows are reported with interim start events
+// carrying their counters every interimInterval, disabled when 0
+func New(nodeIter NodeIterator, tupleFilter FlowTupleFilter, filter FlowEventFilter, participantEnricher ParticipantEnricher, flowExporter exporter.Exporter, interimInterval time.Duration) (*FlowTracker, error) {
 	var hostID uuid.UUID
 	nodeIter(func(node *models.CommonNode) bool {
 		hostID = node.HostID
@@ -55,9 +73,11 @@ func New(nodeIter NodeIterator, filter FlowEventFilter, participantEnricher Part
 		hostID:              hostID,
 		hostIDStr:           hostID.String(),
 		nodeIter:            nodeIter,
+		tupleFilter:         tupleFilter,
 		filter:              filter,
 		participantEnricher: participantEnricher,
 		flowExporter:        flowExporter,
+		interimInterval:     interimInterval,
 	}
 
 	err := c.enableAccounting()
@@ -107,10 +127,68 @@ func (c *FlowTracker) TrackConnections() error {
 	c.cancel = cancel
 
 	go c.startEventHandler(ctx, conn, events, errChan)
+	if c.interimInterval > 0 {
+		go c.startInterimUpdates(ctx)
+	}
 
 	return nil
 }
 
+// FlowTracker.startInterimUpdates - periodically dumps the active flows, exporting the ones with
+// new traffic as start events with their current counters. The events share the flow id of the
+// start event and carry a newer version, so the server keeps the latest counters
+func (c *FlowTracker) startInterimUpdates(ctx context.Context) {
+	conn, err := ct.Dial(nil)
+	if err != nil {
+		logger.Log(0, fmt.Sprintf("Error dialing ct for interim flow updates: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	// packets of the active flows at the last dump, keyed by flow id
+	packets := make(map[string]uint64)
+	ticker := time.NewTicker(c.interimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flows, err := conn.Dump(nil)
+			if err != nil {
+				logger.Log(0, fmt.Sprintf("Error dumping ct flows: %v", err))
+				continue
+			}
+			packets = c.handleInterimFlows(flows, packets)
+		}
+	}
+}
+
+// FlowTracker.handleInterimFlows - exports the flows of the networks with traffic since the last dump,
+// returning their packets for the next dump
+func (c *FlowTracker) handleInterimFlows(flows []ct.Flow, lastPackets map[string]uint64) map[string]uint64 {
+	active := make(map[string]uint64)
+	for i := range flows {
+		event := c.flowEvent(pbflow.EventType_EVENT_START, &flows[i])
+		if event == nil {
+			continue
+		}
+		packets := event.PacketsSent + event.PacketsRecv
+		active[event.FlowId] = packets
+		if last, ok := lastPackets[event.FlowId]; ok && last == packets {
+			// no traffic since the last update
+			continue
+		}
+		err := c.export(event, &flows[i])
+		if err != nil {
+			logger.Log(0, fmt.Sprintf("Error exporting interim flow update: %v", err))
+		}
+	}
+	// flows ended meanwhile are forgotten
+	return active
+}
+
 func (c *FlowTracker) startEventHandler(ctx context.Context, conn *ct.Conn, events chan ct.Event, errChan chan error) {
 	defer func() {
 		logger.Log(0, "Stopping connection tracking")
@@ -160,21 +238,38 @@ func (c *FlowTracker) handleEvent(event ct.Event) error {
 		return nil
 	}
 
-	networkID, direction := c.inferNetworkAndDirection(event.Flow)
-	if networkID == "" {
-		// if flow doesn't belong to any of our networks, ignore it.
+	flowEvent := c.flowEvent(eventType, event.Flow)
+	if flowEvent == nil {
 		return nil
 	}
+	return c.export(flowEvent, event.Flow)
+}
 
-	if c.filter(event.Flow) {
+// FlowTracker.export - exports the event along with the nat details of its flow, the exporters
+// that do not record them get the event alone
+func (c *FlowTracker) export(event *pbflow.FlowEvent, flow *ct.Flow) error {
+	return exporter.ExportNAT(c.flowExporter, event, c.flowNAT(flow, event))
+}
+
+// FlowTracker.flowEvent - the event of the flow, nil for flows outside of the networks or filtered out
+func (c *FlowTracker) flowEvent(eventType pbflow.EventType, ctFlow *ct.Flow) *pbflow.FlowEvent {
+	flowID := c.getFlowID(ctFlow)
+	src, dst := flowEnds(ctFlow)
+	if c.tupleFilter(flowID, uint32(ctFlow.TupleOrig.Proto.Protocol), src, dst) {
+		// filtered out flows are not enriched
 		return nil
 	}
 
-	flowID := c.getFlowID(event.Flow)
-	sentCounter := c.getSentCounter(event.Flow, direction)
-	receivedCounter := c.getReceivedCounter(event.Flow, direction)
+	networkID, direction := c.inferNetworkAndDirection(ctFlow)
+	if networkID == "" {
+		// if flow doesn't belong to any of our networks, ignore it.
+		return nil
+	}
 
-	flow := *event.Flow
+	sentCounter := c.getSentCounter(ctFlow, direction)
+	receivedCounter := c.getReceivedCounter(ctFlow, direction)
+
+	flow := *ctFlow
 	var icmpType, icmpCode uint8
 	if flow.TupleOrig.Proto.Protocol == 1 || flow.TupleOrig.Proto.Protocol == 58 {
 		// ICMP
@@ -182,19 +277,19 @@ func (c *FlowTracker) handleEvent(event ct.Event) error {
 		icmpCode = flow.TupleOrig.Proto.ICMPCode
 	}
 
-	return c.flowExporter.Export(&pbflow.FlowEvent{
+	event := &pbflow.FlowEvent{
 		Type:        eventType,
 		FlowId:      flowID,
 		NetworkId:   networkID,
 		HostId:      c.hostIDStr,
 		Protocol:    uint32(flow.TupleOrig.Proto.Protocol),
-		SrcPort:     uint32(flow.TupleOrig.Proto.SourcePort),
-		DstPort:     uint32(flow.TupleOrig.Proto.DestinationPort),
+		SrcPort:     uint32(src.Port()),
+		DstPort:     uint32(dst.Port()),
 		IcmpType:    uint32(icmpType),
 		IcmpCode:    uint32(icmpCode),
 		Direction:   direction,
-		Src:         c.participantEnricher(flow.TupleOrig.IP.SourceAddress),
-		Dst:         c.participantEnricher(flow.TupleOrig.IP.DestinationAddress),
+		Src:         c.participantEnricher(src.Addr()),
+		Dst:         c.participantEnricher(dst.Addr()),
 		StartTsMs:   flow.Timestamp.Start.UnixMilli(),
 		EndTsMs:     flow.Timestamp.Stop.UnixMilli(),
 		BytesSent:   sentCounter.Bytes,
@@ -203,15 +298,21 @@ func (c *FlowTracker) handleEvent(event ct.Event) error {
 		PacketsRecv: receivedCounter.Packets,
 		Status:      uint32(flow.Status),
 		Version:     time.Now().UnixMilli(),
-	})
+	}
+	if c.filter(event) {
+		return nil
+	}
+	return event
 }
 
 func (c *FlowTracker) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.cancel()
-	c.cancel = nil
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
 
 	err := c.disableAccounting()
 	if err != nil {
@@ -227,20 +328,32 @@ func (c *FlowTracker) getFlowID(flow *ct.Flow) string {
 	return uuid.NewSHA1(c.hostID, buf[:]).String()
 }
 
+// FlowTracker.inferNetworkAndDirection - the network of the flow and its direction, matching the ends
+// of the flow with the addresses and ranges of the nodes first and then with the egress ranges the
+// nodes forward to. The destination before nat and the source after nat are matched too, so flows
+// translated by the host are attributed to the network they were translated to or from
 func (c *FlowTracker) inferNetworkAndDirection(flow *ct.Flow) (string, pbflow.Direction) {
-	srcIP := net.ParseIP(flow.TupleOrig.IP.SourceAddress.String())
-	dstIP := net.ParseIP(flow.TupleOrig.IP.DestinationAddress.String())
+	src, dst := flowEnds(flow)
+	srcIP := net.IP(src.Addr().AsSlice())
+	dstIP := net.IP(dst.Addr().AsSlice())
+	origDstIP := net.IP(flow.TupleOrig.IP.DestinationAddress.Unmap().AsSlice())
+	natSrcIP := srcIP
+	if flow.TupleReply.IP.DestinationAddress.IsValid() {
+		natSrcIP = net.IP(flow.TupleReply.IP.DestinationAddress.Unmap().AsSlice())
+	}
 
 	var networkID string
 	direction := pbflow.Direction_DIR_UNSPECIFIED
 	c.nodeIter(func(node *models.CommonNode) bool {
-		if node.Address.IP.Equal(srcIP) || node.Address6.IP.Equal(srcIP) {
+		if isNodeAddress(node, srcIP) {
 			direction = pbflow.Direction_DIR_EGRESS
-		} else if node.Address.IP.Equal(dstIP) || node.Address6.IP.Equal(dstIP) {
+		} else if isNodeAddress(node, dstIP) || isNodeAddress(node, origDstIP) {
 			direction = pbflow.Direction_DIR_INGRESS
 		} else if node.NetworkRange.Contains(srcIP) || node.NetworkRange6.Contains(srcIP) {
 			direction = pbflow.Direction_DIR_INGRESS
-		} else if node.NetworkRange.Contains(dstIP) || node.NetworkRange6.Contains(dstIP) {
+		} else if node.NetworkRange.Contains(dstIP) || node.NetworkRange6.Contains(dstIP) ||
+			node.NetworkRange.Contains(origDstIP) || node.NetworkRange6.Contains(origDstIP) ||
+			isNodeAddress(node, natSrcIP) {
 			direction = pbflow.Direction_DIR_EGRESS
 		} else {
 			return true
@@ -249,10 +362,100 @@ func (c *FlowTracker) inferNetworkAndDirection(flow *ct.Flow) (string, pbflow.Di
 		networkID = node.Network
 		return false
 	})
+	if networkID != "" {
+		return networkID, direction
+	}
+
+	// flows between the egress ranges and the ranges behind other gateways are forwarded by the host
+	c.nodeIter(func(node *models.CommonNode) bool {
+		if !node.IsEgressGateway {
+			return true
+		}
+		for _, egressRange := range node.EgressGatewayRanges {
+			prefix, err := netip.ParsePrefix(egressRange)
+			if err != nil {
+				continue
+			}
+			if prefix.Contains(src.Addr()) {
+				direction = pbflow.Direction_DIR_EGRESS
+			} else if prefix.Contains(dst.Addr()) {
+				direction = pbflow.Direction_DIR_INGRESS
+			} else {
+				continue
+			}
+
+			networkID = node.Network
+			return false
+		}
+		return true
+	})
 
 	return networkID, direction
 }
 
+// FlowTracker.flowNAT - the tuples of the flow before and after nat, from the original tuple and the
+// reversed reply tuple. Flows neither started nor ended by the host are forwarded, along with the
+// egress range they are forwarded to or from when an end is in one
+func (c *FlowTracker) flowNAT(flow *ct.Flow, event *pbflow.FlowEvent) *exporter.FlowNAT {
+	nat := &exporter.FlowNAT{
+		PreNATSrc: netip.AddrPortFrom(flow.TupleOrig.IP.SourceAddress.Unmap(), flow.TupleOrig.Proto.SourcePort),
+		PreNATDst: netip.AddrPortFrom(flow.TupleOrig.IP.DestinationAddress.Unmap(), flow.TupleOrig.Proto.DestinationPort),
+	}
+	nat.PostNATSrc, nat.PostNATDst = nat.PreNATSrc, nat.PreNATDst
+	if flow.TupleReply.IP.SourceAddress.IsValid() && flow.TupleReply.IP.DestinationAddress.IsValid() {
+		nat.PostNATSrc = netip.AddrPortFrom(flow.TupleReply.IP.DestinationAddress.Unmap(), flow.TupleReply.Proto.DestinationPort)
+		nat.PostNATDst = netip.AddrPortFrom(flow.TupleReply.IP.SourceAddress.Unmap(), flow.TupleReply.Proto.SourcePort)
+	}
+
+	src, dst := flowEnds(flow)
+	nat.Forwarded = !c.isLocal(src.Addr()) && !c.isLocal(dst.Addr())
+	if nat.Forwarded {
+		if event.GetDst().GetType() == pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE {
+			nat.EgressRangeID = event.GetDst().GetId()
+		} else if event.GetSrc().GetType() == pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE {
+			nat.EgressRangeID = event.GetSrc().GetId()
+		}
+	}
+	return nat
+}
+
+// FlowTracker.isLocal - whether the address is one of the host, the addresses are looked up again
+// every localAddrsRefresh
+func (c *FlowTracker) isLocal(addr netip.Addr) bool {
+	c.localMu.Lock()
+	defer c.localMu.Unlock()
+
+	if c.localAddrs == nil || time.Since(c.localAddrsAt) >= localAddrsRefresh {
+		c.localAddrsAt = time.Now()
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			logger.Log(0, fmt.Sprintf("Error listing the host addresses: %v", err))
+		}
+		c.localAddrs = make(map[netip.Addr]bool)
+		for _, a := range addrs {
+			if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+				c.localAddrs[prefix.Addr().Unmap()] = true
+			}
+		}
+	}
+	return c.localAddrs[addr.Unmap()]
+}
+
+// flowEnds - the real ends of the flow, the source before source nat from the original tuple and the
+// destination after destination nat from the reply tuple
+func flowEnds(flow *ct.Flow) (src, dst netip.AddrPort) {
+	src = netip.AddrPortFrom(flow.TupleOrig.IP.SourceAddress.Unmap(), flow.TupleOrig.Proto.SourcePort)
+	dst = netip.AddrPortFrom(flow.TupleOrig.IP.DestinationAddress.Unmap(), flow.TupleOrig.Proto.DestinationPort)
+	if flow.TupleReply.IP.SourceAddress.IsValid() {
+		dst = netip.AddrPortFrom(flow.TupleReply.IP.SourceAddress.Unmap(), flow.TupleReply.Proto.SourcePort)
+	}
+	return src, dst
+}
+
+func isNodeAddress(node *models.CommonNode, ip net.IP) bool {
+	return node.Address.IP.Equal(ip) || node.Address6.IP.Equal(ip)
+}
+
 func (c *FlowTracker) getSentCounter(flow *ct.Flow, direction pbflow.Direction) ct.Counter {
 	switch direction {
 	case pbflow.Direction_DIR_INGRESS:
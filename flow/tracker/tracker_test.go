@@ -0,0 +1,235 @@
+package tracker
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gravitl/netclient/flow/exporter"
+	pbflow "github.com/gravitl/netmaker/grpc/flow"
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+	ct "github.com/ti-mo/conntrack"
+)
+
+type recordingExporter []*pbflow.FlowEvent
+
+func (r *recordingExporter) Export(event *pbflow.FlowEvent) error {
+	*r = append(*r, event)
+	return nil
+}
+
+type natRecordingExporter []*exporter.FlowNAT
+
+func (r *natRecordingExporter) Export(event *pbflow.FlowEvent) error {
+	return r.ExportNAT(event, nil)
+}
+
+func (r *natRecordingExporter) ExportNAT(event *pbflow.FlowEvent, nat *exporter.FlowNAT) error {
+	*r = append(*r, nat)
+	return nil
+}
+
+func ctFlow(id uint32, src, dst string, packets uint64) ct.Flow {
+	flow := ct.Flow{ID: id}
+	flow.TupleOrig.IP.SourceAddress = netip.MustParseAddr(src)
+	flow.TupleOrig.IP.DestinationAddress = netip.MustParseAddr(dst)
+	flow.TupleOrig.Proto.Protocol = 6
+	flow.CountersOrig.Packets = packets
+	return flow
+}
+
+func TestHandleInterimFlows(t *testing.T) {
+	node := models.CommonNode{
+		Network: "netmaker",
+		Address: net.IPNet{IP: net.ParseIP("100.64.0.1"), Mask: net.CIDRMask(32, 32)},
+	}
+	_, networkRange, _ := net.ParseCIDR("100.64.0.0/16")
+	node.NetworkRange = *networkRange
+	events := &recordingExporter{}
+	c := &FlowTracker{
+		nodeIter:    func(f func(node *models.CommonNode) bool) { f(&node) },
+		tupleFilter: func(string, uint32, netip.AddrPort, netip.AddrPort) bool { return false },
+		filter:      func(event *pbflow.FlowEvent) bool { return false },
+		participantEnricher: func(addr netip.Addr) *pbflow.FlowParticipant {
+			return &pbflow.FlowParticipant{Ip: addr.String()}
+		},
+		flowExporter: events,
+	}
+
+	flows := []ct.Flow{
+		ctFlow(1, "100.64.0.1", "100.64.0.2", 10),
+		ctFlow(2, "192.168.1.1", "192.168.1.2", 10),
+	}
+	packets := c.handleInterimFlows(flows, nil)
+	// flows outside of the networks are ignored
+	assert.Len(t, *events, 1)
+	assert.Len(t, packets, 1)
+	assert.Equal(t, pbflow.EventType_EVENT_START, (*events)[0].Type)
+	assert.Equal(t, uint64(10), (*events)[0].PacketsSent)
+
+	// idle flows are not updated
+	packets = c.handleInterimFlows(flows, packets)
+	assert.Len(t, *events, 1)
+
+	flows[0].CountersOrig.Packets = 20
+	packets = c.handleInterimFlows(flows, packets)
+	assert.Len(t, *events, 2)
+	assert.Equal(t, uint64(20), (*events)[1].PacketsSent)
+	assert.Equal(t, (*events)[0].FlowId, (*events)[1].FlowId)
+
+	// ended flows are forgotten
+	assert.Empty(t, c.handleInterimFlows(nil, packets))
+}
+
+func TestFlowEventTupleFilter(t *testing.T) {
+	node := models.CommonNode{
+		Network: "netmaker",
+		Address: net.IPNet{IP: net.ParseIP("100.64.0.1"), Mask: net.CIDRMask(32, 32)},
+	}
+	_, networkRange, _ := net.ParseCIDR("100.64.0.0/16")
+	node.NetworkRange = *networkRange
+	enriched := 0
+	c := &FlowTracker{
+		nodeIter: func(f func(node *models.CommonNode) bool) {
+			enriched++
+			f(&node)
+		},
+		tupleFilter: func(_ string, protocol uint32, _, dst netip.AddrPort) bool {
+			return protocol == 6 && dst.Addr() == netip.MustParseAddr("100.64.0.3")
+		},
+		filter: func(event *pbflow.FlowEvent) bool { return false },
+		participantEnricher: func(addr netip.Addr) *pbflow.FlowParticipant {
+			enriched++
+			return &pbflow.FlowParticipant{Ip: addr.String()}
+		},
+	}
+
+	// flows filtered out on their tuple are not enriched
+	flow := ctFlow(1, "100.64.0.1", "100.64.0.3", 10)
+	assert.Nil(t, c.flowEvent(pbflow.EventType_EVENT_START, &flow))
+	assert.Zero(t, enriched)
+
+	flow = ctFlow(2, "100.64.0.1", "100.64.0.2", 10)
+	assert.NotNil(t, c.flowEvent(pbflow.EventType_EVENT_START, &flow))
+	assert.NotZero(t, enriched)
+}
+
+func TestInferNetworkAndDirectionNAT(t *testing.T) {
+	node := models.CommonNode{
+		Network:             "netmaker",
+		Address:             net.IPNet{IP: net.ParseIP("100.64.0.1"), Mask: net.CIDRMask(32, 32)},
+		IsEgressGateway:     true,
+		EgressGatewayRanges: []string{"10.0.0.0/24"},
+	}
+	_, networkRange, _ := net.ParseCIDR("100.64.0.0/16")
+	node.NetworkRange = *networkRange
+	c := &FlowTracker{nodeIter: func(f func(node *models.CommonNode) bool) { f(&node) }}
+
+	reply := func(flow ct.Flow, src, dst string, srcPort uint16) ct.Flow {
+		flow.TupleReply.IP.SourceAddress = netip.MustParseAddr(src)
+		flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr(dst)
+		flow.TupleReply.Proto.SourcePort = srcPort
+		return flow
+	}
+
+	// a peer reaching the egress range, masqueraded with the gateway lan address
+	flow := reply(ctFlow(1, "100.64.0.5", "10.0.0.7", 0), "10.0.0.7", "10.0.0.1", 0)
+	network, direction := c.inferNetworkAndDirection(&flow)
+	assert.Equal(t, "netmaker", network)
+	assert.Equal(t, pbflow.Direction_DIR_INGRESS, direction)
+
+	// a port of the node forwarded to a lan host, the real destination is in the reply tuple
+	flow = reply(ctFlow(2, "100.64.0.5", "100.64.0.1", 0), "10.0.0.7", "100.64.0.5", 8080)
+	flow.TupleOrig.Proto.DestinationPort = 80
+	network, direction = c.inferNetworkAndDirection(&flow)
+	assert.Equal(t, "netmaker", network)
+	assert.Equal(t, pbflow.Direction_DIR_INGRESS, direction)
+	src, dst := flowEnds(&flow)
+	assert.Equal(t, "100.64.0.5:0", src.String())
+	assert.Equal(t, "10.0.0.7:8080", dst.String())
+
+	// a lan host reaching a range behind another gateway, forwarded without nat
+	flow = reply(ctFlow(3, "10.0.0.7", "192.168.50.5", 0), "192.168.50.5", "10.0.0.7", 0)
+	network, direction = c.inferNetworkAndDirection(&flow)
+	assert.Equal(t, "netmaker", network)
+	assert.Equal(t, pbflow.Direction_DIR_EGRESS, direction)
+
+	// the same, masqueraded with the node address
+	flow = reply(ctFlow(4, "10.0.0.8", "192.168.60.5", 0), "192.168.60.5", "100.64.0.1", 0)
+	flow.TupleOrig.IP.SourceAddress = netip.MustParseAddr("172.16.0.8")
+	network, direction = c.inferNetworkAndDirection(&flow)
+	assert.Equal(t, "netmaker", network)
+	assert.Equal(t, pbflow.Direction_DIR_EGRESS, direction)
+
+	// traffic unrelated to the networks is ignored
+	flow = reply(ctFlow(5, "172.16.0.8", "8.8.8.8", 0), "8.8.8.8", "172.16.0.8", 0)
+	network, _ = c.inferNetworkAndDirection(&flow)
+	assert.Empty(t, network)
+}
+
+func TestFlowNAT(t *testing.T) {
+	node := models.CommonNode{
+		Network:             "netmaker",
+		Address:             net.IPNet{IP: net.ParseIP("100.64.0.1"), Mask: net.CIDRMask(32, 32)},
+		IsEgressGateway:     true,
+		EgressGatewayRanges: []string{"10.0.0.0/24"},
+	}
+	_, networkRange, _ := net.ParseCIDR("100.64.0.0/16")
+	node.NetworkRange = *networkRange
+	nats := &natRecordingExporter{}
+	c := &FlowTracker{
+		nodeIter:    func(f func(node *models.CommonNode) bool) { f(&node) },
+		tupleFilter: func(string, uint32, netip.AddrPort, netip.AddrPort) bool { return false },
+		filter:      func(event *pbflow.FlowEvent) bool { return false },
+		participantEnricher: func(addr netip.Addr) *pbflow.FlowParticipant {
+			if netip.MustParsePrefix("10.0.0.0/24").Contains(addr) {
+				return &pbflow.FlowParticipant{Ip: addr.String(), Type: pbflow.ParticipantType_PARTICIPANT_EGRESS_ROUTE, Id: "egress-1"}
+			}
+			return &pbflow.FlowParticipant{Ip: addr.String()}
+		},
+		flowExporter: exporter.MultiExporter{nats},
+		localAddrs:   map[netip.Addr]bool{netip.MustParseAddr("100.64.0.1"): true, netip.MustParseAddr("10.0.0.1"): true},
+		localAddrsAt: time.Now(),
+	}
+	export := func(flow ct.Flow) *exporter.FlowNAT {
+		t.Helper()
+		assert.NoError(t, c.handleEvent(ct.Event{Type: ct.EventDestroy, Flow: &flow}))
+		return (*nats)[len(*nats)-1]
+	}
+
+	// a peer reaching the egress range, masqueraded with the gateway lan address
+	flow := ctFlow(1, "100.64.0.5", "10.0.0.7", 0)
+	flow.TupleOrig.Proto.SourcePort = 40000
+	flow.TupleReply.IP.SourceAddress = netip.MustParseAddr("10.0.0.7")
+	flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr("10.0.0.1")
+	flow.TupleReply.Proto.DestinationPort = 40000
+	nat := export(flow)
+	assert.Equal(t, "100.64.0.5:40000", nat.PreNATSrc.String())
+	assert.Equal(t, "10.0.0.1:40000", nat.PostNATSrc.String())
+	assert.Equal(t, "10.0.0.7:0", nat.PostNATDst.String())
+	assert.True(t, nat.Forwarded)
+	assert.Equal(t, "egress-1", nat.EgressRangeID)
+
+	// a port of the node forwarded to a lan host keeps the destination before nat
+	flow = ctFlow(2, "100.64.0.5", "100.64.0.1", 0)
+	flow.TupleOrig.Proto.DestinationPort = 80
+	flow.TupleReply.IP.SourceAddress = netip.MustParseAddr("10.0.0.7")
+	flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr("100.64.0.5")
+	flow.TupleReply.Proto.SourcePort = 8080
+	nat = export(flow)
+	assert.Equal(t, "100.64.0.1:80", nat.PreNATDst.String())
+	assert.Equal(t, "10.0.0.7:8080", nat.PostNATDst.String())
+	assert.True(t, nat.Forwarded)
+	assert.Equal(t, "egress-1", nat.EgressRangeID)
+
+	// flows the host ends are not forwarded
+	flow = ctFlow(3, "100.64.0.5", "10.0.0.1", 0)
+	flow.TupleReply.IP.SourceAddress = netip.MustParseAddr("10.0.0.1")
+	flow.TupleReply.IP.DestinationAddress = netip.MustParseAddr("100.64.0.5")
+	nat = export(flow)
+	assert.Equal(t, nat.PreNATDst, nat.PostNATDst)
+	assert.False(t, nat.Forwarded)
+	assert.Empty(t, nat.EgressRangeID)
+}
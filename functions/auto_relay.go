@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/devilcove/httpclient"
+	"github.com/google/uuid"
 	"github.com/gravitl/netclient/auth"
 	"github.com/gravitl/netclient/config"
 	"github.com/gravitl/netclient/metrics"
@@ -77,9 +78,18 @@ func setAutoRelayNodes(autoRelaynodes map[models.NetworkID][]models.Node, gwNode
 	autoRelayCache = autoRelaynodes
 	gwNodesCache = gwNodes
 	currentNodesCache = make(map[string]models.Node)
+	nodeStates := make(map[string]metrics.NodeState)
 	for _, currNode := range currNodes {
 		currentNodesCache[currNode.ID.String()] = currNode
+		nodeStates[currNode.Network] = metrics.NodeState{
+			IsRelay:     currNode.IsRelay,
+			IsRelayed:   currNode.IsRelayed,
+			IsFailOver:  currNode.IsFailOver,
+			FailedOver:  currNode.FailedOverBy != uuid.Nil,
+			IsAutoRelay: currNode.IsAutoRelay,
+		}
 	}
+	metrics.SetNodeStates(nodeStates)
 
 	// Calculate and cache metrics for each network
 	server := config.GetServer(config.CurrServer)
@@ -6,57 +6,67 @@ import (
 
 	"github.com/gravitl/netclient/config"
 	"github.com/gravitl/netclient/daemon"
+	"github.com/gravitl/netclient/localapi"
 )
 
 // Disconnect disconnects a node from the given network
 func Disconnect(network string) error {
-	nodes := config.GetNodes()
-	node, ok := nodes[network]
-	if !ok {
-		return errors.New("no such network")
-	}
-	if !node.Connected {
-		return errors.New("node is already disconnected")
-	}
-	node.Connected = false
-	config.UpdateNodeMap(node.Network, node)
-	if err := config.WriteNodeConfig(); err != nil {
-		return fmt.Errorf("error writing node config %w", err)
+	return requestConnect(network, false)
+}
+
+// Connect will attempt to connect a node on given network
+func Connect(network string) error {
+	return requestConnect(network, true)
+}
+
+// requestConnect - asks the running daemon to connect or disconnect the node, updating the
+// config and restarting the daemon from the cli if the daemon is not reachable
+func requestConnect(network string, connected bool) error {
+	err := localapi.Post(localapi.RouteConnect, nil, localapi.Connect{Network: network, Connected: connected}, nil)
+	if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+		return err
 	}
-	if err := PublishNodeUpdate(&node); err != nil {
+	return setNodeConnected(network, connected)
+}
+
+// setNodeConnected - connects or disconnects the node of the network and restarts the daemon to apply it,
+// used by the cli when the daemon is not reachable
+func setNodeConnected(network string, connected bool) error {
+	if err := updateNodeConnected(network, connected); err != nil {
 		return err
 	}
 	if err := daemon.Restart(); err != nil {
-		fmt.Println("daemon restart failed", err)
+		if !connected {
+			fmt.Println("daemon restart failed", err)
+			if err := daemon.Start(); err != nil {
+				fmt.Println("daemon failed to start", err)
+			}
+			return nil
+		}
 		if err := daemon.Start(); err != nil {
-			fmt.Println("daemon failed to start", err)
+			return fmt.Errorf("daemon restart failed %w", err)
 		}
 	}
 	return nil
 }
 
-// Connect will attempt to connect a node on given network
-func Connect(network string) error {
+// updateNodeConnected - saves the connected state of the node of the network and publishes it to the server
+func updateNodeConnected(network string, connected bool) error {
 	nodes := config.GetNodes()
 	node, ok := nodes[network]
 	if !ok {
 		return errors.New("no such network")
 	}
-	if node.Connected {
-		return errors.New("node already connected")
+	if node.Connected == connected {
+		if connected {
+			return errors.New("node already connected")
+		}
+		return errors.New("node is already disconnected")
 	}
-	node.Connected = true
+	node.Connected = connected
 	config.UpdateNodeMap(node.Network, node)
 	if err := config.WriteNodeConfig(); err != nil {
 		return fmt.Errorf("error writing node config %w", err)
 	}
-	if err := PublishNodeUpdate(&node); err != nil {
-		return err
-	}
-	if err := daemon.Restart(); err != nil {
-		if err := daemon.Start(); err != nil {
-			return fmt.Errorf("daemon restart failed %w", err)
-		}
-	}
-	return nil
+	return PublishNodeUpdate(&node)
 }
@@ -25,7 +25,9 @@ import (
 	"github.com/gravitl/netclient/dns"
 	"github.com/gravitl/netclient/firewall"
 	"github.com/gravitl/netclient/flow"
+	"github.com/gravitl/netclient/flow/droplog"
 	"github.com/gravitl/netclient/local"
+	"github.com/gravitl/netclient/metrics"
 	"github.com/gravitl/netclient/ncutils"
 	"github.com/gravitl/netclient/networking"
 	"github.com/gravitl/netclient/stun"
@@ -48,6 +50,8 @@ const (
 var (
 	Mqclient     mqtt.Client
 	messageCache = new(sync.Map)
+	// daemonReset - resets the running daemon, on SIGHUP or from within the daemon
+	daemonReset = make(chan os.Signal, 1)
 )
 
 type cachedMessage struct {
@@ -68,9 +72,8 @@ func Daemon() {
 	}
 	wg := sync.WaitGroup{}
 	quit := make(chan os.Signal, 1)
-	reset := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, os.Interrupt)
-	signal.Notify(reset, syscall.SIGHUP)
+	signal.Notify(daemonReset, syscall.SIGHUP)
 
 	cancel := startGoRoutines(&wg)
 
@@ -88,7 +91,7 @@ func Daemon() {
 			config.FwClose()
 			slog.Info("shutdown complete")
 			return
-		case <-reset:
+		case <-daemonReset:
 			slog.Info("received reset")
 			dns.GetDNSServerInstance().Stop()
 			_ = flow.GetManager().Stop()
@@ -104,6 +107,15 @@ func Daemon() {
 	}
 }
 
+// resetDaemon - resets the daemon from within, as a SIGHUP does. Signalling the daemon from itself
+// restarts the service on windows and kills the process
+func resetDaemon() {
+	select {
+	case daemonReset <- syscall.SIGHUP:
+	default:
+	}
+}
+
 // checkAndRestoreDefaultGateway -check if it needs to restore the default gateway
 func checkAndRestoreDefaultGateway() {
 	if config.Netclient().CurrGwNmIP == nil {
@@ -132,6 +144,7 @@ func closeRoutines(closers []context.CancelFunc, wg *sync.WaitGroup) {
 	if Mqclient != nil {
 		Mqclient.Disconnect(250)
 	}
+	metrics.SetMQConnected(false)
 	wg.Wait()
 	// clear cache
 	cache.EndpointCache = sync.Map{}
@@ -273,6 +286,10 @@ func startGoRoutines(wg *sync.WaitGroup) context.CancelFunc {
 		cache.EndpointCache = sync.Map{}
 		cache.SkipEndpointCache = sync.Map{}
 	}
+	startLocalAPI(ctx, wg)
+	if addr := config.Netclient().MetricsExporterAddr; addr != "" {
+		metrics.StartExporter(ctx, wg, addr)
+	}
 	server = config.GetServer(config.CurrServer)
 	if server == nil {
 		return cancel
@@ -325,6 +342,14 @@ func startGoRoutines(wg *sync.WaitGroup) context.CancelFunc {
 	}
 	wg.Add(1)
 	go mqFallback(ctx, wg)
+	if !config.Netclient().DisableFirewallReconcile {
+		wg.Add(1)
+		go reconcileFirewall(ctx, wg)
+	}
+	if config.Netclient().DropLog {
+		wg.Add(1)
+		go droplog.Run(ctx, wg)
+	}
 
 	if server.ManageDNS {
 		if dns.GetDNSServerInstance().AddrStr == "" {
@@ -380,6 +405,7 @@ func setupMQTT(server *config.Server) error {
 	opts.SetCleanSession(true)
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		slog.Info("mqtt connect handler")
+		metrics.SetMQConnected(true)
 		nodes := config.GetNodes()
 		for _, node := range nodes {
 			node := node
@@ -394,6 +420,7 @@ func setupMQTT(server *config.Server) error {
 	opts.SetResumeSubs(true)
 	opts.SetConnectionLostHandler(func(c mqtt.Client, e error) {
 		slog.Warn("detected broker connection lost for", "server", server.Broker)
+		metrics.SetMQConnected(false)
 	})
 	Mqclient = mqtt.NewClient(opts)
 	var connecterr error
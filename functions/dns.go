@@ -0,0 +1,118 @@
+package functions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/dns"
+	"github.com/gravitl/netclient/localapi"
+)
+
+// dnsStatsTop - most queried names reported when no count is requested
+const dnsStatsTop = 10
+
+// FlushDNSCache - drops the responses cached by the dns resolver of the running daemon
+func FlushDNSCache() error {
+	var result localapi.DNSCacheFlush
+	if err := localapi.Post(localapi.RouteDNSCacheFlush, nil, nil, &result); err != nil {
+		return err
+	}
+	fmt.Printf("\nFlushed %d cached dns responses\n", result.Flushed)
+	return nil
+}
+
+// DNSForwardStrategy - sets how the dns resolver forwards the queries of the current server to its
+// nameservers, or displays the strategy in use when strategy is empty
+func DNSForwardStrategy(strategy string) error {
+	var result localapi.DNSForward
+	var err error
+	if strategy == "" {
+		err = localapi.Get(localapi.RouteDNSForward, nil, &result)
+	} else {
+		err = localapi.Post(localapi.RouteDNSForward, nil, localapi.DNSForward{Strategy: strategy}, &result)
+	}
+	if errors.Is(err, localapi.ErrDaemonNotRunning) {
+		// the daemon reads the strategy from the server config when it starts
+		if strategy != "" {
+			err = setDNSForwardStrategy(strategy)
+		} else {
+			err = nil
+		}
+		result = dnsForward()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nDNS forward strategy of server %s: %s\n", result.Server, result.Strategy)
+	return nil
+}
+
+// dnsForward - returns the forward strategy in use for the current server
+func dnsForward() localapi.DNSForward {
+	return localapi.DNSForward{
+		Server:   config.CurrServer,
+		Strategy: dns.ForwardStrategy(config.GetServer(config.CurrServer)),
+	}
+}
+
+// setDNSForwardStrategy - saves the forward strategy of the current server
+func setDNSForwardStrategy(strategy string) error {
+	if !dns.ValidForwardStrategy(strategy) {
+		return fmt.Errorf("unknown dns forward strategy %q, expected %s, %s or %s", strategy,
+			dns.DNS_FORWARD_SEQUENTIAL, dns.DNS_FORWARD_STAGGER, dns.DNS_FORWARD_RACE)
+	}
+	server := config.GetServer(config.CurrServer)
+	if server == nil {
+		return errors.New("server config not found")
+	}
+	server.DNSForwardStrategy = strategy
+	return config.SaveServer(config.CurrServer, *server)
+}
+
+// DNSStats - displays the queries answered by the dns resolver of the running daemon,
+// the most queried names and the error rates of the nameservers
+func DNSStats(jsonOutput bool, top int) error {
+	var stats dns.QueryStats
+	if err := localapi.Get(localapi.RouteDNSStats, url.Values{"top": {strconv.Itoa(top)}}, &stats); err != nil {
+		return err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal dns stats: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	fmt.Printf("\nQueries: %d  Cache hits: %d (%s)  Local: %d  Blocked: %d  NXDOMAIN: %d (%s)  SERVFAIL: %d (%s)\n\n",
+		stats.Queries, stats.CacheHits, percent(stats.CacheHits, stats.Queries), stats.Local, stats.Blocked,
+		stats.NXDomain, percent(stats.NXDomain, stats.Queries), stats.ServFail, percent(stats.ServFail, stats.Queries))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(stats.TopDomains) > 0 {
+		fmt.Fprintln(w, "NAME\tQUERIES")
+		for _, d := range stats.TopDomains {
+			fmt.Fprintf(w, "%s\t%d\n", d.Name, d.Queries)
+		}
+		fmt.Fprintln(w)
+	}
+	if len(stats.Upstreams) > 0 {
+		fmt.Fprintln(w, "NAMESERVER\tQUERIES\tERRORS\tERROR RATE\tSRTT\tDEMOTED")
+		for _, u := range stats.Upstreams {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%.1fms\t%t\n", u.Nameserver, u.Queries, u.Errors, percent(u.Errors, u.Queries), u.SRTTMs, u.Demoted)
+		}
+	}
+	return w.Flush()
+}
+
+func percent(n, total uint64) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
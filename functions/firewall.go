@@ -0,0 +1,203 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/firewall"
+	"github.com/gravitl/netclient/localapi"
+	"github.com/gravitl/netclient/metrics"
+	"github.com/gravitl/netmaker/models"
+	"golang.org/x/exp/slog"
+)
+
+// firewallReconcileInterval - how often the live firewall is compared with the installed rules
+const firewallReconcileInterval = time.Minute
+
+// reconcileFirewall - periodically re-applies firewall rules removed or changed by other tools
+func reconcileFirewall(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(firewallReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("firewall reconcile routine stop")
+			return
+		case <-ticker.C:
+			counts := make(map[string]int)
+			for _, drift := range firewall.Reconcile() {
+				counts[drift.Kind]++
+			}
+			metrics.RecordFirewallReconcile(counts)
+		}
+	}
+}
+
+// PlanFirewall displays the firewall rule operations the update in file would perform.
+// The plan is made by the running daemon against the rules it installed, or against
+// empty state if the daemon is not reachable.
+func PlanFirewall(file, backend string, jsonOutput bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var fwUpdate models.FwUpdate
+	if err := json.Unmarshal(data, &fwUpdate); err != nil {
+		return fmt.Errorf("failed to parse firewall update %s: %w", file, err)
+	}
+	backend = firewallBackend(backend)
+	var plan firewall.Plan
+	query := url.Values{}
+	query.Set("backend", backend)
+	err = localapi.Post(localapi.RouteFirewallPlan, query, fwUpdate, &plan)
+	if err != nil {
+		if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+			return err
+		}
+		plan = firewall.PlanFwUpdate(config.CurrServer, &fwUpdate, backend)
+		plan.Warnings = append([]string{"daemon is not running, planned against an empty firewall"}, plan.Warnings...)
+	}
+	if jsonOutput {
+		// keep rendered commands readable, they contain <, > and &
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			return fmt.Errorf("failed to marshal firewall plan: %w", err)
+		}
+		return nil
+	}
+	printFirewallPlan(plan)
+	return nil
+}
+
+// firewallBackend - returns the backend to plan for, defaulting to the one in use by the host
+func firewallBackend(backend string) string {
+	if backend == models.FIREWALL_IPTABLES || backend == models.FIREWALL_NFTABLES {
+		return backend
+	}
+	if config.Netclient().FirewallInUse == models.FIREWALL_IPTABLES {
+		return models.FIREWALL_IPTABLES
+	}
+	return models.FIREWALL_NFTABLES
+}
+
+func printFirewallPlan(plan firewall.Plan) {
+	fmt.Printf("\nBackend: %s\n", plan.Backend)
+	for _, warning := range plan.Warnings {
+		fmt.Println("Warning:", warning)
+	}
+	if len(plan.Ops) == 0 {
+		fmt.Println("\nNo changes")
+		return
+	}
+	var adds, deletes int
+	fmt.Println()
+	for _, op := range plan.Ops {
+		sign := "+"
+		if op.Action == firewall.PlanActionDelete {
+			sign = "-"
+			deletes++
+		} else {
+			adds++
+		}
+		owner := "default target"
+		if op.RuleTable != "" {
+			owner = op.RuleTable + " " + op.Key
+		}
+		fmt.Printf("%s [%s] %s\n", sign, owner, op.Command)
+	}
+	fmt.Printf("\n%d to add, %d to delete\n", adds, deletes)
+}
+
+// CheckFirewall - displays whether the acl rules installed by the daemon allow a new flow
+func CheckFirewall(src, dst, proto string, port int, jsonOutput bool) error {
+	query := url.Values{}
+	query.Set("src", src)
+	query.Set("dst", dst)
+	query.Set("proto", proto)
+	if port > 0 {
+		query.Set("port", strconv.Itoa(port))
+	}
+	var result firewall.CheckResult
+	if err := localapi.Get(localapi.RouteFirewallCheck, query, &result); err != nil {
+		return err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal firewall check: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	fmt.Printf("\nChain:   %s\n", result.Chain)
+	if result.Rule != "" {
+		fmt.Printf("Matched: [%s %s] %s\n", result.RuleTable, result.Key, result.Rule)
+	} else {
+		fmt.Println("Matched: default target")
+	}
+	fmt.Printf("Verdict: %s\n", result.Verdict)
+	for _, note := range result.Notes {
+		fmt.Println("Note:", note)
+	}
+	return nil
+}
+
+// FirewallStats - displays the packets and bytes matched by the rules of each acl policy,
+// egress gateway and ingress node
+func FirewallStats(jsonOutput bool) error {
+	var stats []firewall.RuleStats
+	if err := localapi.Get(localapi.RouteFirewallStats, nil, &stats); err != nil {
+		return err
+	}
+	if jsonOutput {
+		out, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal firewall stats: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	if len(stats) == 0 {
+		fmt.Println("\nNo acl rules installed")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tKEY\tACL\tPACKETS\tBYTES")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", s.Table, s.Key, s.AclID, s.Packets, s.Bytes)
+	}
+	return w.Flush()
+}
+
+// firewallHitsPrefix - prefix of the metrics connectivity keys carrying the hits of the firewall rules
+const firewallHitsPrefix = "firewall/"
+
+// firewallHits - returns the hits of the firewall rules as metrics connectivity entries, keyed by
+// table, key and acl id. The matched packets are carried in TotalReceived and the bytes in TotalSent
+func firewallHits(stats []firewall.RuleStats) map[string]models.Metric {
+	hits := make(map[string]models.Metric, len(stats))
+	for _, s := range stats {
+		key := firewallHitsPrefix + s.Table + "/" + s.Key
+		if s.AclID != "" {
+			key += "/" + s.AclID
+		}
+		hits[key] = models.Metric{
+			NodeName:      key,
+			TotalReceived: int64(s.Packets),
+			TotalSent:     int64(s.Bytes),
+		}
+	}
+	return hits
+}
@@ -0,0 +1,20 @@
+package functions
+
+import (
+	"testing"
+
+	"github.com/gravitl/netclient/firewall"
+	"github.com/gravitl/netmaker/models"
+	"github.com/matryer/is"
+)
+
+func TestFirewallHits(t *testing.T) {
+	is := is.New(t)
+	hits := firewallHits([]firewall.RuleStats{
+		{Table: "acl", Key: "web", Packets: 12, Bytes: 1500},
+		{Table: "egress", Key: "gw1", AclID: "db", Packets: 3, Bytes: 180},
+	})
+	is.Equal(len(hits), 2)
+	is.Equal(hits["firewall/acl/web"], models.Metric{NodeName: "firewall/acl/web", TotalReceived: 12, TotalSent: 1500})
+	is.Equal(hits["firewall/egress/gw1/db"], models.Metric{NodeName: "firewall/egress/gw1/db", TotalReceived: 3, TotalSent: 180})
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/devilcove/httpclient"
 	"github.com/gravitl/netclient/auth"
 	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/localapi"
 	"github.com/gravitl/netmaker/logger"
 	"github.com/gravitl/netmaker/models"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -32,10 +33,25 @@ type peerOut struct {
 
 // List - list network details for specified networks
 // long flag passed passed to cmd line will list additional details about network including peers
+// state is taken from the running daemon when reachable, from config files otherwise
 func List(net string, long bool) {
 	listOutput := []output{}
 	found := false
-	nodes := config.GetNodes()
+	var daemonPeers *localapi.Peers
+	nodes := config.NodeMap{}
+	if err := localapi.Get(localapi.RouteNodes, nil, &nodes); err != nil {
+		if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+			logger.Log(1, "failed to get nodes from daemon: ", err.Error())
+		}
+		nodes = config.GetNodes()
+	} else if long {
+		peers := localapi.Peers{}
+		if err := localapi.Get(localapi.RoutePeers, nil, &peers); err != nil {
+			logger.Log(1, "failed to get peers from daemon: ", err.Error())
+		} else {
+			daemonPeers = &peers
+		}
+	}
 	for _, node := range nodes {
 		if node.Network != net && net != "" {
 			continue
@@ -53,7 +69,15 @@ func List(net string, long bool) {
 		if node.Address6.IP != nil {
 			output.Ipv6Addr = node.Address6.String()
 		}
-		if long {
+		if long && daemonPeers != nil {
+			for _, peer := range daemonPeers.Peers[node.Network] {
+				output.Peers = append(output.Peers, peerOut{
+					PublicKey:  peer.PublicKey,
+					Endpoint:   peer.Endpoint,
+					AllowedIps: peer.AllowedIPs,
+				})
+			}
+		} else if long {
 			peers, err := GetNodePeers(node)
 			if err != nil {
 				logger.Log(1, "failed to get peers for node: ", node.ID.String(), " Err: ", err.Error())
@@ -0,0 +1,207 @@
+package functions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gravitl/netclient/cache"
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/dns"
+	"github.com/gravitl/netclient/firewall"
+	"github.com/gravitl/netclient/localapi"
+	"github.com/gravitl/netclient/ncutils"
+	"github.com/gravitl/netclient/wireguard"
+	"github.com/gravitl/netmaker/models"
+	"golang.org/x/exp/slog"
+)
+
+// startLocalAPI - serves the daemon state on the local unix socket
+func startLocalAPI(ctx context.Context, wg *sync.WaitGroup) {
+	srv := localapi.NewServer()
+	srv.Handle(localapi.RouteStatus, handleStatusRequest)
+	srv.Handle(localapi.RouteNodes, func(r *http.Request) (any, error) {
+		return config.GetNodes(), nil
+	})
+	srv.Handle(localapi.RoutePeers, func(r *http.Request) (any, error) {
+		return collectPeers(r.URL.Query().Get("network"))
+	})
+	srv.Handle(localapi.RouteEndpoints, handleEndpointsRequest)
+	srv.Handle(localapi.RouteIGW, func(r *http.Request) (any, error) {
+		return wireguard.GetIGWMonitor().Status(), nil
+	})
+	srv.Handle(localapi.RouteFirewall, func(r *http.Request) (any, error) {
+		return firewall.GetRuleTables(config.CurrServer), nil
+	})
+	srv.Handle(localapi.RouteFirewallPlan, handleFirewallPlanRequest)
+	srv.Handle(localapi.RouteFirewallCheck, handleFirewallCheckRequest)
+	srv.Handle(localapi.RouteFirewallStats, func(r *http.Request) (any, error) {
+		return firewall.GetRuleStats(config.CurrServer), nil
+	})
+	srv.Handle(localapi.RouteDNS, func(r *http.Request) (any, error) {
+		return localapi.DNS{
+			ListenAddrs:  dns.GetDNSServerInstance().ListenAddrs(),
+			CacheEntries: dns.ResponseCacheLen(),
+		}, nil
+	})
+	srv.Handle(localapi.RouteDNSCacheFlush, func(r *http.Request) (any, error) {
+		if r.Method != http.MethodPost {
+			return nil, errors.New("dns cache flush requires a POST request")
+		}
+		return localapi.DNSCacheFlush{Flushed: dns.FlushResponseCache()}, nil
+	})
+	srv.Handle(localapi.RouteDNSStats, func(r *http.Request) (any, error) {
+		top, err := strconv.Atoi(r.URL.Query().Get("top"))
+		if err != nil {
+			top = dnsStatsTop
+		}
+		return dns.GetQueryStats(top), nil
+	})
+	srv.Handle(localapi.RouteDNSForward, handleDNSForwardRequest)
+	srv.Handle(localapi.RoutePing, handlePingRequest)
+	// the daemon resets itself once the reply is written, so the request is not cut off
+	srv.HandleThen(localapi.RouteConnect, handleConnectRequest, resetDaemon)
+	srv.HandleThen(localapi.RoutePull, func(r *http.Request) (any, error) {
+		if r.Method != http.MethodPost {
+			return nil, errors.New("pull requires a POST request")
+		}
+		if _, _, _, err := Pull(false, true); err != nil {
+			return nil, err
+		}
+		return localapi.Pull{Server: config.CurrServer}, nil
+	}, resetDaemon)
+	if err := srv.Start(ctx, wg); err != nil {
+		slog.Error("failed to start local api", "error", err)
+	}
+}
+
+func handleStatusRequest(r *http.Request) (any, error) {
+	host := config.Netclient()
+	status := localapi.Status{
+		Version:       config.Version,
+		HostID:        host.ID.String(),
+		HostName:      host.Name,
+		Interface:     ncutils.GetInterfaceName(),
+		ListenPort:    host.ListenPort,
+		FirewallInUse: host.FirewallInUse,
+		Server:        config.CurrServer,
+		MQConnected:   Mqclient != nil && Mqclient.IsConnectionOpen(),
+	}
+	if server := config.GetServer(config.CurrServer); server != nil {
+		status.API = server.API
+		status.Broker = server.Broker
+		status.IsPro = server.IsPro
+		status.ManageDNS = server.ManageDNS
+	}
+	return status, nil
+}
+
+func handleEndpointsRequest(r *http.Request) (any, error) {
+	endpoints := []localapi.Endpoint{}
+	cache.EndpointCache.Range(func(key, value any) bool {
+		pubKey, ok := key.(string)
+		if !ok {
+			return true
+		}
+		cached, ok := value.(cache.EndpointCacheValue)
+		if !ok || cached.Endpoint == nil {
+			return true
+		}
+		endpoints = append(endpoints, localapi.Endpoint{
+			PublicKey: pubKey,
+			Endpoint:  cached.Endpoint.String(),
+		})
+		return true
+	})
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].PublicKey < endpoints[j].PublicKey
+	})
+	return endpoints, nil
+}
+
+func handlePingRequest(r *http.Request) (any, error) {
+	query := r.URL.Query()
+	count, err := strconv.Atoi(query.Get("count"))
+	if err != nil {
+		count = 0
+	}
+	return collectPingResults(query.Get("network"), query.Get("peer"), count, query.Get("ip"))
+}
+
+func handleConnectRequest(r *http.Request) (any, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("connect requires a POST request")
+	}
+	var req localapi.Connect
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if err := updateNodeConnected(req.Network, req.Connected); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func handleDNSForwardRequest(r *http.Request) (any, error) {
+	if r.Method == http.MethodPost {
+		var req localapi.DNSForward
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		if err := setDNSForwardStrategy(req.Strategy); err != nil {
+			return nil, err
+		}
+	}
+	return dnsForward(), nil
+}
+
+func handleFirewallPlanRequest(r *http.Request) (any, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.New("firewall plan requires a POST request")
+	}
+	var fwUpdate models.FwUpdate
+	if err := json.NewDecoder(r.Body).Decode(&fwUpdate); err != nil {
+		return nil, err
+	}
+	return firewall.PlanFwUpdate(config.CurrServer, &fwUpdate, firewallBackend(r.URL.Query().Get("backend"))), nil
+}
+
+func handleFirewallCheckRequest(r *http.Request) (any, error) {
+	query := r.URL.Query()
+	flow := firewall.Flow{
+		Src:   net.ParseIP(query.Get("src")),
+		Dst:   net.ParseIP(query.Get("dst")),
+		Proto: query.Get("proto"),
+	}
+	if flow.Src == nil || flow.Dst == nil {
+		return nil, errors.New("firewall check requires a valid src and dst ip")
+	}
+	if port := query.Get("port"); port != "" {
+		var err error
+		if flow.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+	}
+	flow.Forward = !inNodeNetworks(flow.Dst)
+	return firewall.Check(config.CurrServer, flow)
+}
+
+// inNodeNetworks - reports if the ip belongs to one of the host's networks,
+// traffic to other addresses is forwarded out of the netmaker interface
+func inNodeNetworks(ip net.IP) bool {
+	for _, node := range config.GetNodes() {
+		if node.NetworkRange.IP != nil && node.NetworkRange.Contains(ip) {
+			return true
+		}
+		if node.NetworkRange6.IP != nil && node.NetworkRange6.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
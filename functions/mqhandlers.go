@@ -123,8 +123,7 @@ func NodeUpdate(client mqtt.Client, msg mqtt.Message) {
 		wireguard.SetRoutesFromCache()
 		time.Sleep(time.Second)
 		if server.ManageDNS {
-			dns.GetDNSServerInstance().Stop()
-			dns.GetDNSServerInstance().Start()
+			dns.GetDNSServerInstance().Reconcile()
 		}
 
 		doneErr := publishSignal(&newNode, DONE)
@@ -372,15 +371,19 @@ func HostPeerUpdate(client mqtt.Client, msg mqtt.Message) {
 			dns.GetDNSServerInstance().Start()
 		case stop:
 			dns.GetDNSServerInstance().Stop()
+			dns.FlushResponseCache()
 		case update:
+			dns.FlushResponseCache()
 			_ = dns.SetupDNSConfig()
 			_ = dns.FlushLocalDnsCache()
 		}
 	}
 
-	if peerUpdate.Host.EnableFlowLogs {
-		_ = flow.GetManager().Start(peerUpdate.AddressIdentityMap)
+	if peerUpdate.Host.EnableFlowLogs || config.Netclient().FlowExportCollector != "" {
+		_ = flow.GetManager().Start(peerUpdate.AddressIdentityMap, peerUpdate.Host.EnableFlowLogs)
 	} else {
+		// identities are kept for the drop log
+		flow.GetManager().SetParticipantIdentifiers(peerUpdate.AddressIdentityMap)
 		_ = flow.GetManager().Stop()
 	}
 
@@ -590,8 +593,7 @@ func resetInterfaceFunc() {
 
 		//Setup DNS for Linux and Windows
 		if config.Netclient().Host.OS == "linux" || config.Netclient().Host.OS == "windows" {
-			dns.GetDNSServerInstance().Stop()
-			dns.GetDNSServerInstance().Start()
+			dns.GetDNSServerInstance().Reconcile()
 		}
 	}
 	wireguard.EgressResetCh <- struct{}{}
@@ -699,19 +701,13 @@ func isAddressInPeers(ip net.IP, cidrs []net.IPNet) bool {
 }
 
 func handleFwUpdate(server string, payload *models.FwUpdate) {
-
-	if payload.IsEgressGw {
-		firewall.SetEgressRoutes(server, payload.EgressInfo)
-	} else {
-		firewall.DeleteEgressGwRoutes(server)
-	}
-	if payload.IsIngressGw {
-		firewall.ProcessIngressUpdate(server, payload.IngressInfo)
-	} else {
-		firewall.RemoveIngressRoutingRules(server)
+	if err := firewall.ApplyFwUpdate(server, payload); err != nil {
+		slog.Error("failed to apply firewall update", "error", err)
+		metrics.RecordFirewallUpdateFailure()
+		if err := publishFwUpdateFailure(err); err != nil {
+			slog.Warn("failed to report firewall update failure to server", "error", err)
+		}
 	}
-	firewall.ProcessAclRules(server, payload)
-
 }
 
 func getServerBrokerStatus() (bool, error) {
@@ -766,6 +762,7 @@ func mqFallback(ctx context.Context, wg *sync.WaitGroup) {
 			}
 			// Call netclient http config pull
 			slog.Info("### mqfallback routine execute")
+			metrics.RecordPullFallback()
 			auth.CleanJwtToken()
 			response, resetInterface, replacePeers, err := Pull(false, false)
 			if err != nil {
@@ -890,14 +887,15 @@ func mqFallbackPull(pullResponse models.HostPull, resetInterface, replacePeers b
 	go CheckEgressDomainUpdates()
 	pullResponse.DnsNameservers = FilterDnsNameservers(pullResponse.DnsNameservers)
 	var saveServerConfig bool
-	if len(server.NameServers) != len(pullResponse.NameServers) || reflect.DeepEqual(server.NameServers, pullResponse.NameServers) {
+	if len(server.NameServers) != len(pullResponse.NameServers) || !reflect.DeepEqual(server.NameServers, pullResponse.NameServers) {
 		server.NameServers = pullResponse.NameServers
 		saveServerConfig = true
 	}
 
-	if len(server.DnsNameservers) != len(pullResponse.DnsNameservers) || reflect.DeepEqual(server.DnsNameservers, pullResponse.DnsNameservers) {
+	if len(server.DnsNameservers) != len(pullResponse.DnsNameservers) || !reflect.DeepEqual(server.DnsNameservers, pullResponse.DnsNameservers) {
 		server.DnsNameservers = pullResponse.DnsNameservers
 		saveServerConfig = true
+		dns.FlushResponseCache()
 	}
 
 	if pullResponse.ServerConfig.ManageDNS != server.ManageDNS {
@@ -911,7 +909,8 @@ func mqFallbackPull(pullResponse models.HostPull, resetInterface, replacePeers b
 	}
 
 	if server.ManageDNS {
-		if (config.Netclient().Host.OS == "linux" && dns.GetDNSServerInstance().AddrStr != "" && config.Netclient().DNSManagerType == dns.DNS_MANAGER_STUB) ||
+		if (config.Netclient().Host.OS == "linux" && dns.GetDNSServerInstance().AddrStr != "" &&
+			(config.Netclient().DNSManagerType == dns.DNS_MANAGER_STUB || config.Netclient().DNSManagerType == dns.DNS_MANAGER_RESOLVED_DBUS)) ||
 			config.Netclient().Host.OS == "windows" {
 			_ = dns.SetupDNSConfig()
 			_ = dns.FlushLocalDnsCache()
@@ -938,9 +937,11 @@ func mqFallbackPull(pullResponse models.HostPull, resetInterface, replacePeers b
 		daemon.Restart()
 	}
 
-	if pullResponse.Host.EnableFlowLogs {
-		_ = flow.GetManager().Start(pullResponse.AddressIdentityMap)
+	if pullResponse.Host.EnableFlowLogs || config.Netclient().FlowExportCollector != "" {
+		_ = flow.GetManager().Start(pullResponse.AddressIdentityMap, pullResponse.Host.EnableFlowLogs)
 	} else {
+		// identities are kept for the drop log
+		flow.GetManager().SetParticipantIdentifiers(pullResponse.AddressIdentityMap)
 		_ = flow.GetManager().Stop()
 	}
 
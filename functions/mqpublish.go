@@ -15,6 +15,7 @@ import (
 	"github.com/gravitl/netclient/auth"
 	"github.com/gravitl/netclient/config"
 	"github.com/gravitl/netclient/daemon"
+	"github.com/gravitl/netclient/firewall"
 	"github.com/gravitl/netclient/metrics"
 	"github.com/gravitl/netclient/ncutils"
 	"github.com/gravitl/netclient/networking"
@@ -160,6 +161,31 @@ func hostUpdateWithServer(server *config.Server, hu models.HostUpdate) error {
 
 // hostServerUpdate - used to send host updates to server via restful api
 func hostServerUpdate(hu models.HostUpdate) error {
+	return putHostUpdate(func(host models.Host) any {
+		hu.Host = host
+		return hu
+	})
+}
+
+// fwUpdateFailure - check-in carrying the error of a firewall update the host rolled back,
+// servers that don't read the error process it as a routine check-in
+type fwUpdateFailure struct {
+	models.HostUpdate
+	FwUpdateError string `json:"fw_update_error"`
+}
+
+// publishFwUpdateFailure - reports to the server that a firewall update failed and the previous rules were kept
+func publishFwUpdateFailure(fwErr error) error {
+	return putHostUpdate(func(host models.Host) any {
+		return fwUpdateFailure{
+			HostUpdate:    models.HostUpdate{Action: models.CheckIn, Host: host},
+			FwUpdateError: fwErr.Error(),
+		}
+	})
+}
+
+// putHostUpdate - sends the host update built for the host to the fallback host endpoint of the server
+func putHostUpdate(update func(host models.Host) any) error {
 
 	server := config.GetServer(config.CurrServer)
 	if server == nil {
@@ -173,12 +199,11 @@ func hostServerUpdate(hu models.HostUpdate) error {
 	if err != nil {
 		return err
 	}
-	hu.Host = host.Host
 	endpoint := httpclient.JSONEndpoint[models.SuccessResponse, models.ErrorResponse]{
 		URL:           "https://" + server.API,
 		Route:         fmt.Sprintf("/api/v1/fallback/host/%s", host.ID.String()),
 		Method:        http.MethodPut,
-		Data:          hu,
+		Data:          update(host.Host),
 		Authorization: "Bearer " + token,
 		ErrorResponse: models.ErrorResponse{},
 	}
@@ -273,6 +298,13 @@ func publishMetrics(node *config.Node, metricPort int, peerInfo models.PeerMap,
 	metrics.Network = node.Network
 	metrics.NodeName = config.Netclient().Name
 	metrics.NodeID = node.ID.String()
+	if metrics.Connectivity == nil {
+		metrics.Connectivity = make(map[string]models.Metric)
+	}
+	// the hits per acl policy, egress gateway and ingress node ride along with the peer metrics
+	for key, hits := range firewallHits(firewall.GetRuleStats(node.Server)) {
+		metrics.Connectivity[key] = hits
+	}
 	data, err := json.Marshal(metrics)
 	if err != nil {
 		logger.Log(0, "something went wrong when marshalling metrics data for node", config.Netclient().Name, err.Error())
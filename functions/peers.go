@@ -2,73 +2,75 @@ package functions
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/gravitl/netclient/localapi"
 	"github.com/gravitl/netclient/ncutils"
 	"github.com/gravitl/netclient/networking"
 	"github.com/gravitl/netclient/wireguard"
 	"golang.zx2c4.com/wireguard/wgctrl"
 )
 
-type peerInfo struct {
-	PublicKey           string    `json:"public_key"`
-	HostName            string    `json:"host_name,omitempty"`
-	Network             string    `json:"network,omitempty"`
-	Endpoint            string    `json:"endpoint,omitempty"`
-	LastHandshake       string    `json:"last_handshake,omitempty"`
-	LastHandshakeTime   time.Time `json:"last_handshake_time,omitempty"`
-	ReceiveBytes        int64     `json:"receive_bytes"`
-	TransmitBytes       int64     `json:"transmit_bytes"`
-	AllowedIPs          []string  `json:"allowed_ips,omitempty"`
-	PersistentKeepalive string    `json:"persistent_keepalive,omitempty"`
-	IsExt               bool      `json:"is_extclient,omitempty"`
-	UserName            string    `json:"username,omitempty"`
-}
-
 // ShowPeers displays peer information from the WireGuard interface,
 // grouped per network. If networkFilter is non-empty, only that
-// network's peers are displayed.
+// network's peers are displayed. Peers are fetched from the running daemon
+// and read from the interface directly if the daemon is not reachable.
 func ShowPeers(jsonOutput bool, networkFilter string) error {
+	var peers localapi.Peers
+	query := url.Values{}
+	query.Set("network", networkFilter)
+	err := localapi.Get(localapi.RoutePeers, query, &peers)
+	if err != nil {
+		if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+			return err
+		}
+		peers, err = collectPeers(networkFilter)
+		if err != nil {
+			return err
+		}
+	}
+	return printPeers(peers, jsonOutput, networkFilter)
+}
+
+// collectPeers gathers the peers applied on the WireGuard interface along
+// with the host metadata known by the server
+func collectPeers(networkFilter string) (localapi.Peers, error) {
 	ifaceName := ncutils.GetInterfaceName()
+	peers := localapi.Peers{
+		Interface: localapi.Interface{Name: ifaceName},
+		Peers:     make(map[string][]localapi.Peer),
+	}
 	devicePeers, err := wireguard.GetPeersFromDevice(ifaceName)
 	if err != nil {
-		return fmt.Errorf("failed to get peers from device %s: %w", ifaceName, err)
+		return peers, fmt.Errorf("failed to get peers from device %s: %w", ifaceName, err)
 	}
 
 	// Get device information (port and public key)
-	var interfacePort int
-	var interfacePublicKey string
 	wg, err := wgctrl.New()
 	if err == nil {
 		defer wg.Close()
 		device, err := wg.Device(ifaceName)
 		if err == nil {
-			interfacePort = device.ListenPort
-			interfacePublicKey = device.PublicKey.String()
+			peers.Interface.Port = device.ListenPort
+			peers.Interface.PublicKey = device.PublicKey.String()
 		}
 	}
 
 	if len(devicePeers) == 0 {
-		if jsonOutput {
-			fmt.Println("[]")
-		} else {
-			fmt.Println("\nNo peers found on interface", ifaceName)
-		}
-		return nil
+		return peers, nil
 	}
 
 	// Get additional metadata (including host names and network mapping) from server
 	hostPeerInfo, err := networking.GetPeerInfo()
 	if err != nil {
-		return fmt.Errorf("failed to fetch peer metadata from server: %w", err)
+		return peers, fmt.Errorf("failed to fetch peer metadata from server: %w", err)
 	}
 
-	// Build network -> []peerInfo map
-	networkPeers := make(map[string][]peerInfo)
-
 	for networkID, peerMap := range hostPeerInfo.NetworkPeerIDs {
 		netName := string(networkID)
 		if networkFilter != "" && netName != networkFilter {
@@ -81,7 +83,7 @@ func ShowPeers(jsonOutput bool, networkFilter string) error {
 				continue
 			}
 
-			info := peerInfo{
+			info := localapi.Peer{
 				PublicKey:     devicePeer.PublicKey.String(),
 				HostName:      idAndAddr.Name,
 				Network:       idAndAddr.Network,
@@ -122,30 +124,33 @@ func ShowPeers(jsonOutput bool, networkFilter string) error {
 				info.PersistentKeepalive = devicePeer.PersistentKeepaliveInterval.String()
 			}
 
-			networkPeers[netName] = append(networkPeers[netName], info)
+			peers.Peers[netName] = append(peers.Peers[netName], info)
 		}
 	}
+	return peers, nil
+}
+
+// printPeers prints the peers as a table per network or as json
+func printPeers(peers localapi.Peers, jsonOutput bool, networkFilter string) error {
+	ifaceName := peers.Interface.Name
+	interfacePort := peers.Interface.Port
+	interfacePublicKey := peers.Interface.PublicKey
+	networkPeers := peers.Peers
 
 	if len(networkPeers) == 0 {
-		if networkFilter != "" {
+		if jsonOutput {
+			fmt.Println("[]")
+		} else if networkFilter != "" {
 			fmt.Println("\nNo peers found for network", networkFilter)
 		} else {
-			fmt.Println("\nNo peers found across any networks")
+			fmt.Println("\nNo peers found on interface", ifaceName)
 		}
 		return nil
 	}
 
 	if jsonOutput {
 		// Include interface info in JSON output
-		output := map[string]interface{}{
-			"interface": map[string]interface{}{
-				"name":       ifaceName,
-				"port":       interfacePort,
-				"public_key": interfacePublicKey,
-			},
-			"peers": networkPeers,
-		}
-		out, err := json.MarshalIndent(output, "", "  ")
+		out, err := json.MarshalIndent(peers, "", "  ")
 		if err != nil {
 			return fmt.Errorf("failed to marshal peer info: %w", err)
 		}
@@ -181,11 +186,11 @@ func ShowPeers(jsonOutput bool, networkFilter string) error {
 		}
 
 		for _, netName := range networks {
-			peers := networkPeers[netName]
+			netPeers := networkPeers[netName]
 			fmt.Printf("Network: %s\n", netName)
 
-			rows := make([][]string, 0, len(peers))
-			for i, info := range peers {
+			rows := make([][]string, 0, len(netPeers))
+			for i, info := range netPeers {
 				allowed := ""
 				if len(info.AllowedIPs) > 0 {
 					allowed = strings.Join(info.AllowedIPs, ",")
@@ -2,13 +2,17 @@ package functions
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/localapi"
 	"github.com/gravitl/netclient/metrics"
 	"github.com/gravitl/netclient/networking"
 	"github.com/gravitl/netmaker/models"
@@ -16,15 +20,7 @@ import (
 )
 
 // PingResult holds the result of a single peer connectivity check
-type PingResult struct {
-	Network   string `json:"network"`
-	Name      string `json:"name"`
-	Address   string `json:"address"`
-	IsExt     bool   `json:"is_extclient"`
-	Connected bool   `json:"connected"`
-	LatencyMs int64  `json:"latency_ms"`
-	UserName  string `json:"username,omitempty"`
-}
+type PingResult = localapi.PingResult
 
 // displayWidth calculates the display width of a string using golang.org/x/text/width
 // This properly handles emojis, wide characters, and other Unicode complexities
@@ -67,10 +63,32 @@ func padRight(s string, width int) string {
 // If peerFilter is non-empty, only peers whose name, address, or ID match (case-insensitive) are considered.
 // packetCount controls how many packets/probes are sent per peer (<=0 uses a sensible default).
 // ipVersion can be "4" for IPv4, "6" for IPv6, or "" for default address.
+// The peers are checked by the running daemon, and by the cli if the daemon is not reachable.
 func PingPeers(networkFilter, peerFilter string, jsonOutput bool, packetCount int, ipVersion string) error {
+	results := []PingResult{}
+	query := url.Values{}
+	query.Set("network", networkFilter)
+	query.Set("peer", peerFilter)
+	query.Set("count", strconv.Itoa(packetCount))
+	query.Set("ip", ipVersion)
+	err := localapi.Get(localapi.RoutePing, query, &results)
+	if err != nil {
+		if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+			return err
+		}
+		results, err = collectPingResults(networkFilter, peerFilter, packetCount, ipVersion)
+		if err != nil {
+			return err
+		}
+	}
+	return printPingResults(results, networkFilter, peerFilter, jsonOutput)
+}
+
+// collectPingResults checks the connectivity of the peers matching the filters
+func collectPingResults(networkFilter, peerFilter string, packetCount int, ipVersion string) ([]PingResult, error) {
 	server := config.GetServer(config.CurrServer)
 	if server == nil {
-		return fmt.Errorf("server config not found")
+		return nil, fmt.Errorf("server config not found")
 	}
 
 	metricPort := server.MetricsPort
@@ -80,7 +98,7 @@ func PingPeers(networkFilter, peerFilter string, jsonOutput bool, packetCount in
 
 	peerInfo, err := networking.GetPeerInfo()
 	if err != nil {
-		return fmt.Errorf("failed to fetch peer info from server: %w", err)
+		return nil, fmt.Errorf("failed to fetch peer info from server: %w", err)
 	}
 
 	// Collect all peers to ping
@@ -122,17 +140,6 @@ func PingPeers(networkFilter, peerFilter string, jsonOutput bool, packetCount in
 		}
 	}
 
-	if len(peersToPing) == 0 {
-		if peerFilter != "" {
-			fmt.Println("\nNo peers matched the provided filters")
-		} else if networkFilter != "" {
-			fmt.Println("\nNo peers found for network", networkFilter)
-		} else {
-			fmt.Println("\nNo peers found")
-		}
-		return nil
-	}
-
 	// Collect metrics asynchronously for each peer
 	results := make([]PingResult, 0, len(peersToPing))
 	var resultsMutex sync.Mutex
@@ -220,6 +227,11 @@ func PingPeers(networkFilter, peerFilter string, jsonOutput bool, packetCount in
 	// Wait for all goroutines to complete
 	wg.Wait()
 
+	return results, nil
+}
+
+// printPingResults displays the ping results grouped by network, as tables or json
+func printPingResults(results []PingResult, networkFilter, peerFilter string, jsonOutput bool) error {
 	if len(results) == 0 {
 		if peerFilter != "" {
 			fmt.Println("\nNo peers matched the provided filters")
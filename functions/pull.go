@@ -11,6 +11,7 @@ import (
 	"github.com/gravitl/netclient/auth"
 	"github.com/gravitl/netclient/config"
 	"github.com/gravitl/netclient/daemon"
+	"github.com/gravitl/netclient/localapi"
 	"github.com/gravitl/netclient/wireguard"
 	"github.com/gravitl/netmaker/logger"
 	"github.com/gravitl/netmaker/models"
@@ -18,6 +19,22 @@ import (
 
 var pMutex = sync.Mutex{} // used to mutex functions for pull
 
+// RequestPull - asks the running daemon to pull the latest config from the server, pulling from
+// the cli if the daemon is not reachable
+func RequestPull() error {
+	var result localapi.Pull
+	err := localapi.Post(localapi.RoutePull, nil, nil, &result)
+	if err == nil {
+		fmt.Printf("completed pull for server %s\n", result.Server)
+		return nil
+	}
+	if !errors.Is(err, localapi.ErrDaemonNotRunning) {
+		return err
+	}
+	_, _, _, err = Pull(true, true)
+	return err
+}
+
 // Pull - pulls the latest config from the server, if manual it will overwrite
 func Pull(restart bool, resetIfFailedOvered bool) (models.HostPull, bool, bool, error) {
 	pMutex.Lock()
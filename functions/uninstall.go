@@ -53,11 +53,13 @@ func LeaveNetwork(network string, isDaemon bool) ([]error, error) {
 	}
 	// re-configure interface if daemon is calling leave
 	if isDaemon {
-		dns.GetDNSServerInstance().Stop()
 		faults = resetInterfaceUninstall(faults)
 		server := config.GetServer(config.CurrServer)
 		if server != nil && server.ManageDNS {
-			dns.GetDNSServerInstance().Start()
+			// drops the listeners of the addresses of the left network
+			dns.GetDNSServerInstance().Reconcile()
+		} else {
+			dns.GetDNSServerInstance().Stop()
 		}
 	} else { // was called from CLI so restart daemon
 		if err := daemon.Restart(); err != nil {
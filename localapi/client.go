@@ -0,0 +1,75 @@
+package localapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const clientTimeout = time.Second * 10
+
+var client = &http.Client{
+	Timeout: clientTimeout,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", SocketPath())
+		},
+	},
+}
+
+// Get - fetches the given route from the daemon and decodes the response into out
+func Get(route string, query url.Values, out any) error {
+	return do(http.MethodGet, route, query, nil, out)
+}
+
+// Post - sends in as the json request body to the given route and decodes the response into out
+func Post(route string, query url.Values, in, out any) error {
+	return do(http.MethodPost, route, query, in, out)
+}
+
+func do(method, route string, query url.Values, in, out any) error {
+	u := url.URL{Scheme: "http", Host: "netclient", Path: route}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return ErrDaemonNotRunning
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("local api returned %s", resp.Status)
+		}
+		return errors.New(errResp.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,49 @@
+// Package localapi exposes the state of the running netclient daemon over a
+// permission restricted unix socket so that cli subcommands and local tooling
+// can query what the daemon actually believes instead of re-reading config files.
+package localapi
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/gravitl/netclient/config"
+)
+
+const (
+	// SocketName - name of the unix socket created in the netclient config directory
+	SocketName = "netclient.sock"
+	// SocketPerm - permissions applied to the socket, only root may talk to the daemon
+	SocketPerm = 0600
+)
+
+// routes served by the daemon
+const (
+	RouteStatus        = "/v1/status"
+	RouteNodes         = "/v1/nodes"
+	RoutePeers         = "/v1/peers"
+	RouteEndpoints     = "/v1/endpoints"
+	RouteIGW           = "/v1/igw"
+	RouteFirewall      = "/v1/firewall"
+	RouteFirewallPlan  = "/v1/firewall/plan"
+	RouteFirewallCheck = "/v1/firewall/check"
+	RouteFirewallStats = "/v1/firewall/stats"
+	RouteDNS           = "/v1/dns"
+	RouteDNSCacheFlush = "/v1/dns/cache/flush"
+	RouteDNSStats      = "/v1/dns/stats"
+	RouteDNSForward    = "/v1/dns/forward"
+	RoutePing          = "/v1/ping"
+	RouteConnect       = "/v1/connect"
+	RoutePull          = "/v1/pull"
+)
+
+// ErrDaemonNotRunning - returned by the client when the daemon socket is not reachable
+var ErrDaemonNotRunning = errors.New("netclient daemon is not running")
+
+// socketDir - directory of the daemon socket, replaced in tests
+var socketDir = config.GetNetclientPath
+
+// SocketPath - returns the full path of the daemon socket
+func SocketPath() string {
+	return filepath.Join(socketDir(), SocketName)
+}
@@ -0,0 +1,117 @@
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useSocketDir - places the socket in a temporary directory for the test
+func useSocketDir(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	orig := socketDir
+	socketDir = func() string { return dir }
+	t.Cleanup(func() { socketDir = orig })
+}
+
+func startServer(t *testing.T, srv *Server) {
+	t.Helper()
+	useSocketDir(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	require.NoError(t, srv.Start(ctx, wg))
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv := NewServer()
+	srv.Handle(RouteStatus, func(r *http.Request) (any, error) {
+		return Status{Server: r.URL.Query().Get("server"), MQConnected: true}, nil
+	})
+	connected := make(chan struct{}, 2)
+	srv.HandleThen(RouteConnect, func(r *http.Request) (any, error) {
+		if r.Method != http.MethodPost {
+			return nil, errors.New("connect requires a POST request")
+		}
+		var req Connect
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}, func() { connected <- struct{}{} })
+	srv.Handle(RoutePull, func(r *http.Request) (any, error) {
+		return nil, errors.New("server config not found")
+	})
+	startServer(t, srv)
+
+	info, err := os.Stat(SocketPath())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(SocketPerm), info.Mode().Perm())
+	// the directory the socket was bound in is gone
+	entries, err := os.ReadDir(filepath.Dir(SocketPath()))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	var status Status
+	require.NoError(t, Get(RouteStatus, url.Values{"server": {"netmaker"}}, &status))
+	assert.Equal(t, "netmaker", status.Server)
+	assert.True(t, status.MQConnected)
+
+	var connect Connect
+	require.NoError(t, Post(RouteConnect, nil, Connect{Network: "netmaker", Connected: true}, &connect))
+	assert.Equal(t, Connect{Network: "netmaker", Connected: true}, connect)
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("follow up of the connect request did not run")
+	}
+	// handler errors are returned to the client as they are
+	assert.EqualError(t, Get(RouteConnect, nil, &connect), "connect requires a POST request")
+	// failed requests have no follow up
+	assert.Empty(t, connected)
+	assert.EqualError(t, Post(RoutePull, nil, nil, nil), "server config not found")
+	assert.Error(t, Get(RouteNodes, nil, nil))
+}
+
+func TestDaemonNotRunning(t *testing.T) {
+	useSocketDir(t)
+
+	err := Get(RouteStatus, nil, &Status{})
+	assert.ErrorIs(t, err, ErrDaemonNotRunning)
+}
+
+func TestStaleSocket(t *testing.T) {
+	useSocketDir(t)
+	require.NoError(t, os.WriteFile(SocketPath(), nil, 0600))
+
+	srv := NewServer()
+	srv.Handle(RouteStatus, func(r *http.Request) (any, error) {
+		return Status{Server: "netmaker"}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	require.NoError(t, srv.Start(ctx, wg))
+
+	var status Status
+	assert.NoError(t, Get(RouteStatus, nil, &status))
+	cancel()
+	wg.Wait()
+	// the socket is removed on shutdown
+	_, err := os.Stat(SocketPath())
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
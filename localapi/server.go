@@ -0,0 +1,120 @@
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// HandlerFunc - returns the value to be encoded as the json response body
+type HandlerFunc func(r *http.Request) (any, error)
+
+// Server - serves the daemon state on the local unix socket
+type Server struct {
+	mux *http.ServeMux
+}
+
+// NewServer - returns a server with no routes registered
+func NewServer() *Server {
+	return &Server{mux: http.NewServeMux()}
+}
+
+// Handle - registers a handler for the given route
+func (s *Server) Handle(route string, handler HandlerFunc) {
+	s.HandleThen(route, handler, nil)
+}
+
+// HandleThen - registers a handler for the given route, then runs once a successful response is
+// written. Requests changing the daemon itself reply first and then act on the daemon
+func (s *Server) HandleThen(route string, handler HandlerFunc, then func()) {
+	s.mux.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+		resp, err := handler(r)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+			return
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Warn("failed to encode local api response", "route", route, "error", err)
+		}
+		if then != nil {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			then()
+		}
+	})
+}
+
+// Start - listens on the daemon socket until ctx is cancelled
+func (s *Server) Start(ctx context.Context, wg *sync.WaitGroup) error {
+	path := SocketPath()
+	// remove a stale socket left behind by a previous daemon
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	l, err := listen(path)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: time.Second * 5,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		_ = os.Remove(path)
+		slog.Info("closed local api", "socket", path)
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("local api stopped", "error", err)
+		}
+	}()
+	slog.Info("local api listening", "socket", path)
+	return nil
+}
+
+// listen - binds the socket in a private directory and moves it into place once its permissions
+// are restricted, so it is never reachable with the permissions left by the umask
+func listen(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".localapi-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(path))
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed by the server on shutdown, the bound path is gone once moved
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, SocketPerm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
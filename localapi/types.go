@@ -0,0 +1,95 @@
+package localapi
+
+import (
+	"time"
+)
+
+// Status - current server context of the daemon
+type Status struct {
+	Version       string `json:"version"`
+	HostID        string `json:"host_id"`
+	HostName      string `json:"host_name"`
+	Interface     string `json:"interface"`
+	ListenPort    int    `json:"listen_port"`
+	FirewallInUse string `json:"firewall_in_use"`
+	Server        string `json:"server"`
+	API           string `json:"api,omitempty"`
+	Broker        string `json:"broker,omitempty"`
+	IsPro         bool   `json:"is_pro"`
+	ManageDNS     bool   `json:"manage_dns"`
+	MQConnected   bool   `json:"mq_connected"`
+}
+
+// Interface - details of the netmaker wireguard interface
+type Interface struct {
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+	PublicKey string `json:"public_key"`
+}
+
+// Peer - a peer applied on the wireguard interface
+type Peer struct {
+	PublicKey           string    `json:"public_key"`
+	HostName            string    `json:"host_name,omitempty"`
+	Network             string    `json:"network,omitempty"`
+	Endpoint            string    `json:"endpoint,omitempty"`
+	LastHandshake       string    `json:"last_handshake,omitempty"`
+	LastHandshakeTime   time.Time `json:"last_handshake_time,omitempty"`
+	ReceiveBytes        int64     `json:"receive_bytes"`
+	TransmitBytes       int64     `json:"transmit_bytes"`
+	AllowedIPs          []string  `json:"allowed_ips,omitempty"`
+	PersistentKeepalive string    `json:"persistent_keepalive,omitempty"`
+	IsExt               bool      `json:"is_extclient,omitempty"`
+	UserName            string    `json:"username,omitempty"`
+}
+
+// Peers - applied peers grouped by network
+type Peers struct {
+	Interface Interface         `json:"interface"`
+	Peers     map[string][]Peer `json:"peers"`
+}
+
+// Endpoint - an entry of the endpoint detection cache
+type Endpoint struct {
+	PublicKey string `json:"public_key"`
+	Endpoint  string `json:"endpoint"`
+}
+
+// DNS - state of the local dns listener
+type DNS struct {
+	ListenAddrs  []string `json:"listen_addrs"`
+	CacheEntries int      `json:"cache_entries"`
+}
+
+// DNSForward - strategy forwarding the dns queries of a server to its nameservers
+type DNSForward struct {
+	Server   string `json:"server"`
+	Strategy string `json:"strategy"`
+}
+
+// DNSCacheFlush - result of flushing the dns response cache
+type DNSCacheFlush struct {
+	Flushed int `json:"flushed"`
+}
+
+// PingResult - result of the connectivity check of a peer
+type PingResult struct {
+	Network   string `json:"network"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	IsExt     bool   `json:"is_extclient"`
+	Connected bool   `json:"connected"`
+	LatencyMs int64  `json:"latency_ms"`
+	UserName  string `json:"username,omitempty"`
+}
+
+// Connect - connects or disconnects the node of a network
+type Connect struct {
+	Network   string `json:"network"`
+	Connected bool   `json:"connected"`
+}
+
+// Pull - result of pulling the host configuration from the server
+type Pull struct {
+	Server string `json:"server"`
+}
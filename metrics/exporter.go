@@ -0,0 +1,322 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/firewall"
+	"github.com/gravitl/netclient/ncutils"
+	"github.com/gravitl/netmaker/models"
+	"golang.org/x/exp/slog"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// NodeState - relay and failover state of a node
+type NodeState struct {
+	IsRelay     bool
+	IsRelayed   bool
+	IsFailOver  bool
+	FailedOver  bool
+	IsAutoRelay bool
+}
+
+// peerKey - identifies a peer within a network, a peer may be shared by several networks
+type peerKey struct {
+	network string
+	pubKey  string
+}
+
+// peerSample - last collected connectivity of a peer
+type peerSample struct {
+	name          string
+	connected     bool
+	latency       int64
+	uptimeMinutes int64
+	totalMinutes  int64
+}
+
+// exporterState - values exposed by the prometheus exporter
+type exporterState struct {
+	mu                  sync.Mutex
+	peers               map[peerKey]*peerSample // network and public key -> last sample
+	endpointChanges     map[string]uint64       // public key -> endpoint changes
+	nodeStates          map[string]NodeState    // network -> node state
+	pullFallbacks       uint64
+	mqConnected         bool
+	firewallDrift       map[string]uint64 // drift kind -> occurrences
+	lastReconcile       time.Time
+	fwUpdateFailures    uint64
+	lastFwUpdateFailure time.Time
+}
+
+var exporter = &exporterState{
+	peers:           make(map[peerKey]*peerSample),
+	endpointChanges: make(map[string]uint64),
+	nodeStates:      make(map[string]NodeState),
+	firewallDrift:   make(map[string]uint64),
+}
+
+// recordCollection - keeps the results of Collect for the exporter,
+// peers of the network missing from the collection are dropped
+func recordCollection(network string, peerMap models.PeerMap, metrics *models.Metrics) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	for key := range exporter.peers {
+		if key.network != network {
+			continue
+		}
+		peer, ok := peerMap[key.pubKey]
+		if ok {
+			_, ok = metrics.Connectivity[peer.ID]
+		}
+		if !ok {
+			delete(exporter.peers, key)
+		}
+	}
+	for pubKey, peer := range peerMap {
+		metric, ok := metrics.Connectivity[peer.ID]
+		if !ok {
+			continue
+		}
+		key := peerKey{network: network, pubKey: pubKey}
+		sample, ok := exporter.peers[key]
+		if !ok {
+			sample = &peerSample{}
+			exporter.peers[key] = sample
+		}
+		sample.name = peer.Name
+		sample.connected = metric.Connected
+		sample.latency = metric.Latency
+		sample.uptimeMinutes += metric.Uptime
+		sample.totalMinutes += metric.TotalTime
+	}
+}
+
+// RecordEndpointChange - counts an endpoint change of a peer
+func RecordEndpointChange(pubKey string) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.endpointChanges[pubKey]++
+}
+
+// RecordPullFallback - counts a config pull made because the broker was unreachable
+func RecordPullFallback() {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.pullFallbacks++
+}
+
+// RecordFirewallReconcile - counts the drift found by a firewall reconciliation, keyed by kind
+func RecordFirewallReconcile(drift map[string]int) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	for kind, count := range drift {
+		exporter.firewallDrift[kind] += uint64(count)
+	}
+	exporter.lastReconcile = time.Now()
+}
+
+// RecordFirewallUpdateFailure - counts a firewall update that failed and was rolled back
+func RecordFirewallUpdateFailure() {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.fwUpdateFailures++
+	exporter.lastFwUpdateFailure = time.Now()
+}
+
+// SetMQConnected - sets the broker connection status
+func SetMQConnected(connected bool) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.mqConnected = connected
+}
+
+// SetNodeStates - replaces the relay/failover state of the host's nodes, keyed by network
+func SetNodeStates(states map[string]NodeState) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.nodeStates = states
+}
+
+// StartExporter - serves the prometheus /metrics endpoint on addr until ctx is cancelled
+func StartExporter(ctx context.Context, wg *sync.WaitGroup, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w)
+	})
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 5,
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		slog.Info("closed metrics exporter", "addr", addr)
+	}()
+	go func() {
+		slog.Info("metrics exporter listening", "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics exporter stopped", "addr", addr, "error", err)
+		}
+	}()
+}
+
+// metricFamily - a metric with its samples in exposition format
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []string
+}
+
+func (f *metricFamily) add(labels map[string]string, value any) {
+	f.samples = append(f.samples, f.name+formatLabels(labels)+" "+fmt.Sprint(value))
+}
+
+func (f *metricFamily) write(w io.Writer) {
+	if len(f.samples) == 0 {
+		return
+	}
+	sort.Strings(f.samples)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	for _, sample := range f.samples {
+		fmt.Fprintln(w, sample)
+	}
+}
+
+func writeMetrics(w io.Writer) {
+	var (
+		mqConnected   = &metricFamily{name: "netclient_mq_connected", help: "Whether the broker connection is open.", kind: "gauge"}
+		pullFallbacks = &metricFamily{name: "netclient_pull_fallback_total", help: "Config pulls made because the broker was unreachable.", kind: "counter"}
+		nodeRelay     = &metricFamily{name: "netclient_node_is_relay", help: "Whether the node relays other nodes.", kind: "gauge"}
+		nodeRelayed   = &metricFamily{name: "netclient_node_is_relayed", help: "Whether the node is relayed.", kind: "gauge"}
+		nodeFailOver  = &metricFamily{name: "netclient_node_is_failover", help: "Whether the node acts as a failover.", kind: "gauge"}
+		nodeFailed    = &metricFamily{name: "netclient_node_failed_over", help: "Whether the node's traffic is failed over.", kind: "gauge"}
+		nodeAutoRelay = &metricFamily{name: "netclient_node_is_auto_relay", help: "Whether the node acts as an auto relay.", kind: "gauge"}
+		connected     = &metricFamily{name: "netclient_peer_connected", help: "Whether the peer was reachable on the last collection.", kind: "gauge"}
+		latency       = &metricFamily{name: "netclient_peer_latency_milliseconds", help: "Latency to the peer on the last collection.", kind: "gauge"}
+		uptime        = &metricFamily{name: "netclient_peer_uptime_minutes_total", help: "Minutes the peer was observed connected.", kind: "counter"}
+		observed      = &metricFamily{name: "netclient_peer_observed_minutes_total", help: "Minutes the peer was observed.", kind: "counter"}
+		received      = &metricFamily{name: "netclient_peer_receive_bytes_total", help: "Bytes received from the peer.", kind: "counter"}
+		sent          = &metricFamily{name: "netclient_peer_transmit_bytes_total", help: "Bytes sent to the peer.", kind: "counter"}
+		handshakeAge  = &metricFamily{name: "netclient_peer_last_handshake_age_seconds", help: "Seconds since the last handshake with the peer.", kind: "gauge"}
+		epChanges     = &metricFamily{name: "netclient_peer_endpoint_changes_total", help: "Endpoint changes applied to the peer.", kind: "counter"}
+		fwDrift       = &metricFamily{name: "netclient_firewall_drift_total", help: "Differences found between the live firewall and the installed rules.", kind: "counter"}
+		fwPackets     = &metricFamily{name: "netclient_firewall_rule_packets_total", help: "Packets matched by the acl rules of a policy, egress gateway or ingress node.", kind: "counter"}
+		fwBytes       = &metricFamily{name: "netclient_firewall_rule_bytes_total", help: "Bytes matched by the acl rules of a policy, egress gateway or ingress node.", kind: "counter"}
+		fwReconcile   = &metricFamily{name: "netclient_firewall_last_reconcile_timestamp_seconds", help: "Time of the last firewall reconciliation.", kind: "gauge"}
+		fwFailures    = &metricFamily{name: "netclient_firewall_update_failures_total", help: "Firewall updates that failed and were rolled back.", kind: "counter"}
+		fwLastFailure = &metricFamily{name: "netclient_firewall_last_update_failure_timestamp_seconds", help: "Time of the last failed firewall update.", kind: "gauge"}
+	)
+
+	exporter.mu.Lock()
+	mqConnected.add(nil, boolValue(exporter.mqConnected))
+	pullFallbacks.add(nil, exporter.pullFallbacks)
+	for kind, count := range exporter.firewallDrift {
+		fwDrift.add(map[string]string{"kind": kind}, count)
+	}
+	if !exporter.lastReconcile.IsZero() {
+		fwReconcile.add(nil, exporter.lastReconcile.Unix())
+	}
+	fwFailures.add(nil, exporter.fwUpdateFailures)
+	if !exporter.lastFwUpdateFailure.IsZero() {
+		fwLastFailure.add(nil, exporter.lastFwUpdateFailure.Unix())
+	}
+	for network, state := range exporter.nodeStates {
+		labels := map[string]string{"network": network}
+		nodeRelay.add(labels, boolValue(state.IsRelay))
+		nodeRelayed.add(labels, boolValue(state.IsRelayed))
+		nodeFailOver.add(labels, boolValue(state.IsFailOver))
+		nodeFailed.add(labels, boolValue(state.FailedOver))
+		nodeAutoRelay.add(labels, boolValue(state.IsAutoRelay))
+	}
+	// traffic is read per public key, it is labelled with the first network of a shared peer
+	peerLabels := make(map[string]map[string]string)
+	for key, sample := range exporter.peers {
+		labels := map[string]string{"network": key.network, "peer": key.pubKey, "peer_name": sample.name}
+		if existing, ok := peerLabels[key.pubKey]; !ok || key.network < existing["network"] {
+			peerLabels[key.pubKey] = labels
+		}
+		connected.add(labels, boolValue(sample.connected))
+		latency.add(labels, sample.latency)
+		uptime.add(labels, sample.uptimeMinutes)
+		observed.add(labels, sample.totalMinutes)
+	}
+	labelsFor := func(pubKey string) map[string]string {
+		if labels, ok := peerLabels[pubKey]; ok {
+			return labels
+		}
+		return map[string]string{"network": "", "peer": pubKey, "peer_name": ""}
+	}
+	for pubKey, changes := range exporter.endpointChanges {
+		epChanges.add(labelsFor(pubKey), changes)
+	}
+	exporter.mu.Unlock()
+
+	// traffic and handshakes are read live from the interface
+	if wgclient, err := wgctrl.New(); err == nil {
+		defer wgclient.Close()
+		if device, err := wgclient.Device(ncutils.GetInterfaceName()); err == nil {
+			for _, peer := range device.Peers {
+				labels := labelsFor(peer.PublicKey.String())
+				received.add(labels, peer.ReceiveBytes)
+				sent.add(labels, peer.TransmitBytes)
+				if !peer.LastHandshakeTime.IsZero() {
+					handshakeAge.add(labels, int64(time.Since(peer.LastHandshakeTime).Seconds()))
+				}
+			}
+		}
+	}
+
+	// rule hits are read live from the firewall
+	for _, stats := range firewall.GetRuleStats(config.CurrServer) {
+		labels := map[string]string{"table": stats.Table, "key": stats.Key, "acl": stats.AclID}
+		fwPackets.add(labels, stats.Packets)
+		fwBytes.add(labels, stats.Bytes)
+	}
+
+	for _, family := range []*metricFamily{mqConnected, pullFallbacks, nodeRelay, nodeRelayed, nodeFailOver,
+		nodeFailed, nodeAutoRelay, connected, latency, uptime, observed, received, sent, handshakeAge, epChanges,
+		fwDrift, fwReconcile, fwFailures, fwLastFailure, fwPackets, fwBytes} {
+		family.write(w)
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"=\""+labelEscaper.Replace(labels[k])+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/gravitl/netmaker/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCollection(t *testing.T) {
+	exporter.peers = make(map[peerKey]*peerSample)
+	t.Cleanup(func() { exporter.peers = make(map[peerKey]*peerSample) })
+
+	peers := models.PeerMap{
+		"key-a": {ID: "node-a", Name: "a"},
+		"key-b": {ID: "node-b", Name: "b"},
+	}
+	collected := &models.Metrics{Connectivity: map[string]models.Metric{
+		"node-a": {Connected: true, Uptime: 5, TotalTime: 5},
+		"node-b": {Connected: true, Uptime: 5, TotalTime: 5},
+	}}
+	shared := models.PeerMap{"key-a": {ID: "node-a2", Name: "a"}}
+	sharedCollected := &models.Metrics{Connectivity: map[string]models.Metric{
+		"node-a2": {Connected: false, Uptime: 0, TotalTime: 5},
+	}}
+
+	// a peer shared by two networks keeps a sample per network
+	recordCollection("net1", peers, collected)
+	recordCollection("net2", shared, sharedCollected)
+	recordCollection("net1", peers, collected)
+	recordCollection("net2", shared, sharedCollected)
+	assert.Len(t, exporter.peers, 3)
+	assert.Equal(t, int64(10), exporter.peers[peerKey{network: "net1", pubKey: "key-a"}].uptimeMinutes)
+	assert.Equal(t, int64(0), exporter.peers[peerKey{network: "net2", pubKey: "key-a"}].uptimeMinutes)
+	assert.Equal(t, int64(10), exporter.peers[peerKey{network: "net2", pubKey: "key-a"}].totalMinutes)
+
+	// departed peers are dropped, the other networks are left alone
+	delete(peers, "key-b")
+	recordCollection("net1", peers, collected)
+	assert.Len(t, exporter.peers, 2)
+	assert.NotContains(t, exporter.peers, peerKey{network: "net1", pubKey: "key-b"})
+	assert.Contains(t, exporter.peers, peerKey{network: "net2", pubKey: "key-a"})
+}
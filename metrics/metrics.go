@@ -82,6 +82,7 @@ func Collect(network string, peerMap models.PeerMap, metricPort int) (*models.Me
 	}
 
 	fillUnconnectedData(&metrics, peerMap, mi)
+	recordCollection(network, peerMap, &metrics)
 	return &metrics, nil
 }
 
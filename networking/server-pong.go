@@ -8,6 +8,7 @@ import (
 
 	"github.com/gravitl/netclient/cache"
 	"github.com/gravitl/netclient/config"
+	"github.com/gravitl/netclient/metrics"
 	"github.com/gravitl/netclient/wireguard"
 	"github.com/gravitl/netmaker/logger"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -84,6 +85,7 @@ func storeNewPeerIface(peerPubKey string, endpoint *net.UDPAddr) error {
 		return err
 	}
 	cache.EndpointCache.Store(peerPubKey, newIfaceValue)
+	metrics.RecordEndpointChange(peerPubKey)
 
 	return nil
 }
@@ -31,6 +31,8 @@ var (
 )
 
 type IGWMonitor struct {
+	// mu guards the status, read by the local api while the monitor updates it
+	mu         sync.RWMutex
 	status     *igwStatus
 	cancelFunc context.CancelFunc
 }
@@ -57,6 +59,7 @@ func (m *IGWMonitor) Monitor(publicKey string, networkIP net.IP) {
 	// ideally, it should never happen that we have multiple
 	// internet gateways, but just in case it happens, we need to
 	// stop the monitor for the other internet gateway.
+	m.mu.Lock()
 	if m.cancelFunc != nil {
 		m.cancelFunc()
 	}
@@ -64,25 +67,27 @@ func (m *IGWMonitor) Monitor(publicKey string, networkIP net.IP) {
 	var ctx context.Context
 	ctx, m.cancelFunc = context.WithCancel(context.Background())
 
-	m.status = &igwStatus{
+	status := &igwStatus{
 		networkIP: networkIP,
 		publicKey: publicKey,
 		ctx:       ctx,
 		ticker:    time.NewTicker(IGWMonitorInterval),
 		isHealthy: true, // Assume healthy initially
 	}
+	m.status = status
+	m.mu.Unlock()
 
 	go func(m *IGWMonitor) {
 		logger.Log(0, "starting health monitor for internet gateway")
 
 		for {
 			select {
-			case <-m.status.ctx.Done():
+			case <-status.ctx.Done():
 				logger.Log(0, "stopping health monitor for internet gateway")
 				return
-			case <-m.status.ticker.C:
+			case <-status.ticker.C:
 				logger.Log(2, "checking health of internet gateway...")
-				m.updateStatus()
+				m.updateStatus(status)
 			}
 		}
 	}(m)
@@ -90,6 +95,8 @@ func (m *IGWMonitor) Monitor(publicKey string, networkIP net.IP) {
 
 // Stop stops the monitor.
 func (m *IGWMonitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.cancelFunc != nil {
 		m.cancelFunc()
 	}
@@ -100,33 +107,38 @@ func (m *IGWMonitor) Stop() {
 // it will set it to healthy. If the internet gateway is not
 // reachable, it will set it to unhealthy. It will set and
 // reset default routes on host accordingly.
-func (m *IGWMonitor) updateStatus() {
-	igw, err := GetPeer(ncutils.GetInterfaceName(), m.status.publicKey)
+func (m *IGWMonitor) updateStatus(status *igwStatus) {
+	igw, err := GetPeer(ncutils.GetInterfaceName(), status.publicKey)
 	if err != nil {
 		logger.Log(0, "failed to get internet gateway peer:", err.Error())
 		return
 	}
 
-	reachable := isHostReachable(m.status.networkIP, igw.Endpoint.Port)
+	reachable := isHostReachable(status.networkIP, igw.Endpoint.Port)
 	if reachable {
 		logger.Log(2, "internet gateway detected up")
 
-		m.status.successCount++
-		m.status.failureCount = 0
+		m.mu.Lock()
+		status.successCount++
+		status.failureCount = 0
+		recovered := !status.isHealthy && status.successCount >= IGWRecoveryThreshold
+		if recovered {
+			status.isHealthy = true
+		}
+		m.mu.Unlock()
 
-		if !m.status.isHealthy && m.status.successCount >= IGWRecoveryThreshold {
+		if recovered {
 			logger.Log(2, "setting internet gateway healthy")
-			m.status.isHealthy = true
 
 			logger.Log(2, "restoring default routes for internet gateway")
 			// internet gateway is back up, restore 0.0.0.0/0 and ::/0 routes
-			err := restoreDefaultRoutesOnIGWPeer(igw, m.status.networkIP)
+			err := restoreDefaultRoutesOnIGWPeer(igw, status.networkIP)
 			if err != nil {
 				logger.Log(0, "failed to restore default routes for internet gateway:", err.Error())
 			}
 
 			logger.Log(2, "setting default routes on host")
-			err = setDefaultRoutesOnHost(m.status.publicKey, m.status.networkIP)
+			err = setDefaultRoutesOnHost(status.publicKey, status.networkIP)
 			if err != nil {
 				logger.Log(0, "failed to set default routes on host:", err.Error())
 			}
@@ -134,12 +146,17 @@ func (m *IGWMonitor) updateStatus() {
 	} else {
 		logger.Log(2, "internet gateway detected down")
 
-		m.status.failureCount++
-		m.status.successCount = 0
+		m.mu.Lock()
+		status.failureCount++
+		status.successCount = 0
+		failed := status.isHealthy && status.failureCount >= IGWFailureThreshold
+		if failed {
+			status.isHealthy = false
+		}
+		m.mu.Unlock()
 
-		if m.status.isHealthy && m.status.failureCount >= IGWFailureThreshold {
+		if failed {
 			logger.Log(2, "setting internet gateway unhealthy")
-			m.status.isHealthy = false
 
 			logger.Log(2, "removing default routes for internet gateway")
 			// internet gateway is down, remove 0.0.0.0/0 and ::/0 routes
@@ -160,6 +177,8 @@ func (m *IGWMonitor) updateStatus() {
 // IsCurrentIGW returns true if the node represented by the networkIP is
 // the current internet gateway.
 func (m *IGWMonitor) IsCurrentIGW(networkIP net.IP) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	if m.status == nil {
 		return false
 	}
@@ -167,6 +186,33 @@ func (m *IGWMonitor) IsCurrentIGW(networkIP net.IP) bool {
 	return m.status.networkIP.Equal(networkIP)
 }
 
+// IGWHealth - health of the internet gateway tracked by the monitor
+type IGWHealth struct {
+	Monitoring   bool   `json:"monitoring"`
+	NetworkIP    string `json:"network_ip,omitempty"`
+	PublicKey    string `json:"public_key,omitempty"`
+	IsHealthy    bool   `json:"is_healthy"`
+	SuccessCount int    `json:"success_count"`
+	FailureCount int    `json:"failure_count"`
+}
+
+// Status returns the current health of the monitored internet gateway.
+func (m *IGWMonitor) Status() IGWHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.status == nil || m.status.ctx.Err() != nil {
+		return IGWHealth{}
+	}
+	return IGWHealth{
+		Monitoring:   true,
+		NetworkIP:    m.status.networkIP.String(),
+		PublicKey:    m.status.publicKey,
+		IsHealthy:    m.status.isHealthy,
+		SuccessCount: m.status.successCount,
+		FailureCount: m.status.failureCount,
+	}
+}
+
 // restoreDefaultRoutesOnIGWPeer restores default routes (0.0.0.0/0,::/0)
 // to the internet gateway peer.
 func restoreDefaultRoutesOnIGWPeer(igw wgtypes.Peer, networkIP net.IP) error {